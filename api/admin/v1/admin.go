@@ -0,0 +1,65 @@
+package v1
+
+import (
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// 列表接口请求参数, resource: users, apps, keys, models, model_agents, corps
+type ListReq struct {
+	g.Meta   `path:"/{resource}" tags:"admin" method:"get" summary:"列表接口"`
+	Resource string `json:"resource" in:"path"`
+	Page     int64  `json:"page"`
+	PageSize int64  `json:"page_size"`
+}
+
+// 列表接口响应参数
+type ListRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+}
+
+// 详情接口请求参数
+type DetailReq struct {
+	g.Meta   `path:"/{resource}/{id}" tags:"admin" method:"get" summary:"详情接口"`
+	Resource string `json:"resource" in:"path"`
+	Id       string `json:"id" in:"path"`
+}
+
+// 详情接口响应参数
+type DetailRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+}
+
+// 新建接口请求参数, 请求体字段名同数据库
+type CreateReq struct {
+	g.Meta   `path:"/{resource}" tags:"admin" method:"post" summary:"新建接口"`
+	Resource string `json:"resource" in:"path"`
+}
+
+// 新建接口响应参数
+type CreateRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+}
+
+// 更新接口请求参数, 只更新请求体中包含的字段
+type UpdateReq struct {
+	g.Meta   `path:"/{resource}/{id}" tags:"admin" method:"put,patch" summary:"更新接口"`
+	Resource string `json:"resource" in:"path"`
+	Id       string `json:"id" in:"path"`
+}
+
+// 更新接口响应参数
+type UpdateRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+}
+
+// 删除接口请求参数
+type DeleteReq struct {
+	g.Meta   `path:"/{resource}/{id}" tags:"admin" method:"delete" summary:"删除接口"`
+	Resource string `json:"resource" in:"path"`
+	Id       string `json:"id" in:"path"`
+}
+
+// 删除接口响应参数
+type DeleteRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+}
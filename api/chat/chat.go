@@ -12,4 +12,5 @@ import (
 
 type IChatV1 interface {
 	Completions(ctx context.Context, req *v1.CompletionsReq) (res *v1.CompletionsRes, err error)
+	Fanout(ctx context.Context, req *v1.FanoutReq) (res *v1.FanoutRes, err error)
 }
@@ -3,6 +3,7 @@ package v1
 import (
 	"github.com/gogf/gf/v2/frame/g"
 	sdkm "github.com/iimeta/fastapi-sdk/model"
+	"github.com/iimeta/fastapi/internal/model"
 )
 
 // Completions接口请求参数
@@ -15,3 +16,15 @@ type CompletionsReq struct {
 type CompletionsRes struct {
 	g.Meta `mime:"application/json" example:"json"`
 }
+
+// Fanout接口请求参数
+type FanoutReq struct {
+	g.Meta `path:"/fanout" tags:"chat" method:"post" summary:"Fanout接口"`
+	model.ChatFanoutReq
+}
+
+// Fanout接口响应参数
+type FanoutRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+	*model.ChatFanoutRes
+}
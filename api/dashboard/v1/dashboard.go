@@ -27,6 +27,18 @@ type UsageRes struct {
 	*model.DashboardUsageRes
 }
 
+// EndUserUsage接口请求参数
+type EndUserUsageReq struct {
+	g.Meta  `path:"/billing/end_user_usage" tags:"dashboard" method:"get,post" summary:"EndUserUsage接口"`
+	EndUser string `json:"end_user"`
+}
+
+// EndUserUsage接口响应参数
+type EndUserUsageRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+	*model.DashboardEndUserUsageRes
+}
+
 // models接口请求参数
 type ModelsReq struct {
 	g.Meta    `path:"/models" tags:"dashboard" method:"get,post" summary:"models接口"`
@@ -12,4 +12,6 @@ import (
 
 type IHealthV1 interface {
 	Health(ctx context.Context, req *v1.HealthReq) (res *v1.HealthRes, err error)
+	Healthz(ctx context.Context, req *v1.HealthzReq) (res *v1.HealthzRes, err error)
+	Readyz(ctx context.Context, req *v1.ReadyzReq) (res *v1.ReadyzRes, err error)
 }
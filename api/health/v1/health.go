@@ -13,3 +13,23 @@ type HealthReq struct {
 type HealthRes struct {
 	g.Meta `mime:"application/json" example:"json"`
 }
+
+// 存活检查接口请求参数
+type HealthzReq struct {
+	g.Meta `path:"/healthz" tags:"health" method:"get" summary:"存活检查接口"`
+}
+
+// 存活检查接口响应参数
+type HealthzRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+}
+
+// 就绪检查接口请求参数
+type ReadyzReq struct {
+	g.Meta `path:"/readyz" tags:"health" method:"get" summary:"就绪检查接口"`
+}
+
+// 就绪检查接口响应参数
+type ReadyzRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+}
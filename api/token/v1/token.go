@@ -0,0 +1,18 @@
+package v1
+
+import (
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/iimeta/fastapi/internal/model"
+)
+
+// 签发令牌接口请求参数
+type CreateReq struct {
+	g.Meta `path:"/tokens" tags:"token" method:"post" summary:"签发令牌接口"`
+	model.TokenCreateReq
+}
+
+// 签发令牌接口响应参数
+type CreateRes struct {
+	g.Meta `mime:"application/json" example:"json"`
+	*model.TokenCreateRes
+}
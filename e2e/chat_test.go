@@ -0,0 +1,220 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"fmt"
+	"github.com/iimeta/fastapi/internal/model/do"
+	"go.mongodb.org/mongo-driver/bson"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+
+	response, err := http.Get(env.baseURL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("readyz status: %d", response.StatusCode)
+	}
+}
+
+func TestInvalidApiKey(t *testing.T) {
+
+	status, body := chat(t, "sk-invalid-0123456789abcdef", MODEL_GPT)
+
+	if status != http.StatusUnauthorized || errorCode(body) != "invalid_api_key" {
+		t.Fatalf("status: %d, body: %v", status, body)
+	}
+}
+
+func TestCompletions(t *testing.T) {
+
+	for _, model := range []string{MODEL_GPT, MODEL_CLAUDE, MODEL_GEMINI} {
+		t.Run(model, func(t *testing.T) {
+
+			status, body := chat(t, secretKey(USER_DEFAULT), model)
+
+			if status != http.StatusOK {
+				t.Fatalf("status: %d, body: %v", status, body)
+			}
+
+			if got := content(body); got != answer(model) {
+				t.Fatalf("content: %q, want: %q", got, answer(model))
+			}
+
+			if requests := env.provider.requestsByKey(modelKey(model, 1)); len(requests) == 0 || requests[len(requests)-1].Model != model {
+				t.Fatalf("upstream requests: %+v", requests)
+			}
+		})
+	}
+}
+
+func TestCompletionsStream(t *testing.T) {
+
+	for _, model := range []string{MODEL_GPT, MODEL_CLAUDE, MODEL_GEMINI} {
+		t.Run(model, func(t *testing.T) {
+
+			status, chunks := chatStream(t, secretKey(USER_DEFAULT), model)
+
+			if status != http.StatusOK {
+				t.Fatalf("status: %d", status)
+			}
+
+			var builder strings.Builder
+			for _, chunk := range chunks {
+				builder.WriteString(content(chunk))
+			}
+
+			if got := builder.String(); got != answer(model) {
+				t.Fatalf("content: %q, want: %q", got, answer(model))
+			}
+
+			requests := env.provider.requestsByKey(modelKey(model, 1))
+			if len(requests) == 0 || !requests[len(requests)-1].Stream {
+				t.Fatalf("upstream requests: %+v", requests)
+			}
+		})
+	}
+}
+
+// 上游返回不重试的错误时直接返回错误
+func TestUpstreamError(t *testing.T) {
+
+	key := modelKey(MODEL_ERROR, 1)
+	env.provider.script(key, providerReply{Status: http.StatusBadRequest, Code: "context_length_exceeded", Message: "Please reduce the length of the messages."})
+
+	status, body := chat(t, secretKey(USER_DEFAULT), MODEL_ERROR)
+
+	if status == http.StatusOK {
+		t.Fatalf("status: %d, body: %v", status, body)
+	}
+
+	if requests := env.provider.requestsByKey(key); len(requests) != 1 {
+		t.Fatalf("upstream requests: %d, want: 1", len(requests))
+	}
+}
+
+// 上游临时错误后重试成功
+func TestRetry(t *testing.T) {
+
+	key := modelKey(MODEL_RETRY, 1)
+	env.provider.script(key, providerReply{Status: http.StatusInternalServerError, Code: "server_error", Message: "The server had an error while processing your request."})
+
+	status, body := chat(t, secretKey(USER_DEFAULT), MODEL_RETRY)
+
+	if status != http.StatusOK || content(body) != answer(MODEL_RETRY) {
+		t.Fatalf("status: %d, body: %v", status, body)
+	}
+
+	if requests := env.provider.requestsByKey(key); len(requests) != 2 {
+		t.Fatalf("upstream requests: %d, want: 2", len(requests))
+	}
+}
+
+// 重试次数用完后使用后备模型
+func TestFallback(t *testing.T) {
+
+	key := modelKey(MODEL_PRIMARY, 1)
+	for i := 0; i < 3; i++ {
+		env.provider.script(key, providerReply{Status: http.StatusInternalServerError, Code: "server_error", Message: "The server had an error while processing your request."})
+	}
+
+	status, body := chat(t, secretKey(USER_DEFAULT), MODEL_PRIMARY)
+
+	if status != http.StatusOK || content(body) != answer(MODEL_BACKUP) {
+		t.Fatalf("status: %d, body: %v", status, body)
+	}
+
+	if requests := env.provider.requestsByKey(key); len(requests) != 3 {
+		t.Fatalf("primary upstream requests: %d, want: 3", len(requests))
+	}
+
+	if requests := env.provider.requestsByKey(modelKey(MODEL_BACKUP, 1)); len(requests) != 1 {
+		t.Fatalf("backup upstream requests: %d, want: 1", len(requests))
+	}
+}
+
+// 上游返回自动禁用错误时禁用模型密钥, 并使用其它密钥重试
+func TestKeyDisabled(t *testing.T) {
+
+	disabled := modelKey(MODEL_DISABLED, 1)
+	env.provider.script(disabled, providerReply{Status: http.StatusUnauthorized, Code: "invalid_api_key", Message: "Incorrect API key provided: pk-***. You can find your API key at https://platform.openai.com/account/api-keys."})
+
+	// 负载均衡可能先选中另一个密钥
+	for i := 0; i < 4 && len(env.provider.requestsByKey(disabled)) == 0; i++ {
+		if status, body := chat(t, secretKey(USER_DEFAULT), MODEL_DISABLED); status != http.StatusOK {
+			t.Fatalf("status: %d, body: %v", status, body)
+		}
+	}
+
+	if len(env.provider.requestsByKey(disabled)) == 0 {
+		t.Fatal("disabled key is never used")
+	}
+
+	eventually(t, "model key disabled", func() bool {
+		key := findOne(do.KEY_COLLECTION, bson.M{"_id": fmt.Sprintf("key-%s-1", MODEL_DISABLED)})
+		return key != nil && compareValues(key["status"], 2) == 0
+	})
+
+	before := len(env.provider.requestsByKey(disabled))
+
+	for i := 0; i < 3; i++ {
+		if status, body := chat(t, secretKey(USER_DEFAULT), MODEL_DISABLED); status != http.StatusOK {
+			t.Fatalf("status: %d, body: %v", status, body)
+		}
+	}
+
+	if after := len(env.provider.requestsByKey(disabled)); after != before {
+		t.Fatalf("disabled key is still used, requests: %d, want: %d", after, before)
+	}
+}
+
+// 额度不足时不请求上游
+func TestQuotaExhausted(t *testing.T) {
+
+	before := len(env.provider.requestsByKey(modelKey(MODEL_GPT, 1)))
+
+	status, body := chat(t, secretKey(USER_EMPTY), MODEL_GPT)
+
+	if status != http.StatusTooManyRequests || errorCode(body) != "insufficient_quota" {
+		t.Fatalf("status: %d, body: %v", status, body)
+	}
+
+	if after := len(env.provider.requestsByKey(modelKey(MODEL_GPT, 1))); after != before {
+		t.Fatalf("upstream requests: %d, want: %d", after, before)
+	}
+}
+
+// 按用量扣减Redis中的额度, 并同步到MongoDB和调用日志
+func TestUsageRecording(t *testing.T) {
+
+	status, body := chat(t, secretKey(USER_USAGE), MODEL_GPT)
+	if status != http.StatusOK {
+		t.Fatalf("status: %d, body: %v", status, body)
+	}
+
+	totalTokens := PROVIDER_PROMPT_TOKENS + PROVIDER_COMPLETION_TOKENS
+	remaining := fmt.Sprint(USER_USAGE_QUOTA - totalTokens)
+
+	eventually(t, "redis quota", func() bool {
+		// 不引用consts, 避免在测试进程中加载网关配置
+		quota := env.redis.HGet(fmt.Sprintf("api:user:%d:usage", USER_USAGE), "user.quota")
+		return quota == remaining
+	})
+
+	eventually(t, "mongodb used quota", func() bool {
+		user := findOne(do.USER_COLLECTION, bson.M{"user_id": USER_USAGE})
+		return user != nil && compareValues(user["used_quota"], totalTokens) == 0
+	})
+
+	eventually(t, "chat log", func() bool {
+		chat := findOne(do.CHAT_COLLECTION, bson.M{"user_id": USER_USAGE})
+		return chat != nil && compareValues(chat["total_tokens"], totalTokens) == 0
+	})
+}
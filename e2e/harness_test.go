@@ -0,0 +1,560 @@
+//go:build e2e
+
+// Package e2e 端到端测试, 以子进程启动网关, 使用内嵌的MongoDB, miniredis和模拟上游服务
+//
+// 运行: go test -tags e2e ./e2e/
+package e2e
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/iimeta/fastapi/internal/model/common"
+	"github.com/iimeta/fastapi/internal/model/do"
+	"github.com/iimeta/fastapi/internal/model/entity"
+	"github.com/iimeta/fastapi/utility/crypto"
+	"go.mongodb.org/mongo-driver/bson"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const DATABASE = "fastapi"
+
+var env *environment
+
+type environment struct {
+	dir      string
+	mongo    *mongoServer
+	redis    *miniredis.Miniredis
+	provider *provider
+	gateway  *exec.Cmd
+	exited   chan error
+	baseURL  string
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+
+	dir, err := os.MkdirTemp("", "fastapi-e2e-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	if env, err = start(dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		printLog(dir)
+		if env != nil {
+			env.stop()
+		}
+		return 1
+	}
+	defer env.stop()
+
+	code := m.Run()
+	if code != 0 {
+		printLog(dir)
+	}
+
+	return code
+}
+
+func start(dir string) (*environment, error) {
+
+	e := &environment{
+		dir:      dir,
+		provider: newProvider(),
+	}
+
+	var err error
+
+	if e.redis, err = miniredis.Run(); err != nil {
+		return e, err
+	}
+
+	if e.mongo, err = newMongoServer(); err != nil {
+		return e, err
+	}
+
+	seed(e.mongo, e.provider)
+
+	port, err := freePort()
+	if err != nil {
+		return e, err
+	}
+
+	e.baseURL = fmt.Sprintf("http://127.0.0.1:%d", port)
+
+	if err = os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(gatewayConfig(port, e.redis.Addr(), e.mongo.uri())), 0o644); err != nil {
+		return e, err
+	}
+
+	binary := filepath.Join(dir, "fastapi")
+
+	// 继承环境变量, 可通过GOFLAGS传递构建参数
+	build := exec.Command("go", "build", "-o", binary, ".")
+	build.Dir = ".."
+	if output, err := build.CombinedOutput(); err != nil {
+		return e, fmt.Errorf("build gateway error: %v\n%s", err, output)
+	}
+
+	logFile, err := os.Create(filepath.Join(dir, "gateway.log"))
+	if err != nil {
+		return e, err
+	}
+
+	e.gateway = exec.Command(binary)
+	e.gateway.Dir = dir
+	e.gateway.Stdout = logFile
+	e.gateway.Stderr = logFile
+
+	if err = e.gateway.Start(); err != nil {
+		return e, err
+	}
+
+	e.exited = make(chan error, 1)
+	go func() {
+		e.exited <- e.gateway.Wait()
+		_ = logFile.Close()
+	}()
+
+	deadline := time.Now().Add(60 * time.Second)
+
+	for time.Now().Before(deadline) {
+
+		select {
+		case err = <-e.exited:
+			e.gateway = nil
+			return e, fmt.Errorf("gateway exited: %v", err)
+		default:
+		}
+
+		if response, err := http.Get(e.baseURL + "/readyz"); err == nil {
+			_ = response.Body.Close()
+			if response.StatusCode == http.StatusOK {
+				return e, nil
+			}
+		}
+
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	return e, fmt.Errorf("gateway is not ready after 60s")
+}
+
+func (e *environment) stop() {
+
+	if e.gateway != nil && e.gateway.Process != nil {
+
+		_ = e.gateway.Process.Signal(os.Interrupt)
+
+		select {
+		case <-e.exited:
+		case <-time.After(10 * time.Second):
+			_ = e.gateway.Process.Kill()
+		}
+	}
+
+	if e.mongo != nil {
+		e.mongo.close()
+	}
+
+	if e.redis != nil {
+		e.redis.Close()
+	}
+
+	e.provider.close()
+}
+
+func gatewayConfig(port int, redisAddr, mongoURI string) string {
+	return fmt.Sprintf(`api_server_address: "127.0.0.1:%d"
+
+redis:
+  default:
+    address: %s
+    db: 0
+
+mongodb:
+  uri: %s
+  database: %s
+
+logger:
+  level: "all"
+  stdout: true
+  stdoutColorDisabled: true
+
+http:
+  timeout: 10
+
+api:
+  retry: 2
+
+retry_policy:
+  default:
+    base_delay: 0
+    not_retry_status:
+      - 400
+
+log_writer:
+  flush_interval: 1
+  spill_file: ./spill.log
+  sinks:
+    - type: mongodb
+
+shutdown:
+  timeout: 3
+  drain_timeout: 3
+
+core:
+  warmup: "sync"
+  change_stream:
+    node: "e2e"
+    block: 1
+
+error:
+  auto_disabled:
+    - "Incorrect API key provided"
+`, port, redisAddr, mongoURI, DATABASE)
+}
+
+func freePort() (int, error) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// 测试失败时输出网关日志的最后部分
+func printLog(dir string) {
+
+	data, err := os.ReadFile(filepath.Join(dir, "gateway.log"))
+	if err != nil {
+		return
+	}
+
+	lines := strings.Split(string(data), "\n")
+	if len(lines) > 200 {
+		lines = lines[len(lines)-200:]
+	}
+
+	fmt.Fprintln(os.Stderr, "==== gateway log ====")
+	fmt.Fprintln(os.Stderr, strings.Join(lines, "\n"))
+}
+
+// 测试数据
+const (
+	CORP_OPENAI    = "corp-openai"
+	CORP_ANTHROPIC = "corp-anthropic"
+	CORP_GOOGLE    = "corp-google"
+
+	MODEL_GPT      = "e2e-gpt"
+	MODEL_CLAUDE   = "e2e-claude"
+	MODEL_GEMINI   = "e2e-gemini"
+	MODEL_RETRY    = "e2e-retry"
+	MODEL_ERROR    = "e2e-error"
+	MODEL_PRIMARY  = "e2e-primary"
+	MODEL_BACKUP   = "e2e-backup"
+	MODEL_DISABLED = "e2e-disabled"
+
+	USER_DEFAULT = 1001
+	USER_USAGE   = 1002
+	USER_EMPTY   = 1003
+
+	USER_DEFAULT_QUOTA = 100000000
+	USER_USAGE_QUOTA   = 1000
+)
+
+// 应用密钥, 前后两半中的数字分别为用户ID和应用ID, 应用ID与用户ID相同
+func secretKey(userId int) string {
+	half := fmt.Sprintf("%16d", userId)
+	half = strings.ReplaceAll(half, " ", "x")
+	return "sk-FastAPI" + half + half
+}
+
+// 模型密钥
+func modelKey(model string, n int) string {
+	return fmt.Sprintf("pk-%s-%d", model, n)
+}
+
+func seed(mongo *mongoServer, p *provider) {
+
+	mongo.insert(DATABASE, do.CORP_COLLECTION,
+		entity.Corp{Id: CORP_OPENAI, Name: "OpenAI", Code: "OpenAI", IsPublic: true, Status: 1},
+		entity.Corp{Id: CORP_ANTHROPIC, Name: "Anthropic", Code: "Anthropic", IsPublic: true, Status: 1},
+		entity.Corp{Id: CORP_GOOGLE, Name: "Google", Code: "Google", IsPublic: true, Status: 1},
+	)
+
+	models := []struct {
+		name     string
+		corp     string
+		format   string
+		keys     int
+		fallback string
+	}{
+		{MODEL_GPT, CORP_OPENAI, FORMAT_OPENAI, 1, ""},
+		{MODEL_CLAUDE, CORP_ANTHROPIC, FORMAT_ANTHROPIC, 1, ""},
+		{MODEL_GEMINI, CORP_GOOGLE, FORMAT_GEMINI, 1, ""},
+		{MODEL_RETRY, CORP_OPENAI, FORMAT_OPENAI, 1, ""},
+		{MODEL_ERROR, CORP_OPENAI, FORMAT_OPENAI, 1, ""},
+		{MODEL_PRIMARY, CORP_OPENAI, FORMAT_OPENAI, 1, MODEL_BACKUP},
+		{MODEL_BACKUP, CORP_OPENAI, FORMAT_OPENAI, 1, ""},
+		{MODEL_DISABLED, CORP_OPENAI, FORMAT_OPENAI, 2, ""},
+	}
+
+	modelIds := make([]string, 0, len(models))
+
+	for _, m := range models {
+
+		model := entity.Model{
+			Id:        "model-" + m.name,
+			Corp:      m.corp,
+			Name:      m.name,
+			Model:     m.name,
+			Type:      1,
+			BaseUrl:   p.baseURL(m.format),
+			TextQuota: common.TextQuota{BillingMethod: 1, PromptRatio: 1, CompletionRatio: 1},
+			IsPublic:  true,
+			Status:    1,
+		}
+
+		if m.fallback != "" {
+			model.IsEnableFallback = true
+			model.FallbackConfig = &common.FallbackConfig{Model: "model-" + m.fallback, ModelName: m.fallback}
+		}
+
+		mongo.insert(DATABASE, do.MODEL_COLLECTION, model)
+
+		for n := 1; n <= m.keys; n++ {
+			key := toM(entity.Key{
+				Id:     fmt.Sprintf("key-%s-%d", m.name, n),
+				Corp:   m.corp,
+				Key:    modelKey(m.name, n),
+				Type:   2,
+				Weight: 1,
+				Models: []string{model.Id},
+				Status: 1,
+			})
+
+			// 与管理后台一致, 显式写入是否代理专用
+			key["is_agents_only"] = false
+
+			mongo.insert(DATABASE, do.KEY_COLLECTION, key)
+		}
+
+		modelIds = append(modelIds, model.Id)
+	}
+
+	for userId, quota := range map[int]int{USER_DEFAULT: USER_DEFAULT_QUOTA, USER_USAGE: USER_USAGE_QUOTA, USER_EMPTY: 0} {
+
+		sk := secretKey(userId)
+
+		mongo.insert(DATABASE, do.USER_COLLECTION, entity.User{
+			Id:     fmt.Sprintf("user-%d", userId),
+			UserId: userId,
+			Name:   fmt.Sprintf("e2e-%d", userId),
+			Quota:  quota,
+			Models: modelIds,
+			Status: 1,
+		})
+
+		mongo.insert(DATABASE, do.APP_COLLECTION, entity.App{
+			Id:     fmt.Sprintf("app-%d", userId),
+			AppId:  userId,
+			Name:   fmt.Sprintf("e2e-%d", userId),
+			UserId: userId,
+			Status: 1,
+		})
+
+		mongo.insert(DATABASE, do.KEY_COLLECTION, entity.Key{
+			Id:      fmt.Sprintf("key-app-%d", userId),
+			UserId:  userId,
+			AppId:   userId,
+			Key:     crypto.KeyPrefix(sk),
+			KeyHash: crypto.HashKey(sk),
+			Type:    1,
+			Status:  1,
+		})
+	}
+}
+
+// 调用网关接口
+func post(t *testing.T, sk, path string, body any) *http.Response {
+
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, env.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+sk)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = response.Body.Close()
+	})
+
+	return response
+}
+
+func chatRequest(model string, stream bool) map[string]any {
+
+	request := map[string]any{
+		"model":    model,
+		"messages": []any{map[string]any{"role": "user", "content": "Hello"}},
+	}
+
+	if stream {
+		request["stream"] = true
+		request["stream_options"] = map[string]any{"include_usage": true}
+	}
+
+	return request
+}
+
+// 非流式对话, 返回状态码和响应内容
+func chat(t *testing.T, sk, model string) (int, map[string]any) {
+
+	t.Helper()
+
+	response := post(t, sk, "/v1/chat/completions", chatRequest(model, false))
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := make(map[string]any)
+	if err = json.Unmarshal(data, &body); err != nil {
+		t.Fatalf("decode response error: %v, status: %d, body: %s", err, response.StatusCode, data)
+	}
+
+	return response.StatusCode, body
+}
+
+// 流式对话, 返回状态码和全部数据块
+func chatStream(t *testing.T, sk, model string) (int, []map[string]any) {
+
+	t.Helper()
+
+	response := post(t, sk, "/v1/chat/completions", chatRequest(model, true))
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		t.Logf("stream response: %s", body)
+		return response.StatusCode, nil
+	}
+
+	chunks := make([]map[string]any, 0)
+	scanner := bufio.NewScanner(response.Body)
+
+	for scanner.Scan() {
+
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		chunk := make(map[string]any)
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("decode chunk error: %v, data: %s", err, data)
+		}
+
+		chunks = append(chunks, chunk)
+	}
+
+	return response.StatusCode, chunks
+}
+
+// 回答内容
+func content(body map[string]any) string {
+
+	choices, _ := body["choices"].([]any)
+	if len(choices) == 0 {
+		return ""
+	}
+
+	choice, _ := choices[0].(map[string]any)
+	if message, ok := choice["message"].(map[string]any); ok {
+		text, _ := message["content"].(string)
+		return text
+	}
+
+	if delta, ok := choice["delta"].(map[string]any); ok {
+		text, _ := delta["content"].(string)
+		return text
+	}
+
+	return ""
+}
+
+// 错误码
+func errorCode(body map[string]any) string {
+
+	if e, ok := body["error"].(map[string]any); ok {
+		code, _ := e["code"].(string)
+		return code
+	}
+
+	return ""
+}
+
+// 等待异步处理完成
+func eventually(t *testing.T, message string, condition func() bool) {
+
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for time.Now().Before(deadline) {
+
+		if condition() {
+			return
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Fatalf("timeout waiting for %s", message)
+}
+
+func findOne(collection string, filter bson.M) bson.M {
+
+	if documents := env.mongo.find(DATABASE, collection, filter); len(documents) > 0 {
+		return documents[0]
+	}
+
+	return nil
+}
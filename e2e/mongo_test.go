@@ -0,0 +1,1091 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 内嵌的MongoDB, 实现网关用到的命令和操作符, 数据只保存在内存中
+type mongoServer struct {
+	listener    net.Listener
+	mu          sync.Mutex
+	collections map[string][]bson.M // [库名.集合名]文档列表
+	connections atomic.Int32
+	requestId   atomic.Int32
+}
+
+func newMongoServer() (*mongoServer, error) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &mongoServer{
+		listener:    listener,
+		collections: make(map[string][]bson.M),
+	}
+
+	go s.serve()
+
+	return s, nil
+}
+
+func (s *mongoServer) close() {
+	_ = s.listener.Close()
+}
+
+func (s *mongoServer) uri() string {
+	return fmt.Sprintf("mongodb://%s/?directConnection=true", s.listener.Addr().String())
+}
+
+// 写入文档, 文档按bson序列化, 可以是实体或bson.M
+func (s *mongoServer) insert(database, collection string, documents ...interface{}) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ns := database + "." + collection
+
+	for _, document := range documents {
+		s.collections[ns] = append(s.collections[ns], toM(document))
+	}
+}
+
+// 查询文档, 返回副本
+func (s *mongoServer) find(database, collection string, filter bson.M) []bson.M {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]bson.M, 0)
+	for _, document := range s.collections[database+"."+collection] {
+		if matchDocument(document, filter) {
+			result = append(result, toM(document))
+		}
+	}
+
+	return result
+}
+
+func (s *mongoServer) serve() {
+	for {
+
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *mongoServer) handle(conn net.Conn) {
+
+	defer conn.Close()
+
+	connectionId := s.connections.Add(1)
+
+	for {
+
+		size := make([]byte, 4)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return
+		}
+
+		message := make([]byte, binary.LittleEndian.Uint32(size))
+		copy(message, size)
+
+		if _, err := io.ReadFull(conn, message[4:]); err != nil {
+			return
+		}
+
+		_, requestId, _, opcode, body, ok := wiremessage.ReadHeader(message)
+		if !ok {
+			return
+		}
+
+		var reply []byte
+
+		switch opcode {
+		case wiremessage.OpQuery:
+			reply = s.handleQuery(requestId, body, connectionId)
+		case wiremessage.OpMsg:
+			reply = s.handleMsg(requestId, body, connectionId)
+		default:
+			return
+		}
+
+		if reply == nil {
+			continue
+		}
+
+		if _, err := conn.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// 旧版协议只用于驱动的首次握手
+func (s *mongoServer) handleQuery(requestId int32, body []byte, connectionId int32) []byte {
+
+	_, body, _ = wiremessage.ReadQueryFlags(body)
+	_, body, _ = wiremessage.ReadQueryFullCollectionName(body)
+	_, body, _ = wiremessage.ReadQueryNumberToSkip(body)
+	_, body, _ = wiremessage.ReadQueryNumberToReturn(body)
+
+	query, _, ok := wiremessage.ReadQueryQuery(body)
+	if !ok {
+		return nil
+	}
+
+	if wrapped, ok := query.Lookup("$query").DocumentOK(); ok {
+		query = wrapped
+	}
+
+	document := s.command(query, nil, connectionId)
+
+	index, reply := wiremessage.AppendHeaderStart(nil, s.requestId.Add(1), requestId, wiremessage.OpReply)
+	reply = wiremessage.AppendReplyFlags(reply, 0)
+	reply = wiremessage.AppendReplyCursorID(reply, 0)
+	reply = wiremessage.AppendReplyStartingFrom(reply, 0)
+	reply = wiremessage.AppendReplyNumberReturned(reply, 1)
+	reply = append(reply, document...)
+
+	return bsoncore.UpdateLength(reply, index, int32(len(reply[index:])))
+}
+
+func (s *mongoServer) handleMsg(requestId int32, body []byte, connectionId int32) []byte {
+
+	flags, body, ok := wiremessage.ReadMsgFlags(body)
+	if !ok {
+		return nil
+	}
+
+	if flags&wiremessage.ChecksumPresent != 0 {
+		body = body[:len(body)-4]
+	}
+
+	var (
+		command   bsoncore.Document
+		sequences = make(map[string][]bsoncore.Document)
+	)
+
+	for len(body) > 0 {
+
+		var sectionType wiremessage.SectionType
+		if sectionType, body, ok = wiremessage.ReadMsgSectionType(body); !ok {
+			return nil
+		}
+
+		switch sectionType {
+		case wiremessage.SingleDocument:
+			if command, body, ok = wiremessage.ReadMsgSectionSingleDocument(body); !ok {
+				return nil
+			}
+		case wiremessage.DocumentSequence:
+			var (
+				identifier string
+				documents  []bsoncore.Document
+			)
+			if identifier, documents, body, ok = wiremessage.ReadMsgSectionDocumentSequence(body); !ok {
+				return nil
+			}
+			sequences[identifier] = documents
+		default:
+			return nil
+		}
+	}
+
+	document := s.command(command, sequences, connectionId)
+
+	// 不需要应答的写入
+	if flags&wiremessage.MoreToCome != 0 {
+		return nil
+	}
+
+	index, reply := wiremessage.AppendHeaderStart(nil, s.requestId.Add(1), requestId, wiremessage.OpMsg)
+	reply = wiremessage.AppendMsgFlags(reply, 0)
+	reply = wiremessage.AppendMsgSectionType(reply, wiremessage.SingleDocument)
+	reply = append(reply, document...)
+
+	return bsoncore.UpdateLength(reply, index, int32(len(reply[index:])))
+}
+
+func (s *mongoServer) command(command bsoncore.Document, sequences map[string][]bsoncore.Document, connectionId int32) bsoncore.Document {
+
+	elements, err := command.Elements()
+	if err != nil || len(elements) == 0 {
+		return commandError(fmt.Errorf("invalid command"))
+	}
+
+	name := elements[0].Key()
+	database, _ := command.Lookup("$db").StringValueOK()
+	collection, _ := command.Lookup(name).StringValueOK()
+	ns := database + "." + collection
+
+	var result bson.D
+
+	switch strings.ToLower(name) {
+	case "hello", "ismaster":
+		result = bson.D{
+			{Key: "helloOk", Value: true},
+			{Key: "ismaster", Value: true},
+			{Key: "isWritablePrimary", Value: true},
+			{Key: "maxBsonObjectSize", Value: int32(16 * 1024 * 1024)},
+			{Key: "maxMessageSizeBytes", Value: int32(48000000)},
+			{Key: "maxWriteBatchSize", Value: int32(100000)},
+			{Key: "localTime", Value: time.Now()},
+			{Key: "logicalSessionTimeoutMinutes", Value: int32(30)},
+			{Key: "connectionId", Value: connectionId},
+			{Key: "minWireVersion", Value: int32(0)},
+			{Key: "maxWireVersion", Value: int32(17)},
+			{Key: "readOnly", Value: false},
+		}
+	case "ping", "endsessions", "killcursors", "createindexes", "dropindexes", "getlasterror":
+	case "buildinfo":
+		result = bson.D{{Key: "version", Value: "6.0.0"}}
+	case "find":
+		result, err = s.findCommand(ns, command)
+	case "aggregate":
+		result, err = s.aggregateCommand(ns, command)
+	case "count":
+		result, err = s.countCommand(ns, command)
+	case "insert":
+		result, err = s.insertCommand(ns, arguments(command, sequences, "documents"))
+	case "update":
+		result, err = s.updateCommand(ns, arguments(command, sequences, "updates"))
+	case "delete":
+		result, err = s.deleteCommand(ns, arguments(command, sequences, "deletes"))
+	case "findandmodify":
+		result, err = s.findAndModifyCommand(ns, command)
+	default:
+		err = fmt.Errorf("no such command: '%s'", name)
+	}
+
+	if err != nil {
+		return commandError(err)
+	}
+
+	bytes, _ := bson.Marshal(append(result, bson.E{Key: "ok", Value: 1.0}))
+
+	return bytes
+}
+
+func commandError(err error) bsoncore.Document {
+	bytes, _ := bson.Marshal(bson.D{{Key: "ok", Value: 0.0}, {Key: "errmsg", Value: err.Error()}, {Key: "code", Value: int32(59)}})
+	return bytes
+}
+
+// 写入命令的参数可能在命令中, 也可能在文档序列中
+func arguments(command bsoncore.Document, sequences map[string][]bsoncore.Document, key string) []bson.M {
+
+	result := make([]bson.M, 0)
+
+	if documents, ok := sequences[key]; ok {
+		for _, document := range documents {
+			result = append(result, toM(bson.Raw(document)))
+		}
+		return result
+	}
+
+	if array, ok := command.Lookup(key).ArrayOK(); ok {
+		values, _ := array.Values()
+		for _, value := range values {
+			result = append(result, toM(bson.Raw(value.Document())))
+		}
+	}
+
+	return result
+}
+
+func (s *mongoServer) findCommand(ns string, command bsoncore.Document) (bson.D, error) {
+
+	filter := lookupM(command, "filter")
+
+	var sortSpec bson.D
+	if document, ok := command.Lookup("sort").DocumentOK(); ok {
+		_ = bson.Unmarshal(document, &sortSpec)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	documents := make([]bson.M, 0)
+	for _, document := range s.collections[ns] {
+		if matchDocument(document, filter) {
+			documents = append(documents, document)
+		}
+	}
+
+	sortDocuments(documents, sortSpec)
+
+	documents = skipLimit(documents, lookupInt(command, "skip"), lookupInt(command, "limit"))
+
+	return cursorResult(ns, documents), nil
+}
+
+func (s *mongoServer) aggregateCommand(ns string, command bsoncore.Document) (bson.D, error) {
+
+	var pipeline []bson.M
+	if array, ok := command.Lookup("pipeline").ArrayOK(); ok {
+		values, _ := array.Values()
+		for _, value := range values {
+			pipeline = append(pipeline, toM(bson.Raw(value.Document())))
+		}
+	}
+
+	s.mu.Lock()
+	documents := make([]bson.M, len(s.collections[ns]))
+	copy(documents, s.collections[ns])
+	s.mu.Unlock()
+
+	for _, stage := range pipeline {
+		for operator, argument := range stage {
+			switch operator {
+			case "$match":
+				matched := make([]bson.M, 0)
+				for _, document := range documents {
+					if matchDocument(document, toM(argument)) {
+						matched = append(matched, document)
+					}
+				}
+				documents = matched
+			case "$sort":
+				var sortSpec bson.D
+				bytes, _ := bson.Marshal(argument)
+				_ = bson.Unmarshal(bytes, &sortSpec)
+				sortDocuments(documents, sortSpec)
+			case "$skip":
+				documents = skipLimit(documents, toInt(argument), 0)
+			case "$limit":
+				documents = skipLimit(documents, 0, toInt(argument))
+			case "$count":
+				documents = []bson.M{{argument.(string): int64(len(documents))}}
+			case "$group":
+				documents = group(documents, toM(argument))
+			case "$project":
+			default:
+				return nil, fmt.Errorf("unsupported pipeline stage: %s", operator)
+			}
+		}
+	}
+
+	return cursorResult(ns, documents), nil
+}
+
+func (s *mongoServer) countCommand(ns string, command bsoncore.Document) (bson.D, error) {
+
+	filter := lookupM(command, "query")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, document := range s.collections[ns] {
+		if matchDocument(document, filter) {
+			n++
+		}
+	}
+
+	return bson.D{{Key: "n", Value: int32(n)}}, nil
+}
+
+func (s *mongoServer) insertCommand(ns string, documents []bson.M) (bson.D, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, document := range documents {
+
+		if _, ok := document["_id"]; !ok {
+			document["_id"] = primitive.NewObjectID()
+		}
+
+		s.collections[ns] = append(s.collections[ns], document)
+	}
+
+	return bson.D{{Key: "n", Value: int32(len(documents))}}, nil
+}
+
+func (s *mongoServer) updateCommand(ns string, updates []bson.M) (bson.D, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		n        int
+		modified int
+		upserted bson.A
+	)
+
+	for i, update := range updates {
+
+		filter := toM(update["q"])
+		multi, _ := update["multi"].(bool)
+		upsert, _ := update["upsert"].(bool)
+
+		matched := 0
+		for _, document := range s.collections[ns] {
+
+			if !matchDocument(document, filter) {
+				continue
+			}
+
+			if err := applyUpdate(document, toM(update["u"]), false); err != nil {
+				return nil, err
+			}
+
+			matched++
+
+			if !multi {
+				break
+			}
+		}
+
+		if matched == 0 && upsert {
+
+			document := upsertDocument(filter)
+			if err := applyUpdate(document, toM(update["u"]), true); err != nil {
+				return nil, err
+			}
+
+			if _, ok := document["_id"]; !ok {
+				document["_id"] = primitive.NewObjectID()
+			}
+
+			s.collections[ns] = append(s.collections[ns], document)
+			upserted = append(upserted, bson.D{{Key: "index", Value: int32(i)}, {Key: "_id", Value: document["_id"]}})
+			n++
+
+			continue
+		}
+
+		n += matched
+		modified += matched
+	}
+
+	result := bson.D{{Key: "n", Value: int32(n)}, {Key: "nModified", Value: int32(modified)}}
+	if len(upserted) > 0 {
+		result = append(result, bson.E{Key: "upserted", Value: upserted})
+	}
+
+	return result, nil
+}
+
+func (s *mongoServer) deleteCommand(ns string, deletes []bson.M) (bson.D, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, del := range deletes {
+
+		filter := toM(del["q"])
+		limit := toInt(del["limit"])
+
+		remaining := make([]bson.M, 0, len(s.collections[ns]))
+		deleted := 0
+
+		for _, document := range s.collections[ns] {
+			if (limit == 0 || deleted < limit) && matchDocument(document, filter) {
+				deleted++
+				continue
+			}
+			remaining = append(remaining, document)
+		}
+
+		s.collections[ns] = remaining
+		n += deleted
+	}
+
+	return bson.D{{Key: "n", Value: int32(n)}}, nil
+}
+
+func (s *mongoServer) findAndModifyCommand(ns string, command bsoncore.Document) (bson.D, error) {
+
+	filter := lookupM(command, "query")
+	remove, _ := command.Lookup("remove").BooleanOK()
+	returnNew, _ := command.Lookup("new").BooleanOK()
+
+	var sortSpec bson.D
+	if document, ok := command.Lookup("sort").DocumentOK(); ok {
+		_ = bson.Unmarshal(document, &sortSpec)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	documents := s.collections[ns]
+
+	candidates := make([]bson.M, 0)
+	for _, document := range documents {
+		if matchDocument(document, filter) {
+			candidates = append(candidates, document)
+		}
+	}
+
+	sortDocuments(candidates, sortSpec)
+
+	if len(candidates) == 0 {
+		return bson.D{{Key: "lastErrorObject", Value: bson.D{{Key: "n", Value: int32(0)}}}, {Key: "value", Value: nil}}, nil
+	}
+
+	target := candidates[0]
+	value := toM(target)
+
+	if remove {
+
+		remaining := make([]bson.M, 0, len(documents))
+		for _, document := range documents {
+			if !sameDocument(document, target) {
+				remaining = append(remaining, document)
+			}
+		}
+
+		s.collections[ns] = remaining
+
+	} else {
+
+		if err := applyUpdate(target, lookupM(command, "update"), false); err != nil {
+			return nil, err
+		}
+
+		if returnNew {
+			value = toM(target)
+		}
+	}
+
+	return bson.D{{Key: "lastErrorObject", Value: bson.D{{Key: "n", Value: int32(1)}}}, {Key: "value", Value: value}}, nil
+}
+
+func cursorResult(ns string, documents []bson.M) bson.D {
+
+	batch := make(bson.A, 0, len(documents))
+	for _, document := range documents {
+		batch = append(batch, document)
+	}
+
+	return bson.D{{Key: "cursor", Value: bson.D{{Key: "firstBatch", Value: batch}, {Key: "id", Value: int64(0)}, {Key: "ns", Value: ns}}}}
+}
+
+func lookupM(document bsoncore.Document, key string) bson.M {
+
+	if value, ok := document.Lookup(key).DocumentOK(); ok {
+		return toM(bson.Raw(value))
+	}
+
+	return bson.M{}
+}
+
+func lookupInt(document bsoncore.Document, key string) int {
+
+	if value, ok := document.Lookup(key).AsInt64OK(); ok {
+		return int(value)
+	}
+
+	return 0
+}
+
+// 按bson序列化后转换成bson.M, 同时用于深拷贝
+func toM(value interface{}) bson.M {
+
+	result := bson.M{}
+
+	switch v := value.(type) {
+	case nil:
+		return result
+	case bson.Raw:
+		_ = bson.Unmarshal(v, &result)
+		return result
+	}
+
+	bytes, err := bson.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+
+	if err = bson.Unmarshal(bytes, &result); err != nil {
+		panic(err)
+	}
+
+	return result
+}
+
+func toInt(value interface{}) int {
+	if number, ok := toFloat(value); ok {
+		return int(number)
+	}
+	return 0
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func skipLimit(documents []bson.M, skip, limit int) []bson.M {
+
+	if skip > 0 {
+		if skip >= len(documents) {
+			return []bson.M{}
+		}
+		documents = documents[skip:]
+	}
+
+	if limit < 0 {
+		limit = -limit
+	}
+
+	if limit > 0 && limit < len(documents) {
+		documents = documents[:limit]
+	}
+
+	return documents
+}
+
+func sameDocument(a, b bson.M) bool {
+	return compareValues(a["_id"], b["_id"]) == 0
+}
+
+func sortDocuments(documents []bson.M, sortSpec bson.D) {
+
+	if len(sortSpec) == 0 {
+		return
+	}
+
+	sort.SliceStable(documents, func(i, j int) bool {
+		for _, e := range sortSpec {
+
+			a, _ := lookupPath(documents[i], e.Key)
+			b, _ := lookupPath(documents[j], e.Key)
+
+			if c := compareValues(a, b); c != 0 {
+				if toInt(e.Value) < 0 {
+					return c > 0
+				}
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+// 分组, 支持常量和字段作为分组键, 累加器只支持$sum
+func group(documents []bson.M, spec bson.M) []bson.M {
+
+	groups := make([]bson.M, 0)
+	index := make(map[string]bson.M)
+
+	for _, document := range documents {
+
+		id := evaluate(document, spec["_id"])
+		key := fmt.Sprint(id)
+
+		result, ok := index[key]
+		if !ok {
+			result = bson.M{"_id": id}
+			index[key] = result
+			groups = append(groups, result)
+		}
+
+		for field, accumulator := range spec {
+
+			if field == "_id" {
+				continue
+			}
+
+			if sum, ok := toM(accumulator)["$sum"]; ok {
+				result[field] = addValues(result[field], evaluate(document, sum))
+			}
+		}
+	}
+
+	return groups
+}
+
+func evaluate(document bson.M, expression interface{}) interface{} {
+
+	if field, ok := expression.(string); ok && strings.HasPrefix(field, "$") {
+		value, _ := lookupPath(document, field[1:])
+		return value
+	}
+
+	return expression
+}
+
+func lookupPath(document bson.M, path string) (interface{}, bool) {
+
+	var current interface{} = document
+
+	for _, key := range strings.Split(path, ".") {
+
+		m, ok := current.(bson.M)
+		if !ok {
+			return nil, false
+		}
+
+		if current, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+func setPath(document bson.M, path string, value interface{}) {
+
+	keys := strings.Split(path, ".")
+	current := document
+
+	for _, key := range keys[:len(keys)-1] {
+
+		next, ok := current[key].(bson.M)
+		if !ok {
+			next = bson.M{}
+			current[key] = next
+		}
+
+		current = next
+	}
+
+	current[keys[len(keys)-1]] = value
+}
+
+func unsetPath(document bson.M, path string) {
+
+	keys := strings.Split(path, ".")
+	current := document
+
+	for _, key := range keys[:len(keys)-1] {
+
+		next, ok := current[key].(bson.M)
+		if !ok {
+			return
+		}
+
+		current = next
+	}
+
+	delete(current, keys[len(keys)-1])
+}
+
+func matchDocument(document bson.M, filter bson.M) bool {
+
+	for key, condition := range filter {
+
+		switch key {
+		case "$or", "$and", "$nor":
+
+			matched := 0
+			conditions, _ := condition.(bson.A)
+
+			for _, c := range conditions {
+				if matchDocument(document, toM(c)) {
+					matched++
+				}
+			}
+
+			if (key == "$or" && matched == 0) || (key == "$and" && matched != len(conditions)) || (key == "$nor" && matched > 0) {
+				return false
+			}
+
+		default:
+			value, exists := lookupPath(document, key)
+			if !matchValue(value, exists, condition) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func matchValue(value interface{}, exists bool, condition interface{}) bool {
+
+	operators, ok := condition.(bson.M)
+	if !ok || len(operators) == 0 {
+		return equalsAny(value, condition)
+	}
+
+	for operator := range operators {
+		if !strings.HasPrefix(operator, "$") {
+			return equalsAny(value, condition)
+		}
+	}
+
+	for operator, argument := range operators {
+
+		var matched bool
+
+		switch operator {
+		case "$eq":
+			matched = equalsAny(value, argument)
+		case "$ne":
+			matched = !equalsAny(value, argument)
+		case "$in", "$nin":
+			arguments, _ := argument.(bson.A)
+			for _, a := range arguments {
+				if equalsAny(value, a) {
+					matched = true
+					break
+				}
+			}
+			if operator == "$nin" {
+				matched = !matched
+			}
+		case "$exists":
+			matched = exists == truthy(argument)
+		case "$gt":
+			matched = exists && compareValues(value, argument) > 0
+		case "$gte":
+			matched = exists && compareValues(value, argument) >= 0
+		case "$lt":
+			matched = exists && compareValues(value, argument) < 0
+		case "$lte":
+			matched = exists && compareValues(value, argument) <= 0
+		case "$regex":
+			pattern, _ := argument.(string)
+			if regex, ok := argument.(primitive.Regex); ok {
+				pattern = regex.Pattern
+			}
+			str, _ := value.(string)
+			matched, _ = regexp.MatchString(pattern, str)
+		default:
+			return false
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// 数组字段匹配其中任一元素
+func equalsAny(value, target interface{}) bool {
+
+	if array, ok := value.(bson.A); ok {
+
+		if _, ok := target.(bson.A); !ok {
+			for _, element := range array {
+				if compareValues(element, target) == 0 {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	return compareValues(value, target) == 0
+}
+
+func truthy(value interface{}) bool {
+
+	if b, ok := value.(bool); ok {
+		return b
+	}
+
+	return toInt(value) != 0
+}
+
+// 比较两个值, 数字按数值比较, 不同类型按类型顺序比较
+func compareValues(a, b interface{}) int {
+
+	if x, ok := toFloat(a); ok {
+		if y, ok := toFloat(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	if ta, tb := typeOrder(a), typeOrder(b); ta != tb {
+		if ta < tb {
+			return -1
+		}
+		return 1
+	}
+
+	switch x := a.(type) {
+	case nil:
+		return 0
+	case string:
+		return strings.Compare(x, b.(string))
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case primitive.ObjectID:
+		return strings.Compare(x.Hex(), b.(primitive.ObjectID).Hex())
+	case primitive.DateTime:
+		y := b.(primitive.DateTime)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+
+	ba, _ := bson.Marshal(bson.M{"v": a})
+	bb, _ := bson.Marshal(bson.M{"v": b})
+
+	return strings.Compare(string(ba), string(bb))
+}
+
+func typeOrder(value interface{}) int {
+
+	switch value.(type) {
+	case nil:
+		return 0
+	case int, int32, int64, float64:
+		return 1
+	case string:
+		return 2
+	case bson.M:
+		return 3
+	case bson.A:
+		return 4
+	case primitive.ObjectID:
+		return 5
+	case bool:
+		return 6
+	case primitive.DateTime:
+		return 7
+	}
+
+	return 8
+}
+
+func addValues(a, b interface{}) interface{} {
+
+	if a == nil {
+		a = int64(0)
+	}
+
+	x, _ := toFloat(a)
+	y, _ := toFloat(b)
+
+	_, fa := a.(float64)
+	_, fb := b.(float64)
+
+	if fa || fb {
+		return x + y
+	}
+
+	return int64(x) + int64(y)
+}
+
+func applyUpdate(document bson.M, update bson.M, isInsert bool) error {
+
+	isReplacement := true
+	for key := range update {
+		if strings.HasPrefix(key, "$") {
+			isReplacement = false
+		}
+	}
+
+	if isReplacement {
+
+		id := document["_id"]
+
+		for key := range document {
+			delete(document, key)
+		}
+
+		for key, value := range update {
+			document[key] = value
+		}
+
+		if id != nil {
+			document["_id"] = id
+		}
+
+		return nil
+	}
+
+	for operator, fields := range update {
+		for path, value := range toM(fields) {
+			switch operator {
+			case "$set":
+				setPath(document, path, value)
+			case "$setOnInsert":
+				if isInsert {
+					setPath(document, path, value)
+				}
+			case "$unset":
+				unsetPath(document, path)
+			case "$inc":
+				current, _ := lookupPath(document, path)
+				setPath(document, path, addValues(current, value))
+			case "$push":
+				current, _ := lookupPath(document, path)
+				array, _ := current.(bson.A)
+				setPath(document, path, append(array, value))
+			default:
+				return fmt.Errorf("unsupported update operator: %s", operator)
+			}
+		}
+	}
+
+	return nil
+}
+
+// 未匹配时插入的文档, 取过滤条件中的等值字段
+func upsertDocument(filter bson.M) bson.M {
+
+	document := bson.M{}
+
+	for key, condition := range filter {
+
+		if strings.HasPrefix(key, "$") {
+			continue
+		}
+
+		if operators, ok := condition.(bson.M); ok {
+			if eq, ok := operators["$eq"]; ok {
+				setPath(document, key, eq)
+			}
+			continue
+		}
+
+		setPath(document, key, condition)
+	}
+
+	return document
+}
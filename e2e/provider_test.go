@@ -0,0 +1,401 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FORMAT_OPENAI    = "openai"
+	FORMAT_ANTHROPIC = "anthropic"
+	FORMAT_GEMINI    = "gemini"
+
+	PROVIDER_PROMPT_TOKENS     = 10
+	PROVIDER_COMPLETION_TOKENS = 20
+)
+
+// 上游请求记录
+type providerRequest struct {
+	Format string
+	Path   string
+	Key    string
+	Model  string
+	Stream bool
+	Body   map[string]any
+}
+
+// 预设的上游响应, Status为0时正常响应
+type providerReply struct {
+	Status  int
+	Code    string
+	Message string
+	Delay   time.Duration
+}
+
+// 模拟上游服务, 支持OpenAI, Anthropic和Gemini格式, 可按密钥预设错误响应
+type provider struct {
+	server   *httptest.Server
+	mu       sync.Mutex
+	requests []providerRequest
+	replies  map[string][]providerReply // [密钥]按顺序返回的响应
+}
+
+func newProvider() *provider {
+
+	p := &provider{
+		replies: make(map[string][]providerReply),
+	}
+
+	p.server = httptest.NewServer(http.HandlerFunc(p.handle))
+
+	return p
+}
+
+func (p *provider) close() {
+	p.server.Close()
+}
+
+func (p *provider) baseURL(format string) string {
+	switch format {
+	case FORMAT_ANTHROPIC:
+		return p.server.URL + "/anthropic/v1"
+	case FORMAT_GEMINI:
+		return p.server.URL + "/gemini/v1beta"
+	}
+	return p.server.URL + "/openai/v1"
+}
+
+// 预设密钥接下来的响应, 用完后正常响应
+func (p *provider) script(key string, replies ...providerReply) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.replies[key] = append(p.replies[key], replies...)
+}
+
+// 使用指定密钥的请求
+func (p *provider) requestsByKey(key string) []providerRequest {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	requests := make([]providerRequest, 0)
+	for _, request := range p.requests {
+		if request.Key == key {
+			requests = append(requests, request)
+		}
+	}
+
+	return requests
+}
+
+func (p *provider) handle(w http.ResponseWriter, r *http.Request) {
+
+	request := providerRequest{
+		Path: r.URL.Path,
+		Body: make(map[string]any),
+	}
+
+	if body, err := io.ReadAll(r.Body); err == nil && len(body) > 0 {
+		_ = json.Unmarshal(body, &request.Body)
+	}
+
+	request.Model, _ = request.Body["model"].(string)
+	request.Stream, _ = request.Body["stream"].(bool)
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/chat/completions"):
+		request.Format = FORMAT_OPENAI
+		request.Key = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	case strings.HasSuffix(r.URL.Path, "/messages"):
+		request.Format = FORMAT_ANTHROPIC
+		request.Key = r.Header.Get("x-api-key")
+	case strings.Contains(r.URL.Path, ":generateContent"), strings.Contains(r.URL.Path, ":streamGenerateContent"):
+		request.Format = FORMAT_GEMINI
+		request.Key = r.URL.Query().Get("key")
+		if request.Key == "" {
+			request.Key = r.Header.Get("x-goog-api-key")
+		}
+		// 路径格式: /models/{model}:generateContent
+		action := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		request.Model = action[:strings.Index(action, ":")]
+		request.Stream = strings.Contains(action, ":streamGenerateContent")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	p.mu.Lock()
+
+	p.requests = append(p.requests, request)
+
+	var reply providerReply
+	if replies := p.replies[request.Key]; len(replies) > 0 {
+		reply = replies[0]
+		p.replies[request.Key] = replies[1:]
+	}
+
+	p.mu.Unlock()
+
+	if reply.Delay > 0 {
+		time.Sleep(reply.Delay)
+	}
+
+	if reply.Status != 0 {
+		p.writeError(w, request.Format, reply)
+		return
+	}
+
+	if request.Stream {
+		p.writeStream(w, request)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(completion(request))
+}
+
+func (p *provider) writeError(w http.ResponseWriter, format string, reply providerReply) {
+
+	var body any
+
+	switch format {
+	case FORMAT_ANTHROPIC:
+		body = map[string]any{
+			"type": "error",
+			"error": map[string]any{
+				"type":    reply.Code,
+				"message": reply.Message,
+			},
+		}
+	case FORMAT_GEMINI:
+		body = map[string]any{
+			"error": map[string]any{
+				"code":    reply.Status,
+				"message": reply.Message,
+				"status":  reply.Code,
+			},
+		}
+	default:
+		body = map[string]any{
+			"error": map[string]any{
+				"message": reply.Message,
+				"type":    "invalid_request_error",
+				"code":    reply.Code,
+			},
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(reply.Status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (p *provider) writeStream(w http.ResponseWriter, request providerRequest) {
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	flusher, _ := w.(http.Flusher)
+
+	write := func(event string, data any) {
+
+		if event != "" {
+			_, _ = fmt.Fprintf(w, "event: %s\n", event)
+		}
+
+		bytes, _ := json.Marshal(data)
+		_, _ = fmt.Fprintf(w, "data: %s\n\n", bytes)
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	for _, event := range streamEvents(request) {
+		write(event.name, event.data)
+	}
+
+	if request.Format == FORMAT_OPENAI {
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// 回答内容, 带上模型名称便于确认实际请求的模型
+func answer(model string) string {
+	return "Hello from " + model
+}
+
+func completion(request providerRequest) any {
+
+	switch request.Format {
+	case FORMAT_ANTHROPIC:
+		return map[string]any{
+			"id":          "msg_e2e",
+			"type":        "message",
+			"role":        "assistant",
+			"model":       request.Model,
+			"content":     []any{map[string]any{"type": "text", "text": answer(request.Model)}},
+			"stop_reason": "end_turn",
+			"usage": map[string]any{
+				"input_tokens":  PROVIDER_PROMPT_TOKENS,
+				"output_tokens": PROVIDER_COMPLETION_TOKENS,
+			},
+		}
+	case FORMAT_GEMINI:
+		return geminiChunk(request.Model, answer(request.Model), true)
+	}
+
+	return map[string]any{
+		"id":      "chatcmpl-e2e",
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   request.Model,
+		"choices": []any{map[string]any{
+			"index":         0,
+			"message":       map[string]any{"role": "assistant", "content": answer(request.Model)},
+			"finish_reason": "stop",
+		}},
+		"usage": openaiUsage(),
+	}
+}
+
+type streamEvent struct {
+	name string
+	data any
+}
+
+func streamEvents(request providerRequest) []streamEvent {
+
+	words := strings.SplitAfter(answer(request.Model), " ")
+
+	events := make([]streamEvent, 0)
+
+	switch request.Format {
+	case FORMAT_ANTHROPIC:
+
+		events = append(events,
+			streamEvent{"message_start", map[string]any{
+				"type": "message_start",
+				"message": map[string]any{
+					"id":      "msg_e2e",
+					"type":    "message",
+					"role":    "assistant",
+					"model":   request.Model,
+					"content": []any{},
+					"usage":   map[string]any{"input_tokens": PROVIDER_PROMPT_TOKENS, "output_tokens": 1},
+				},
+			}},
+			streamEvent{"content_block_start", map[string]any{
+				"type":          "content_block_start",
+				"index":         0,
+				"content_block": map[string]any{"type": "text", "text": ""},
+			}},
+		)
+
+		for _, word := range words {
+			events = append(events, streamEvent{"content_block_delta", map[string]any{
+				"type":  "content_block_delta",
+				"index": 0,
+				"delta": map[string]any{"type": "text_delta", "text": word},
+			}})
+		}
+
+		events = append(events,
+			streamEvent{"content_block_stop", map[string]any{"type": "content_block_stop", "index": 0}},
+			streamEvent{"message_delta", map[string]any{
+				"type":  "message_delta",
+				"delta": map[string]any{"stop_reason": "end_turn"},
+				"usage": map[string]any{"output_tokens": PROVIDER_COMPLETION_TOKENS},
+			}},
+			streamEvent{"message_stop", map[string]any{"type": "message_stop"}},
+		)
+
+	case FORMAT_GEMINI:
+
+		for i, word := range words {
+			events = append(events, streamEvent{"", geminiChunk(request.Model, word, i == len(words)-1)})
+		}
+
+	default:
+
+		for i, word := range words {
+
+			chunk := map[string]any{
+				"id":      "chatcmpl-e2e",
+				"object":  "chat.completion.chunk",
+				"created": time.Now().Unix(),
+				"model":   request.Model,
+				"choices": []any{map[string]any{
+					"index": 0,
+					"delta": map[string]any{"role": "assistant", "content": word},
+				}},
+			}
+
+			if i == len(words)-1 {
+				chunk["choices"].([]any)[0].(map[string]any)["finish_reason"] = "stop"
+			}
+
+			events = append(events, streamEvent{"", chunk})
+		}
+
+		events = append(events, streamEvent{"", map[string]any{
+			"id":      "chatcmpl-e2e",
+			"object":  "chat.completion.chunk",
+			"created": time.Now().Unix(),
+			"model":   request.Model,
+			"choices": []any{},
+			"usage":   openaiUsage(),
+		}})
+	}
+
+	return events
+}
+
+func openaiUsage() map[string]any {
+	return map[string]any{
+		"prompt_tokens":     PROVIDER_PROMPT_TOKENS,
+		"completion_tokens": PROVIDER_COMPLETION_TOKENS,
+		"total_tokens":      PROVIDER_PROMPT_TOKENS + PROVIDER_COMPLETION_TOKENS,
+	}
+}
+
+// 最后一个数据块带上结束原因和用量
+func geminiChunk(model, text string, last bool) map[string]any {
+
+	candidate := map[string]any{
+		"index":   0,
+		"content": map[string]any{"role": "model", "parts": []any{map[string]any{"text": text}}},
+	}
+
+	if last {
+		candidate["finishReason"] = "STOP"
+	}
+
+	chunk := map[string]any{
+		"candidates":   []any{candidate},
+		"modelVersion": model,
+	}
+
+	if last {
+		chunk["usageMetadata"] = map[string]any{
+			"promptTokenCount":     PROVIDER_PROMPT_TOKENS,
+			"candidatesTokenCount": PROVIDER_COMPLETION_TOKENS,
+			"totalTokenCount":      PROVIDER_PROMPT_TOKENS + PROVIDER_COMPLETION_TOKENS,
+		}
+	}
+
+	return chunk
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/encoding/gyaml"
+	"github.com/gogf/gf/v2/os/gcmd"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/utility/logger"
+	"slices"
+)
+
+var (
+	Apply = gcmd.Command{
+		Name:  "apply",
+		Usage: "apply -file=config.yaml [-dry-run] [-prune]",
+		Brief: "apply corps, model agents, models and model keys from yaml, only fields in the file are compared and updated",
+		Arguments: []gcmd.Argument{{
+			Name:  "file",
+			Short: "f",
+			Brief: "yaml file exported by the export command",
+		}, {
+			Name:   "dry-run",
+			Short:  "d",
+			Brief:  "only print the changes",
+			Orphan: true,
+		}, {
+			Name:   "prune",
+			Short:  "p",
+			Brief:  "delete data not in the file, only for sections in the file",
+			Orphan: true,
+		}},
+		Func: func(ctx context.Context, parser *gcmd.Parser) (err error) {
+
+			file := parser.GetOpt("file").String()
+			if file == "" {
+				return errors.New("file is required")
+			}
+
+			content := gfile.GetBytes(file)
+			if content == nil {
+				return errors.Newf("file %s not found or empty", file)
+			}
+
+			data, err := gyaml.Decode(content)
+			if err != nil {
+				logger.Error(ctx, err)
+				return err
+			}
+
+			for section := range data {
+				if !slices.ContainsFunc(syncCollections, func(s syncer) bool { return s.section() == section }) {
+					return errors.Newf("unknown section: %s", section)
+				}
+			}
+
+			dryRun := parser.GetOpt("dry-run") != nil
+			prune := parser.GetOpt("prune") != nil
+
+			for _, s := range syncCollections {
+
+				items, ok := data[s.section()]
+				if !ok {
+					continue
+				}
+
+				result, err := s.apply(ctx, gconv.Interfaces(items), dryRun, prune)
+				if err != nil {
+					logger.Errorf(ctx, "apply %s error: %v", s.section(), err)
+					return err
+				}
+
+				logger.Infof(ctx, "apply %s created: %d, updated: %d, deleted: %d, unchanged: %d, dry run: %t",
+					s.section(), result.Created, result.Updated, result.Deleted, result.Unchanged, dryRun)
+			}
+
+			return nil
+		},
+	}
+)
@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"cmp"
 	"context"
+	"crypto/subtle"
 	"github.com/gogf/gf/v2/encoding/gjson"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/net/ghttp"
@@ -9,6 +11,7 @@ import (
 	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/iimeta/fastapi/internal/config"
 	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/controller/admin"
 	"github.com/iimeta/fastapi/internal/controller/audio"
 	"github.com/iimeta/fastapi/internal/controller/chat"
 	"github.com/iimeta/fastapi/internal/controller/dashboard"
@@ -18,9 +21,11 @@ import (
 	"github.com/iimeta/fastapi/internal/controller/image"
 	"github.com/iimeta/fastapi/internal/controller/midjourney"
 	"github.com/iimeta/fastapi/internal/controller/moderation"
+	"github.com/iimeta/fastapi/internal/controller/token"
 	"github.com/iimeta/fastapi/internal/errors"
 	"github.com/iimeta/fastapi/internal/model"
 	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/crypto"
 	"github.com/iimeta/fastapi/utility/logger"
 	"net/http"
 	"strings"
@@ -78,6 +83,7 @@ var (
 						embedding.NewV1(),
 						moderation.NewV1(),
 						file.NewV1(),
+						token.NewV1(),
 					)
 				})
 
@@ -114,12 +120,21 @@ var (
 				)
 			})
 
+			if config.Cfg.Admin.Open {
+				s.Group(cmp.Or(config.Cfg.Admin.Path, "/admin"), func(g *ghttp.RouterGroup) {
+					g.Middleware(middlewareHandlerResponse)
+					g.Middleware(middlewareAdmin)
+					g.Bind(
+						admin.NewV1(),
+					)
+				})
+			}
+
 			if config.Cfg.ApiServerAddress != "" {
 				s.SetAddr(config.Cfg.ApiServerAddress)
 			}
 
-			s.Run()
-			return nil
+			return run(ctx, s)
 		},
 	}
 )
@@ -168,7 +183,21 @@ func middleware(r *ghttp.Request) {
 		return
 	}
 
-	logger.Infof(r.GetCtx(), "middleware secretKey: %s", secretKey)
+	logger.Infof(r.GetCtx(), "middleware secretKey: %s", crypto.KeyPrefix(secretKey))
+
+	// 终端用户
+	endUser := ""
+	if config.Cfg.EndUser.Header != "" {
+		endUser = r.GetHeader(config.Cfg.EndUser.Header)
+	}
+
+	if endUser == "" {
+		endUser = r.Get("user").String()
+	}
+
+	if endUser != "" {
+		service.Session().SaveEndUser(r.GetCtx(), gstr.SubStrRune(endUser, 0, 128))
+	}
 
 	if err := service.Auth().Authenticator(r.GetCtx(), secretKey); err != nil {
 		err := errors.Error(r.GetCtx(), err)
@@ -189,6 +218,31 @@ func middleware(r *ghttp.Request) {
 	r.Middleware.Next()
 }
 
+// 管理接口认证, 使用配置的管理密钥
+func middlewareAdmin(r *ghttp.Request) {
+
+	secretKey := strings.TrimPrefix(r.GetHeader("Authorization"), "Bearer ")
+
+	authorized := false
+	for _, key := range config.Cfg.Admin.SecretKeys {
+		if key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(secretKey)) == 1 {
+			authorized = true
+			break
+		}
+	}
+
+	if !authorized {
+		logger.Errorf(r.GetCtx(), "middlewareAdmin secretKey: %s, ip: %s not authorized", crypto.KeyPrefix(secretKey), r.GetClientIp())
+		err := errors.Error(r.GetCtx(), errors.ERR_INVALID_API_KEY)
+		r.Response.Header().Set("Content-Type", "application/json")
+		r.Response.WriteStatus(err.Status(), gjson.MustEncodeString(err))
+		r.Exit()
+		return
+	}
+
+	r.Middleware.Next()
+}
+
 type defaultHandlerResponse struct {
 	Code    any         `json:"code"    dc:"Error code"`
 	Message string      `json:"message" dc:"Error message"`
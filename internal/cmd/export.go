@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/encoding/gyaml"
+	"github.com/gogf/gf/v2/os/gcmd"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/gogf/gf/v2/text/gstr"
+	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/utility/logger"
+	"slices"
+)
+
+var (
+	Export = gcmd.Command{
+		Name:  "export",
+		Usage: "export [-file=config.yaml] [-sections=corps,model_agents,models,keys]",
+		Brief: "export corps, model agents, models and model keys to yaml",
+		Arguments: []gcmd.Argument{{
+			Name:  "file",
+			Short: "f",
+			Brief: "output file, print to stdout if empty",
+		}, {
+			Name:  "sections",
+			Short: "s",
+			Brief: "comma separated sections to export, export all if empty",
+		}},
+		Func: func(ctx context.Context, parser *gcmd.Parser) (err error) {
+
+			sections := gstr.SplitAndTrim(parser.GetOpt("sections").String(), ",")
+
+			for _, section := range sections {
+				if !slices.ContainsFunc(syncCollections, func(s syncer) bool { return s.section() == section }) {
+					return errors.Newf("unknown section: %s", section)
+				}
+			}
+
+			data := make(map[string]interface{})
+			for _, s := range syncCollections {
+
+				if len(sections) > 0 && !slices.Contains(sections, s.section()) {
+					continue
+				}
+
+				documents, err := s.export(ctx)
+				if err != nil {
+					logger.Errorf(ctx, "export %s error: %v", s.section(), err)
+					return err
+				}
+
+				data[s.section()] = documents
+			}
+
+			content, err := gyaml.Encode(data)
+			if err != nil {
+				logger.Error(ctx, err)
+				return err
+			}
+
+			file := parser.GetOpt("file").String()
+			if file == "" {
+				fmt.Print(string(content))
+				return nil
+			}
+
+			if err = gfile.PutBytes(file, content); err != nil {
+				logger.Error(ctx, err)
+				return err
+			}
+
+			logger.Infof(ctx, "export file: %s", file)
+
+			return nil
+		},
+	}
+)
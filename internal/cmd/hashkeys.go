@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/os/gcmd"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/dao"
+	"github.com/iimeta/fastapi/internal/logic/common"
+	"github.com/iimeta/fastapi/internal/model"
+	"github.com/iimeta/fastapi/utility/crypto"
+	"github.com/iimeta/fastapi/utility/logger"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	HashKeys = gcmd.Command{
+		Name:  "hashkeys",
+		Usage: "hashkeys [-dry-run]",
+		Brief: "replace plaintext app keys with hashes and display prefixes, this cannot be undone",
+		Arguments: []gcmd.Argument{{
+			Name:   "dry-run",
+			Short:  "d",
+			Brief:  "only print the number of keys to be hashed",
+			Orphan: true,
+		}},
+		Func: func(ctx context.Context, parser *gcmd.Parser) (err error) {
+
+			dryRun := parser.GetOpt("dry-run") != nil
+
+			keys, err := dao.Key.Find(ctx, bson.M{"type": 1, "key_hash": bson.M{"$exists": false}})
+			if err != nil {
+				logger.Error(ctx, err)
+				return err
+			}
+
+			if dryRun {
+				logger.Infof(ctx, "hashkeys total: %d, dry run: %t", len(keys), dryRun)
+				return nil
+			}
+
+			for _, key := range keys {
+
+				if err = dao.Key.UpdateById(ctx, key.Id, bson.M{
+					"$set": bson.M{"key": crypto.KeyPrefix(key.Key), "key_hash": crypto.HashKey(key.Key)},
+					"$inc": bson.M{"version": 1},
+				}); err != nil {
+					logger.Errorf(ctx, "hashkeys key id: %s, error: %v", key.Id, err)
+					return err
+				}
+
+				// 重新读取, 变更通知带上更新后的版本号
+				newData, err := dao.Key.FindById(ctx, key.Id)
+				if err != nil {
+					logger.Errorf(ctx, "hashkeys key id: %s, error: %v", key.Id, err)
+					return err
+				}
+
+				// 通知运行中的实例刷新缓存
+				if err = common.PublishChange(ctx, consts.CHANGE_CHANNEL_APP_KEY, model.PubMessage{
+					Action:  consts.ACTION_UPDATE,
+					OldData: key,
+					NewData: newData,
+				}); err != nil {
+					logger.Error(ctx, err)
+				}
+			}
+
+			logger.Infof(ctx, "hashkeys total: %d, dry run: %t", len(keys), dryRun)
+
+			return nil
+		},
+	}
+)
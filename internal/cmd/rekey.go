@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/os/gcmd"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/dao"
+	"github.com/iimeta/fastapi/internal/logic/common"
+	"github.com/iimeta/fastapi/internal/model"
+	"github.com/iimeta/fastapi/utility/logger"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	Rekey = gcmd.Command{
+		Name:  "rekey",
+		Usage: "rekey [-dry-run]",
+		Brief: "encrypt plaintext model keys and rewrap keys encrypted by old master keys",
+		Arguments: []gcmd.Argument{{
+			Name:   "dry-run",
+			Short:  "d",
+			Brief:  "only print the number of keys to be rekeyed",
+			Orphan: true,
+		}},
+		Func: func(ctx context.Context, parser *gcmd.Parser) (err error) {
+
+			masterKey, err := common.GetMasterKey()
+			if err != nil {
+				logger.Error(ctx, err)
+				return err
+			}
+
+			if masterKey == nil {
+				logger.Info(ctx, "rekey master key is not configured, skip")
+				return nil
+			}
+
+			dryRun := parser.GetOpt("dry-run") != nil
+
+			keys, err := dao.Key.Find(ctx, bson.M{"type": 2})
+			if err != nil {
+				logger.Error(ctx, err)
+				return err
+			}
+
+			count := 0
+			for _, key := range keys {
+
+				secret, err := common.EncryptKey(key.Key)
+				if err != nil {
+					logger.Errorf(ctx, "rekey key id: %s, error: %v", key.Id, err)
+					return err
+				}
+
+				if secret == key.Key {
+					continue
+				}
+
+				count++
+
+				if dryRun {
+					continue
+				}
+
+				if err = dao.Key.UpdateById(ctx, key.Id, bson.M{"$set": bson.M{"key": secret}, "$inc": bson.M{"version": 1}}); err != nil {
+					logger.Errorf(ctx, "rekey key id: %s, error: %v", key.Id, err)
+					return err
+				}
+
+				// 重新读取, 变更通知带上更新后的版本号
+				newData, err := dao.Key.FindById(ctx, key.Id)
+				if err != nil {
+					logger.Errorf(ctx, "rekey key id: %s, error: %v", key.Id, err)
+					return err
+				}
+
+				// 通知运行中的实例刷新缓存
+				if err = common.PublishChange(ctx, consts.CHANGE_CHANNEL_KEY, model.PubMessage{
+					Action:  consts.ACTION_UPDATE,
+					OldData: key,
+					NewData: newData,
+				}); err != nil {
+					logger.Error(ctx, err)
+				}
+			}
+
+			logger.Infof(ctx, "rekey total: %d, rekeyed: %d, dry run: %t", len(keys), count, dryRun)
+
+			return nil
+		},
+	}
+)
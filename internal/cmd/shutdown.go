@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gproc"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/graceful"
+	"github.com/iimeta/fastapi/utility/logger"
+	"os"
+	"time"
+)
+
+// 启动服务并阻塞, 收到退出信号后优雅关闭
+func run(ctx context.Context, s *ghttp.Server) error {
+
+	timeout := config.Cfg.Shutdown.Timeout
+	if timeout <= 0 {
+		timeout = 30
+	}
+
+	// 停止服务时等待进行中的请求(含流式响应)的最长时间
+	s.SetGracefulShutdownTimeout(int(timeout))
+
+	if err := s.Start(); err != nil {
+		return err
+	}
+
+	gproc.AddSigHandlerShutdown(func(sig os.Signal) {
+		logger.Infof(ctx, "Main receive signal: %s, graceful shutdown ing...", sig.String())
+		shutdown(ctx, s, timeout*time.Second)
+	})
+
+	gproc.Listen()
+
+	return nil
+}
+
+// 优雅关闭, 标记未就绪, 停止接收新连接并等待进行中的请求和实时会话结束, 再等待用量和日志写入完成
+func shutdown(ctx context.Context, s *ghttp.Server, timeout time.Duration) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Infof(ctx, "Main graceful shutdown time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	graceful.SetShuttingDown()
+
+	// 等待负载均衡根据就绪状态摘除实例
+	if config.Cfg.Shutdown.ReadyDelay > 0 {
+		time.Sleep(config.Cfg.Shutdown.ReadyDelay * time.Second)
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	if err := s.Shutdown(); err != nil {
+		logger.Error(ctx, err)
+	}
+
+	// 实时会话的连接已被接管, 停止服务时不会等待, 需单独等待
+	if !graceful.WaitSessions(time.Until(deadline)) {
+		logger.Errorf(ctx, "Main graceful shutdown timeout, sessions: %d", graceful.Sessions())
+	}
+
+	drainTimeout := config.Cfg.Shutdown.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = 30
+	}
+
+	if !graceful.WaitTasks(drainTimeout * time.Second) {
+		logger.Errorf(ctx, "Main graceful shutdown timeout, tasks: %d", graceful.Tasks())
+	}
+
+	service.LogWriter().Close(ctx)
+}
@@ -0,0 +1,488 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/dao"
+	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/internal/logic/common"
+	"github.com/iimeta/fastapi/internal/model"
+	"github.com/iimeta/fastapi/internal/model/entity"
+	"github.com/iimeta/fastapi/utility/crypto"
+	"github.com/iimeta/fastapi/utility/logger"
+	"github.com/iimeta/fastapi/utility/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// 不导出也不导入的字段, 包括维护信息和运行时状态
+var syncIgnoreFields = []string{"creator", "updater", "created_at", "updated_at", "version", "used_quota", "is_auto_disabled", "auto_disabled_reason"}
+
+// 声明式配置的操作者
+const syncOperator = "gitops"
+
+// 声明式配置的集合, 按依赖顺序导入
+var syncCollections = []syncer{
+	newSyncCollection[entity.Corp]("corps", dao.Corp.MongoDB, nil, "code", "", consts.CHANGE_CHANNEL_CORP),
+	newSyncCollection[entity.ModelAgent]("model_agents", dao.ModelAgent.MongoDB, nil, "name", "", consts.CHANGE_CHANNEL_AGENT),
+	newSyncCollection[entity.Model]("models", dao.Model.MongoDB, nil, "name", "", consts.CHANGE_CHANNEL_MODEL),
+	newSyncCollection[entity.Key]("keys", dao.Key.MongoDB, bson.M{"type": 2}, "key", "key", consts.CHANGE_CHANNEL_KEY),
+}
+
+type syncer interface {
+	section() string
+	export(ctx context.Context) ([]bson.M, error)
+	apply(ctx context.Context, items []interface{}, dryRun, prune bool) (*syncResult, error)
+}
+
+type syncResult struct {
+	Created   int
+	Updated   int
+	Deleted   int
+	Unchanged int
+}
+
+type syncCollection[E any] struct {
+	name        string
+	dao         *dao.MongoDB[E]
+	filter      bson.M         // 集合范围, 如: 只同步模型密钥
+	naturalKey  string         // 未指定_id时用于匹配的字段
+	secretField string         // 加密保存的字段, 按明文比较
+	channel     string         // 变更通知的频道
+	fields      map[string]int // 数据库字段名对应实体的字段索引
+}
+
+func newSyncCollection[E any](name string, dao *dao.MongoDB[E], filter bson.M, naturalKey, secretField, channel string) *syncCollection[E] {
+
+	fields := make(map[string]int)
+
+	t := reflect.TypeOf(new(E)).Elem()
+	for i := 0; i < t.NumField(); i++ {
+
+		field, _, _ := strings.Cut(t.Field(i).Tag.Get("bson"), ",")
+		if field == "" || field == "-" {
+			continue
+		}
+
+		fields[field] = i
+	}
+
+	return &syncCollection[E]{
+		name:        name,
+		dao:         dao,
+		filter:      filter,
+		naturalKey:  naturalKey,
+		secretField: secretField,
+		channel:     channel,
+		fields:      fields,
+	}
+}
+
+func (c *syncCollection[E]) section() string {
+	return c.name
+}
+
+// 导出集合, 按_id排序保证每次导出的顺序一致
+func (c *syncCollection[E]) export(ctx context.Context) ([]bson.M, error) {
+
+	results, err := c.dao.Find(ctx, c.filterOrEmpty(), "_id")
+	if err != nil {
+		return nil, err
+	}
+
+	documents := make([]bson.M, 0, len(results))
+	for _, result := range results {
+
+		bytes, err := bson.Marshal(result)
+		if err != nil {
+			return nil, err
+		}
+
+		document := bson.M{}
+		if err = bson.Unmarshal(bytes, &document); err != nil {
+			return nil, err
+		}
+
+		for _, field := range syncIgnoreFields {
+			delete(document, field)
+		}
+
+		documents = append(documents, document)
+	}
+
+	return documents, nil
+}
+
+// 导入集合, 按_id或自然键匹配, 只比较和更新文件中包含的字段, prune为true时删除文件中不存在的数据
+func (c *syncCollection[E]) apply(ctx context.Context, items []interface{}, dryRun, prune bool) (*syncResult, error) {
+
+	currents, err := c.dao.Find(ctx, c.filterOrEmpty())
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[string]*E)
+	byKey := make(map[string]*E)
+
+	for _, current := range currents {
+
+		naturalKey, err := c.naturalKeyOf(current)
+		if err != nil {
+			return nil, err
+		}
+
+		byId[c.id(current)] = current
+
+		if naturalKey != "" {
+			byKey[naturalKey] = current
+		}
+	}
+
+	result := new(syncResult)
+	matched := make(map[string]bool)
+
+	for i, item := range items {
+
+		document, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, errors.Newf("%s[%d] is not a mapping", c.name, i)
+		}
+
+		id, document, err := c.decode(document)
+		if err != nil {
+			return nil, errors.Newf("%s[%d] %v", c.name, i, err)
+		}
+
+		desired, err := c.convert(document)
+		if err != nil {
+			return nil, errors.Newf("%s[%d] %v", c.name, i, err)
+		}
+
+		naturalKey, err := c.naturalKeyOf(desired)
+		if err != nil {
+			return nil, err
+		}
+
+		if id == "" && naturalKey == "" {
+			return nil, errors.Newf("%s[%d] requires _id or %s", c.name, i, c.naturalKey)
+		}
+
+		current := byKey[naturalKey]
+		if id != "" {
+			current = byId[id]
+		}
+
+		if current == nil {
+
+			logger.Infof(ctx, "apply %s create: %s", c.name, c.label(naturalKey, id))
+			result.Created++
+
+			if !dryRun {
+				if err = c.create(ctx, id, document, desired); err != nil {
+					return nil, err
+				}
+			}
+
+			continue
+		}
+
+		matched[c.id(current)] = true
+
+		changed, err := c.diff(document, current, desired)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(changed) == 0 {
+			result.Unchanged++
+			continue
+		}
+
+		logger.Infof(ctx, "apply %s update: %s, fields: %s", c.name, c.label(naturalKey, c.id(current)), strings.Join(changed, ", "))
+		result.Updated++
+
+		if !dryRun {
+			if err = c.update(ctx, current, changed, desired); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if prune {
+		for _, current := range currents {
+
+			if matched[c.id(current)] {
+				continue
+			}
+
+			naturalKey, _ := c.naturalKeyOf(current)
+
+			logger.Infof(ctx, "apply %s delete: %s", c.name, c.label(naturalKey, c.id(current)))
+			result.Deleted++
+
+			if !dryRun {
+				if err = c.delete(ctx, current); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (c *syncCollection[E]) create(ctx context.Context, id string, document bson.M, desired *E) error {
+
+	value := bson.M{}
+	for key := range document {
+		value[key] = c.field(desired, key)
+	}
+
+	if err := c.encrypt(value); err != nil {
+		return err
+	}
+
+	value["_id"] = cmp.Or(id, util.GenerateId())
+	value["creator"] = syncOperator
+	value["updater"] = syncOperator
+	value["created_at"] = gtime.TimestampMilli()
+	value["updated_at"] = gtime.TimestampMilli()
+
+	if _, err := dao.InsertDocuments(ctx, c.dao.Database, c.dao.Collection, []interface{}{value}); err != nil {
+		return err
+	}
+
+	newData, err := c.dao.FindById(ctx, value["_id"])
+	if err != nil {
+		return err
+	}
+
+	return c.publish(ctx, consts.ACTION_CREATE, nil, newData)
+}
+
+func (c *syncCollection[E]) update(ctx context.Context, current *E, changed []string, desired *E) error {
+
+	set := bson.M{}
+	for _, key := range changed {
+		set[key] = c.field(desired, key)
+	}
+
+	if err := c.encrypt(set); err != nil {
+		return err
+	}
+
+	set["updater"] = syncOperator
+	set["updated_at"] = gtime.TimestampMilli()
+
+	if err := c.dao.UpdateById(ctx, c.id(current), bson.M{"$set": set, "$inc": bson.M{"version": 1}}); err != nil {
+		return err
+	}
+
+	newData, err := c.dao.FindById(ctx, c.id(current))
+	if err != nil {
+		return err
+	}
+
+	return c.publish(ctx, consts.ACTION_UPDATE, current, newData)
+}
+
+func (c *syncCollection[E]) delete(ctx context.Context, current *E) error {
+
+	if err := c.dao.DeleteById(ctx, c.id(current)); err != nil {
+		return err
+	}
+
+	return c.publish(ctx, consts.ACTION_DELETE, current, nil)
+}
+
+// 通知运行中的实例刷新缓存
+func (c *syncCollection[E]) publish(ctx context.Context, action string, oldData, newData *E) error {
+
+	message := model.PubMessage{
+		Action: action,
+	}
+
+	if oldData != nil {
+		message.OldData = oldData
+	}
+
+	if newData != nil {
+		message.NewData = newData
+	}
+
+	return common.PublishChange(ctx, c.channel, message)
+}
+
+// 解析文件中的数据, 返回_id和待比较的字段
+func (c *syncCollection[E]) decode(item map[string]interface{}) (string, bson.M, error) {
+
+	document := bson.M{}
+	for key, value := range item {
+		document[key] = value
+	}
+
+	id := gconv.String(document["_id"])
+	delete(document, "_id")
+
+	for _, field := range syncIgnoreFields {
+		delete(document, field)
+	}
+
+	for key := range document {
+		if _, ok := c.fields[key]; !ok {
+			return "", nil, errors.Newf("unknown field: %s", key)
+		}
+	}
+
+	for key, value := range c.filter {
+
+		if v, ok := document[key]; ok && gconv.String(v) != gconv.String(value) {
+			return "", nil, errors.Newf("field %s must be %v", key, value)
+		}
+
+		document[key] = value
+	}
+
+	return id, document, nil
+}
+
+// 按实体的字段类型转换
+func (c *syncCollection[E]) convert(document bson.M) (*E, error) {
+
+	bytes, err := bson.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	value := new(E)
+	if err = bson.Unmarshal(bytes, value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// 比较文件中包含的字段, 返回有变化的字段
+func (c *syncCollection[E]) diff(document bson.M, current, desired *E) ([]string, error) {
+
+	changed := make([]string, 0)
+
+	for key := range document {
+
+		if key == c.secretField {
+
+			currentSecret, err := common.DecryptKey(gconv.String(c.field(current, key)))
+			if err != nil {
+				return nil, err
+			}
+
+			desiredSecret, err := common.DecryptKey(gconv.String(c.field(desired, key)))
+			if err != nil {
+				return nil, err
+			}
+
+			if currentSecret != desiredSecret {
+				changed = append(changed, key)
+			}
+
+			continue
+		}
+
+		if !syncEqual(c.fieldValue(current, key), c.fieldValue(desired, key)) {
+			changed = append(changed, key)
+		}
+	}
+
+	slices.Sort(changed)
+
+	return changed, nil
+}
+
+// 加密字段使用主密钥加密后保存
+func (c *syncCollection[E]) encrypt(document bson.M) error {
+
+	if c.secretField == "" || document[c.secretField] == nil {
+		return nil
+	}
+
+	secret, err := common.DecryptKey(gconv.String(document[c.secretField]))
+	if err != nil {
+		return err
+	}
+
+	if document[c.secretField], err = common.EncryptKey(secret); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *syncCollection[E]) naturalKeyOf(value *E) (string, error) {
+
+	naturalKey := gconv.String(c.field(value, c.naturalKey))
+	if c.naturalKey != c.secretField || naturalKey == "" {
+		return naturalKey, nil
+	}
+
+	return common.DecryptKey(naturalKey)
+}
+
+// 日志中展示的名称, 密钥只展示掩码
+func (c *syncCollection[E]) label(naturalKey, id string) string {
+
+	if naturalKey != "" && c.naturalKey == c.secretField {
+		naturalKey = crypto.MaskKey(naturalKey)
+	}
+
+	if id == "" {
+		return naturalKey
+	}
+
+	return naturalKey + "(" + id + ")"
+}
+
+func (c *syncCollection[E]) id(value *E) string {
+	return gconv.String(c.field(value, "_id"))
+}
+
+func (c *syncCollection[E]) field(value *E, key string) interface{} {
+	return c.fieldValue(value, key).Interface()
+}
+
+func (c *syncCollection[E]) fieldValue(value *E, key string) reflect.Value {
+	return reflect.ValueOf(value).Elem().Field(c.fields[key])
+}
+
+func (c *syncCollection[E]) filterOrEmpty() bson.M {
+
+	if c.filter == nil {
+		return bson.M{}
+	}
+
+	return c.filter
+}
+
+// 比较字段值, 空值和空数组视为相同
+func syncEqual(a, b reflect.Value) bool {
+
+	if syncIsEmpty(a) && syncIsEmpty(b) {
+		return true
+	}
+
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+func syncIsEmpty(value reflect.Value) bool {
+
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	default:
+		return value.IsZero()
+	}
+}
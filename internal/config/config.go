@@ -41,20 +41,53 @@ func init() {
 
 // 配置信息
 type Config struct {
-	Core             Core       `json:"core"`
-	ApiServerAddress string     `json:"api_server_address"`
-	Http             Http       `json:"http"`
-	Local            Local      `json:"local"`
-	Api              Api        `json:"api"`
-	Midjourney       Midjourney `json:"midjourney"`
-	Gcp              Gcp        `json:"gcp"`
-	RecordLogs       []string   `json:"record_logs"`
-	Error            Error      `json:"error"`
-	Debug            bool       `json:"debug"`
+	Core             Core             `json:"core"`
+	ApiServerAddress string           `json:"api_server_address"`
+	Http             Http             `json:"http"`
+	Local            Local            `json:"local"`
+	Api              Api              `json:"api"`
+	Midjourney       Midjourney       `json:"midjourney"`
+	Gcp              Gcp              `json:"gcp"`
+	RecordLogs       []string         `json:"record_logs"`
+	Error            Error            `json:"error"`
+	ToolEmulation    ToolEmulation    `json:"tool_emulation"`
+	StructuredOutput StructuredOutput `json:"structured_output"`
+	Hedge            Hedge            `json:"hedge"`
+	Fanout           Fanout           `json:"fanout"`
+	RetryPolicy      RetryPolicy      `json:"retry_policy"`
+	Secret           Secret           `json:"secret"`
+	Token            Token            `json:"token"`
+	EndUser          EndUser          `json:"end_user"`
+	Alert            Alert            `json:"alert"`
+	Billing          Billing          `json:"billing"`
+	Tokenizer        Tokenizer        `json:"tokenizer"`
+	ImageFetch       ImageFetch       `json:"image_fetch"`
+	LogWriter        LogWriter        `json:"log_writer"`
+	Retention        Retention        `json:"retention"`
+	Shutdown         Shutdown         `json:"shutdown"`
+	Health           Health           `json:"health"`
+	Admin            Admin            `json:"admin"`
+	Debug            bool             `json:"debug"`
 }
 
 type Core struct {
-	ChannelPrefix string `json:"channel_prefix"`
+	ChannelPrefix string       `json:"channel_prefix"`
+	Warmup        string       `json:"warmup"`
+	ChangeStream  ChangeStream `json:"change_stream"`
+	KeyMiss       KeyMiss      `json:"key_miss"`
+	LegacyKeyHash bool         `json:"legacy_key_hash"`
+}
+
+type KeyMiss struct {
+	TTL  time.Duration `json:"ttl"`
+	Rate int           `json:"rate"`
+}
+
+type ChangeStream struct {
+	Node     string        `json:"node"`
+	MaxLen   int64         `json:"max_len"`
+	Block    time.Duration `json:"block"`
+	GroupTTL time.Duration `json:"group_ttl"`
 }
 
 type Api struct {
@@ -64,6 +97,23 @@ type Api struct {
 	ModelAgentKeyErrDisable int64 `json:"model_agent_key_err_disable"`
 }
 
+type RetryPolicy struct {
+	Default RetryRule            `json:"default"`
+	Corps   map[string]RetryRule `json:"corps"`
+	Models  map[string]RetryRule `json:"models"`
+}
+
+type RetryRule struct {
+	BaseDelay      int64    `json:"base_delay"`
+	MaxDelay       int64    `json:"max_delay"`
+	Multiplier     float64  `json:"multiplier"`
+	Jitter         float64  `json:"jitter"`
+	MaxElapsed     int64    `json:"max_elapsed"`
+	RetryStatus    []int    `json:"retry_status"`
+	NotRetryStatus []int    `json:"not_retry_status"`
+	NotRetryCodes  []string `json:"not_retry_codes"`
+}
+
 type Http struct {
 	Timeout  time.Duration `json:"timeout"`
 	ProxyUrl string        `json:"proxy_url"`
@@ -89,6 +139,128 @@ type Gcp struct {
 	GetTokenUrl string `json:"get_token_url" d:"https://www.googleapis.com/oauth2/v4/token"`
 }
 
+type ToolEmulation struct {
+	Corps  []string `json:"corps"`
+	Models []string `json:"models"`
+}
+
+type StructuredOutput struct {
+	Open           bool `json:"open"`
+	RepairAttempts int  `json:"repair_attempts"`
+}
+
+type Hedge struct {
+	Thresholds map[string]int64 `json:"thresholds"`
+}
+
+type Fanout struct {
+	MaxModels int `json:"max_models"`
+}
+
+type Secret struct {
+	MasterKey     string   `json:"master_key"`
+	MasterKeyFile string   `json:"master_key_file"`
+	OldMasterKeys []string `json:"old_master_keys"`
+}
+
+type Token struct {
+	Secret string `json:"secret"`
+	MaxTtl int64  `json:"max_ttl"`
+}
+
+type EndUser struct {
+	Header  string                `json:"header"`
+	Default EndUserBudget         `json:"default"`
+	Apps    map[int]EndUserBudget `json:"apps"`
+}
+
+type EndUserBudget struct {
+	Daily   int `json:"daily"`
+	Monthly int `json:"monthly"`
+}
+
+type Alert struct {
+	Thresholds []int          `json:"thresholds"`
+	DedupTtl   int64          `json:"dedup_ttl"`
+	Retry      int            `json:"retry"`
+	Webhooks   []AlertWebhook `json:"webhooks"`
+}
+
+type AlertWebhook struct {
+	Url     string   `json:"url"`
+	Secret  string   `json:"secret"`
+	Events  []string `json:"events"`
+	UserIds []int    `json:"user_ids"`
+	AppIds  []int    `json:"app_ids"`
+}
+
+type Billing struct {
+	QuotaUnit     float64            `json:"quota_unit"`
+	ExchangeRates map[string]float64 `json:"exchange_rates"`
+}
+
+type Tokenizer struct {
+	VocabDir string              `json:"vocab_dir"`
+	Families map[string][]string `json:"families"`
+}
+
+type ImageFetch struct {
+	Open         bool          `json:"open"`
+	Timeout      time.Duration `json:"timeout"`
+	FailTTL      time.Duration `json:"fail_ttl"`
+	AllowPrivate bool          `json:"allow_private"`
+}
+
+type LogWriter struct {
+	QueueSize      int           `json:"queue_size"`
+	BatchSize      int           `json:"batch_size"`
+	FlushInterval  time.Duration `json:"flush_interval"`
+	SpillFile      string        `json:"spill_file"`
+	ReplayInterval time.Duration `json:"replay_interval"`
+	Sinks          []LogSink     `json:"sinks"`
+}
+
+type LogSink struct {
+	Name        string            `json:"name"`
+	Type        string            `json:"type"`
+	Collections []string          `json:"collections"`
+	Path        string            `json:"path"`
+	MaxSize     int64             `json:"max_size"`
+	MaxBackups  int               `json:"max_backups"`
+	Url         string            `json:"url"`
+	Brokers     []string          `json:"brokers"`
+	Topic       string            `json:"topic"`
+	Database    string            `json:"database"`
+	Table       string            `json:"table"`
+	Username    string            `json:"username"`
+	Password    string            `json:"password"`
+	Headers     map[string]string `json:"headers"`
+}
+
+type Retention struct {
+	Collections   map[string]int `json:"collections"`
+	Fields        map[string]int `json:"fields"`
+	PruneInterval time.Duration  `json:"prune_interval"`
+}
+
+type Shutdown struct {
+	ReadyDelay   time.Duration `json:"ready_delay"`
+	Timeout      time.Duration `json:"timeout"`
+	DrainTimeout time.Duration `json:"drain_timeout"`
+}
+
+type Health struct {
+	Timeout        time.Duration `json:"timeout"`
+	MaxPoolSize    int           `json:"max_pool_size"`
+	ModelsInterval time.Duration `json:"models_interval"`
+}
+
+type Admin struct {
+	Open       bool     `json:"open"`
+	Path       string   `json:"path"`
+	SecretKeys []string `json:"secret_keys"`
+}
+
 type Error struct {
 	AutoDisabled []string `json:"auto_disabled"`
 	NotRetry     []string `json:"not_retry"`
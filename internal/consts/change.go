@@ -10,6 +10,10 @@ var (
 	CHANGE_CHANNEL_MODEL   = config.Cfg.Core.ChannelPrefix + "admin:change:channel:model"
 	CHANGE_CHANNEL_KEY     = config.Cfg.Core.ChannelPrefix + "admin:change:channel:key"
 	CHANGE_CHANNEL_AGENT   = config.Cfg.Core.ChannelPrefix + "admin:change:channel:agent"
+
+	// 变更流和版本号使用相同的哈希标签, 集群模式下在同一个槽
+	CHANGE_STREAM_KEY  = config.Cfg.Core.ChannelPrefix + "admin:change:{stream}"
+	CHANGE_VERSION_KEY = config.Cfg.Core.ChannelPrefix + "admin:change:{stream}:version"
 )
 
 const (
@@ -4,6 +4,7 @@ const (
 	SESSION_USER               = "session_user"
 	SESSION_APP                = "session_app"
 	SESSION_KEY                = "session_key"
+	SESSION_TOKEN              = "session_token"
 	SESSION_ERROR_MODEL_AGENTS = "session_error_model_agents"
 	SESSION_ERROR_KEYS         = "session_error_keys"
 
@@ -11,6 +12,7 @@ const (
 	USER_ID_KEY            = "user_id"
 	APP_ID_KEY             = "app_id"
 	SECRET_KEY             = "sk"
+	END_USER_KEY           = "end_user"
 	APP_IS_LIMIT_QUOTA_KEY = "app_is_limit_quota"
 	KEY_IS_LIMIT_QUOTA_KEY = "key_is_limit_quota"
 
@@ -31,7 +33,46 @@ const (
 	ROLE_FUNCTION  = "function"
 	ROLE_TOOL      = "tool"
 
+	FINISH_REASON_STOP          = "stop"
+	FINISH_REASON_TOOL_CALLS    = "tool_calls"
+	FINISH_REASON_FUNCTION_CALL = "function_call"
+
+	RESPONSE_FORMAT_JSON_OBJECT = "json_object"
+	RESPONSE_FORMAT_JSON_SCHEMA = "json_schema"
+
+	ALERT_EVENT_QUOTA_THRESHOLD = "quota.threshold"
+	ALERT_EVENT_KEY_DISABLED    = "key.disabled"
+
+	BILLING_ACTION_CHAT       = "chat"
+	BILLING_ACTION_IMAGE      = "image"
+	BILLING_ACTION_AUDIO      = "audio"
+	BILLING_ACTION_EMBEDDING  = "embedding"
+	BILLING_ACTION_MODERATION = "moderation"
+	BILLING_ACTION_REALTIME   = "realtime"
+	BILLING_ACTION_MIDJOURNEY = "midjourney"
+
+	BILLING_METHOD_PRICE = "price"
+	BILLING_METHOD_RATIO = "ratio"
+
+	DEFAULT_CURRENCY = "USD"
+
+	LOG_SINK_MONGODB    = "mongodb"
+	LOG_SINK_FILE       = "file"
+	LOG_SINK_KAFKA      = "kafka"
+	LOG_SINK_CLICKHOUSE = "clickhouse"
+
+	HEALTH_STATUS_OK   = "ok"
+	HEALTH_STATUS_WARN = "warn"
+	HEALTH_STATUS_FAIL = "fail"
+
+	WARMUP_SYNC  = "sync"
+	WARMUP_ASYNC = "async"
+	WARMUP_NONE  = "none"
+
+	AUDIO_TOKENS_PER_SECOND = 10  // 输入音频每秒tokens
+	DEFAULT_AUDIO_TOKENS    = 288 // 无法解析音频时长时的默认tokens
+
 	GPT_PREFIX     = "gpt-"
 	DEFAULT_MODEL  = "gpt-3.5-turbo"
-	QUOTA_USD_UNIT = 500000.0 // $1 = 50万tokens
+	QUOTA_USD_UNIT = 500000.0 // 默认 $1 = 50万tokens, 可通过 billing.quota_unit 配置
 )
@@ -7,10 +7,25 @@ const (
 	APP_QUOTA_FIELD  = "app.%d.quota"
 	KEY_QUOTA_FIELD  = "key.%d.%s.quota"
 
+	API_PERIOD_USAGE_KEY = "api:user:%d:usage:%s"
+
+	USER_USAGE_FIELD = "user.usage"
+	APP_USAGE_FIELD  = "app.%d.usage"
+	KEY_USAGE_FIELD  = "key.%d.%s.usage"
+
+	BUDGET_PERIOD_DAY   = "day"
+	BUDGET_PERIOD_WEEK  = "week"
+	BUDGET_PERIOD_MONTH = "month"
+
 	API_USER_KEY    = "api:user:%d"
 	API_APP_KEY     = "api:app:%d"
 	API_APP_KEY_KEY = "api:app:key:%s"
 
+	API_TOKEN_USAGE_KEY = "api:token:%s:usage"
+
+	API_END_USER_USAGE_KEY = "api:app:%d:end_user:usage:%s"
+	TOKEN_SPEND_FIELD      = "spend"
+
 	API_CORPS_KEY            = "api:corps"
 	API_MODELS_KEY           = "api:models"
 	API_MODEL_KEYS_KEY       = "api:model:keys:%s"
@@ -21,6 +36,8 @@ const (
 	ERROR_MODEL_AGENT     = "api:error:model:agent:%s"
 	ERROR_MODEL_AGENT_KEY = "api:error:model:agent:key:%s"
 
+	API_ALERT_KEY = "api:alert:%s"
+
 	ACCESS_TOKEN_KEY = "api:baidu:access_token:%s"
 	GCP_TOKEN_KEY    = "api:gcp:token:%s"
 )
@@ -0,0 +1,21 @@
+package admin
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/iimeta/fastapi/internal/service"
+
+	"github.com/iimeta/fastapi/api/admin/v1"
+)
+
+func (c *ControllerV1) Create(ctx context.Context, req *v1.CreateReq) (res *v1.CreateRes, err error) {
+
+	result, err := service.Admin().Create(ctx, req.Resource, g.RequestFromCtx(ctx).GetBody())
+	if err != nil {
+		return nil, err
+	}
+
+	g.RequestFromCtx(ctx).Response.WriteJson(result)
+
+	return
+}
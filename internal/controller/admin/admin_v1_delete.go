@@ -0,0 +1,13 @@
+package admin
+
+import (
+	"context"
+	"github.com/iimeta/fastapi/internal/service"
+
+	"github.com/iimeta/fastapi/api/admin/v1"
+)
+
+func (c *ControllerV1) Delete(ctx context.Context, req *v1.DeleteReq) (res *v1.DeleteRes, err error) {
+	err = service.Admin().Delete(ctx, req.Resource, req.Id)
+	return
+}
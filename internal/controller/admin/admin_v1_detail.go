@@ -0,0 +1,21 @@
+package admin
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/iimeta/fastapi/internal/service"
+
+	"github.com/iimeta/fastapi/api/admin/v1"
+)
+
+func (c *ControllerV1) Detail(ctx context.Context, req *v1.DetailReq) (res *v1.DetailRes, err error) {
+
+	result, err := service.Admin().Detail(ctx, req.Resource, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	g.RequestFromCtx(ctx).Response.WriteJson(result)
+
+	return
+}
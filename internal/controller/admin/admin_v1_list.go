@@ -0,0 +1,31 @@
+package admin
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/iimeta/fastapi/internal/model"
+	"github.com/iimeta/fastapi/internal/service"
+
+	"github.com/iimeta/fastapi/api/admin/v1"
+)
+
+func (c *ControllerV1) List(ctx context.Context, req *v1.ListReq) (res *v1.ListRes, err error) {
+
+	// 分页以外的查询参数作为过滤条件
+	filter := g.RequestFromCtx(ctx).GetQueryMap()
+	delete(filter, "page")
+	delete(filter, "page_size")
+
+	result, err := service.Admin().List(ctx, req.Resource, model.AdminListReq{
+		Page:     req.Page,
+		PageSize: req.PageSize,
+		Filter:   filter,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	g.RequestFromCtx(ctx).Response.WriteJson(result)
+
+	return
+}
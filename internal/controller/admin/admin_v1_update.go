@@ -0,0 +1,21 @@
+package admin
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/iimeta/fastapi/internal/service"
+
+	"github.com/iimeta/fastapi/api/admin/v1"
+)
+
+func (c *ControllerV1) Update(ctx context.Context, req *v1.UpdateReq) (res *v1.UpdateRes, err error) {
+
+	result, err := service.Admin().Update(ctx, req.Resource, req.Id, g.RequestFromCtx(ctx).GetBody())
+	if err != nil {
+		return nil, err
+	}
+
+	g.RequestFromCtx(ctx).Response.WriteJson(result)
+
+	return
+}
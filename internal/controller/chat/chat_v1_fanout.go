@@ -0,0 +1,29 @@
+package chat
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/logger"
+
+	"github.com/iimeta/fastapi/api/chat/v1"
+)
+
+func (c *ControllerV1) Fanout(ctx context.Context, req *v1.FanoutReq) (res *v1.FanoutRes, err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "Controller Fanout time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	response, err := service.Chat().Fanout(ctx, req.ChatFanoutReq)
+	if err != nil {
+		return nil, err
+	}
+
+	res = &v1.FanoutRes{
+		ChatFanoutRes: response,
+	}
+
+	return
+}
@@ -0,0 +1,21 @@
+package dashboard
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/iimeta/fastapi/internal/service"
+
+	"github.com/iimeta/fastapi/api/dashboard/v1"
+)
+
+func (c *ControllerV1) EndUserUsage(ctx context.Context, req *v1.EndUserUsageReq) (res *v1.EndUserUsageRes, err error) {
+
+	usage, err := service.Dashboard().EndUserUsage(ctx, req.EndUser)
+	if err != nil {
+		return nil, err
+	}
+
+	g.RequestFromCtx(ctx).Response.WriteJson(usage)
+
+	return
+}
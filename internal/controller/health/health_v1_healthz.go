@@ -0,0 +1,27 @@
+package health
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/iimeta/fastapi/api/health/v1"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/service"
+)
+
+func (c *ControllerV1) Healthz(ctx context.Context, req *v1.HealthzReq) (res *v1.HealthzRes, err error) {
+
+	result := service.Health().Liveness(ctx)
+
+	status := http.StatusOK
+	if result.Status == consts.HEALTH_STATUS_FAIL {
+		status = http.StatusServiceUnavailable
+	}
+
+	r := g.RequestFromCtx(ctx)
+	r.Response.WriteHeader(status)
+	r.Response.WriteJson(result)
+
+	return
+}
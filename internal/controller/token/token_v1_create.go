@@ -0,0 +1,29 @@
+package token
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/logger"
+
+	"github.com/iimeta/fastapi/api/token/v1"
+)
+
+func (c *ControllerV1) Create(ctx context.Context, req *v1.CreateReq) (res *v1.CreateRes, err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "Controller Token Create time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	response, err := service.Token().Create(ctx, req.TokenCreateReq)
+	if err != nil {
+		return nil, err
+	}
+
+	res = &v1.CreateRes{
+		TokenCreateRes: response,
+	}
+
+	return
+}
@@ -0,0 +1,229 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/grpool"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/logger"
+	"github.com/iimeta/fastapi/utility/redis"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// 已处理的变更版本号, 为0时以下一次读取到的版本号为基准
+var lastVersion atomic.Int64
+
+// 消费变更流, 每个实例使用独立的消费组, 断线重连后从消费组的位置继续消费
+func consume(ctx context.Context) {
+
+	hostname, _ := os.Hostname()
+	node := cmp.Or(config.Cfg.Core.ChangeStream.Node, hostname, "fastapi")
+
+	if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+
+		var (
+			id        string
+			ready     bool
+			started   bool
+			suspect   int64
+			cleanupAt time.Time
+		)
+
+		for {
+
+			if !ready {
+
+				created, err := redis.XGroupCreate(ctx, consts.CHANGE_STREAM_KEY, node, "$")
+				if err != nil {
+					logger.Errorf(ctx, "Core ChangeStream node: %s, error: %v", node, err)
+					service.Health().SetSubscribed(false)
+					time.Sleep(5 * time.Second)
+					continue
+				}
+
+				if !started {
+
+					// 启动时会预热, 从最新位置开始消费, 不预热时从上次的位置继续消费
+					if !created && config.Cfg.Core.Warmup != consts.WARMUP_NONE {
+						if err = redis.XGroupSetID(ctx, consts.CHANGE_STREAM_KEY, node, "$"); err != nil {
+							logger.Errorf(ctx, "Core ChangeStream node: %s, error: %v", node, err)
+							time.Sleep(5 * time.Second)
+							continue
+						}
+					}
+
+					started = true
+
+				} else if created {
+					// 消费组丢失时无法确定错过了哪些变更
+					repair(ctx, "change stream group is lost")
+				}
+
+				// 先处理已读取但未确认的消息
+				id = "0"
+				ready = true
+			}
+
+			messages, err := redis.XReadGroup(ctx, consts.CHANGE_STREAM_KEY, node, node, id, 100, changeBlock())
+			if err != nil {
+				logger.Errorf(ctx, "Core ChangeStream node: %s, error: %v", node, err)
+				service.Health().SetSubscribed(false)
+				ready = false
+				time.Sleep(5 * time.Second)
+				continue
+			}
+
+			service.Health().SetSubscribed(true)
+
+			if len(messages) == 0 {
+
+				if id == "0" {
+					id = ">"
+					continue
+				}
+
+				suspect = checkDrift(ctx, suspect)
+
+				if time.Since(cleanupAt) > time.Hour {
+					cleanupGroups(ctx, node)
+					cleanupAt = time.Now()
+				}
+
+				continue
+			}
+
+			for _, message := range messages {
+
+				version := gconv.Int64(message.Values["version"])
+
+				// 版本号不连续时说明变更流已被裁剪, 中间的变更丢失
+				if last := lastVersion.Load(); last > 0 && version > last+1 {
+					repair(ctx, "change stream version gap")
+				}
+
+				if last := lastVersion.Load(); last == 0 || version > last {
+
+					if err = dispatch(ctx, gconv.String(message.Values["channel"]), gconv.String(message.Values["payload"])); err != nil {
+						logger.Error(ctx, err)
+					}
+
+					lastVersion.Store(version)
+				}
+
+				if _, err = redis.XAck(ctx, consts.CHANGE_STREAM_KEY, node, message.ID); err != nil {
+					logger.Errorf(ctx, "Core ChangeStream node: %s, id: %s, error: %v", node, message.ID, err)
+				}
+			}
+		}
+	}, nil); err != nil {
+		panic(err)
+	}
+}
+
+// 空闲时检查版本号, 上一次空闲时的版本号仍未处理时说明变更丢失, 返回本次的版本号
+func checkDrift(ctx context.Context, suspect int64) int64 {
+
+	version, err := redis.GetInt(ctx, consts.CHANGE_VERSION_KEY)
+	if err != nil {
+		logger.Errorf(ctx, "Core ChangeStream checkDrift error: %v", err)
+		return 0
+	}
+
+	last := lastVersion.Load()
+
+	if last == 0 {
+		lastVersion.Store(int64(version))
+		return 0
+	}
+
+	if suspect > last {
+		repair(ctx, "change stream version is behind")
+		lastVersion.Store(suspect)
+		return 0
+	}
+
+	if int64(version) > last {
+		return int64(version)
+	}
+
+	return 0
+}
+
+// 修复缓存, 重新预热并以下一次读取到的版本号为基准
+func repair(ctx context.Context, reason string) {
+
+	logger.Errorf(ctx, "Core ChangeStream repair, reason: %s, last version: %d", reason, lastVersion.Load())
+
+	lastVersion.Store(0)
+
+	if err := grpool.Add(gctx.NeverDone(ctx), warmup); err != nil {
+		logger.Error(ctx, err)
+	}
+}
+
+// 删除其它实例空闲超时的消费组, 避免实例名称变化后消费组堆积
+func cleanupGroups(ctx context.Context, node string) {
+
+	ttl := config.Cfg.Core.ChangeStream.GroupTTL
+	if ttl <= 0 {
+		ttl = 86400
+	}
+
+	groups, err := redis.XInfoGroups(ctx, consts.CHANGE_STREAM_KEY)
+	if err != nil {
+		logger.Errorf(ctx, "Core ChangeStream cleanupGroups error: %v", err)
+		return
+	}
+
+	for _, group := range groups {
+
+		if group.Name == node {
+			continue
+		}
+
+		consumers, err := redis.XInfoConsumers(ctx, consts.CHANGE_STREAM_KEY, group.Name)
+		if err != nil {
+			logger.Errorf(ctx, "Core ChangeStream cleanupGroups group: %s, error: %v", group.Name, err)
+			continue
+		}
+
+		// 没有消费者时无法判断空闲时间
+		if len(consumers) == 0 {
+			continue
+		}
+
+		idle := true
+		for _, consumer := range consumers {
+			if consumer.Idle < ttl*time.Second {
+				idle = false
+				break
+			}
+		}
+
+		if !idle {
+			continue
+		}
+
+		if _, err = redis.XGroupDestroy(ctx, consts.CHANGE_STREAM_KEY, group.Name); err != nil {
+			logger.Errorf(ctx, "Core ChangeStream cleanupGroups group: %s, error: %v", group.Name, err)
+			continue
+		}
+
+		logger.Infof(ctx, "Core ChangeStream cleanupGroups group: %s destroyed", group.Name)
+	}
+}
+
+func changeBlock() time.Duration {
+
+	if block := config.Cfg.Core.ChangeStream.Block; block > 0 {
+		return block * time.Second
+	}
+
+	return 5 * time.Second
+}
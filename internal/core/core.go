@@ -2,18 +2,17 @@ package core
 
 import (
 	"context"
-	"fmt"
-	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/database/gredis"
+	"github.com/gogf/gf/v2/encoding/gjson"
 	"github.com/gogf/gf/v2/os/gctx"
 	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/internal/config"
 	"github.com/iimeta/fastapi/internal/consts"
 	_ "github.com/iimeta/fastapi/internal/logic"
-	"github.com/iimeta/fastapi/internal/model"
 	"github.com/iimeta/fastapi/internal/service"
 	"github.com/iimeta/fastapi/utility/logger"
 	"github.com/iimeta/fastapi/utility/redis"
-	"github.com/iimeta/fastapi/utility/util"
 	"time"
 )
 
@@ -28,145 +27,77 @@ func init() {
 		logger.Infof(ctx, "Core init time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	users, err := service.User().List(ctx)
-	if err != nil {
-		panic(err)
-	}
-
-	userMap := util.ToMap(users, func(user *model.User) int {
-
-		if err = service.User().SaveCacheUser(ctx, user); err != nil {
-			panic(err)
-		}
-
-		if err = service.User().SaveCacheUserQuota(ctx, user.UserId, user.Quota); err != nil {
-			panic(err)
-		}
-
-		if _, err = redis.HSetStrAny(ctx, fmt.Sprintf(consts.API_USAGE_KEY, user.UserId), consts.USER_QUOTA_FIELD, user.Quota); err != nil {
-			panic(err)
-		}
-
-		return user.UserId
-	})
-
-	keys, err := service.Key().List(ctx, 1)
-	if err != nil {
-		panic(err)
-	}
-
-	keyMap := make(map[int][]*model.Key)
-	for _, key := range keys {
-
-		if err = service.App().SaveCacheAppKey(ctx, key); err != nil {
-			panic(err)
-		}
-
-		if err = service.App().SaveCacheAppKeyQuota(ctx, key.Key, key.Quota); err != nil {
-			panic(err)
+	// 未预热的数据在首次访问时加载
+	switch config.Cfg.Core.Warmup {
+	case consts.WARMUP_SYNC:
+		warmup(ctx)
+	case consts.WARMUP_NONE:
+		logger.Info(ctx, "Core warmup skipped")
+	default:
+		if err := grpool.Add(gctx.NeverDone(ctx), warmup); err != nil {
+			logger.Error(ctx, err)
 		}
-
-		keyMap[key.AppId] = append(keyMap[key.AppId], key)
 	}
 
-	apps, err := service.App().List(ctx)
-	if err != nil {
-		panic(err)
-	}
-
-	for _, app := range apps {
-
-		if err = service.App().SaveCacheApp(ctx, app); err != nil {
-			panic(err)
-		}
+	// 启动日志写入任务
+	service.LogWriter().Start(ctx)
 
-		if err = service.App().SaveCacheAppQuota(ctx, app.AppId, app.Quota); err != nil {
-			panic(err)
-		}
-
-		user := userMap[app.UserId]
-		if user != nil {
-			fields := g.Map{
-				fmt.Sprintf(consts.APP_QUOTA_FIELD, app.AppId): app.Quota,
-			}
+	consume(ctx)
+	subscribe(ctx)
+}
 
-			keys := keyMap[app.AppId]
-			for _, key := range keys {
-				fields[fmt.Sprintf(consts.KEY_QUOTA_FIELD, key.AppId, key.Key)] = key.Quota
-			}
+// 分发变更通知, 变更流和频道的消息都会经过这里, 按实体版本跳过过期的变更
+func dispatch(ctx context.Context, channel, payload string) error {
 
-			if _, err = redis.HSet(ctx, fmt.Sprintf(consts.API_USAGE_KEY, app.UserId), fields); err != nil {
-				panic(err)
-			}
-		}
+	key, action, current, ok := parseEntity(channel, payload)
+	if !ok {
+		return route(ctx, channel, payload)
 	}
 
-	// 获取所有公司
-	corps, err := service.Corp().List(ctx)
-	if err != nil {
-		panic(err)
-	}
+	entityMutex.Lock()
+	defer entityMutex.Unlock()
 
-	if len(corps) > 0 {
-		// 初始化公司信息到缓存
-		if err = service.Corp().SaveCacheList(ctx, corps); err != nil {
-			panic(err)
-		}
+	last, exists := entityVersions[key]
+	if exists && current.staleThan(last, action) {
+		logger.Infof(ctx, "Core dispatch skip stale change: %s, action: %s, version: %d, updated_at: %d, last version: %d, last updated_at: %d",
+			key, action, current.version, current.updatedAt, last.version, last.updatedAt)
+		return nil
 	}
 
-	// 获取所有模型
-	models, err := service.Model().ListAll(ctx)
-	if err != nil {
-		panic(err)
+	if err := route(ctx, channel, payload); err != nil {
+		return err
 	}
 
-	if len(models) > 0 {
-
-		// 初始化模型信息到缓存
-		if err = service.Model().SaveCacheList(ctx, models); err != nil {
-			panic(err)
-		}
-
-		// 初始化模型密钥到缓存
-		for _, model := range models {
+	entityVersions[key] = current.merge(last, action)
 
-			modelKeys, err := service.Key().GetModelKeys(ctx, model.Id)
-			if err != nil {
-				panic(err)
-			}
-
-			if err = service.Key().SaveCacheModelKeys(ctx, model.Id, modelKeys); err != nil {
-				panic(err)
-			}
-		}
-	}
+	return nil
+}
 
-	// 获取所有模型代理
-	modelAgents, err := service.ModelAgent().ListAll(ctx)
-	if err != nil {
-		panic(err)
+// 按频道分发变更通知
+func route(ctx context.Context, channel, payload string) error {
+
+	switch channel {
+	case consts.CHANGE_CHANNEL_USER:
+		return service.User().Subscribe(ctx, payload)
+	case consts.CHANGE_CHANNEL_APP:
+		return service.App().Subscribe(ctx, payload)
+	case consts.CHANGE_CHANNEL_APP_KEY:
+		return service.App().SubscribeKey(ctx, payload)
+	case consts.CHANGE_CHANNEL_CORP:
+		return service.Corp().Subscribe(ctx, payload)
+	case consts.CHANGE_CHANNEL_MODEL:
+		return service.Model().Subscribe(ctx, payload)
+	case consts.CHANGE_CHANNEL_KEY:
+		return service.Key().Subscribe(ctx, payload)
+	case consts.CHANGE_CHANNEL_AGENT:
+		return service.ModelAgent().Subscribe(ctx, payload)
 	}
 
-	if len(modelAgents) > 0 {
-
-		// 初始化模型代理信息到缓存
-		if err = service.ModelAgent().SaveCacheList(ctx, modelAgents); err != nil {
-			panic(err)
-		}
-
-		// 初始化模型代理密钥到缓存
-		for _, modelAgent := range modelAgents {
-
-			agentKeys, err := service.ModelAgent().GetModelAgentKeys(ctx, modelAgent.Id)
-			if err != nil {
-				panic(err)
-			}
+	return nil
+}
 
-			if err = service.ModelAgent().SaveCacheModelAgentKeys(ctx, modelAgent.Id, agentKeys); err != nil {
-				panic(err)
-			}
-		}
-	}
+// 订阅变更通知频道, 兼容未写入变更流的发布者, Redis不可用时由进程内缓存继续提供服务, 重连成功后重新预热
+func subscribe(ctx context.Context) {
 
 	channels := make([]string, 0)
 	channels = append(channels, consts.CHANGE_CHANNEL_USER)
@@ -177,44 +108,53 @@ func init() {
 	channels = append(channels, consts.CHANGE_CHANNEL_KEY)
 	channels = append(channels, consts.CHANGE_CHANNEL_AGENT)
 
-	conn, _, err := redis.Subscribe(ctx, channels[0], channels[1:]...)
-	if err != nil {
-		panic(err)
-	}
+	if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+
+		var (
+			conn      gredis.Conn
+			err       error
+			reconnect bool
+		)
 
-	if err = grpool.AddWithRecover(ctx, func(ctx context.Context) {
 		for {
 
+			if conn == nil {
+
+				if conn, _, err = redis.Subscribe(ctx, channels[0], channels[1:]...); err != nil {
+					logger.Errorf(ctx, "Core Subscribe error: %v", err)
+					conn = nil
+					time.Sleep(5 * time.Second)
+					continue
+				}
+
+				// 断线期间频道的变更通知已丢失, 重新预热刷新缓存和用量
+				if reconnect {
+					logger.Info(ctx, "Core Subscribe Reconnect success")
+					if config.Cfg.Core.Warmup != consts.WARMUP_NONE {
+						if err = grpool.Add(ctx, warmup); err != nil {
+							logger.Error(ctx, err)
+						}
+					}
+				}
+
+				reconnect = true
+			}
+
 			msg, err := conn.ReceiveMessage(ctx)
 			if err != nil {
 				logger.Errorf(ctx, "Core Subscribe error: %v", err)
+				_ = conn.Close(ctx)
+				conn = nil
 				time.Sleep(5 * time.Second)
-				if conn, _, err = redis.Subscribe(ctx, channels[0], channels[1:]...); err != nil {
-					logger.Errorf(ctx, "Core Subscribe Reconnect error: %v", err)
-				} else {
-					logger.Info(ctx, "Core Subscribe Reconnect success")
-				}
 				continue
 			}
 
-			switch msg.Channel {
-			case consts.CHANGE_CHANNEL_USER:
-				err = service.User().Subscribe(ctx, msg.Payload)
-			case consts.CHANGE_CHANNEL_APP:
-				err = service.App().Subscribe(ctx, msg.Payload)
-			case consts.CHANGE_CHANNEL_APP_KEY:
-				err = service.App().SubscribeKey(ctx, msg.Payload)
-			case consts.CHANGE_CHANNEL_CORP:
-				err = service.Corp().Subscribe(ctx, msg.Payload)
-			case consts.CHANGE_CHANNEL_MODEL:
-				err = service.Model().Subscribe(ctx, msg.Payload)
-			case consts.CHANGE_CHANNEL_KEY:
-				err = service.Key().Subscribe(ctx, msg.Payload)
-			case consts.CHANGE_CHANNEL_AGENT:
-				err = service.ModelAgent().Subscribe(ctx, msg.Payload)
+			// 写入变更流的消息由变更流消费, 只处理未写入变更流的发布者的消息
+			if gjson.New(msg.Payload).Get("version").Int64() > 0 {
+				continue
 			}
 
-			if err != nil {
+			if err = dispatch(ctx, msg.Channel, msg.Payload); err != nil {
 				logger.Error(ctx, err)
 			}
 		}
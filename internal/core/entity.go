@@ -0,0 +1,93 @@
+package core
+
+import (
+	"cmp"
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/iimeta/fastapi/internal/consts"
+	"sync"
+)
+
+// 变更通知中实体的标识和版本, 字段名同实体, 兼容管理后台的消息格式
+type changeEntity struct {
+	Id        string
+	Version   int64
+	UpdatedAt int64
+}
+
+type changeMessage struct {
+	Action  string        `json:"action"`
+	OldData *changeEntity `json:"old_data"`
+	NewData *changeEntity `json:"new_data"`
+}
+
+// 已处理的实体版本
+type entityVersion struct {
+	version   int64
+	updatedAt int64
+	deleted   bool
+}
+
+var (
+	entityMutex    sync.Mutex
+	entityVersions = make(map[string]entityVersion) // [频道:实体ID]
+)
+
+// 解析变更通知中的实体, 没有实体ID时返回false
+func parseEntity(channel, payload string) (string, string, entityVersion, bool) {
+
+	message := new(changeMessage)
+	if err := gjson.Unmarshal([]byte(payload), message); err != nil {
+		return "", "", entityVersion{}, false
+	}
+
+	data := cmp.Or(message.NewData, message.OldData)
+	if data == nil || data.Id == "" {
+		return "", "", entityVersion{}, false
+	}
+
+	return channel + ":" + data.Id, message.Action, entityVersion{
+		version:   data.Version,
+		updatedAt: data.UpdatedAt,
+		deleted:   message.Action == consts.ACTION_DELETE,
+	}, true
+}
+
+// 是否早于已处理的版本, 未带版本号的旧消息按更新时间比较
+func (v entityVersion) staleThan(last entityVersion, action string) bool {
+
+	// 新建的实体没有更早的状态
+	if action == consts.ACTION_CREATE {
+		return false
+	}
+
+	if v.version == 0 {
+
+		// 无法判断先后时不覆盖带版本号的状态
+		if v.updatedAt == 0 {
+			return last.version > 0
+		}
+
+		return v.updatedAt < last.updatedAt || (last.deleted && v.updatedAt <= last.updatedAt)
+	}
+
+	if v.version != last.version {
+		return v.version < last.version
+	}
+
+	// 版本号相同时, 旧消息可能已在其后更新过实体
+	return v.updatedAt < last.updatedAt || last.deleted
+}
+
+// 合并已处理的版本, 新建时以新实体为准
+func (v entityVersion) merge(last entityVersion, action string) entityVersion {
+
+	if action == consts.ACTION_CREATE {
+		return v
+	}
+
+	return entityVersion{
+		version:   max(v.version, last.version),
+		updatedAt: max(v.updatedAt, last.updatedAt),
+		deleted:   v.deleted,
+	}
+}
@@ -0,0 +1,217 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/model"
+	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/logger"
+	"github.com/iimeta/fastapi/utility/redis"
+	"sync/atomic"
+)
+
+var warming atomic.Bool
+
+// 预热缓存, 单条数据异常时记录日志并跳过, 不影响其它数据和启动
+func warmup(ctx context.Context) {
+
+	if !warming.CompareAndSwap(false, true) {
+		logger.Info(ctx, "Core warmup is running, skip")
+		return
+	}
+	defer warming.Store(false)
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Infof(ctx, "Core warmup time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	users := warmupUsers(ctx)
+	keys := warmupAppKeys(ctx)
+
+	warmupApps(ctx, users, keys)
+	warmupCorps(ctx)
+	warmupModels(ctx)
+	warmupModelAgents(ctx)
+}
+
+// 预热用户, 返回用户ID对应的用户
+func warmupUsers(ctx context.Context) map[int]*model.User {
+
+	userMap := make(map[int]*model.User)
+
+	users, err := service.User().List(ctx)
+	if err != nil {
+		logger.Errorf(ctx, "Core warmup users error: %v", err)
+		return userMap
+	}
+
+	for _, user := range users {
+
+		userMap[user.UserId] = user
+
+		if err = service.User().SaveCacheUser(ctx, user); err != nil {
+			logger.Errorf(ctx, "Core warmup user: %d, error: %v", user.UserId, err)
+			continue
+		}
+
+		if err = service.User().SaveCacheUserQuota(ctx, user.UserId, user.Quota); err != nil {
+			logger.Errorf(ctx, "Core warmup user: %d, error: %v", user.UserId, err)
+			continue
+		}
+
+		if _, err = redis.HSetStrAny(ctx, fmt.Sprintf(consts.API_USAGE_KEY, user.UserId), consts.USER_QUOTA_FIELD, user.Quota); err != nil {
+			logger.Errorf(ctx, "Core warmup user: %d, error: %v", user.UserId, err)
+		}
+	}
+
+	return userMap
+}
+
+// 预热应用密钥, 返回应用ID对应的密钥列表
+func warmupAppKeys(ctx context.Context) map[int][]*model.Key {
+
+	keyMap := make(map[int][]*model.Key)
+
+	keys, err := service.Key().List(ctx, 1)
+	if err != nil {
+		logger.Errorf(ctx, "Core warmup app keys error: %v", err)
+		return keyMap
+	}
+
+	for _, key := range keys {
+
+		keyMap[key.AppId] = append(keyMap[key.AppId], key)
+
+		if err = service.App().SaveCacheAppKey(ctx, key); err != nil {
+			logger.Errorf(ctx, "Core warmup app key: %s, error: %v", key.Id, err)
+			continue
+		}
+
+		if err = service.App().SaveCacheAppKeyQuota(ctx, key.KeyHash, key.Quota); err != nil {
+			logger.Errorf(ctx, "Core warmup app key: %s, error: %v", key.Id, err)
+		}
+	}
+
+	return keyMap
+}
+
+// 预热应用和用量
+func warmupApps(ctx context.Context, userMap map[int]*model.User, keyMap map[int][]*model.Key) {
+
+	apps, err := service.App().List(ctx)
+	if err != nil {
+		logger.Errorf(ctx, "Core warmup apps error: %v", err)
+		return
+	}
+
+	for _, app := range apps {
+
+		if err = service.App().SaveCacheApp(ctx, app); err != nil {
+			logger.Errorf(ctx, "Core warmup app: %d, error: %v", app.AppId, err)
+			continue
+		}
+
+		if err = service.App().SaveCacheAppQuota(ctx, app.AppId, app.Quota); err != nil {
+			logger.Errorf(ctx, "Core warmup app: %d, error: %v", app.AppId, err)
+			continue
+		}
+
+		if userMap[app.UserId] == nil {
+			continue
+		}
+
+		fields := g.Map{
+			fmt.Sprintf(consts.APP_QUOTA_FIELD, app.AppId): app.Quota,
+		}
+
+		for _, key := range keyMap[app.AppId] {
+			fields[fmt.Sprintf(consts.KEY_QUOTA_FIELD, key.AppId, key.KeyHash)] = key.Quota
+		}
+
+		if _, err = redis.HSet(ctx, fmt.Sprintf(consts.API_USAGE_KEY, app.UserId), fields); err != nil {
+			logger.Errorf(ctx, "Core warmup app: %d, error: %v", app.AppId, err)
+		}
+	}
+}
+
+// 预热公司
+func warmupCorps(ctx context.Context) {
+
+	corps, err := service.Corp().List(ctx)
+	if err != nil {
+		logger.Errorf(ctx, "Core warmup corps error: %v", err)
+		return
+	}
+
+	if len(corps) > 0 {
+		if err = service.Corp().SaveCacheList(ctx, corps); err != nil {
+			logger.Errorf(ctx, "Core warmup corps error: %v", err)
+		}
+	}
+}
+
+// 预热模型和模型密钥
+func warmupModels(ctx context.Context) {
+
+	models, err := service.Model().ListAll(ctx)
+	if err != nil {
+		logger.Errorf(ctx, "Core warmup models error: %v", err)
+		return
+	}
+
+	if len(models) == 0 {
+		return
+	}
+
+	if err = service.Model().SaveCacheList(ctx, models); err != nil {
+		logger.Errorf(ctx, "Core warmup models error: %v", err)
+	}
+
+	for _, m := range models {
+
+		modelKeys, err := service.Key().GetModelKeys(ctx, m.Id)
+		if err != nil {
+			logger.Errorf(ctx, "Core warmup model: %s, error: %v", m.Model, err)
+			continue
+		}
+
+		if err = service.Key().SaveCacheModelKeys(ctx, m.Id, modelKeys); err != nil {
+			logger.Errorf(ctx, "Core warmup model: %s, error: %v", m.Model, err)
+		}
+	}
+}
+
+// 预热模型代理和模型代理密钥
+func warmupModelAgents(ctx context.Context) {
+
+	modelAgents, err := service.ModelAgent().ListAll(ctx)
+	if err != nil {
+		logger.Errorf(ctx, "Core warmup model agents error: %v", err)
+		return
+	}
+
+	if len(modelAgents) == 0 {
+		return
+	}
+
+	if err = service.ModelAgent().SaveCacheList(ctx, modelAgents); err != nil {
+		logger.Errorf(ctx, "Core warmup model agents error: %v", err)
+	}
+
+	for _, modelAgent := range modelAgents {
+
+		agentKeys, err := service.ModelAgent().GetModelAgentKeys(ctx, modelAgent.Id)
+		if err != nil {
+			logger.Errorf(ctx, "Core warmup model agent: %s, error: %v", modelAgent.Name, err)
+			continue
+		}
+
+		if err = service.ModelAgent().SaveCacheModelAgentKeys(ctx, modelAgent.Id, agentKeys); err != nil {
+			logger.Errorf(ctx, "Core warmup model agent: %s, error: %v", modelAgent.Name, err)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package dao
+
+import (
+	"github.com/iimeta/fastapi/internal/model/do"
+	"github.com/iimeta/fastapi/internal/model/entity"
+	"github.com/iimeta/fastapi/utility/db"
+)
+
+var AlertDelivery = NewAlertDeliveryDao()
+
+type AlertDeliveryDao struct {
+	*MongoDB[entity.AlertDelivery]
+}
+
+func NewAlertDeliveryDao(database ...string) *AlertDeliveryDao {
+
+	if len(database) == 0 {
+		database = append(database, db.DefaultDatabase)
+	}
+
+	return &AlertDeliveryDao{
+		MongoDB: NewMongoDB[entity.AlertDelivery](database[0], do.ALERT_DELIVERY_COLLECTION),
+	}
+}
@@ -0,0 +1,24 @@
+package dao
+
+import (
+	"github.com/iimeta/fastapi/internal/model/do"
+	"github.com/iimeta/fastapi/internal/model/entity"
+	"github.com/iimeta/fastapi/utility/db"
+)
+
+var Ledger = NewLedgerDao()
+
+type LedgerDao struct {
+	*MongoDB[entity.Ledger]
+}
+
+func NewLedgerDao(database ...string) *LedgerDao {
+
+	if len(database) == 0 {
+		database = append(database, db.DefaultDatabase)
+	}
+
+	return &LedgerDao{
+		MongoDB: NewMongoDB[entity.Ledger](database[0], do.LEDGER_COLLECTION),
+	}
+}
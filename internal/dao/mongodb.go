@@ -9,6 +9,7 @@ import (
 	"github.com/iimeta/fastapi/internal/errors"
 	"github.com/iimeta/fastapi/internal/service"
 	"github.com/iimeta/fastapi/utility/db"
+	"github.com/iimeta/fastapi/utility/logger"
 	"github.com/iimeta/fastapi/utility/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -31,11 +32,24 @@ func NewMongoDB[T IMongoDB](database, collection string) *MongoDB[T] {
 
 func (m *MongoDB[T]) Find(ctx context.Context, filter map[string]interface{}, sortFields ...string) ([]*T, error) {
 
-	var result []*T
-	if err := Find(ctx, m.Database, m.Collection, filter, &result, sortFields...); err != nil {
+	var documents []bson.Raw
+	if err := Find(ctx, m.Database, m.Collection, filter, &documents, sortFields...); err != nil {
 		return nil, err
 	}
 
+	result := make([]*T, 0, len(documents))
+	for _, document := range documents {
+
+		// 单条数据异常时记录日志并跳过, 不影响其它数据
+		value := new(T)
+		if err := bson.Unmarshal(document, value); err != nil {
+			logger.Errorf(ctx, "MongoDB Find collection: %s, _id: %s, error: %v", m.Collection, document.Lookup("_id"), err)
+			continue
+		}
+
+		result = append(result, value)
+	}
+
 	return result, nil
 }
 
@@ -140,19 +154,40 @@ func (m *MongoDB[T]) Insert(ctx context.Context, document interface{}) (string,
 
 func Insert(ctx context.Context, database string, document interface{}) (string, error) {
 
+	collection, value, err := NewDocument(ctx, document)
+	if err != nil {
+		return "", err
+	}
+
+	m := &db.MongoDB{
+		Database:   database,
+		Collection: collection,
+	}
+
+	id, err := m.InsertOne(ctx, value)
+	if err != nil {
+		return "", err
+	}
+
+	return gconv.String(id), nil
+}
+
+// 转换成待写入的文档, 生成主键并填充创建人和时间, 返回集合名称和文档
+func NewDocument(ctx context.Context, document interface{}) (string, bson.M, error) {
+
 	collection := gmeta.Get(document, "collection").String()
 	if collection == "" {
-		return "", errors.New("collection meta undefined")
+		return "", nil, errors.New("collection meta undefined")
 	}
 
 	bytes, err := bson.Marshal(document)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	value := bson.M{}
 	if err = bson.Unmarshal(bytes, &value); err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	// 统一主键成int类型的string格式, 雪花ID
@@ -170,17 +205,7 @@ func Insert(ctx context.Context, database string, document interface{}) (string,
 		value["updated_at"] = gtime.TimestampMilli()
 	}
 
-	m := &db.MongoDB{
-		Database:   database,
-		Collection: collection,
-	}
-
-	id, err := m.InsertOne(ctx, value)
-	if err != nil {
-		return "", err
-	}
-
-	return gconv.String(id), nil
+	return collection, value, nil
 }
 
 func (m *MongoDB[T]) Inserts(ctx context.Context, documents []interface{}) ([]string, error) {
@@ -197,40 +222,26 @@ func Inserts(ctx context.Context, database string, documents []interface{}) ([]s
 	values := make([]interface{}, 0)
 	for _, document := range documents {
 
-		bytes, err := bson.Marshal(document)
+		_, value, err := NewDocument(ctx, document)
 		if err != nil {
 			return nil, err
 		}
 
-		value := bson.M{}
-		if err = bson.Unmarshal(bytes, &value); err != nil {
-			return nil, err
-		}
-
-		// 统一主键成int类型的string格式, 雪花ID
-		value["_id"] = util.GenerateId()
-
-		if value["creator"] == nil || value["creator"] == "" {
-			value["creator"] = service.Session().GetSecretKey(ctx)
-		}
-
-		if value["created_at"] == nil || gconv.Int(value["created_at"]) == 0 {
-			value["created_at"] = gtime.TimestampMilli()
-		}
-
-		if value["updated_at"] == nil || gconv.Int(value["updated_at"]) == 0 {
-			value["updated_at"] = gtime.TimestampMilli()
-		}
-
 		values = append(values, value)
 	}
 
+	return InsertDocuments(ctx, database, collection, values)
+}
+
+// 批量写入已转换的文档
+func InsertDocuments(ctx context.Context, database, collection string, documents []interface{}) ([]string, error) {
+
 	m := &db.MongoDB{
 		Database:   database,
 		Collection: collection,
 	}
 
-	ids, err := m.InsertMany(ctx, values)
+	ids, err := m.InsertMany(ctx, documents)
 	if err != nil {
 		return nil, err
 	}
@@ -36,11 +36,13 @@ var (
 	ERR_NO_AVAILABLE_MODEL_AGENT_KEY  = NewError(500, "fastapi_error", "No available model agent key.", "fastapi_error")
 	ERR_ALL_MODEL_AGENT_KEY           = NewError(500, "fastapi_error", "All model agent key error.", "fastapi_error")
 	ERR_MODEL_HAS_BEEN_DISABLED       = NewError(500, "fastapi_error", "Model has been disabled.", "fastapi_error")
+	ERR_HEDGE_ABORTED                 = NewError(500, "fastapi_error", "Hedged request aborted, another upstream responded first.", "fastapi_error")
 	ERR_INVALID_PARAMETER             = NewError(400, "invalid_parameter", "Invalid Parameter.", "fastapi_request_error")
 	ERR_UNSUPPORTED_FILE_FORMAT       = NewError(400, "unsupported_file_format", "Unsupported file format.", "fastapi_request_error")
 	ERR_NOT_API_KEY                   = NewError(401, "invalid_request_error", "You didn't provide an API key.", "fastapi_request_error")
 	ERR_INVALID_API_KEY               = NewError(401, "invalid_api_key", "Incorrect API key provided or has been disabled.", "fastapi_request_error")
 	ERR_API_KEY_DISABLED              = NewError(401, "api_key_disabled", "Key has been disabled.", "fastapi_request_error")
+	ERR_INVALID_TOKEN                 = NewError(401, "invalid_token", "Incorrect token provided or has expired.", "fastapi_request_error")
 	ERR_INVALID_USER                  = NewError(401, "invalid_user", "User does not exist or has been disabled.", "fastapi_request_error")
 	ERR_USER_DISABLED                 = NewError(401, "user_disabled", "User has been disabled.", "fastapi_request_error")
 	ERR_INVALID_APP                   = NewError(401, "invalid_app", "App does not exist or has been disabled.", "fastapi_request_error")
@@ -51,7 +53,10 @@ var (
 	ERR_NOT_FOUND                     = NewError(404, "unknown_url", "Unknown request URL.", "fastapi_request_error")
 	ERR_MODEL_NOT_FOUND               = NewError(404, "model_not_found", "The model does not exist or you do not have access to it.", "fastapi_request_error")
 	ERR_PATH_NOT_FOUND                = NewError(404, "path_not_found", "The path does not exist or you do not have access to it.", "fastapi_request_error")
+	ERR_DATA_NOT_FOUND                = NewError(404, "data_not_found", "The data does not exist.", "fastapi_request_error")
 	ERR_INSUFFICIENT_QUOTA            = NewError(429, "insufficient_quota", "You exceeded your current quota.", "fastapi_request_error")
+	ERR_END_USER_BUDGET_EXCEEDED      = NewError(429, "end_user_budget_exceeded", "End user exceeded the token budget.", "fastapi_request_error")
+	ERR_BUDGET_EXCEEDED               = NewError(429, "budget_exceeded", "You exceeded your current period budget.", "fastapi_request_error")
 )
 
 func New(text string) error {
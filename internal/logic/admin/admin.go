@@ -0,0 +1,235 @@
+package admin
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/dao"
+	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/internal/logic/common"
+	"github.com/iimeta/fastapi/internal/model"
+	"github.com/iimeta/fastapi/internal/model/do"
+	"github.com/iimeta/fastapi/internal/model/entity"
+	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/crypto"
+	"github.com/iimeta/fastapi/utility/logger"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type sAdmin struct {
+	resources map[string]handler
+}
+
+func init() {
+	service.RegisterAdmin(New())
+}
+
+func New() service.IAdmin {
+	return &sAdmin{
+		resources: map[string]handler{
+			"users": newResource[entity.User, do.User](dao.User.MongoDB, "user_id", func(data *entity.User) string {
+				return consts.CHANGE_CHANNEL_USER
+			}, nil, nil),
+			"apps": newResource[entity.App, do.App](dao.App.MongoDB, "app_id", func(data *entity.App) string {
+				return consts.CHANGE_CHANNEL_APP
+			}, nil, nil),
+			"keys": newResource[entity.Key, do.Key](dao.Key.MongoDB, "", func(data *entity.Key) string {
+				if data.Type == 1 {
+					return consts.CHANGE_CHANNEL_APP_KEY
+				}
+				return consts.CHANGE_CHANNEL_KEY
+			}, prepareKey, maskKey),
+			"models": newResource[entity.Model, do.Model](dao.Model.MongoDB, "", func(data *entity.Model) string {
+				return consts.CHANGE_CHANNEL_MODEL
+			}, nil, nil),
+			"model_agents": newResource[entity.ModelAgent, do.ModelAgent](dao.ModelAgent.MongoDB, "", func(data *entity.ModelAgent) string {
+				return consts.CHANGE_CHANNEL_AGENT
+			}, nil, nil),
+			"corps": newResource[entity.Corp, do.Corp](dao.Corp.MongoDB, "", func(data *entity.Corp) string {
+				return consts.CHANGE_CHANNEL_CORP
+			}, nil, nil),
+		},
+	}
+}
+
+// 列表
+func (s *sAdmin) List(ctx context.Context, resource string, params model.AdminListReq) (*model.AdminListRes, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sAdmin List resource: %s, time: %d", resource, gtime.TimestampMilli()-now)
+	}()
+
+	h, err := s.handler(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.list(ctx, params)
+}
+
+// 详情
+func (s *sAdmin) Detail(ctx context.Context, resource string, id string) (interface{}, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sAdmin Detail resource: %s, time: %d", resource, gtime.TimestampMilli()-now)
+	}()
+
+	h, err := s.handler(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.detail(ctx, id)
+}
+
+// 新建
+func (s *sAdmin) Create(ctx context.Context, resource string, data []byte) (interface{}, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sAdmin Create resource: %s, time: %d", resource, gtime.TimestampMilli()-now)
+	}()
+
+	h, err := s.handler(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := h.create(ctx, data)
+	if err != nil {
+		logger.Errorf(ctx, "sAdmin Create resource: %s, error: %v", resource, err)
+		return nil, err
+	}
+
+	logger.Infof(ctx, "sAdmin Create resource: %s success", resource)
+
+	return result, nil
+}
+
+// 更新, 只更新请求中包含的字段
+func (s *sAdmin) Update(ctx context.Context, resource string, id string, data []byte) (interface{}, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sAdmin Update resource: %s, time: %d", resource, gtime.TimestampMilli()-now)
+	}()
+
+	h, err := s.handler(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := h.update(ctx, id, data)
+	if err != nil {
+		logger.Errorf(ctx, "sAdmin Update resource: %s, id: %s, error: %v", resource, id, err)
+		return nil, err
+	}
+
+	logger.Infof(ctx, "sAdmin Update resource: %s, id: %s success", resource, id)
+
+	return result, nil
+}
+
+// 删除
+func (s *sAdmin) Delete(ctx context.Context, resource string, id string) error {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sAdmin Delete resource: %s, time: %d", resource, gtime.TimestampMilli()-now)
+	}()
+
+	h, err := s.handler(resource)
+	if err != nil {
+		return err
+	}
+
+	if err = h.delete(ctx, id); err != nil {
+		logger.Errorf(ctx, "sAdmin Delete resource: %s, id: %s, error: %v", resource, id, err)
+		return err
+	}
+
+	logger.Infof(ctx, "sAdmin Delete resource: %s, id: %s success", resource, id)
+
+	return nil
+}
+
+func (s *sAdmin) handler(resource string) (handler, error) {
+
+	h, ok := s.resources[resource]
+	if !ok {
+		return nil, errors.ERR_NOT_FOUND
+	}
+
+	return h, nil
+}
+
+// 应用密钥只保存哈希和展示前缀, 模型密钥使用主密钥加密后保存
+func prepareKey(ctx context.Context, oldData *entity.Key, document bson.M) error {
+
+	typ := gconv.Int(document["type"])
+	if typ == 0 && oldData != nil {
+		typ = oldData.Type
+	}
+
+	if typ != 1 && typ != 2 {
+		return errors.NewError(400, "invalid_parameter", "Key type must be 1 or 2.", "fastapi_request_error")
+	}
+
+	// 与管理后台一致, 新建时显式写入是否代理专用, 获取模型密钥时按false查询
+	if _, ok := document["is_agents_only"]; !ok && oldData == nil {
+		document["is_agents_only"] = false
+	}
+
+	key := gconv.String(document["key"])
+	if key == "" {
+
+		if oldData == nil {
+			return errors.NewError(400, "invalid_parameter", "Key is required.", "fastapi_request_error")
+		}
+
+		return nil
+	}
+
+	if typ == 1 {
+		document["key"] = crypto.KeyPrefix(key)
+		document["key_hash"] = crypto.HashKey(key)
+		return nil
+	}
+
+	secret, err := common.EncryptKey(key)
+	if err != nil {
+		return err
+	}
+
+	document["key"] = secret
+
+	return nil
+}
+
+// 返回的密钥只展示掩码, 应用密钥为展示前缀, 模型密钥解密后只保留末4位
+func maskKey(data *entity.Key) *entity.Key {
+
+	key := *data
+
+	if key.Type == 1 {
+
+		if !crypto.IsKeyPrefix(key.Key) {
+			key.Key = crypto.KeyPrefix(key.Key)
+		}
+
+		return &key
+	}
+
+	secret, err := common.DecryptKey(key.Key)
+	if err != nil {
+		// 无法解密时不展示末4位
+		secret = ""
+	}
+
+	key.Key = crypto.MaskKey(secret)
+
+	return &key
+}
@@ -0,0 +1,363 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/dao"
+	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/internal/logic/common"
+	"github.com/iimeta/fastapi/internal/model"
+	"github.com/iimeta/fastapi/utility/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+	"strings"
+)
+
+// 由管理接口维护的字段, 请求中的值会被忽略
+var protectedFields = []string{"creator", "updater", "created_at", "updated_at", "version"}
+
+// 管理接口的操作者
+const operator = "admin"
+
+type handler interface {
+	list(ctx context.Context, params model.AdminListReq) (*model.AdminListRes, error)
+	detail(ctx context.Context, id string) (interface{}, error)
+	create(ctx context.Context, data []byte) (interface{}, error)
+	update(ctx context.Context, id string, data []byte) (interface{}, error)
+	delete(ctx context.Context, id string) error
+}
+
+// 管理资源, E为实体, D为写入文档
+type resource[E any, D any] struct {
+	dao      *dao.MongoDB[E]
+	seqField string                                                       // 自增编号字段, 新建时未指定则取最大值加1
+	channel  func(data *E) string                                         // 变更通知的频道
+	prepare  func(ctx context.Context, oldData *E, document bson.M) error // 写入前处理文档, 新建时oldData为nil
+	mask     func(data *E) *E                                             // 返回前脱敏, 返回副本, 写入和变更通知仍使用原数据
+	fields   map[string]int                                               // 数据库字段名对应写入文档的字段索引
+}
+
+func newResource[E any, D any](dao *dao.MongoDB[E], seqField string, channel func(data *E) string, prepare func(ctx context.Context, oldData *E, document bson.M) error, mask func(data *E) *E) *resource[E, D] {
+
+	fields := make(map[string]int)
+
+	t := reflect.TypeOf(new(D)).Elem()
+	for i := 0; i < t.NumField(); i++ {
+
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("bson"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		fields[name] = i
+	}
+
+	return &resource[E, D]{
+		dao:      dao,
+		seqField: seqField,
+		channel:  channel,
+		prepare:  prepare,
+		mask:     mask,
+		fields:   fields,
+	}
+}
+
+func (r *resource[E, D]) list(ctx context.Context, params model.AdminListReq) (*model.AdminListRes, error) {
+
+	filter := bson.M{}
+	for key, value := range params.Filter {
+
+		i, ok := r.fields[key]
+		if !ok {
+			return nil, errors.NewErrorf(400, "invalid_parameter", "Unknown filter field: %s.", "fastapi_request_error", key)
+		}
+
+		// 查询参数为字符串, 按字段类型转换, 数组字段按元素匹配
+		field := reflect.TypeOf(new(D)).Elem().Field(i).Type
+		if field.Kind() == reflect.Slice {
+			filter[key] = value
+		} else {
+			filter[key] = gconv.Convert(value, field.String())
+		}
+	}
+
+	paging := &db.Paging{
+		Page:     params.Page,
+		PageSize: params.PageSize,
+	}
+
+	if paging.Page <= 0 {
+		paging.Page = 1
+	}
+
+	if paging.PageSize <= 0 || paging.PageSize > 1000 {
+		paging.PageSize = 20
+	}
+
+	results, err := r.dao.FindByPage(ctx, paging, filter, "-updated_at")
+	if err != nil {
+		return nil, err
+	}
+
+	for i, result := range results {
+		results[i] = r.output(result)
+	}
+
+	return &model.AdminListRes{
+		Items:    results,
+		Page:     paging.Page,
+		PageSize: paging.PageSize,
+		Total:    paging.Total,
+	}, nil
+}
+
+func (r *resource[E, D]) detail(ctx context.Context, id string) (interface{}, error) {
+
+	result, err := r.findById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.output(result), nil
+}
+
+func (r *resource[E, D]) create(ctx context.Context, data []byte) (interface{}, error) {
+
+	document, err := r.decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.seqField != "" && gconv.Int(document[r.seqField]) == 0 {
+		if document[r.seqField], err = r.nextSeq(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.prepare != nil {
+		if err = r.prepare(ctx, nil, document); err != nil {
+			return nil, err
+		}
+	}
+
+	document["creator"] = operator
+	document["updater"] = operator
+
+	value, err := r.convert(document)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := r.dao.Insert(ctx, value)
+	if err != nil {
+		return nil, err
+	}
+
+	// 写入文档的字段带omitempty, 请求中的零值(如false)不会写入, 需单独写入
+	if zeroFields := r.zeroFields(document, value); len(zeroFields) > 0 {
+		if err = r.dao.UpdateById(ctx, id, bson.M{"$set": zeroFields}); err != nil {
+			return nil, err
+		}
+	}
+
+	newData, err := r.findById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = r.publish(ctx, consts.ACTION_CREATE, nil, newData); err != nil {
+		return nil, err
+	}
+
+	return r.output(newData), nil
+}
+
+func (r *resource[E, D]) update(ctx context.Context, id string, data []byte) (interface{}, error) {
+
+	oldData, err := r.findById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	document, err := r.decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(document) == 0 {
+		return nil, errors.NewError(400, "invalid_parameter", "No fields to update.", "fastapi_request_error")
+	}
+
+	action := consts.ACTION_UPDATE
+	if _, ok := document["status"]; ok && len(document) == 1 {
+		action = consts.ACTION_STATUS
+	} else if _, ok = document["models"]; ok && len(document) == 1 {
+		action = consts.ACTION_MODELS
+	}
+
+	if r.prepare != nil {
+		if err = r.prepare(ctx, oldData, document); err != nil {
+			return nil, err
+		}
+	}
+
+	value, err := r.convert(document)
+	if err != nil {
+		return nil, err
+	}
+
+	// 按字段类型取值, 请求中的零值也会更新
+	set := bson.M{}
+	for key := range document {
+		set[key] = reflect.ValueOf(value).Elem().Field(r.fields[key]).Interface()
+	}
+
+	set["updater"] = operator
+	set["updated_at"] = gtime.TimestampMilli()
+
+	// 递增版本号, 订阅方按版本号跳过过期的变更
+	if err = r.dao.UpdateById(ctx, id, bson.M{"$set": set, "$inc": bson.M{"version": 1}}); err != nil {
+		return nil, err
+	}
+
+	newData, err := r.findById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = r.publish(ctx, action, oldData, newData); err != nil {
+		return nil, err
+	}
+
+	return r.output(newData), nil
+}
+
+func (r *resource[E, D]) delete(ctx context.Context, id string) error {
+
+	oldData, err := r.findById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err = r.dao.DeleteById(ctx, id); err != nil {
+		return err
+	}
+
+	return r.publish(ctx, consts.ACTION_DELETE, oldData, nil)
+}
+
+func (r *resource[E, D]) findById(ctx context.Context, id string) (*E, error) {
+
+	result, err := r.dao.FindById(ctx, id)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.ERR_DATA_NOT_FOUND
+		}
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// 返回给调用方的数据, 配置了脱敏时返回脱敏后的副本
+func (r *resource[E, D]) output(data *E) *E {
+
+	if r.mask == nil {
+		return data
+	}
+
+	return r.mask(data)
+}
+
+// 解析请求数据, 字段名同数据库, 忽略管理接口维护的字段
+func (r *resource[E, D]) decode(data []byte) (bson.M, error) {
+
+	document := bson.M{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, errors.NewErrorf(400, "invalid_parameter", "Invalid JSON body: %v.", "fastapi_request_error", err)
+	}
+
+	for _, field := range protectedFields {
+		delete(document, field)
+	}
+
+	for key := range document {
+		if _, ok := r.fields[key]; !ok {
+			return nil, errors.NewErrorf(400, "invalid_parameter", "Unknown field: %s.", "fastapi_request_error", key)
+		}
+	}
+
+	return document, nil
+}
+
+// 按写入文档的字段类型转换
+func (r *resource[E, D]) convert(document bson.M) (*D, error) {
+
+	bytes, err := bson.Marshal(document)
+	if err != nil {
+		return nil, errors.NewErrorf(400, "invalid_parameter", "Invalid field value: %v.", "fastapi_request_error", err)
+	}
+
+	value := new(D)
+	if err = bson.Unmarshal(bytes, value); err != nil {
+		return nil, errors.NewErrorf(400, "invalid_parameter", "Invalid field value: %v.", "fastapi_request_error", err)
+	}
+
+	return value, nil
+}
+
+// 文档中值为零值的字段, 按字段类型取值
+func (r *resource[E, D]) zeroFields(document bson.M, value *D) bson.M {
+
+	fields := bson.M{}
+	for key := range document {
+		if i, ok := r.fields[key]; ok {
+			if field := reflect.ValueOf(value).Elem().Field(i); field.IsZero() {
+				fields[key] = field.Interface()
+			}
+		}
+	}
+
+	return fields
+}
+
+// 自增编号, 取当前最大值加1
+func (r *resource[E, D]) nextSeq(ctx context.Context) (int, error) {
+
+	result := bson.M{}
+	if err := dao.FindOne(ctx, r.dao.Database, r.dao.Collection, bson.M{}, &result, "-"+r.seqField); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return 1, nil
+		}
+		return 0, err
+	}
+
+	return gconv.Int(result[r.seqField]) + 1, nil
+}
+
+// 发布变更通知, 与管理后台的消息格式一致, 各实例订阅后刷新缓存
+func (r *resource[E, D]) publish(ctx context.Context, action string, oldData, newData *E) error {
+
+	data := newData
+	if data == nil {
+		data = oldData
+	}
+
+	message := model.PubMessage{
+		Action: action,
+	}
+
+	if oldData != nil {
+		message.OldData = oldData
+	}
+
+	if newData != nil {
+		message.NewData = newData
+	}
+
+	return common.PublishChange(ctx, r.channel(data), message)
+}
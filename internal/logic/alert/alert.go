@@ -0,0 +1,210 @@
+package alert
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/dao"
+	"github.com/iimeta/fastapi/internal/model"
+	"github.com/iimeta/fastapi/internal/model/do"
+	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/graceful"
+	"github.com/iimeta/fastapi/utility/logger"
+	"github.com/iimeta/fastapi/utility/redis"
+	"github.com/iimeta/fastapi/utility/util"
+	"slices"
+	"time"
+)
+
+type sAlert struct{}
+
+func init() {
+	service.RegisterAlert(New())
+}
+
+func New() service.IAlert {
+	return &sAlert{}
+}
+
+// 额度余量告警, 检测额度余量是否跨越阈值
+func (s *sAlert) QuotaAlert(ctx context.Context, target string, userId, appId, totalQuota, beforeQuota, afterQuota int) {
+
+	if totalQuota <= 0 || len(config.Cfg.Alert.Webhooks) == 0 {
+		return
+	}
+
+	for _, threshold := range config.Cfg.Alert.Thresholds {
+
+		limit := totalQuota * threshold / 100
+
+		// 只在跨越阈值时告警
+		if beforeQuota <= limit || afterQuota > limit {
+			continue
+		}
+
+		s.Notify(ctx, &model.AlertEvent{
+			Event:      consts.ALERT_EVENT_QUOTA_THRESHOLD,
+			UserId:     userId,
+			AppId:      appId,
+			Target:     target,
+			Threshold:  threshold,
+			Quota:      afterQuota,
+			TotalQuota: totalQuota,
+		}, fmt.Sprintf("%s:%s:%d:%d:%d:%d", consts.ALERT_EVENT_QUOTA_THRESHOLD, target, userId, appId, threshold, totalQuota))
+	}
+}
+
+// 密钥自动禁用告警
+func (s *sAlert) KeyDisabledAlert(ctx context.Context, target string, key *model.Key, reason string) {
+
+	if len(config.Cfg.Alert.Webhooks) == 0 {
+		return
+	}
+
+	s.Notify(ctx, &model.AlertEvent{
+		Event:  consts.ALERT_EVENT_KEY_DISABLED,
+		UserId: key.UserId,
+		AppId:  key.AppId,
+		Target: target,
+		KeyId:  key.Id,
+		Reason: reason,
+	}, fmt.Sprintf("%s:%s:%s", consts.ALERT_EVENT_KEY_DISABLED, target, key.Id))
+}
+
+// 推送告警, 相同dedupKey的告警在去重时间内只推送一次
+func (s *sAlert) Notify(ctx context.Context, event *model.AlertEvent, dedupKey string) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sAlert Notify time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	webhooks := make([]config.AlertWebhook, 0)
+	for _, webhook := range config.Cfg.Alert.Webhooks {
+
+		if len(webhook.Events) > 0 && !slices.Contains(webhook.Events, event.Event) {
+			continue
+		}
+
+		if len(webhook.UserIds) > 0 && !slices.Contains(webhook.UserIds, event.UserId) {
+			continue
+		}
+
+		if len(webhook.AppIds) > 0 && !slices.Contains(webhook.AppIds, event.AppId) {
+			continue
+		}
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	if len(webhooks) == 0 {
+		return
+	}
+
+	dedupTtl := config.Cfg.Alert.DedupTtl
+	if dedupTtl <= 0 {
+		dedupTtl = 86400
+	}
+
+	// 去重
+	key := fmt.Sprintf(consts.API_ALERT_KEY, dedupKey)
+	if ok, err := redis.SetNXEX(ctx, key, gtime.TimestampMilli(), dedupTtl); err != nil || !ok {
+		if err != nil {
+			logger.Error(ctx, err)
+		}
+		return
+	}
+
+	event.Id = util.GenerateId()
+	event.CreatedAt = gtime.TimestampMilli()
+
+	logger.Infof(ctx, "sAlert Notify event: %s", gjson.MustEncodeString(event))
+
+	for _, webhook := range webhooks {
+		if err := graceful.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			s.deliver(ctx, webhook, event)
+		}, nil); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
+}
+
+// 投递Webhook, 失败按次数退避重试, 投递结果记录到投递日志
+func (s *sAlert) deliver(ctx context.Context, webhook config.AlertWebhook, event *model.AlertEvent) {
+
+	now := gtime.TimestampMilli()
+
+	payload := gjson.MustEncodeString(event)
+
+	delivery := &do.AlertDelivery{
+		EventId: event.Id,
+		Event:   event.Event,
+		Url:     webhook.Url,
+		Payload: payload,
+		Status:  -1,
+	}
+
+	retry := max(config.Cfg.Alert.Retry, 0)
+
+	for delivery.Attempts = 1; delivery.Attempts <= retry+1; delivery.Attempts++ {
+
+		timestamp := gconv.String(gtime.Timestamp())
+
+		client := g.Client().Timeout(config.Cfg.Http.Timeout * time.Second).ContentJson().SetHeaderMap(map[string]string{
+			"X-FastAPI-Event":     event.Event,
+			"X-FastAPI-Timestamp": timestamp,
+			"X-FastAPI-Signature": sign(webhook.Secret, timestamp, payload),
+		})
+
+		response, err := client.Post(ctx, webhook.Url, payload)
+		if err == nil {
+
+			delivery.StatusCode = response.StatusCode
+			delivery.Response = response.ReadAllString()
+
+			if err = response.Close(); err != nil {
+				logger.Error(ctx, err)
+			}
+
+			if delivery.StatusCode >= 200 && delivery.StatusCode < 300 {
+				delivery.Status = 1
+				delivery.ErrMsg = ""
+				break
+			}
+
+			delivery.ErrMsg = fmt.Sprintf("status code: %d", delivery.StatusCode)
+
+		} else {
+			delivery.ErrMsg = err.Error()
+		}
+
+		logger.Errorf(ctx, "sAlert deliver url: %s, event id: %s, attempts: %d, error: %s", webhook.Url, event.Id, delivery.Attempts, delivery.ErrMsg)
+
+		if delivery.Attempts <= retry {
+			time.Sleep(time.Duration(delivery.Attempts*delivery.Attempts) * time.Second)
+		}
+	}
+
+	delivery.Attempts = min(delivery.Attempts, retry+1)
+	delivery.TotalTime = gtime.TimestampMilli() - now
+
+	if _, err := dao.AlertDelivery.Insert(ctx, delivery); err != nil {
+		logger.Error(ctx, err)
+	}
+}
+
+// 签名, HMAC-SHA256(secret, timestamp + "." + payload)
+func sign(secret, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -1,28 +1,38 @@
 package app
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"github.com/gogf/gf/v2/encoding/gjson"
 	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/internal/config"
 	"github.com/iimeta/fastapi/internal/consts"
 	"github.com/iimeta/fastapi/internal/dao"
 	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/internal/logic/common"
 	"github.com/iimeta/fastapi/internal/model"
 	"github.com/iimeta/fastapi/internal/model/entity"
 	"github.com/iimeta/fastapi/internal/service"
 	"github.com/iimeta/fastapi/utility/cache"
+	"github.com/iimeta/fastapi/utility/crypto"
 	"github.com/iimeta/fastapi/utility/logger"
 	"github.com/iimeta/fastapi/utility/redis"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
 	"time"
 )
 
 type sApp struct {
 	appCache         *cache.Cache // [appId]App
-	appKeyCache      *cache.Cache // [key]Key
+	appKeyCache      *cache.Cache // [keyHash]Key
 	appQuotaCache    *cache.Cache // [appId]Quota
-	appKeyQuotaCache *cache.Cache // [key]Quota
+	appKeyQuotaCache *cache.Cache // [keyHash]Quota
+	appKeyMissCache  *cache.Cache // [keyHash]数据库中不存在的密钥
+	appKeyLimiter    *rate.Limiter
+	group            singleflight.Group
 }
 
 func init() {
@@ -35,9 +45,26 @@ func New() service.IApp {
 		appKeyCache:      cache.New(),
 		appQuotaCache:    cache.New(),
 		appKeyQuotaCache: cache.New(),
+		appKeyMissCache:  cache.New(),
+		appKeyLimiter:    appKeyLimiter(),
 	}
 }
 
+// 从数据库加载应用密钥的限流器, 为nil时不限制
+func appKeyLimiter() *rate.Limiter {
+
+	limit := config.Cfg.Core.KeyMiss.Rate
+	if limit < 0 {
+		return nil
+	}
+
+	if limit == 0 {
+		limit = 100
+	}
+
+	return rate.NewLimiter(rate.Limit(limit), limit)
+}
+
 // 根据应用ID获取应用信息
 func (s *sApp) GetApp(ctx context.Context, appId int) (*model.App, error) {
 
@@ -61,8 +88,10 @@ func (s *sApp) GetApp(ctx context.Context, appId int) (*model.App, error) {
 		Quota:          app.Quota,
 		UsedQuota:      app.UsedQuota,
 		QuotaExpiresAt: app.QuotaExpiresAt,
+		Budgets:        app.Budgets,
 		IpWhitelist:    app.IpWhitelist,
 		IpBlacklist:    app.IpBlacklist,
+		LogPolicy:      app.LogPolicy,
 		Remark:         app.Remark,
 		Status:         app.Status,
 		UserId:         app.UserId,
@@ -96,8 +125,10 @@ func (s *sApp) List(ctx context.Context) ([]*model.App, error) {
 			Quota:          result.Quota,
 			UsedQuota:      result.UsedQuota,
 			QuotaExpiresAt: result.QuotaExpiresAt,
+			Budgets:        result.Budgets,
 			IpWhitelist:    result.IpWhitelist,
 			IpBlacklist:    result.IpBlacklist,
+			LogPolicy:      result.LogPolicy,
 			Remark:         result.Remark,
 			Status:         result.Status,
 			UserId:         result.UserId,
@@ -164,11 +195,7 @@ func (s *sApp) SaveCacheApp(ctx context.Context, app *model.App) error {
 		return errors.New("app is nil")
 	}
 
-	if _, err := redis.Set(ctx, fmt.Sprintf(consts.API_APP_KEY, app.AppId), app); err != nil {
-		logger.Error(ctx, err)
-		return err
-	}
-
+	// 先写本地缓存, Redis不可用时本实例仍可使用
 	service.Session().SaveApp(ctx, app)
 
 	if err := s.appCache.Set(ctx, app.AppId, app, 0); err != nil {
@@ -181,6 +208,11 @@ func (s *sApp) SaveCacheApp(ctx context.Context, app *model.App) error {
 		return err
 	}
 
+	if _, err := redis.Set(ctx, fmt.Sprintf(consts.API_APP_KEY, app.AppId), app); err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
 	return nil
 }
 
@@ -202,29 +234,52 @@ func (s *sApp) GetCacheApp(ctx context.Context, appId int) (*model.App, error) {
 		return app, nil
 	}
 
-	reply, err := redis.Get(ctx, fmt.Sprintf(consts.API_APP_KEY, appId))
+	// 本地缓存未命中时按需加载, 同一应用并发请求只加载一次, 不受首个请求取消的影响
+	value, err, _ := s.group.Do(fmt.Sprint("app:", appId), func() (interface{}, error) {
+		return s.loadApp(context.WithoutCancel(ctx), appId)
+	})
 	if err != nil {
-		logger.Error(ctx, err)
 		return nil, err
 	}
 
-	if reply == nil || reply.IsNil() {
-		return nil, errors.New("app is nil")
-	}
+	app := value.(*model.App)
+	service.Session().SaveApp(ctx, app)
+
+	return app, nil
+}
+
+// 加载应用信息, 优先从Redis获取, Redis不可用或不存在时从数据库获取
+func (s *sApp) loadApp(ctx context.Context, appId int) (*model.App, error) {
 
 	app := new(model.App)
-	if err = reply.Struct(&app); err != nil {
+
+	reply, err := redis.Get(ctx, fmt.Sprintf(consts.API_APP_KEY, appId))
+	if err != nil {
 		logger.Error(ctx, err)
-		return nil, err
 	}
 
-	service.Session().SaveApp(ctx, app)
+	if err != nil || reply == nil || reply.IsNil() || reply.Struct(&app) != nil {
+
+		if app, err = s.GetApp(ctx, appId); err != nil {
+			logger.Error(ctx, err)
+			return nil, err
+		}
+
+		if _, err = redis.Set(ctx, fmt.Sprintf(consts.API_APP_KEY, appId), app); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
 
 	if err = s.appCache.Set(ctx, app.AppId, app, 0); err != nil {
 		logger.Error(ctx, err)
 		return nil, err
 	}
 
+	if err = s.appQuotaCache.Set(ctx, app.AppId, common.LoadUsageQuota(ctx, app.UserId, fmt.Sprintf(consts.APP_QUOTA_FIELD, app.AppId), app.Quota), 0); err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
 	return app, nil
 }
 
@@ -245,8 +300,10 @@ func (s *sApp) UpdateCacheApp(ctx context.Context, app *entity.App) {
 		Quota:          app.Quota,
 		UsedQuota:      app.UsedQuota,
 		QuotaExpiresAt: app.QuotaExpiresAt,
+		Budgets:        app.Budgets,
 		IpWhitelist:    app.IpWhitelist,
 		IpBlacklist:    app.IpBlacklist,
+		LogPolicy:      app.LogPolicy,
 		Status:         app.Status,
 		UserId:         app.UserId,
 	}); err != nil {
@@ -318,19 +375,24 @@ func (s *sApp) SaveCacheAppKey(ctx context.Context, key *model.Key) error {
 		return errors.New("key is nil")
 	}
 
-	if _, err := redis.Set(ctx, fmt.Sprintf(consts.API_APP_KEY_KEY, key.Key), key); err != nil {
+	// 先写本地缓存, Redis不可用时本实例仍可使用
+	service.Session().SaveKey(ctx, key)
+
+	if _, err := s.appKeyMissCache.Remove(ctx, key.KeyHash); err != nil {
 		logger.Error(ctx, err)
-		return err
 	}
 
-	service.Session().SaveKey(ctx, key)
+	if err := s.appKeyCache.Set(ctx, key.KeyHash, key, 0); err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
 
-	if err := s.appKeyCache.Set(ctx, key.Key, key, 0); err != nil {
+	if err := s.appKeyQuotaCache.Set(ctx, key.KeyHash, key.Quota, 0); err != nil {
 		logger.Error(ctx, err)
 		return err
 	}
 
-	if err := s.appKeyQuotaCache.Set(ctx, key.Key, key.Quota, 0); err != nil {
+	if _, err := redis.Set(ctx, fmt.Sprintf(consts.API_APP_KEY_KEY, key.KeyHash), key); err != nil {
 		logger.Error(ctx, err)
 		return err
 	}
@@ -356,25 +418,67 @@ func (s *sApp) GetCacheAppKey(ctx context.Context, secretKey string) (*model.Key
 		return key, nil
 	}
 
+	// 近期已确认不存在的密钥直接拒绝
+	if s.appKeyMissCache.ContainsKey(ctx, secretKey) {
+		return nil, errors.ERR_INVALID_API_KEY
+	}
+
+	// 本地缓存未命中时按需加载, 同一密钥并发请求只加载一次, 不受首个请求取消的影响
+	value, err, _ := s.group.Do("key:"+secretKey, func() (interface{}, error) {
+		return s.loadAppKey(context.WithoutCancel(ctx), secretKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	key := value.(*model.Key)
+	service.Session().SaveKey(ctx, key)
+
+	return key, nil
+}
+
+// 加载应用密钥信息, 优先从Redis获取, Redis不可用或不存在时从数据库获取
+func (s *sApp) loadAppKey(ctx context.Context, secretKey string) (*model.Key, error) {
+
+	key := new(model.Key)
+
 	reply, err := redis.Get(ctx, fmt.Sprintf(consts.API_APP_KEY_KEY, secretKey))
 	if err != nil {
 		logger.Error(ctx, err)
-		return nil, err
 	}
 
-	if reply == nil || reply.IsNil() {
-		return nil, errors.New("key is nil")
+	if err != nil || reply == nil || reply.IsNil() || reply.Struct(&key) != nil {
+
+		// 限制从数据库加载的频率, 避免大量随机密钥穿透到数据库
+		if s.appKeyLimiter != nil && !s.appKeyLimiter.Allow() {
+			logger.Errorf(ctx, "sApp loadAppKey secretKey: %s, too many keys loaded from database", secretKey)
+			return nil, errors.ERR_INVALID_API_KEY
+		}
+
+		if key, err = service.Key().GetKey(ctx, secretKey); err != nil {
+
+			logger.Error(ctx, err)
+
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				if err := s.appKeyMissCache.Set(ctx, secretKey, true, cmp.Or(config.Cfg.Core.KeyMiss.TTL, 60)*time.Second); err != nil {
+					logger.Error(ctx, err)
+				}
+			}
+
+			return nil, err
+		}
+
+		if _, err = redis.Set(ctx, fmt.Sprintf(consts.API_APP_KEY_KEY, secretKey), key); err != nil {
+			logger.Error(ctx, err)
+		}
 	}
 
-	key := new(model.Key)
-	if err = reply.Struct(&key); err != nil {
+	if err = s.appKeyCache.Set(ctx, key.KeyHash, key, 0); err != nil {
 		logger.Error(ctx, err)
 		return nil, err
 	}
 
-	service.Session().SaveKey(ctx, key)
-
-	if err = s.appKeyCache.Set(ctx, key.Key, key, 0); err != nil {
+	if err = s.appKeyQuotaCache.Set(ctx, key.KeyHash, common.LoadUsageQuota(ctx, key.UserId, fmt.Sprintf(consts.KEY_QUOTA_FIELD, key.AppId, key.KeyHash), key.Quota), 0); err != nil {
 		logger.Error(ctx, err)
 		return nil, err
 	}
@@ -390,12 +494,31 @@ func (s *sApp) UpdateCacheAppKey(ctx context.Context, key *entity.Key) {
 		logger.Debugf(ctx, "sApp UpdateCacheAppKey time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	// 新创建或重置的应用密钥为明文, 重新计算哈希并只保留展示前缀
+	if key.Key != "" && !crypto.IsKeyPrefix(key.Key) {
+
+		// 重置后旧哈希不再有效
+		if keyHash := crypto.HashKey(key.Key); key.KeyHash != keyHash {
+			if key.KeyHash != "" {
+				s.RemoveCacheAppKey(ctx, key.KeyHash)
+			}
+			key.KeyHash = keyHash
+		}
+
+		key.Key = crypto.KeyPrefix(key.Key)
+
+		if err := dao.Key.UpdateById(ctx, key.Id, bson.M{"key": key.Key, "key_hash": key.KeyHash}); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
+
 	if err := s.SaveCacheAppKey(ctx, &model.Key{
 		Id:                  key.Id,
 		UserId:              key.UserId,
 		AppId:               key.AppId,
 		Corp:                key.Corp,
 		Key:                 key.Key,
+		KeyHash:             key.KeyHash,
 		Type:                key.Type,
 		Models:              key.Models,
 		ModelAgents:         key.ModelAgents,
@@ -404,6 +527,7 @@ func (s *sApp) UpdateCacheAppKey(ctx context.Context, key *entity.Key) {
 		UsedQuota:           key.UsedQuota,
 		QuotaExpiresRule:    key.QuotaExpiresRule,
 		QuotaExpiresAt:      key.QuotaExpiresAt,
+		Budgets:             key.Budgets,
 		QuotaExpiresMinutes: key.QuotaExpiresMinutes,
 		IpWhitelist:         key.IpWhitelist,
 		IpBlacklist:         key.IpBlacklist,
@@ -413,6 +537,45 @@ func (s *sApp) UpdateCacheAppKey(ctx context.Context, key *entity.Key) {
 	}
 }
 
+// 旧版明文应用密钥首次使用时补充哈希, 返回是否存在该密钥
+func (s *sApp) HashLegacyAppKey(ctx context.Context, secretKey string) bool {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sApp HashLegacyAppKey time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	keyHash := crypto.HashKey(secretKey)
+
+	if s.appKeyMissCache.ContainsKey(ctx, "legacy:"+keyHash) {
+		return false
+	}
+
+	if s.appKeyLimiter != nil && !s.appKeyLimiter.Allow() {
+		logger.Errorf(ctx, "sApp HashLegacyAppKey secretKey: %s, too many keys loaded from database", keyHash)
+		return false
+	}
+
+	if err := service.Key().HashAppKey(ctx, secretKey); err != nil {
+
+		logger.Error(ctx, err)
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			if err := s.appKeyMissCache.Set(ctx, "legacy:"+keyHash, true, cmp.Or(config.Cfg.Core.KeyMiss.TTL, 60)*time.Second); err != nil {
+				logger.Error(ctx, err)
+			}
+		}
+
+		return false
+	}
+
+	if _, err := s.appKeyMissCache.Remove(ctx, keyHash); err != nil {
+		logger.Error(ctx, err)
+	}
+
+	return true
+}
+
 // 移除缓存中的应用密钥信息
 func (s *sApp) RemoveCacheAppKey(ctx context.Context, secretKey string) {
 
@@ -442,7 +605,7 @@ func (s *sApp) AppKeySpendQuota(ctx context.Context, secretKey string, spendQuot
 		logger.Debugf(ctx, "sApp AppKeySpendQuota time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	if err := dao.Key.UpdateOne(ctx, bson.M{"key": secretKey}, bson.M{
+	if err := dao.Key.UpdateOne(ctx, bson.M{"key_hash": secretKey}, bson.M{
 		"$inc": bson.M{
 			"quota":      -spendQuota,
 			"used_quota": spendQuota,
@@ -467,7 +630,7 @@ func (s *sApp) AppKeyUsedQuota(ctx context.Context, secretKey string, quota int)
 		logger.Debugf(ctx, "sApp AppKeyUsedQuota time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	if err := dao.Key.UpdateOne(ctx, bson.M{"key": secretKey}, bson.M{
+	if err := dao.Key.UpdateOne(ctx, bson.M{"key_hash": secretKey}, bson.M{
 		"$inc": bson.M{
 			"used_quota": quota,
 		},
@@ -525,8 +688,11 @@ func (s *sApp) UpdateAppKeyQuotaExpiresAt(ctx context.Context, key *model.Key) e
 	}
 
 	if err = dao.Key.UpdateById(ctx, key.Id, bson.M{
-		"quota_expires_rule": 1,
-		"quota_expires_at":   gtime.Now().Add(time.Duration(key.QuotaExpiresMinutes) * time.Minute).TimestampMilli(),
+		"$set": bson.M{
+			"quota_expires_rule": 1,
+			"quota_expires_at":   gtime.Now().Add(time.Duration(key.QuotaExpiresMinutes) * time.Minute).TimestampMilli(),
+		},
+		"$inc": bson.M{"version": 1},
 	}); err != nil {
 		logger.Error(ctx, err)
 		return err
@@ -538,7 +704,7 @@ func (s *sApp) UpdateAppKeyQuotaExpiresAt(ctx context.Context, key *model.Key) e
 		return err
 	}
 
-	if _, err = redis.Publish(ctx, consts.CHANGE_CHANNEL_APP_KEY, model.PubMessage{
+	if err = common.PublishChange(ctx, consts.CHANGE_CHANNEL_APP_KEY, model.PubMessage{
 		Action:  consts.ACTION_UPDATE,
 		OldData: oldData,
 		NewData: newData,
@@ -622,7 +788,7 @@ func (s *sApp) SubscribeKey(ctx context.Context, msg string) error {
 			return err
 		}
 
-		s.RemoveCacheAppKey(ctx, key.Key)
+		s.RemoveCacheAppKey(ctx, cmp.Or(key.KeyHash, crypto.HashKey(key.Key)))
 	}
 
 	return nil
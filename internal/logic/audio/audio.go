@@ -4,23 +4,21 @@ import (
 	"context"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/os/gctx"
-	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/util/gconv"
 	"github.com/iimeta/fastapi-sdk"
 	sdkm "github.com/iimeta/fastapi-sdk/model"
 	"github.com/iimeta/fastapi/api/audio/v1"
-	"github.com/iimeta/fastapi/internal/dao"
+	"github.com/iimeta/fastapi/internal/consts"
 	"github.com/iimeta/fastapi/internal/errors"
 	"github.com/iimeta/fastapi/internal/logic/common"
 	"github.com/iimeta/fastapi/internal/model"
 	mcommon "github.com/iimeta/fastapi/internal/model/common"
 	"github.com/iimeta/fastapi/internal/model/do"
 	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/graceful"
 	"github.com/iimeta/fastapi/utility/logger"
 	"github.com/iimeta/fastapi/utility/util"
-	"math"
-	"time"
 )
 
 type sAudio struct{}
@@ -59,24 +57,32 @@ func (s *sAudio) Speech(ctx context.Context, params sdkm.SpeechRequest, fallback
 
 		if retryInfo == nil && (err == nil || common.IsAborted(err)) && mak.ReqModel != nil {
 
-			if mak.ReqModel.AudioQuota.BillingMethod == 1 {
-				totalTokens = int(math.Ceil(float64(len(params.Input)) * mak.ReqModel.AudioQuota.PromptRatio))
-			} else {
-				totalTokens = mak.ReqModel.AudioQuota.FixedQuota
-			}
+			totalTokens = service.Billing().SpeechQuota(mak.ReqModel, len(params.Input))
 
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-				if err := service.Common().RecordUsage(ctx, totalTokens, mak.Key.Key); err != nil {
+			bill := service.Billing().Bill(ctx, &model.BillingReq{
+				Model:        mak.ReqModel,
+				Action:       consts.BILLING_ACTION_AUDIO,
+				PromptTokens: len(params.Input),
+				RatioQuota:   totalTokens,
+			})
+			totalTokens = bill.Quota
+
+			if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+				if err := service.Common().RecordUsage(ctx, totalTokens, mak.Key.Id); err != nil {
 					logger.Error(ctx, err)
 					panic(err)
 				}
+
+				if err := service.Billing().Ledger(ctx, bill, mak.Key.Id); err != nil {
+					logger.Error(ctx, err)
+				}
 			}); err != nil {
 				logger.Error(ctx, err)
 			}
 		}
 
 		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
 
 				mak.RealModel.ModelAgent = mak.ModelAgent
 
@@ -123,10 +129,10 @@ func (s *sAudio) Speech(ctx context.Context, params sdkm.SpeechRequest, fallback
 		// 记录错误次数和禁用
 		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+		isRetry, isDisabled := common.IsNeedRetry(ctx, mak, err)
 
 		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
 				if mak.RealModel.IsEnableModelAgent {
 					service.ModelAgent().DisabledModelAgentKey(ctx, mak.Key, err.Error())
 				} else {
@@ -175,6 +181,12 @@ func (s *sAudio) Speech(ctx context.Context, params sdkm.SpeechRequest, fallback
 				ErrMsg:     err.Error(),
 			}
 
+			// 重试退避
+			if !common.RetryBackoff(ctx, mak, err, retryInfo) {
+				retryInfo.IsRetry = false
+				return response, err
+			}
+
 			return s.Speech(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
 		}
 
@@ -218,24 +230,32 @@ func (s *sAudio) Transcriptions(ctx context.Context, params *v1.TranscriptionsRe
 				response.Duration = params.Duration
 			}
 
-			if mak.ReqModel.AudioQuota.BillingMethod == 1 {
-				totalTokens = int(math.Ceil(minute * 1000 * mak.ReqModel.AudioQuota.CompletionRatio))
-			} else {
-				totalTokens = mak.ReqModel.AudioQuota.FixedQuota
-			}
+			totalTokens = service.Billing().TranscriptionQuota(mak.ReqModel, minute)
 
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-				if err := service.Common().RecordUsage(ctx, totalTokens, mak.Key.Key); err != nil {
+			bill := service.Billing().Bill(ctx, &model.BillingReq{
+				Model:        mak.ReqModel,
+				Action:       consts.BILLING_ACTION_AUDIO,
+				AudioMinutes: minute,
+				RatioQuota:   totalTokens,
+			})
+			totalTokens = bill.Quota
+
+			if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+				if err := service.Common().RecordUsage(ctx, totalTokens, mak.Key.Id); err != nil {
 					logger.Error(ctx, err)
 					panic(err)
 				}
+
+				if err := service.Billing().Ledger(ctx, bill, mak.Key.Id); err != nil {
+					logger.Error(ctx, err)
+				}
 			}); err != nil {
 				logger.Error(ctx, err)
 			}
 		}
 
 		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
 
 				mak.RealModel.ModelAgent = mak.ModelAgent
 
@@ -283,10 +303,10 @@ func (s *sAudio) Transcriptions(ctx context.Context, params *v1.TranscriptionsRe
 		// 记录错误次数和禁用
 		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+		isRetry, isDisabled := common.IsNeedRetry(ctx, mak, err)
 
 		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
 				if mak.RealModel.IsEnableModelAgent {
 					service.ModelAgent().DisabledModelAgentKey(ctx, mak.Key, err.Error())
 				} else {
@@ -335,6 +355,12 @@ func (s *sAudio) Transcriptions(ctx context.Context, params *v1.TranscriptionsRe
 				ErrMsg:     err.Error(),
 			}
 
+			// 重试退避
+			if !common.RetryBackoff(ctx, mak, err, retryInfo) {
+				retryInfo.IsRetry = false
+				return response, err
+			}
+
 			return s.Transcriptions(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
 		}
 
@@ -345,7 +371,7 @@ func (s *sAudio) Transcriptions(ctx context.Context, params *v1.TranscriptionsRe
 }
 
 // 保存日志
-func (s *sAudio) SaveLog(ctx context.Context, reqModel, realModel *model.Model, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, key *model.Key, audioReq *model.AudioReq, audioRes *model.AudioRes, retryInfo *mcommon.Retry, retry ...int) {
+func (s *sAudio) SaveLog(ctx context.Context, reqModel, realModel *model.Model, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, key *model.Key, audioReq *model.AudioReq, audioRes *model.AudioRes, retryInfo *mcommon.Retry) {
 
 	now := gtime.TimestampMilli()
 	defer func() {
@@ -361,8 +387,7 @@ func (s *sAudio) SaveLog(ctx context.Context, reqModel, realModel *model.Model,
 		TraceId:      gctx.CtxId(ctx),
 		UserId:       service.Session().GetUserId(ctx),
 		AppId:        service.Session().GetAppId(ctx),
-		Input:        audioReq.Input,
-		Text:         audioRes.Text,
+		EndUser:      service.Session().GetEndUser(ctx),
 		Characters:   audioRes.Characters,
 		Minute:       audioRes.Minute,
 		FilePath:     audioReq.FilePath,
@@ -378,6 +403,14 @@ func (s *sAudio) SaveLog(ctx context.Context, reqModel, realModel *model.Model,
 		Host:         g.RequestFromCtx(ctx).GetHost(),
 	}
 
+	if common.IsRecordLog(ctx, "prompt") {
+		audio.Input = audioReq.Input
+	}
+
+	if common.IsRecordLog(ctx, "completion") {
+		audio.Text = audioRes.Text
+	}
+
 	if reqModel != nil {
 		audio.Corp = reqModel.Corp
 		audio.ModelId = reqModel.Id
@@ -431,7 +464,7 @@ func (s *sAudio) SaveLog(ctx context.Context, reqModel, realModel *model.Model,
 	}
 
 	if key != nil {
-		audio.Key = key.Key
+		audio.Key = common.KeyRef(key)
 	}
 
 	if audioRes.Error != nil {
@@ -450,6 +483,10 @@ func (s *sAudio) SaveLog(ctx context.Context, reqModel, realModel *model.Model,
 			IsRetry:    retryInfo.IsRetry,
 			RetryCount: retryInfo.RetryCount,
 			ErrMsg:     retryInfo.ErrMsg,
+			Status:     retryInfo.Status,
+			Code:       retryInfo.Code,
+			Delay:      retryInfo.Delay,
+			Decision:   retryInfo.Decision,
 		}
 
 		if audio.IsRetry {
@@ -458,19 +495,7 @@ func (s *sAudio) SaveLog(ctx context.Context, reqModel, realModel *model.Model,
 		}
 	}
 
-	if _, err := dao.Audio.Insert(ctx, audio); err != nil {
+	if err := service.LogWriter().Write(ctx, audio); err != nil {
 		logger.Error(ctx, err)
-
-		if len(retry) == 10 {
-			panic(err)
-		}
-
-		retry = append(retry, 1)
-
-		time.Sleep(time.Duration(len(retry)*5) * time.Second)
-
-		logger.Errorf(ctx, "sAudio SaveLog retry: %d", len(retry))
-
-		s.SaveLog(ctx, reqModel, realModel, fallbackModelAgent, fallbackModel, key, audioReq, audioRes, retryInfo, retry...)
 	}
 }
@@ -4,9 +4,11 @@ import (
 	"context"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/internal/config"
 	"github.com/iimeta/fastapi/internal/errors"
 	"github.com/iimeta/fastapi/internal/logic/common"
 	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/crypto"
 	"github.com/iimeta/fastapi/utility/logger"
 )
 
@@ -28,12 +30,44 @@ func (s *sAuth) Authenticator(ctx context.Context, secretKey string) error {
 		logger.Debugf(g.RequestFromCtx(ctx).GetCtx(), "sAuth Authenticator time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	if err := service.Session().Save(ctx, secretKey); err != nil {
+	// 派生令牌
+	if crypto.IsJwt(secretKey) {
+
+		claims, err := service.Token().Parse(ctx, secretKey)
+		if err != nil {
+			logger.Error(ctx, err)
+			return err
+		}
+
+		service.Session().SaveToken(ctx, claims)
+
+	} else if err := service.Session().Save(ctx, secretKey); err != nil {
 		logger.Error(ctx, err)
 		return err
 	}
 
-	if err := s.VerifySecretKey(g.RequestFromCtx(ctx).GetCtx(), secretKey); err != nil {
+	if err := s.VerifySecretKey(g.RequestFromCtx(ctx).GetCtx(), service.Session().GetSecretKey(g.RequestFromCtx(ctx).GetCtx())); err != nil {
+
+		// 开启旧版密钥兼容时, 未执行哈希迁移的旧版应用密钥补充哈希后重新核验
+		if !config.Cfg.Core.LegacyKeyHash || crypto.IsJwt(secretKey) || !errors.Is(err, errors.ERR_INVALID_API_KEY) || !service.App().HashLegacyAppKey(g.RequestFromCtx(ctx).GetCtx(), secretKey) {
+			logger.Error(g.RequestFromCtx(ctx).GetCtx(), err)
+			return err
+		}
+
+		if err = s.VerifySecretKey(g.RequestFromCtx(ctx).GetCtx(), service.Session().GetSecretKey(g.RequestFromCtx(ctx).GetCtx())); err != nil {
+			logger.Error(g.RequestFromCtx(ctx).GetCtx(), err)
+			return err
+		}
+	}
+
+	if claims := service.Session().GetToken(g.RequestFromCtx(ctx).GetCtx()); claims != nil {
+		if err := service.Token().Verify(g.RequestFromCtx(ctx).GetCtx(), claims); err != nil {
+			logger.Error(g.RequestFromCtx(ctx).GetCtx(), err)
+			return err
+		}
+	}
+
+	if err := common.CheckEndUserBudget(g.RequestFromCtx(ctx).GetCtx()); err != nil {
 		logger.Error(g.RequestFromCtx(ctx).GetCtx(), err)
 		return err
 	}
@@ -41,7 +75,7 @@ func (s *sAuth) Authenticator(ctx context.Context, secretKey string) error {
 	return nil
 }
 
-// 核验密钥
+// 核验密钥, secretKey为密钥哈希
 func (s *sAuth) VerifySecretKey(ctx context.Context, secretKey string) error {
 
 	now := gtime.TimestampMilli()
@@ -49,21 +83,14 @@ func (s *sAuth) VerifySecretKey(ctx context.Context, secretKey string) error {
 		logger.Debugf(ctx, "sAuth VerifySecretKey time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	// 缓存未命中时从数据库加载
 	key, err := service.App().GetCacheAppKey(ctx, secretKey)
-	if err != nil || key == nil {
-
-		if key, err = service.Key().GetKey(ctx, secretKey); err != nil {
-			logger.Error(ctx, err)
-			return errors.ERR_INVALID_API_KEY
-		}
-
-		if err = service.App().SaveCacheAppKey(ctx, key); err != nil {
-			logger.Error(ctx, err)
-			return err
-		}
+	if err != nil {
+		logger.Error(ctx, err)
+		return errors.ERR_INVALID_API_KEY
 	}
 
-	if key == nil || key.Key != secretKey {
+	if key == nil || key.KeyHash != secretKey {
 		err = errors.ERR_INVALID_API_KEY
 		logger.Error(ctx, err)
 		return err
@@ -80,24 +107,16 @@ func (s *sAuth) VerifySecretKey(ctx context.Context, secretKey string) error {
 		return err
 	}
 
-	if key.IsLimitQuota && (service.App().GetCacheAppKeyQuota(ctx, key.Key) <= 0 || (key.QuotaExpiresAt != 0 && key.QuotaExpiresAt < gtime.TimestampMilli())) {
+	if key.IsLimitQuota && (service.App().GetCacheAppKeyQuota(ctx, key.KeyHash) <= 0 || (key.QuotaExpiresAt != 0 && key.QuotaExpiresAt < gtime.TimestampMilli())) {
 		err = errors.ERR_INSUFFICIENT_QUOTA
 		logger.Error(ctx, err)
 		return err
 	}
 
 	user, err := service.User().GetCacheUser(ctx, service.Session().GetUserId(ctx))
-	if err != nil || user == nil {
-
-		if user, err = service.User().GetUser(ctx, service.Session().GetUserId(ctx)); err != nil {
-			logger.Error(ctx, err)
-			return errors.ERR_INVALID_USER
-		}
-
-		if err = service.User().SaveCacheUser(ctx, user); err != nil {
-			logger.Error(ctx, err)
-			return err
-		}
+	if err != nil {
+		logger.Error(ctx, err)
+		return errors.ERR_INVALID_USER
 	}
 
 	if user == nil {
@@ -119,17 +138,9 @@ func (s *sAuth) VerifySecretKey(ctx context.Context, secretKey string) error {
 	}
 
 	app, err := service.App().GetCacheApp(ctx, key.AppId)
-	if err != nil || app == nil {
-
-		if app, err = service.App().GetApp(ctx, key.AppId); err != nil {
-			logger.Error(ctx, err)
-			return errors.ERR_INVALID_APP
-		}
-
-		if err = service.App().SaveCacheApp(ctx, app); err != nil {
-			logger.Error(ctx, err)
-			return err
-		}
+	if err != nil {
+		logger.Error(ctx, err)
+		return errors.ERR_INVALID_APP
 	}
 
 	if app == nil {
@@ -155,6 +166,11 @@ func (s *sAuth) VerifySecretKey(ctx context.Context, secretKey string) error {
 		return err
 	}
 
+	if err = common.CheckBudgets(ctx, user, app, key); err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
 	service.Session().SaveUser(ctx, user)
 	service.Session().SaveIsLimitQuota(ctx, app.IsLimitQuota, key.IsLimitQuota)
 
@@ -0,0 +1,220 @@
+package billing
+
+import (
+	"cmp"
+	"context"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/gtime"
+	sdkm "github.com/iimeta/fastapi-sdk/model"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/dao"
+	"github.com/iimeta/fastapi/internal/logic/common"
+	"github.com/iimeta/fastapi/internal/model"
+	mcommon "github.com/iimeta/fastapi/internal/model/common"
+	"github.com/iimeta/fastapi/internal/model/do"
+	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/logger"
+	"github.com/iimeta/fastapi/utility/pricing"
+	"math"
+)
+
+type sBilling struct{}
+
+func init() {
+	service.RegisterBilling(New())
+}
+
+func New() service.IBilling {
+	return &sBilling{}
+}
+
+// 计费, 模型配置了价格时按价格计算费用并折算成额度, 否则使用按倍率计算的额度
+func (s *sBilling) Bill(ctx context.Context, params *model.BillingReq) *model.Bill {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sBilling Bill time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	if params.Usage != nil {
+
+		params.PromptTokens = params.Usage.PromptTokens
+		params.CompletionTokens = params.Usage.CompletionTokens
+
+		if params.Usage.PromptTokensDetails != nil {
+			params.CachedTokens = params.Usage.PromptTokensDetails.CachedTokens
+		}
+
+		if params.Usage.CompletionTokensDetails != nil {
+			params.ReasoningTokens = params.Usage.CompletionTokensDetails.ReasoningTokens
+		}
+	}
+
+	bill := &model.Bill{
+		BillingReq:    params,
+		BillingMethod: consts.BILLING_METHOD_RATIO,
+		Currency:      consts.DEFAULT_CURRENCY,
+		Amount:        float64(params.RatioQuota) / s.QuotaUnit(),
+		ExchangeRate:  1,
+		Quota:         params.RatioQuota,
+	}
+
+	if params.Model == nil || params.Model.Pricing == nil {
+		return bill
+	}
+
+	amount, ok := s.amount(params.Model.Pricing, params)
+	if !ok {
+		logger.Debugf(ctx, "sBilling Bill model: %s, action: %s, price not found, use ratio quota: %d", params.Model.Model, params.Action, params.RatioQuota)
+		return bill
+	}
+
+	bill.BillingMethod = consts.BILLING_METHOD_PRICE
+	bill.Currency = cmp.Or(params.Model.Pricing.Currency, consts.DEFAULT_CURRENCY)
+	bill.Amount = amount
+	bill.ExchangeRate = s.ExchangeRate(bill.Currency)
+	bill.Quota = int(math.Ceil(amount * bill.ExchangeRate * s.QuotaUnit()))
+
+	return bill
+}
+
+// 写入账本, 每次请求追加一条记录, 用于对账
+func (s *sBilling) Ledger(ctx context.Context, bill *model.Bill, keyId string) error {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sBilling Ledger time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	ledger := &do.Ledger{
+		TraceId:          gctx.CtxId(ctx),
+		UserId:           service.Session().GetUserId(ctx),
+		AppId:            service.Session().GetAppId(ctx),
+		AppKey:           service.Session().GetSecretKey(ctx),
+		EndUser:          service.Session().GetEndUser(ctx),
+		Key:              keyId,
+		Action:           bill.Action,
+		PromptTokens:     bill.PromptTokens,
+		CompletionTokens: bill.CompletionTokens,
+		CachedTokens:     bill.CachedTokens,
+		ReasoningTokens:  bill.ReasoningTokens,
+		ImageSize:        bill.ImageSize,
+		ImageQuality:     bill.ImageQuality,
+		ImageCount:       bill.ImageCount,
+		AudioMinutes:     bill.AudioMinutes,
+		MidjourneyAction: bill.MidjourneyAction,
+		BillingMethod:    bill.BillingMethod,
+		Currency:         bill.Currency,
+		Amount:           bill.Amount,
+		ExchangeRate:     bill.ExchangeRate,
+		Quota:            bill.Quota,
+	}
+
+	if bill.Model != nil {
+		ledger.Model = bill.Model.Model
+		ledger.ModelId = bill.Model.Id
+	}
+
+	if _, err := dao.Ledger.Insert(ctx, ledger); err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
+	return nil
+}
+
+// 1美元对应的额度
+func (s *sBilling) QuotaUnit() float64 {
+
+	if config.Cfg.Billing.QuotaUnit > 0 {
+		return config.Cfg.Billing.QuotaUnit
+	}
+
+	return consts.QUOTA_USD_UNIT
+}
+
+// 汇率, 1单位币种兑换的美元
+func (s *sBilling) ExchangeRate(currency string) float64 {
+
+	if rate, ok := config.Cfg.Billing.ExchangeRates[currency]; ok && rate > 0 {
+		return rate
+	}
+
+	return 1
+}
+
+// 按文本倍率计算额度, 计费方式为固定额度时返回固定额度
+func (s *sBilling) TextQuota(m *model.Model, usage *sdkm.Usage) int {
+
+	if m.TextQuota.BillingMethod == 1 {
+		return pricing.TextQuota(m.TextQuota, usage)
+	}
+
+	return m.TextQuota.FixedQuota
+}
+
+// 按模型类型计算对话用量的额度
+func (s *sBilling) ChatQuota(m *model.Model, usage *sdkm.Usage) int {
+
+	switch m.Type {
+	case 100: // 多模态
+		return pricing.TextQuota(m.MultimodalQuota.TextQuota, usage)
+	case 102: // 多模态语音
+		return pricing.MultimodalAudioQuota(m.MultimodalAudioQuota, usage)
+	}
+
+	return s.TextQuota(m, usage)
+}
+
+// 按多模态倍率计算估算用量的额度, 图像固定额度单独累加
+func (s *sBilling) MultimodalQuota(m *model.Model, textTokens, imageTokens, imageQuota, completionTokens int) int {
+	return pricing.MultimodalQuota(m.MultimodalQuota.TextQuota, textTokens, imageTokens, imageQuota, completionTokens)
+}
+
+// 按图像尺寸的固定额度计算额度
+func (s *sBilling) ImageQuota(m *model.Model, size string, count int) int {
+
+	if m == nil {
+		return 0
+	}
+
+	return common.GetImageQuota(m, size).FixedQuota * count
+}
+
+// 按输入字符数计算语音合成的额度
+func (s *sBilling) SpeechQuota(m *model.Model, characters int) int {
+
+	if m.AudioQuota.BillingMethod == 1 {
+		return int(math.Ceil(float64(characters) * m.AudioQuota.PromptRatio))
+	}
+
+	return m.AudioQuota.FixedQuota
+}
+
+// 按音频时长计算语音识别的额度
+func (s *sBilling) TranscriptionQuota(m *model.Model, minute float64) int {
+
+	if m.AudioQuota.BillingMethod == 1 {
+		return int(math.Ceil(minute * 1000 * m.AudioQuota.CompletionRatio))
+	}
+
+	return m.AudioQuota.FixedQuota
+}
+
+// 按价格计算费用, 未匹配到价格时返回false
+func (s *sBilling) amount(modelPricing *mcommon.Pricing, params *model.BillingReq) (float64, bool) {
+
+	switch params.Action {
+	case consts.BILLING_ACTION_IMAGE:
+		return pricing.ImageAmount(modelPricing, params)
+	case consts.BILLING_ACTION_MIDJOURNEY:
+		return pricing.MidjourneyAmount(modelPricing, params)
+	case consts.BILLING_ACTION_AUDIO:
+		if params.AudioMinutes > 0 {
+			return pricing.AudioAmount(modelPricing, params)
+		}
+	}
+
+	return pricing.TokenAmount(modelPricing, params)
+}
@@ -6,34 +6,25 @@ import (
 	"github.com/gogf/gf/v2/encoding/gjson"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/os/gctx"
-	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/gogf/gf/v2/util/gconv"
 	"github.com/iimeta/fastapi-sdk"
 	sdkm "github.com/iimeta/fastapi-sdk/model"
-	"github.com/iimeta/fastapi/internal/config"
 	"github.com/iimeta/fastapi/internal/consts"
-	"github.com/iimeta/fastapi/internal/dao"
 	"github.com/iimeta/fastapi/internal/errors"
 	"github.com/iimeta/fastapi/internal/logic/common"
 	"github.com/iimeta/fastapi/internal/model"
 	mcommon "github.com/iimeta/fastapi/internal/model/common"
 	"github.com/iimeta/fastapi/internal/model/do"
 	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/graceful"
 	"github.com/iimeta/fastapi/utility/logger"
 	"github.com/iimeta/fastapi/utility/util"
-	"github.com/iimeta/tiktoken-go"
 	"io"
-	"math"
-	"slices"
-	"sync"
-	"time"
 )
 
-type sChat struct {
-	mutex sync.Mutex
-}
+type sChat struct{}
 
 func init() {
 	service.RegisterChat(New())
@@ -51,13 +42,17 @@ func (s *sChat) Completions(ctx context.Context, params sdkm.ChatCompletionReque
 		logger.Debugf(ctx, "sChat Completions time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	if len(params.Functions) == 0 {
+	if len(params.Functions) == 0 && len(params.Tools) == 0 {
 		params.Messages = common.HandleMessages(params.Messages)
 		if len(params.Messages) == 0 {
 			return response, errors.ERR_INVALID_PARAMETER
 		}
 	}
 
+	if len(retry) == 0 {
+		common.PrefetchImageSizes(ctx, params.Messages)
+	}
+
 	var (
 		mak = &common.MAK{
 			Model:              params.Model,
@@ -69,8 +64,11 @@ func (s *sChat) Completions(ctx context.Context, params sdkm.ChatCompletionReque
 		retryInfo   *mcommon.Retry
 		textTokens  int
 		imageTokens int
+		imageQuota  int
 		audioTokens int
 		totalTokens int
+		repairCount int
+		repairFail  bool
 	)
 
 	defer func() {
@@ -82,11 +80,6 @@ func (s *sChat) Completions(ctx context.Context, params sdkm.ChatCompletionReque
 
 			// 替换成调用的模型
 			response.Model = mak.ReqModel.Model
-			model := mak.ReqModel.Model
-
-			if !tiktoken.IsEncodingForModel(model) {
-				model = consts.DEFAULT_MODEL
-			}
 
 			if mak.ReqModel.Type == 100 { // 多模态
 
@@ -95,25 +88,25 @@ func (s *sChat) Completions(ctx context.Context, params sdkm.ChatCompletionReque
 					response.Usage = new(sdkm.Usage)
 
 					if content, ok := params.Messages[len(params.Messages)-1].Content.([]interface{}); ok {
-						textTokens, imageTokens = common.GetMultimodalTokens(ctx, model, content, mak.ReqModel)
-						response.Usage.PromptTokens = textTokens + imageTokens
+						textTokens, imageTokens, imageQuota = common.GetMultimodalTokens(ctx, mak.ReqModel, content)
+						response.Usage.PromptTokens = textTokens + imageTokens + imageQuota
 					} else {
 						if response.Usage.PromptTokens == 0 {
-							response.Usage.PromptTokens = common.GetPromptTokens(ctx, model, params.Messages)
+							response.Usage.PromptTokens = common.GetPromptTokens(ctx, mak.ReqModel, params.Messages)
 						}
 					}
 
 					if response.Usage.CompletionTokens == 0 && len(response.Choices) > 0 && response.Choices[0].Message != nil {
 						for _, choice := range response.Choices {
-							response.Usage.CompletionTokens += common.GetCompletionTokens(ctx, model, gconv.String(choice.Message.Content))
+							response.Usage.CompletionTokens += common.GetCompletionTokens(ctx, mak.ReqModel, gconv.String(choice.Message.Content))
 						}
 					}
 
 					response.Usage.TotalTokens = response.Usage.PromptTokens + response.Usage.CompletionTokens
-					totalTokens = imageTokens + int(math.Ceil(float64(textTokens)*mak.ReqModel.MultimodalQuota.TextQuota.PromptRatio)) + int(math.Ceil(float64(response.Usage.CompletionTokens)*mak.ReqModel.MultimodalQuota.TextQuota.CompletionRatio))
+					totalTokens = service.Billing().MultimodalQuota(mak.ReqModel, textTokens, imageTokens, imageQuota, response.Usage.CompletionTokens)
 
 				} else {
-					totalTokens = int(math.Ceil(float64(response.Usage.PromptTokens)*mak.ReqModel.MultimodalQuota.TextQuota.PromptRatio)) + int(math.Ceil(float64(response.Usage.CompletionTokens)*mak.ReqModel.MultimodalQuota.TextQuota.CompletionRatio))
+					totalTokens = service.Billing().ChatQuota(mak.ReqModel, response.Usage)
 				}
 
 			} else if mak.ReqModel.Type == 102 { // 多模态语音
@@ -122,28 +115,27 @@ func (s *sChat) Completions(ctx context.Context, params sdkm.ChatCompletionReque
 
 					response.Usage = new(sdkm.Usage)
 
-					textTokens, audioTokens = common.GetMultimodalAudioTokens(ctx, model, params.Messages, mak.ReqModel)
+					textTokens, audioTokens = common.GetMultimodalAudioTokens(ctx, mak.ReqModel, params.Messages)
 					response.Usage.PromptTokens = textTokens + audioTokens
 
 					if len(response.Choices) > 0 && response.Choices[0].Message != nil && response.Choices[0].Message.Audio != nil {
 						for _, choice := range response.Choices {
-							response.Usage.CompletionTokens += common.GetCompletionTokens(ctx, model, choice.Message.Audio.Transcript) + 388
+							response.Usage.CompletionTokens += common.GetCompletionTokens(ctx, mak.ReqModel, choice.Message.Audio.Transcript) + 388
 						}
 					}
 				}
 
 				response.Usage.TotalTokens = response.Usage.PromptTokens + response.Usage.CompletionTokens
-				totalTokens = int(math.Ceil(float64(response.Usage.PromptTokens)*mak.ReqModel.MultimodalAudioQuota.AudioQuota.PromptRatio)) + int(math.Ceil(float64(response.Usage.CompletionTokens)*mak.ReqModel.MultimodalAudioQuota.AudioQuota.CompletionRatio))
 
 			} else if response.Usage == nil || response.Usage.TotalTokens == 0 {
 
 				response.Usage = new(sdkm.Usage)
 
-				response.Usage.PromptTokens = common.GetPromptTokens(ctx, model, params.Messages)
+				response.Usage.PromptTokens = common.GetPromptTokens(ctx, mak.ReqModel, params.Messages)
 
 				if len(response.Choices) > 0 && response.Choices[0].Message != nil {
 					for _, choice := range response.Choices {
-						response.Usage.CompletionTokens += common.GetCompletionTokens(ctx, model, gconv.String(choice.Message.Content))
+						response.Usage.CompletionTokens += common.GetCompletionTokens(ctx, mak.ReqModel, gconv.String(choice.Message.Content))
 					}
 				}
 
@@ -151,39 +143,28 @@ func (s *sChat) Completions(ctx context.Context, params sdkm.ChatCompletionReque
 			}
 		}
 
-		if mak.ReqModel != nil && response.Usage != nil {
-			if mak.ReqModel.Type == 102 {
-
-				if response.Usage.PromptTokensDetails != nil {
-					textTokens = int(math.Ceil(float64(response.Usage.PromptTokensDetails.TextTokens) * mak.ReqModel.MultimodalAudioQuota.TextQuota.PromptRatio))
-					audioTokens = int(math.Ceil(float64(response.Usage.PromptTokensDetails.AudioTokens) * mak.ReqModel.MultimodalAudioQuota.AudioQuota.PromptRatio))
-				} else {
-					audioTokens = int(math.Ceil(float64(response.Usage.PromptTokens) * mak.ReqModel.MultimodalAudioQuota.AudioQuota.PromptRatio))
-				}
+		if mak.ReqModel != nil && response.Usage != nil && mak.ReqModel.Type != 100 {
+			totalTokens = service.Billing().ChatQuota(mak.ReqModel, response.Usage)
+		}
 
-				if response.Usage.CompletionTokensDetails != nil {
-					textTokens += int(math.Ceil(float64(response.Usage.CompletionTokensDetails.TextTokens) * mak.ReqModel.MultimodalAudioQuota.TextQuota.CompletionRatio))
-					audioTokens += int(math.Ceil(float64(response.Usage.CompletionTokensDetails.AudioTokens) * mak.ReqModel.MultimodalAudioQuota.AudioQuota.CompletionRatio))
-				} else {
-					audioTokens += int(math.Ceil(float64(response.Usage.CompletionTokens) * mak.ReqModel.MultimodalAudioQuota.AudioQuota.CompletionRatio))
-				}
+		if retryInfo == nil && (err == nil || common.IsAborted(err)) && mak.ReqModel != nil {
 
-				totalTokens = textTokens + audioTokens
+			bill := service.Billing().Bill(ctx, &model.BillingReq{
+				Model:      mak.ReqModel,
+				Action:     consts.BILLING_ACTION_CHAT,
+				Usage:      response.Usage,
+				RatioQuota: totalTokens,
+			})
+			totalTokens = bill.Quota
 
-			} else if mak.ReqModel.Type != 100 {
-				if mak.ReqModel.TextQuota.BillingMethod == 1 {
-					totalTokens = int(math.Ceil(float64(response.Usage.PromptTokens)*mak.ReqModel.TextQuota.PromptRatio + float64(response.Usage.CompletionTokens)*mak.ReqModel.TextQuota.CompletionRatio))
-				} else {
-					totalTokens = mak.ReqModel.TextQuota.FixedQuota
+			if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+				if err := service.Common().RecordUsage(ctx, totalTokens, mak.Key.Id); err != nil {
+					logger.Error(ctx, err)
+					panic(err)
 				}
-			}
-		}
 
-		if retryInfo == nil && (err == nil || common.IsAborted(err)) && mak.ReqModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-				if err := service.Common().RecordUsage(ctx, totalTokens, mak.Key.Key); err != nil {
+				if err := service.Billing().Ledger(ctx, bill, mak.Key.Id); err != nil {
 					logger.Error(ctx, err)
-					panic(err)
 				}
 			}); err != nil {
 				logger.Error(ctx, err)
@@ -191,11 +172,13 @@ func (s *sChat) Completions(ctx context.Context, params sdkm.ChatCompletionReque
 		}
 
 		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
 
 				mak.RealModel.ModelAgent = mak.ModelAgent
 
 				completionsRes := &model.CompletionsRes{
+					RepairCount:  repairCount,
+					RepairFail:   repairFail,
 					Error:        err,
 					ConnTime:     response.ConnTime,
 					Duration:     response.Duration,
@@ -270,22 +253,44 @@ func (s *sChat) Completions(ctx context.Context, params sdkm.ChatCompletionReque
 		}
 	}
 
+	// 工具调用模拟
+	var toolNames []string
+	if isToolEmulation(ctx, mak, request) {
+		request, toolNames = toolEmulationRequest(request)
+	}
+
 	if client, err = common.NewClient(ctx, mak.Corp, mak.RealModel, mak.RealKey, mak.BaseUrl, mak.Path); err != nil {
 		logger.Error(ctx, err)
 		return response, err
 	}
 
-	response, err = client.ChatCompletion(ctx, request)
+	// 对冲请求
+	if threshold := common.GetHedgeThreshold(mak.ReqModel.Model); threshold > 0 {
+
+		var winner *hedgeResult
+		winner, err = s.hedgeCompletions(ctx, mak, client, request, &params, threshold)
+
+		if winner.mak != mak {
+			*mak = *winner.mak
+			client = winner.client
+		}
+
+		response = winner.response
+
+	} else {
+		response, err = client.ChatCompletion(ctx, request)
+	}
+
 	if err != nil {
 		logger.Error(ctx, err)
 
 		// 记录错误次数和禁用
 		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+		isRetry, isDisabled := common.IsNeedRetry(ctx, mak, err)
 
 		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
 				if mak.RealModel.IsEnableModelAgent {
 					service.ModelAgent().DisabledModelAgentKey(ctx, mak.Key, err.Error())
 				} else {
@@ -334,12 +339,27 @@ func (s *sChat) Completions(ctx context.Context, params sdkm.ChatCompletionReque
 				ErrMsg:     err.Error(),
 			}
 
+			// 重试退避
+			if !common.RetryBackoff(ctx, mak, err, retryInfo) {
+				retryInfo.IsRetry = false
+				return response, err
+			}
+
 			return s.Completions(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
 		}
 
 		return response, err
 	}
 
+	if len(toolNames) > 0 {
+		toolCallsResponse(ctx, &response, toolNames, len(params.Functions) > 0)
+	}
+
+	// 结构化输出校验和修复
+	if structuredOutput := getStructuredOutput(params, mak.ReqModel); structuredOutput != nil {
+		repairCount, repairFail = structuredOutputResponse(ctx, client, request, &response, structuredOutput)
+	}
+
 	return response, nil
 }
 
@@ -351,13 +371,17 @@ func (s *sChat) CompletionsStream(ctx context.Context, params sdkm.ChatCompletio
 		logger.Debugf(ctx, "sChat CompletionsStream time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	if len(params.Functions) == 0 {
+	if len(params.Functions) == 0 && len(params.Tools) == 0 {
 		params.Messages = common.HandleMessages(params.Messages)
 		if len(params.Messages) == 0 {
 			return errors.ERR_INVALID_PARAMETER
 		}
 	}
 
+	if len(retry) == 0 {
+		common.PrefetchImageSizes(ctx, params.Messages)
+	}
+
 	var (
 		mak = &common.MAK{
 			Model:              params.Model,
@@ -372,10 +396,13 @@ func (s *sChat) CompletionsStream(ctx context.Context, params sdkm.ChatCompletio
 		totalTime   int64
 		textTokens  int
 		imageTokens int
+		imageQuota  int
 		audioTokens int
 		totalTokens int
 		usage       *sdkm.Usage
 		retryInfo   *mcommon.Retry
+		repairCount int
+		repairFail  bool
 	)
 
 	defer func() {
@@ -383,91 +410,81 @@ func (s *sChat) CompletionsStream(ctx context.Context, params sdkm.ChatCompletio
 		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
 		internalTime := gtime.TimestampMilli() - enterTime - totalTime
 
-		if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+		if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
 			if retryInfo == nil && completion != "" && (usage == nil || usage.PromptTokens == 0 || usage.CompletionTokens == 0) && mak.ReqModel != nil {
 
 				if usage == nil {
 					usage = new(sdkm.Usage)
 				}
 
-				model := mak.ReqModel.Model
-				if !tiktoken.IsEncodingForModel(model) {
-					model = consts.DEFAULT_MODEL
-				}
-
 				if mak.ReqModel.Type == 102 { // 多模态语音
-					textTokens, audioTokens = common.GetMultimodalAudioTokens(ctx, model, params.Messages, mak.ReqModel)
+					textTokens, audioTokens = common.GetMultimodalAudioTokens(ctx, mak.ReqModel, params.Messages)
 					usage.PromptTokens = textTokens + audioTokens
 				} else {
 					if content, ok := params.Messages[len(params.Messages)-1].Content.([]interface{}); ok {
-						textTokens, imageTokens = common.GetMultimodalTokens(ctx, model, content, mak.ReqModel)
-						usage.PromptTokens = textTokens + imageTokens
+						textTokens, imageTokens, imageQuota = common.GetMultimodalTokens(ctx, mak.ReqModel, content)
+						usage.PromptTokens = textTokens + imageTokens + imageQuota
 					} else {
 						if usage.PromptTokens == 0 {
-							usage.PromptTokens = common.GetPromptTokens(ctx, model, params.Messages)
+							usage.PromptTokens = common.GetPromptTokens(ctx, mak.ReqModel, params.Messages)
 						}
 					}
 				}
 
 				if usage.CompletionTokens == 0 {
-					usage.CompletionTokens = common.GetCompletionTokens(ctx, model, completion)
+					usage.CompletionTokens = common.GetCompletionTokens(ctx, mak.ReqModel, completion)
 					if mak.ReqModel.Type == 102 { // 多模态语音
 						usage.CompletionTokens += 388
 					}
 				}
 
+				usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
 				if mak.ReqModel.Type == 100 { // 多模态
-					usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
-					totalTokens = imageTokens + int(math.Ceil(float64(textTokens)*mak.ReqModel.MultimodalQuota.TextQuota.PromptRatio)) + int(math.Ceil(float64(usage.CompletionTokens)*mak.ReqModel.MultimodalQuota.TextQuota.CompletionRatio))
-				} else if mak.ReqModel.Type == 102 { // 多模态语音
-					totalTokens = int(math.Ceil(float64(usage.PromptTokens)*mak.ReqModel.MultimodalAudioQuota.AudioQuota.PromptRatio)) + int(math.Ceil(float64(usage.CompletionTokens)*mak.ReqModel.MultimodalAudioQuota.AudioQuota.CompletionRatio))
+					totalTokens = service.Billing().MultimodalQuota(mak.ReqModel, textTokens, imageTokens, imageQuota, usage.CompletionTokens)
 				} else {
-					if mak.ReqModel.TextQuota.BillingMethod == 1 {
-						usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
-						totalTokens = int(math.Ceil(float64(usage.PromptTokens)*mak.ReqModel.TextQuota.PromptRatio + float64(usage.CompletionTokens)*mak.ReqModel.TextQuota.CompletionRatio))
-					} else {
-						usage.TotalTokens = mak.ReqModel.TextQuota.FixedQuota
-						totalTokens = mak.ReqModel.TextQuota.FixedQuota
-					}
+					totalTokens = service.Billing().ChatQuota(mak.ReqModel, usage)
 				}
 
 			} else if retryInfo == nil && usage != nil && mak.ReqModel != nil {
 
-				if mak.ReqModel.Type == 100 { // 多模态
-					usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
-					totalTokens = int(math.Ceil(float64(usage.PromptTokens)*mak.ReqModel.MultimodalQuota.TextQuota.PromptRatio)) + int(math.Ceil(float64(usage.CompletionTokens)*mak.ReqModel.MultimodalQuota.TextQuota.CompletionRatio))
-				} else if mak.ReqModel.Type == 102 { // 多模态语音
-					usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
-					totalTokens = int(math.Ceil(float64(usage.PromptTokens)*mak.ReqModel.MultimodalAudioQuota.AudioQuota.PromptRatio)) + int(math.Ceil(float64(usage.CompletionTokens)*mak.ReqModel.MultimodalAudioQuota.AudioQuota.CompletionRatio))
-				} else {
-					if mak.ReqModel.TextQuota.BillingMethod == 1 {
-						usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
-						totalTokens = int(math.Ceil(float64(usage.PromptTokens)*mak.ReqModel.TextQuota.PromptRatio + float64(usage.CompletionTokens)*mak.ReqModel.TextQuota.CompletionRatio))
-					} else {
-						usage.TotalTokens = mak.ReqModel.TextQuota.FixedQuota
-						totalTokens = mak.ReqModel.TextQuota.FixedQuota
-					}
-				}
+				usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+				totalTokens = service.Billing().ChatQuota(mak.ReqModel, usage)
 			}
 
 			if retryInfo == nil && (err == nil || common.IsAborted(err)) && mak.ReqModel != nil {
-				if err := grpool.Add(ctx, func(ctx context.Context) {
-					if err := service.Common().RecordUsage(ctx, totalTokens, mak.Key.Key); err != nil {
+
+				bill := service.Billing().Bill(ctx, &model.BillingReq{
+					Model:      mak.ReqModel,
+					Action:     consts.BILLING_ACTION_CHAT,
+					Usage:      usage,
+					RatioQuota: totalTokens,
+				})
+				totalTokens = bill.Quota
+
+				if err := graceful.Add(ctx, func(ctx context.Context) {
+					if err := service.Common().RecordUsage(ctx, totalTokens, mak.Key.Id); err != nil {
 						logger.Error(ctx, err)
 						panic(err)
 					}
+
+					if err := service.Billing().Ledger(ctx, bill, mak.Key.Id); err != nil {
+						logger.Error(ctx, err)
+					}
 				}); err != nil {
 					logger.Error(ctx, err)
 				}
 			}
 
 			if mak.ReqModel != nil && mak.RealModel != nil {
-				if err := grpool.Add(ctx, func(ctx context.Context) {
+				if err := graceful.Add(ctx, func(ctx context.Context) {
 
 					mak.RealModel.ModelAgent = mak.ModelAgent
 
 					completionsRes := &model.CompletionsRes{
 						Completion:   completion,
+						RepairCount:  repairCount,
+						RepairFail:   repairFail,
 						Error:        err,
 						ConnTime:     connTime,
 						Duration:     duration,
@@ -533,22 +550,66 @@ func (s *sChat) CompletionsStream(ctx context.Context, params sdkm.ChatCompletio
 		}
 	}
 
+	// 工具调用模拟
+	var toolEmulator *toolStream
+	if isToolEmulation(ctx, mak, request) {
+		var toolNames []string
+		if request, toolNames = toolEmulationRequest(request); len(toolNames) > 0 {
+			toolEmulator = &toolStream{
+				names:       toolNames,
+				isFunctions: len(params.Functions) > 0,
+				model:       mak.ReqModel.Model,
+			}
+		}
+	}
+
+	// 结构化输出校验和修复
+	var structured *structuredStream
+	if output := getStructuredOutput(params, mak.ReqModel); output != nil {
+		structured = &structuredStream{
+			output: output,
+			model:  mak.ReqModel.Model,
+		}
+	}
+
 	if client, err = common.NewClient(ctx, mak.Corp, mak.RealModel, mak.RealKey, mak.BaseUrl, mak.Path); err != nil {
 		logger.Error(ctx, err)
 		return err
 	}
 
-	response, err := client.ChatCompletionStream(ctx, request)
+	var (
+		stream     chan *sdkm.ChatCompletionResponse
+		firstChunk *sdkm.ChatCompletionResponse
+	)
+
+	// 对冲请求
+	if threshold := common.GetHedgeThreshold(mak.ReqModel.Model); threshold > 0 {
+
+		var winner *hedgeResult
+		winner, err = s.hedgeCompletionsStream(ctx, mak, client, request, &params, threshold)
+		defer winner.cancel()
+
+		if winner.mak != mak {
+			*mak = *winner.mak
+			client = winner.client
+		}
+
+		stream, firstChunk = winner.stream, winner.first
+
+	} else {
+		stream, err = client.ChatCompletionStream(ctx, request)
+	}
+
 	if err != nil {
 		logger.Error(ctx, err)
 
 		// 记录错误次数和禁用
 		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+		isRetry, isDisabled := common.IsNeedRetry(ctx, mak, err)
 
 		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
 				if mak.RealModel.IsEnableModelAgent {
 					service.ModelAgent().DisabledModelAgentKey(ctx, mak.Key, err.Error())
 				} else {
@@ -597,17 +658,27 @@ func (s *sChat) CompletionsStream(ctx context.Context, params sdkm.ChatCompletio
 				ErrMsg:     err.Error(),
 			}
 
+			// 重试退避
+			if !common.RetryBackoff(ctx, mak, err, retryInfo) {
+				retryInfo.IsRetry = false
+				return err
+			}
+
 			return s.CompletionsStream(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
 		}
 
 		return err
 	}
 
-	defer close(response)
+	defer close(stream)
 
 	for {
 
-		response := <-response
+		response := firstChunk
+		if response == nil {
+			response = <-stream
+		}
+		firstChunk = nil
 
 		connTime = response.ConnTime
 		duration = response.Duration
@@ -617,24 +688,19 @@ func (s *sChat) CompletionsStream(ctx context.Context, params sdkm.ChatCompletio
 
 			if errors.Is(response.Error, io.EOF) {
 
-				if response.Usage != nil {
-					if usage == nil {
-						usage = response.Usage
-					} else {
-						if response.Usage.PromptTokens != 0 {
-							usage.PromptTokens = response.Usage.PromptTokens
-						}
-						if response.Usage.CompletionTokens != 0 {
-							usage.CompletionTokens = response.Usage.CompletionTokens
-						}
-						if response.Usage.CompletionTokensDetails.ReasoningTokens != 0 {
-							usage.CompletionTokensDetails.ReasoningTokens = response.Usage.CompletionTokensDetails.ReasoningTokens
-						}
-						if response.Usage.TotalTokens != 0 {
-							usage.TotalTokens = response.Usage.TotalTokens
-						} else {
-							usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
-						}
+				usage = mergeUsage(usage, response.Usage)
+
+				if toolEmulator != nil {
+					if err = toolEmulator.flush(ctx, usage); err != nil {
+						return err
+					}
+				}
+
+				if structured != nil {
+					completion, usage, err = structured.flush(ctx, client, request, usage)
+					repairCount, repairFail = structured.attempts, structured.invalid
+					if err != nil {
+						return err
 					}
 				}
 
@@ -651,10 +717,10 @@ func (s *sChat) CompletionsStream(ctx context.Context, params sdkm.ChatCompletio
 			// 记录错误次数和禁用
 			service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
 
-			isRetry, isDisabled := common.IsNeedRetry(err)
+			isRetry, isDisabled := common.IsNeedRetry(ctx, mak, err)
 
 			if isDisabled {
-				if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+				if err := graceful.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
 					if mak.RealModel.IsEnableModelAgent {
 						service.ModelAgent().DisabledModelAgentKey(ctx, mak.Key, err.Error())
 					} else {
@@ -703,6 +769,12 @@ func (s *sChat) CompletionsStream(ctx context.Context, params sdkm.ChatCompletio
 					ErrMsg:     err.Error(),
 				}
 
+				// 重试退避
+				if !common.RetryBackoff(ctx, mak, err, retryInfo) {
+					retryInfo.IsRetry = false
+					return err
+				}
+
 				return s.CompletionsStream(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
 			}
 
@@ -727,22 +799,18 @@ func (s *sChat) CompletionsStream(ctx context.Context, params sdkm.ChatCompletio
 			completion += response.Choices[0].Delta.ToolCalls[0].Function.Arguments
 		}
 
-		if response.Usage != nil {
-			if usage == nil {
-				usage = response.Usage
-			} else {
-				if response.Usage.PromptTokens != 0 {
-					usage.PromptTokens = response.Usage.PromptTokens
-				}
-				if response.Usage.CompletionTokens != 0 {
-					usage.CompletionTokens = response.Usage.CompletionTokens
-				}
-				if response.Usage.TotalTokens != 0 {
-					usage.TotalTokens = response.Usage.TotalTokens
-				} else {
-					usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
-				}
-			}
+		// 部分厂商在结束前的数据块中返回用量
+		usage = mergeUsage(usage, response.Usage)
+
+		// 工具调用模拟, 缓冲中的响应不下发
+		if toolEmulator != nil && toolEmulator.handle(response) {
+			continue
+		}
+
+		// 结构化输出, 缓冲到结束后再下发
+		if structured != nil {
+			structured.handle(response)
+			continue
 		}
 
 		// 替换成调用的模型
@@ -777,12 +845,7 @@ func (s *sChat) CompletionsStream(ctx context.Context, params sdkm.ChatCompletio
 }
 
 // 保存日志
-func (s *sChat) SaveLog(ctx context.Context, reqModel, realModel *model.Model, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, key *model.Key, completionsReq *sdkm.ChatCompletionRequest, completionsRes *model.CompletionsRes, retryInfo *mcommon.Retry, isSmartMatch bool, retry ...int) {
-
-	if len(retry) == 0 {
-		s.mutex.Lock()
-		defer s.mutex.Unlock()
-	}
+func (s *sChat) SaveLog(ctx context.Context, reqModel, realModel *model.Model, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, key *model.Key, completionsReq *sdkm.ChatCompletionRequest, completionsRes *model.CompletionsRes, retryInfo *mcommon.Retry, isSmartMatch bool) {
 
 	now := gtime.TimestampMilli()
 	defer func() {
@@ -798,6 +861,7 @@ func (s *sChat) SaveLog(ctx context.Context, reqModel, realModel *model.Model, f
 		TraceId:      gctx.CtxId(ctx),
 		UserId:       service.Session().GetUserId(ctx),
 		AppId:        service.Session().GetAppId(ctx),
+		EndUser:      service.Session().GetEndUser(ctx),
 		IsSmartMatch: isSmartMatch,
 		Stream:       completionsReq.Stream,
 		ConnTime:     completionsRes.ConnTime,
@@ -809,11 +873,13 @@ func (s *sChat) SaveLog(ctx context.Context, reqModel, realModel *model.Model, f
 		ClientIp:     g.RequestFromCtx(ctx).GetClientIp(),
 		RemoteIp:     g.RequestFromCtx(ctx).GetRemoteIp(),
 		LocalIp:      util.GetLocalIp(),
+		RepairCount:  completionsRes.RepairCount,
+		RepairFail:   completionsRes.RepairFail,
 		Status:       1,
 		Host:         g.RequestFromCtx(ctx).GetHost(),
 	}
 
-	if len(completionsReq.Messages) > 0 && slices.Contains(config.Cfg.RecordLogs, "prompt") {
+	if len(completionsReq.Messages) > 0 && common.IsRecordLog(ctx, "prompt") {
 
 		prompt := completionsReq.Messages[len(completionsReq.Messages)-1].Content
 
@@ -833,7 +899,7 @@ func (s *sChat) SaveLog(ctx context.Context, reqModel, realModel *model.Model, f
 
 		} else {
 
-			if slices.Contains(config.Cfg.RecordLogs, "image") {
+			if common.IsRecordLog(ctx, "image") {
 				chat.Prompt = gconv.String(prompt)
 			} else {
 				if multiContent, ok := prompt.([]interface{}); ok {
@@ -868,7 +934,7 @@ func (s *sChat) SaveLog(ctx context.Context, reqModel, realModel *model.Model, f
 		}
 	}
 
-	if slices.Contains(config.Cfg.RecordLogs, "completion") {
+	if common.IsRecordLog(ctx, "completion") {
 		chat.Completion = completionsRes.Completion
 	}
 
@@ -918,6 +984,14 @@ func (s *sChat) SaveLog(ctx context.Context, reqModel, realModel *model.Model, f
 	chat.CompletionTokens = completionsRes.Usage.CompletionTokens
 	chat.TotalTokens = completionsRes.Usage.TotalTokens
 
+	if completionsRes.Usage.PromptTokensDetails != nil {
+		chat.CachedTokens = completionsRes.Usage.PromptTokensDetails.CachedTokens
+	}
+
+	if completionsRes.Usage.CompletionTokensDetails != nil {
+		chat.ReasoningTokens = completionsRes.Usage.CompletionTokensDetails.ReasoningTokens
+	}
+
 	if fallbackModelAgent != nil {
 		chat.IsEnableFallback = true
 		chat.FallbackConfig = &mcommon.FallbackConfig{
@@ -936,7 +1010,7 @@ func (s *sChat) SaveLog(ctx context.Context, reqModel, realModel *model.Model, f
 	}
 
 	if key != nil {
-		chat.Key = key.Key
+		chat.Key = common.KeyRef(key)
 	}
 
 	if completionsRes.Error != nil {
@@ -948,12 +1022,12 @@ func (s *sChat) SaveLog(ctx context.Context, reqModel, realModel *model.Model, f
 		}
 	}
 
-	if slices.Contains(config.Cfg.RecordLogs, "messages") {
+	if common.IsRecordLog(ctx, "messages") {
 		for _, message := range completionsReq.Messages {
 
 			content := message.Content
 
-			if !slices.Contains(config.Cfg.RecordLogs, "image") {
+			if !common.IsRecordLog(ctx, "image") {
 
 				if multiContent, ok := content.([]interface{}); ok {
 
@@ -996,6 +1070,10 @@ func (s *sChat) SaveLog(ctx context.Context, reqModel, realModel *model.Model, f
 			IsRetry:    retryInfo.IsRetry,
 			RetryCount: retryInfo.RetryCount,
 			ErrMsg:     retryInfo.ErrMsg,
+			Status:     retryInfo.Status,
+			Code:       retryInfo.Code,
+			Delay:      retryInfo.Delay,
+			Decision:   retryInfo.Decision,
 		}
 
 		if chat.IsRetry {
@@ -1004,19 +1082,49 @@ func (s *sChat) SaveLog(ctx context.Context, reqModel, realModel *model.Model, f
 		}
 	}
 
-	if _, err := dao.Chat.Insert(ctx, chat); err != nil {
+	if err := service.LogWriter().Write(ctx, chat); err != nil {
 		logger.Error(ctx, err)
+	}
+}
 
-		if len(retry) == 10 {
-			panic(err)
-		}
+// 合并流式数据块中的用量, 非零值覆盖已有的值, 包括缓存和推理的令牌数
+func mergeUsage(usage, chunk *sdkm.Usage) *sdkm.Usage {
 
-		retry = append(retry, 1)
+	if chunk == nil {
+		return usage
+	}
 
-		time.Sleep(time.Duration(len(retry)*5) * time.Second)
+	if usage == nil {
+		return chunk
+	}
 
-		logger.Errorf(ctx, "sChat SaveLog retry: %d", len(retry))
+	if chunk.PromptTokens != 0 {
+		usage.PromptTokens = chunk.PromptTokens
+	}
 
-		s.SaveLog(ctx, reqModel, realModel, fallbackModelAgent, fallbackModel, key, completionsReq, completionsRes, retryInfo, isSmartMatch, retry...)
+	if chunk.CompletionTokens != 0 {
+		usage.CompletionTokens = chunk.CompletionTokens
 	}
+
+	if chunk.PromptTokensDetails != nil && chunk.PromptTokensDetails.CachedTokens != 0 {
+		if usage.PromptTokensDetails == nil {
+			usage.PromptTokensDetails = new(sdkm.PromptTokensDetails)
+		}
+		usage.PromptTokensDetails.CachedTokens = chunk.PromptTokensDetails.CachedTokens
+	}
+
+	if chunk.CompletionTokensDetails != nil && chunk.CompletionTokensDetails.ReasoningTokens != 0 {
+		if usage.CompletionTokensDetails == nil {
+			usage.CompletionTokensDetails = new(sdkm.CompletionTokensDetails)
+		}
+		usage.CompletionTokensDetails.ReasoningTokens = chunk.CompletionTokensDetails.ReasoningTokens
+	}
+
+	if chunk.TotalTokens != 0 {
+		usage.TotalTokens = chunk.TotalTokens
+	} else {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+
+	return usage
 }
@@ -0,0 +1,169 @@
+package chat
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/text/gregex"
+	"github.com/gogf/gf/v2/text/gstr"
+	"github.com/gogf/gf/v2/util/gconv"
+	sdkm "github.com/iimeta/fastapi-sdk/model"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/internal/model"
+	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/logger"
+	"github.com/iimeta/fastapi/utility/util"
+	"sync"
+)
+
+const judgePrompt = `You are an impartial judge. Compare the candidate answers to the conversation below and pick the best one by correctness, helpfulness and clarity.
+
+Conversation:
+%s
+
+Candidates:
+%s
+Reply with ONLY the number of the best candidate.`
+
+// Fanout
+func (s *sChat) Fanout(ctx context.Context, params model.ChatFanoutReq) (response *model.ChatFanoutRes, err error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sChat Fanout time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	models := util.Unique(params.Models)
+	if len(models) == 0 {
+		err = errors.ERR_INVALID_PARAMETER
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	if maxModels := cmp.Or(config.Cfg.Fanout.MaxModels, 5); len(models) > maxModels {
+		err = errors.NewErrorf(400, "invalid_parameter", "Too many models, the maximum is %d.", "fastapi_request_error", maxModels)
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	// 校验密钥是否有权限使用所有模型
+	for _, m := range append(models, params.JudgeModel) {
+		if m == "" {
+			continue
+		}
+		if _, err = service.Model().GetModelBySecretKey(ctx, m, service.Session().GetSecretKey(ctx)); err != nil {
+			logger.Error(ctx, err)
+			return nil, err
+		}
+	}
+
+	response = &model.ChatFanoutRes{
+		Id:      gctx.CtxId(ctx),
+		Object:  "chat.completion.fanout",
+		Created: gtime.Timestamp(),
+		Results: make([]*model.ChatFanoutResult, len(models)),
+	}
+
+	// 每个模型单独计费和记录日志, 共用同一个TraceId
+	var wg sync.WaitGroup
+	for i, m := range models {
+		wg.Add(1)
+		go func(i int, m string) {
+			defer wg.Done()
+			response.Results[i] = s.fanoutCompletions(ctx, params.ChatCompletionRequest, m)
+		}(i, m)
+	}
+	wg.Wait()
+
+	if params.JudgeModel != "" {
+		response.Winner, response.Judge = s.fanoutJudge(ctx, params, response.Results)
+	}
+
+	return response, nil
+}
+
+// 调用单个模型
+func (s *sChat) fanoutCompletions(ctx context.Context, params sdkm.ChatCompletionRequest, m string) *model.ChatFanoutResult {
+
+	now := gtime.TimestampMilli()
+
+	params.Model = m
+	params.Stream = false
+	params.StreamOptions = nil
+
+	result := &model.ChatFanoutResult{
+		Model: m,
+	}
+
+	defer func() {
+		result.Latency = gtime.TimestampMilli() - now
+	}()
+
+	response, err := s.Completions(ctx, params, nil, nil)
+	if err != nil {
+		logger.Error(ctx, err)
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Response = &response
+	result.Usage = response.Usage
+
+	return result
+}
+
+// 使用评判模型选出最佳回答
+func (s *sChat) fanoutJudge(ctx context.Context, params model.ChatFanoutReq, results []*model.ChatFanoutResult) (string, *model.ChatFanoutResult) {
+
+	var (
+		conversation string
+		candidates   string
+		indexes      = make([]int, 0)
+	)
+
+	for _, message := range params.Messages {
+		conversation += fmt.Sprintf("%s: %s\n", message.Role, gconv.String(message.Content))
+	}
+
+	for i, result := range results {
+		if result.Response != nil && len(result.Response.Choices) > 0 && result.Response.Choices[0].Message != nil {
+			indexes = append(indexes, i)
+			candidates += fmt.Sprintf("[%d]\n%s\n\n", len(indexes), gconv.String(result.Response.Choices[0].Message.Content))
+		}
+	}
+
+	if len(indexes) == 0 {
+		return "", nil
+	}
+
+	if len(indexes) == 1 {
+		return results[indexes[0]].Model, nil
+	}
+
+	judge := s.fanoutCompletions(ctx, sdkm.ChatCompletionRequest{
+		Messages: []sdkm.ChatCompletionMessage{{
+			Role:    consts.ROLE_USER,
+			Content: fmt.Sprintf(judgePrompt, conversation, candidates),
+		}},
+	}, params.JudgeModel)
+
+	if judge.Response == nil || len(judge.Response.Choices) == 0 || judge.Response.Choices[0].Message == nil {
+		return "", judge
+	}
+
+	match, err := gregex.MatchString(`\d+`, gstr.Trim(gconv.String(judge.Response.Choices[0].Message.Content)))
+	if err != nil || len(match) == 0 {
+		logger.Errorf(ctx, "sChat fanoutJudge parse judge answer failed, answer: %s", gconv.String(judge.Response.Choices[0].Message.Content))
+		return "", judge
+	}
+
+	if index := gconv.Int(match[0]); index >= 1 && index <= len(indexes) {
+		return results[indexes[index-1]].Model, judge
+	}
+
+	return "", judge
+}
@@ -0,0 +1,207 @@
+package chat
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/text/gstr"
+	sdk "github.com/iimeta/fastapi-sdk"
+	sdkm "github.com/iimeta/fastapi-sdk/model"
+	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/internal/logic/common"
+	"github.com/iimeta/fastapi/internal/model"
+	"github.com/iimeta/fastapi/utility/graceful"
+	"github.com/iimeta/fastapi/utility/logger"
+	"io"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+type hedgeResult struct {
+	mak       *common.MAK
+	client    sdk.Client
+	response  sdkm.ChatCompletionResponse
+	stream    chan *sdkm.ChatCompletionResponse
+	first     *sdkm.ChatCompletionResponse
+	err       error
+	start     int64
+	cancel    context.CancelFunc
+	done      chan struct{} // 调用结束
+	firstByte chan struct{} // 收到首字节
+	once      sync.Once
+}
+
+// 是否成功
+func (r *hedgeResult) isSuccess() bool {
+	return r.err == nil && (r.first == nil || r.first.Error == nil || errors.Is(r.first.Error, io.EOF))
+}
+
+// 标记收到首字节
+func (r *hedgeResult) markFirstByte() {
+	r.once.Do(func() {
+		close(r.firstByte)
+	})
+}
+
+// 对冲请求, 首个上游在阈值内未响应时, 使用其它密钥或模型代理发起相同请求, 返回先成功响应的结果
+func (s *sChat) hedgeCompletions(ctx context.Context, mak *common.MAK, client sdk.Client, request sdkm.ChatCompletionRequest, params *sdkm.ChatCompletionRequest, threshold time.Duration) (*hedgeResult, error) {
+
+	winner, err := s.hedge(ctx, mak, client, request, params, threshold, func(ctx context.Context, client sdk.Client, request sdkm.ChatCompletionRequest, result *hedgeResult) {
+
+		// 非流式以收到响应的首字节作为首字节, 不等待完整响应
+		ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			GotFirstResponseByte: result.markFirstByte,
+		})
+
+		result.response, result.err = client.ChatCompletion(ctx, request)
+	})
+
+	// 非流式响应已完整读取, 可以释放
+	winner.cancel()
+
+	return winner, err
+}
+
+// 流式对冲请求, 以收到首个数据块作为首字节
+func (s *sChat) hedgeCompletionsStream(ctx context.Context, mak *common.MAK, client sdk.Client, request sdkm.ChatCompletionRequest, params *sdkm.ChatCompletionRequest, threshold time.Duration) (*hedgeResult, error) {
+	return s.hedge(ctx, mak, client, request, params, threshold, func(ctx context.Context, client sdk.Client, request sdkm.ChatCompletionRequest, result *hedgeResult) {
+		if result.stream, result.err = client.ChatCompletionStream(ctx, request); result.err == nil {
+			result.first = <-result.stream
+		}
+	})
+}
+
+func (s *sChat) hedge(ctx context.Context, mak *common.MAK, client sdk.Client, request sdkm.ChatCompletionRequest, params *sdkm.ChatCompletionRequest, threshold time.Duration,
+	call func(ctx context.Context, client sdk.Client, request sdkm.ChatCompletionRequest, result *hedgeResult)) (*hedgeResult, error) {
+
+	start := func(mak *common.MAK, client sdk.Client, request sdkm.ChatCompletionRequest) *hedgeResult {
+
+		callCtx, cancel := context.WithCancel(ctx)
+
+		result := &hedgeResult{
+			mak:       mak,
+			client:    client,
+			start:     gtime.TimestampMilli(),
+			cancel:    cancel,
+			done:      make(chan struct{}),
+			firstByte: make(chan struct{}),
+		}
+
+		go func() {
+			defer close(result.done)
+			defer result.markFirstByte()
+			call(callCtx, client, request, result)
+		}()
+
+		return result
+	}
+
+	primary := start(mak, client, request)
+
+	timer := time.NewTimer(threshold)
+	defer timer.Stop()
+
+	select {
+	case <-primary.firstByte:
+		<-primary.done
+		return primary, primary.err
+	case <-timer.C:
+	}
+
+	hedgeMak, err := mak.HedgeMAK(ctx)
+	if err != nil {
+		logger.Errorf(ctx, "sChat hedge model: %s, no hedge key available, error: %v", mak.ReqModel.Model, err)
+		<-primary.done
+		return primary, primary.err
+	}
+
+	hedgeClient, err := common.NewClient(ctx, hedgeMak.Corp, hedgeMak.RealModel, hedgeMak.RealKey, hedgeMak.BaseUrl, hedgeMak.Path)
+	if err != nil {
+		logger.Error(ctx, err)
+		<-primary.done
+		return primary, primary.err
+	}
+
+	hedgeRequest := request
+	if !gstr.Contains(hedgeMak.RealModel.Model, "*") {
+		hedgeRequest.Model = hedgeMak.RealModel.Model
+	}
+
+	logger.Infof(ctx, "sChat hedge model: %s, first byte exceeded %s, start hedge with key id: %s", mak.ReqModel.Model, threshold, hedgeMak.Key.Id)
+
+	hedge := start(hedgeMak, hedgeClient, hedgeRequest)
+
+	// 先收到首字节的一方优先
+	leader, other := primary, hedge
+	select {
+	case <-primary.firstByte:
+	case <-hedge.firstByte:
+		leader, other = hedge, primary
+	}
+
+	<-leader.done
+
+	winner, loser := leader, other
+
+	// 优先方失败, 等待另一方的结果, 均失败时返回首个上游的结果
+	if !leader.isSuccess() {
+		if <-other.done; other.isSuccess() || other == primary {
+			winner, loser = other, leader
+		}
+	}
+
+	s.hedgeLoser(ctx, loser, params, leader.isSuccess())
+
+	return winner, winner.err
+}
+
+// 取消对冲失败方并记录日志, 不计费, 流式需要读取完剩余的数据块, 由SDK关闭
+func (s *sChat) hedgeLoser(ctx context.Context, loser *hedgeResult, params *sdkm.ChatCompletionRequest, aborted bool) {
+
+	loser.cancel()
+
+	if e := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+
+		<-loser.done
+
+		if loser.stream != nil {
+			for range loser.stream {
+			}
+		}
+
+		var (
+			mak       = *loser.mak
+			realModel = *loser.mak.RealModel
+			err       = errors.ERR_HEDGE_ABORTED
+			connTime  = loser.response.ConnTime
+		)
+
+		if loser.first != nil {
+			connTime = loser.first.ConnTime
+		}
+
+		if !aborted {
+			if loser.err != nil {
+				err = loser.err
+			} else if loser.first != nil && loser.first.Error != nil {
+				err = loser.first.Error
+			}
+		}
+
+		realModel.ModelAgent = mak.ModelAgent
+
+		completionsRes := &model.CompletionsRes{
+			Error:     err,
+			ConnTime:  connTime,
+			TotalTime: gtime.TimestampMilli() - loser.start,
+			EnterTime: g.RequestFromCtx(ctx).EnterTime.TimestampMilli(),
+		}
+
+		s.SaveLog(ctx, mak.ReqModel, &realModel, mak.FallbackModelAgent, mak.FallbackModel, mak.Key, params, completionsRes, nil, false)
+
+	}); e != nil {
+		logger.Error(ctx, e)
+	}
+}
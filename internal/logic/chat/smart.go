@@ -4,7 +4,6 @@ import (
 	"context"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/os/gctx"
-	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/util/gconv"
 	"github.com/iimeta/fastapi-sdk"
@@ -14,9 +13,8 @@ import (
 	"github.com/iimeta/fastapi/internal/model"
 	mcommon "github.com/iimeta/fastapi/internal/model/common"
 	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/graceful"
 	"github.com/iimeta/fastapi/utility/logger"
-	"github.com/iimeta/tiktoken-go"
-	"math"
 )
 
 // SmartCompletions
@@ -39,6 +37,7 @@ func (s *sChat) SmartCompletions(ctx context.Context, params sdkm.ChatCompletion
 		retryInfo   *mcommon.Retry
 		textTokens  int
 		imageTokens int
+		imageQuota  int
 		totalTokens int
 	)
 
@@ -49,44 +48,39 @@ func (s *sChat) SmartCompletions(ctx context.Context, params sdkm.ChatCompletion
 
 		if retryInfo == nil && (err == nil || common.IsAborted(err)) && mak.RealModel != nil {
 
-			model := mak.RealModel.Model
-			if !tiktoken.IsEncodingForModel(model) {
-				model = consts.DEFAULT_MODEL
-			}
-
 			if mak.RealModel.Type == 100 { // 多模态
 				if response.Usage == nil {
 
 					response.Usage = new(sdkm.Usage)
 
 					if content, ok := params.Messages[len(params.Messages)-1].Content.([]interface{}); ok {
-						textTokens, imageTokens = common.GetMultimodalTokens(ctx, model, content, mak.RealModel)
-						response.Usage.PromptTokens = textTokens + imageTokens
+						textTokens, imageTokens, imageQuota = common.GetMultimodalTokens(ctx, mak.RealModel, content)
+						response.Usage.PromptTokens = textTokens + imageTokens + imageQuota
 					} else {
 						if response.Usage.PromptTokens == 0 {
-							response.Usage.PromptTokens = common.GetPromptTokens(ctx, model, params.Messages)
+							response.Usage.PromptTokens = common.GetPromptTokens(ctx, mak.RealModel, params.Messages)
 						}
 					}
 
 					if response.Usage.CompletionTokens == 0 && len(response.Choices) > 0 && response.Choices[0].Message != nil {
-						response.Usage.CompletionTokens = common.GetCompletionTokens(ctx, model, gconv.String(response.Choices[0].Message.Content))
+						response.Usage.CompletionTokens = common.GetCompletionTokens(ctx, mak.RealModel, gconv.String(response.Choices[0].Message.Content))
 					}
 
 					response.Usage.TotalTokens = response.Usage.PromptTokens + response.Usage.CompletionTokens
-					totalTokens = imageTokens + int(math.Ceil(float64(textTokens)*mak.RealModel.MultimodalQuota.TextQuota.PromptRatio)) + int(math.Ceil(float64(response.Usage.CompletionTokens)*mak.RealModel.MultimodalQuota.TextQuota.CompletionRatio))
+					totalTokens = service.Billing().MultimodalQuota(mak.RealModel, textTokens, imageTokens, imageQuota, response.Usage.CompletionTokens)
 
 				} else {
-					totalTokens = int(math.Ceil(float64(response.Usage.PromptTokens)*mak.RealModel.MultimodalQuota.TextQuota.PromptRatio)) + int(math.Ceil(float64(response.Usage.CompletionTokens)*mak.RealModel.MultimodalQuota.TextQuota.CompletionRatio))
+					totalTokens = service.Billing().ChatQuota(mak.RealModel, response.Usage)
 				}
 
 			} else if response.Usage == nil || response.Usage.TotalTokens == 0 {
 
 				response.Usage = new(sdkm.Usage)
 
-				response.Usage.PromptTokens = common.GetPromptTokens(ctx, model, params.Messages)
+				response.Usage.PromptTokens = common.GetPromptTokens(ctx, mak.RealModel, params.Messages)
 
 				if len(response.Choices) > 0 && response.Choices[0].Message != nil {
-					response.Usage.CompletionTokens = common.GetCompletionTokens(ctx, model, gconv.String(response.Choices[0].Message.Content))
+					response.Usage.CompletionTokens = common.GetCompletionTokens(ctx, mak.RealModel, gconv.String(response.Choices[0].Message.Content))
 				}
 
 				response.Usage.TotalTokens = response.Usage.PromptTokens + response.Usage.CompletionTokens
@@ -95,16 +89,12 @@ func (s *sChat) SmartCompletions(ctx context.Context, params sdkm.ChatCompletion
 
 		if mak.RealModel != nil && response.Usage != nil {
 			if mak.RealModel.Type != 100 {
-				if mak.RealModel.TextQuota.BillingMethod == 1 {
-					totalTokens = int(math.Ceil(float64(response.Usage.PromptTokens)*mak.RealModel.TextQuota.PromptRatio + float64(response.Usage.CompletionTokens)*mak.RealModel.TextQuota.CompletionRatio))
-				} else {
-					totalTokens = mak.RealModel.TextQuota.FixedQuota
-				}
+				totalTokens = service.Billing().TextQuota(mak.RealModel, response.Usage)
 			}
 		}
 
 		if mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
 
 				mak.RealModel.ModelAgent = mak.ModelAgent
 
@@ -177,10 +167,10 @@ func (s *sChat) SmartCompletions(ctx context.Context, params sdkm.ChatCompletion
 	if err != nil {
 		logger.Error(ctx, err)
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+		isRetry, isDisabled := common.IsNeedRetry(ctx, mak, err)
 
 		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
 				if mak.RealModel.IsEnableModelAgent {
 					service.ModelAgent().DisabledModelAgentKey(ctx, mak.Key, err.Error())
 				} else {
@@ -229,6 +219,12 @@ func (s *sChat) SmartCompletions(ctx context.Context, params sdkm.ChatCompletion
 				ErrMsg:     err.Error(),
 			}
 
+			// 重试退避
+			if !common.RetryBackoff(ctx, mak, err, retryInfo) {
+				retryInfo.IsRetry = false
+				return response, err
+			}
+
 			return s.SmartCompletions(g.RequestFromCtx(ctx).GetCtx(), params, reqModel, fallbackModelAgent, fallbackModel, append(retry, 1)...)
 		}
 
@@ -0,0 +1,227 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gconv"
+	sdk "github.com/iimeta/fastapi-sdk"
+	sdkm "github.com/iimeta/fastapi-sdk/model"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/logic/common"
+	"github.com/iimeta/fastapi/internal/model"
+	"github.com/iimeta/fastapi/utility/jsonschema"
+	"github.com/iimeta/fastapi/utility/logger"
+)
+
+const structuredRepairPrompt = `Your previous response is not valid for the required response format: %s
+
+Reply again with ONLY the corrected JSON, without any explanation or Markdown code block.%s`
+
+// 修复次数用完后仍校验不通过时返回的响应头
+const structuredInvalidHeader = "X-Structured-Output-Invalid"
+
+// 结构化输出
+type structuredOutput struct {
+	formatType string
+	schema     map[string]interface{}
+	model      *model.Model // 上游未返回用量时用于估算修复消耗的令牌
+}
+
+// 获取请求的结构化输出格式, 未开启或不需要校验时返回nil
+func getStructuredOutput(params sdkm.ChatCompletionRequest, reqModel *model.Model) *structuredOutput {
+
+	if !config.Cfg.StructuredOutput.Open || params.ResponseFormat == nil || len(params.Tools) > 0 || len(params.Functions) > 0 {
+		return nil
+	}
+
+	format := gjson.New(params.ResponseFormat)
+
+	switch format.Get("type").String() {
+	case consts.RESPONSE_FORMAT_JSON_OBJECT:
+		return &structuredOutput{
+			formatType: consts.RESPONSE_FORMAT_JSON_OBJECT,
+			schema:     map[string]interface{}{"type": "object"},
+			model:      reqModel,
+		}
+	case consts.RESPONSE_FORMAT_JSON_SCHEMA:
+
+		schema := format.Get("json_schema.schema").Map()
+		if len(schema) == 0 {
+			schema = map[string]interface{}{"type": "object"}
+		}
+
+		return &structuredOutput{
+			formatType: consts.RESPONSE_FORMAT_JSON_SCHEMA,
+			schema:     schema,
+			model:      reqModel,
+		}
+	}
+
+	return nil
+}
+
+// 校验回答, 返回去除代码块标记后的内容
+func (o *structuredOutput) validate(content string) (string, error) {
+
+	content = trimCodeFence(content)
+
+	return content, jsonschema.Validate([]byte(content), o.schema)
+}
+
+// 校验并修复回答, 校验不通过时带上错误信息重新请求模型, 返回修复后的内容、修复次数和修复消耗的令牌
+func (o *structuredOutput) repair(ctx context.Context, client sdk.Client, request sdkm.ChatCompletionRequest, content string) (string, int, sdkm.Usage, error) {
+
+	var (
+		usage    sdkm.Usage
+		attempts int
+		schema   string
+	)
+
+	result, err := o.validate(content)
+
+	if o.formatType == consts.RESPONSE_FORMAT_JSON_SCHEMA {
+		schema = "\n\nThe JSON must conform to this JSON Schema:\n" + gjson.MustEncodeString(o.schema)
+	}
+
+	request.Stream = false
+	request.StreamOptions = nil
+
+	for err != nil && attempts < config.Cfg.StructuredOutput.RepairAttempts {
+
+		attempts++
+		logger.Infof(ctx, "structured output repair attempt: %d, err: %v", attempts, err)
+
+		request.Messages = append(request.Messages, sdkm.ChatCompletionMessage{
+			Role:    consts.ROLE_ASSISTANT,
+			Content: content,
+		}, sdkm.ChatCompletionMessage{
+			Role:    consts.ROLE_USER,
+			Content: fmt.Sprintf(structuredRepairPrompt, err.Error(), schema),
+		})
+
+		response, reqErr := client.ChatCompletion(ctx, request)
+		if reqErr != nil {
+			logger.Error(ctx, reqErr)
+			return result, attempts, usage, err
+		}
+
+		completion := ""
+		if len(response.Choices) > 0 && response.Choices[0].Message != nil {
+			completion = gconv.String(response.Choices[0].Message.Content)
+			content = completion
+			result, err = o.validate(content)
+		}
+
+		// 上游未返回用量时按请求消息和回答估算
+		if response.Usage != nil {
+			usage.PromptTokens += response.Usage.PromptTokens
+			usage.CompletionTokens += response.Usage.CompletionTokens
+			usage.TotalTokens += response.Usage.TotalTokens
+		} else {
+			promptTokens := common.GetPromptTokens(ctx, o.model, request.Messages)
+			completionTokens := common.GetCompletionTokens(ctx, o.model, completion)
+			usage.PromptTokens += promptTokens
+			usage.CompletionTokens += completionTokens
+			usage.TotalTokens += promptTokens + completionTokens
+		}
+	}
+
+	if err != nil {
+		logger.Errorf(ctx, "structured output validate failed after %d repair attempts, err: %v", attempts, err)
+	}
+
+	return result, attempts, usage, err
+}
+
+// 累加修复消耗的令牌, 上游未返回用量时先按原请求和回答估算
+func (o *structuredOutput) addUsage(ctx context.Context, usage *sdkm.Usage, request sdkm.ChatCompletionRequest, content string, repairUsage sdkm.Usage) *sdkm.Usage {
+
+	if usage == nil {
+		usage = &sdkm.Usage{
+			PromptTokens:     common.GetPromptTokens(ctx, o.model, request.Messages),
+			CompletionTokens: common.GetCompletionTokens(ctx, o.model, content),
+		}
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+
+	usage.PromptTokens += repairUsage.PromptTokens
+	usage.CompletionTokens += repairUsage.CompletionTokens
+	usage.TotalTokens += repairUsage.TotalTokens
+
+	return usage
+}
+
+// 非流式结构化输出校验和修复, 返回修复次数和修复后是否仍校验不通过
+func structuredOutputResponse(ctx context.Context, client sdk.Client, request sdkm.ChatCompletionRequest, response *sdkm.ChatCompletionResponse, output *structuredOutput) (int, bool) {
+
+	if len(response.Choices) == 0 || response.Choices[0].Message == nil {
+		return 0, false
+	}
+
+	content := gconv.String(response.Choices[0].Message.Content)
+
+	result, attempts, usage, err := output.repair(ctx, client, request, content)
+
+	if result != content {
+		response.Choices[0].Message.Content = result
+		response.ResponseBytes = nil
+	}
+
+	if attempts > 0 {
+		response.Usage = output.addUsage(ctx, response.Usage, request, content, usage)
+	}
+
+	if err != nil {
+		g.RequestFromCtx(ctx).Response.Header().Set(structuredInvalidHeader, "true")
+	}
+
+	return attempts, err != nil
+}
+
+// 流式结构化输出, 缓冲全部回答, 校验和修复后再下发
+type structuredStream struct {
+	output   *structuredOutput
+	content  string
+	id       string
+	created  int64
+	model    string
+	attempts int
+	invalid  bool // 修复后仍校验不通过
+}
+
+// 处理流式响应, 缓冲回答内容
+func (t *structuredStream) handle(response *sdkm.ChatCompletionResponse) {
+
+	if t.id == "" {
+		data := gjson.New(response)
+		t.id = data.Get("id").String()
+		t.created = data.Get("created").Int64()
+	}
+
+	if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
+		t.content += response.Choices[0].Delta.Content
+	}
+}
+
+// 结束时校验和修复, 并下发最终内容, 返回最终内容和累加修复消耗后的用量
+func (t *structuredStream) flush(ctx context.Context, client sdk.Client, request sdkm.ChatCompletionRequest, usage *sdkm.Usage) (string, *sdkm.Usage, error) {
+
+	result, attempts, repairUsage, err := t.output.repair(ctx, client, request, t.content)
+
+	t.attempts = attempts
+	t.invalid = err != nil
+
+	if attempts > 0 {
+		usage = t.output.addUsage(ctx, usage, request, t.content, repairUsage)
+	}
+
+	delta := map[string]interface{}{
+		"role":    consts.ROLE_ASSISTANT,
+		"content": result,
+	}
+
+	return result, usage, sseChunks(ctx, t.id, t.created, t.model, delta, consts.FINISH_REASON_STOP, usage)
+}
@@ -0,0 +1,390 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/text/gstr"
+	"github.com/gogf/gf/v2/util/gconv"
+	sdkm "github.com/iimeta/fastapi-sdk/model"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/logic/common"
+	"github.com/iimeta/fastapi/utility/logger"
+	"github.com/iimeta/fastapi/utility/util"
+	"slices"
+)
+
+const toolEmulationPrompt = `You have access to the following tools:
+
+%s
+
+When you decide to call one or more tools, respond with ONLY a JSON object in the following format and nothing else:
+{"tool_calls": [{"name": "<tool name>", "arguments": {<arguments matching the tool parameters>}}]}
+
+If no tool is needed, answer the user directly in plain text without mentioning the tools.`
+
+// 是否需要模拟工具调用
+func isToolEmulation(ctx context.Context, mak *common.MAK, params sdkm.ChatCompletionRequest) bool {
+
+	if len(params.Tools) == 0 && len(params.Functions) == 0 {
+		return false
+	}
+
+	if slices.Contains(config.Cfg.ToolEmulation.Models, mak.RealModel.Model) {
+		return true
+	}
+
+	return slices.Contains(config.Cfg.ToolEmulation.Corps, common.GetCorpCode(ctx, mak.Corp))
+}
+
+// 工具调用模拟请求, 将工具定义注入系统提示词, 并将历史中的工具调用转换为普通消息
+func toolEmulationRequest(request sdkm.ChatCompletionRequest) (sdkm.ChatCompletionRequest, []string) {
+
+	var (
+		tools      = make([]map[string]interface{}, 0)
+		names      = make([]string, 0)
+		toolChoice = gconv.String(request.ToolChoice)
+	)
+
+	for _, tool := range request.Tools {
+		if tool.Function != nil {
+			tools = append(tools, map[string]interface{}{
+				"name":        tool.Function.Name,
+				"description": tool.Function.Description,
+				"parameters":  tool.Function.Parameters,
+			})
+			names = append(names, tool.Function.Name)
+		}
+	}
+
+	for _, function := range request.Functions {
+		tools = append(tools, map[string]interface{}{
+			"name":        function.Name,
+			"description": function.Description,
+			"parameters":  function.Parameters,
+		})
+		names = append(names, function.Name)
+	}
+
+	// 指定调用的工具
+	forceName := gjson.New(request.ToolChoice).Get("function.name").String()
+
+	if request.FunctionCall != nil {
+		toolChoice = gconv.String(request.FunctionCall)
+		if name := gjson.New(request.FunctionCall).Get("name").String(); name != "" {
+			forceName = name
+		}
+	}
+
+	messages := make([]sdkm.ChatCompletionMessage, 0)
+	for _, message := range request.Messages {
+
+		switch message.Role {
+		case consts.ROLE_TOOL:
+			message = sdkm.ChatCompletionMessage{
+				Role:    consts.ROLE_USER,
+				Content: fmt.Sprintf("Result of tool call %s:\n%s", message.ToolCallID, gconv.String(message.Content)),
+			}
+		case consts.ROLE_FUNCTION:
+			message = sdkm.ChatCompletionMessage{
+				Role:    consts.ROLE_USER,
+				Content: fmt.Sprintf("Result of function %s:\n%s", message.Name, gconv.String(message.Content)),
+			}
+		case consts.ROLE_ASSISTANT:
+
+			toolCalls := make([]map[string]interface{}, 0)
+
+			for _, toolCall := range gjson.New(message.ToolCalls).Array() {
+				call := gjson.New(toolCall)
+				toolCalls = append(toolCalls, map[string]interface{}{
+					"name":      call.Get("function.name").String(),
+					"arguments": gjson.New(call.Get("function.arguments").String()).Map(),
+				})
+			}
+
+			if message.FunctionCall != nil {
+				toolCalls = append(toolCalls, map[string]interface{}{
+					"name":      message.FunctionCall.Name,
+					"arguments": gjson.New(message.FunctionCall.Arguments).Map(),
+				})
+			}
+
+			if len(toolCalls) > 0 {
+				message = sdkm.ChatCompletionMessage{
+					Role:    consts.ROLE_ASSISTANT,
+					Content: gjson.MustEncodeString(map[string]interface{}{"tool_calls": toolCalls}),
+				}
+			}
+		}
+
+		messages = append(messages, message)
+	}
+
+	request.Messages = messages
+	request.Tools = nil
+	request.ToolChoice = nil
+	request.Functions = nil
+	request.FunctionCall = nil
+
+	if toolChoice == "none" || len(tools) == 0 {
+		return request, nil
+	}
+
+	prompt := fmt.Sprintf(toolEmulationPrompt, gjson.MustEncodeString(tools))
+
+	if toolChoice == "required" {
+		prompt += "\n\nYou MUST call at least one tool."
+	} else if forceName != "" {
+		prompt += fmt.Sprintf("\n\nYou MUST call the tool `%s`.", forceName)
+	}
+
+	if len(request.Messages) > 0 && request.Messages[0].Role == consts.ROLE_SYSTEM {
+		request.Messages[0].Content = gconv.String(request.Messages[0].Content) + "\n\n" + prompt
+	} else {
+		request.Messages = append([]sdkm.ChatCompletionMessage{{
+			Role:    consts.ROLE_SYSTEM,
+			Content: prompt,
+		}}, request.Messages...)
+	}
+
+	return request, names
+}
+
+// 解析回答中的工具调用, 返回OpenAI格式的tool_calls
+func parseToolCalls(content string, names []string) []map[string]interface{} {
+
+	content = trimCodeFence(content)
+
+	start := gstr.Pos(content, "{")
+	end := gstr.PosR(content, "}")
+	if start == -1 || end < start {
+		return nil
+	}
+
+	j, err := gjson.DecodeToJson(content[start : end+1])
+	if err != nil {
+		return nil
+	}
+
+	calls := j.Get("tool_calls").Array()
+	if len(calls) == 0 && j.Contains("name") {
+		calls = append(calls, j.Map())
+	}
+
+	toolCalls := make([]map[string]interface{}, 0)
+	for _, value := range calls {
+
+		call := gjson.New(value)
+
+		name := call.Get("name").String()
+		if name == "" {
+			name = call.Get("function.name").String()
+		}
+
+		if !slices.Contains(names, name) {
+			return nil
+		}
+
+		arguments := call.Get("arguments")
+		if arguments.IsNil() {
+			arguments = call.Get("function.arguments")
+		}
+
+		args := "{}"
+		if !arguments.IsNil() {
+			if arguments.IsMap() || arguments.IsSlice() {
+				args = gjson.MustEncodeString(arguments.Val())
+			} else {
+				args = arguments.String()
+			}
+		}
+
+		toolCalls = append(toolCalls, map[string]interface{}{
+			"id":   "call_" + util.GenerateId(),
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":      name,
+				"arguments": args,
+			},
+		})
+	}
+
+	return toolCalls
+}
+
+// 将回答中的工具调用转换为tool_calls
+func toolCallsResponse(ctx context.Context, response *sdkm.ChatCompletionResponse, names []string, isFunctions bool) {
+
+	for i, choice := range response.Choices {
+
+		if choice.Message == nil {
+			continue
+		}
+
+		toolCalls := parseToolCalls(gconv.String(choice.Message.Content), names)
+		if len(toolCalls) == 0 {
+			continue
+		}
+
+		if isFunctions {
+
+			if err := gjson.Unmarshal(gjson.MustEncode(toolCalls[0]["function"]), &response.Choices[i].Message.FunctionCall); err != nil {
+				logger.Error(ctx, err)
+				continue
+			}
+
+			response.Choices[i].FinishReason = consts.FINISH_REASON_FUNCTION_CALL
+
+		} else {
+
+			if err := gjson.Unmarshal(gjson.MustEncode(toolCalls), &response.Choices[i].Message.ToolCalls); err != nil {
+				logger.Error(ctx, err)
+				continue
+			}
+
+			response.Choices[i].FinishReason = consts.FINISH_REASON_TOOL_CALLS
+		}
+
+		response.Choices[i].Message.Content = nil
+	}
+
+	response.ResponseBytes = nil
+}
+
+// 流式工具调用模拟, 以JSON开头的回答会被缓冲到结束后再解析
+type toolStream struct {
+	names       []string
+	isFunctions bool
+	isDecided   bool
+	isBuffering bool
+	content     string
+	id          string
+	created     int64
+	model       string
+}
+
+// 处理流式响应, 返回true表示已被缓冲, 无需下发
+func (t *toolStream) handle(response *sdkm.ChatCompletionResponse) bool {
+
+	if t.isDecided && !t.isBuffering {
+		return false
+	}
+
+	if t.id == "" {
+		data := gjson.New(response)
+		t.id = data.Get("id").String()
+		t.created = data.Get("created").Int64()
+	}
+
+	if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
+		t.content += response.Choices[0].Delta.Content
+	}
+
+	if t.isBuffering {
+		return true
+	}
+
+	content := gstr.TrimLeft(t.content)
+	if content == "" {
+		return true
+	}
+
+	t.isDecided = true
+
+	if gstr.HasPrefix(content, "{") || gstr.HasPrefix(content, "```") {
+		t.isBuffering = true
+		return true
+	}
+
+	// 非工具调用, 将已缓冲的内容合并到当前响应下发
+	if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
+		response.Choices[0].Delta.Content = t.content
+		response.ResponseBytes = nil
+	}
+
+	return false
+}
+
+// 结束时下发缓冲的内容
+func (t *toolStream) flush(ctx context.Context, usage *sdkm.Usage) error {
+
+	if t.isDecided && !t.isBuffering {
+		return nil
+	}
+
+	var (
+		delta        = map[string]interface{}{"role": consts.ROLE_ASSISTANT}
+		finishReason = consts.FINISH_REASON_STOP
+	)
+
+	if toolCalls := parseToolCalls(t.content, t.names); len(toolCalls) > 0 {
+
+		if t.isFunctions {
+			delta["function_call"] = toolCalls[0]["function"]
+			finishReason = consts.FINISH_REASON_FUNCTION_CALL
+		} else {
+			for i, toolCall := range toolCalls {
+				toolCall["index"] = i
+			}
+			delta["tool_calls"] = toolCalls
+			finishReason = consts.FINISH_REASON_TOOL_CALLS
+		}
+
+	} else {
+		delta["content"] = t.content
+	}
+
+	return sseChunks(ctx, t.id, t.created, t.model, delta, finishReason, usage)
+}
+
+// 去除Markdown代码块标记
+func trimCodeFence(content string) string {
+
+	content = gstr.Trim(content)
+
+	if gstr.HasPrefix(content, "```") {
+		content = gstr.TrimLeftStr(content, "```json")
+		content = gstr.TrimLeftStr(content, "```")
+		content = gstr.TrimRightStr(content, "```")
+	}
+
+	return gstr.Trim(content)
+}
+
+// 下发缓冲后的内容块和结束块
+func sseChunks(ctx context.Context, id string, created int64, model string, delta map[string]interface{}, finishReason string, usage *sdkm.Usage) error {
+
+	if err := util.SSEServer(ctx, gjson.MustEncodeString(newChunk(id, created, model, delta, nil, nil))); err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
+	if err := util.SSEServer(ctx, gjson.MustEncodeString(newChunk(id, created, model, map[string]interface{}{}, finishReason, usage))); err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
+	return nil
+}
+
+func newChunk(id string, created int64, model string, delta map[string]interface{}, finishReason interface{}, usage *sdkm.Usage) map[string]interface{} {
+
+	chunk := map[string]interface{}{
+		"id":      id,
+		"object":  "chat.completion.chunk",
+		"created": created,
+		"model":   model,
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"delta":         delta,
+			"finish_reason": finishReason,
+		}},
+	}
+
+	if usage != nil {
+		chunk["usage"] = usage
+	}
+
+	return chunk
+}
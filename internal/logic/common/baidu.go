@@ -9,6 +9,7 @@ import (
 	"github.com/iimeta/fastapi/internal/consts"
 	"github.com/iimeta/fastapi/internal/model"
 	"github.com/iimeta/fastapi/utility/cache"
+	"github.com/iimeta/fastapi/utility/crypto"
 	"github.com/iimeta/fastapi/utility/logger"
 	"github.com/iimeta/fastapi/utility/redis"
 	"github.com/iimeta/fastapi/utility/util"
@@ -16,34 +17,34 @@ import (
 	"time"
 )
 
-var baiduCache = cache.New() // [key]AccessToken
+var baiduCache = cache.New() // [SM3(key)]AccessToken
 
-func getBaiduToken(ctx context.Context, key, baseURL, proxyURL string) string {
+func getBaiduToken(ctx context.Context, key *model.Key, secret, baseURL, proxyURL string) string {
 
 	now := gtime.TimestampMilli()
 	defer func() {
 		logger.Debugf(ctx, "getBaiduToken time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	if accessTokenCacheValue := baiduCache.GetVal(ctx, fmt.Sprintf(consts.ACCESS_TOKEN_KEY, key)); accessTokenCacheValue != nil {
+	if accessTokenCacheValue := baiduCache.GetVal(ctx, fmt.Sprintf(consts.ACCESS_TOKEN_KEY, crypto.SM3(secret))); accessTokenCacheValue != nil {
 		return accessTokenCacheValue.(string)
 	}
 
-	reply, err := redis.GetStr(ctx, fmt.Sprintf(consts.ACCESS_TOKEN_KEY, key))
+	reply, err := redis.GetStr(ctx, fmt.Sprintf(consts.ACCESS_TOKEN_KEY, crypto.SM3(secret)))
 	if err == nil && reply != "" {
 
-		if expiresIn, err := redis.TTL(ctx, fmt.Sprintf(consts.ACCESS_TOKEN_KEY, key)); err != nil {
-			logger.Errorf(ctx, "getBaiduToken key: %s, error: %v", key, err)
+		if expiresIn, err := redis.TTL(ctx, fmt.Sprintf(consts.ACCESS_TOKEN_KEY, crypto.SM3(secret))); err != nil {
+			logger.Errorf(ctx, "getBaiduToken key id: %s, error: %v", key.Id, err)
 		} else {
-			if err = baiduCache.Set(ctx, fmt.Sprintf(consts.ACCESS_TOKEN_KEY, key), reply, time.Second*time.Duration(expiresIn-60)); err != nil {
-				logger.Errorf(ctx, "getBaiduToken key: %s, error: %v", key, err)
+			if err = baiduCache.Set(ctx, fmt.Sprintf(consts.ACCESS_TOKEN_KEY, crypto.SM3(secret)), reply, time.Second*time.Duration(expiresIn-60)); err != nil {
+				logger.Errorf(ctx, "getBaiduToken key id: %s, error: %v", key.Id, err)
 			}
 		}
 
 		return reply
 	}
 
-	result := gstr.Split(key, "|")
+	result := gstr.Split(secret, "|")
 
 	data := g.Map{
 		"client_id":     result[0],
@@ -61,21 +62,21 @@ func getBaiduToken(ctx context.Context, key, baseURL, proxyURL string) string {
 
 	getBaiduTokenRes := new(model.GetBaiduTokenRes)
 	if err = util.HttpPost(ctx, url, nil, data, &getBaiduTokenRes, proxyURL); err != nil {
-		logger.Errorf(ctx, "getBaiduToken key: %s, error: %v", key, err)
+		logger.Errorf(ctx, "getBaiduToken key id: %s, error: %v", key.Id, err)
 		return ""
 	}
 
 	if getBaiduTokenRes.Error != "" {
-		logger.Errorf(ctx, "getBaiduToken key: %s, getBaiduTokenRes.Error: %s", key, getBaiduTokenRes.Error)
+		logger.Errorf(ctx, "getBaiduToken key id: %s, getBaiduTokenRes.Error: %s", key.Id, getBaiduTokenRes.Error)
 		return ""
 	}
 
-	if err = baiduCache.Set(ctx, fmt.Sprintf(consts.ACCESS_TOKEN_KEY, key), getBaiduTokenRes.AccessToken, time.Second*time.Duration(getBaiduTokenRes.ExpiresIn-60)); err != nil {
-		logger.Errorf(ctx, "getBaiduToken key: %s, error: %v", key, err)
+	if err = baiduCache.Set(ctx, fmt.Sprintf(consts.ACCESS_TOKEN_KEY, crypto.SM3(secret)), getBaiduTokenRes.AccessToken, time.Second*time.Duration(getBaiduTokenRes.ExpiresIn-60)); err != nil {
+		logger.Errorf(ctx, "getBaiduToken key id: %s, error: %v", key.Id, err)
 	}
 
-	if err = redis.SetEX(ctx, fmt.Sprintf(consts.ACCESS_TOKEN_KEY, key), getBaiduTokenRes.AccessToken, getBaiduTokenRes.ExpiresIn-60); err != nil {
-		logger.Errorf(ctx, "getBaiduToken key: %s, error: %v", key, err)
+	if err = redis.SetEX(ctx, fmt.Sprintf(consts.ACCESS_TOKEN_KEY, crypto.SM3(secret)), getBaiduTokenRes.AccessToken, getBaiduTokenRes.ExpiresIn-60); err != nil {
+		logger.Errorf(ctx, "getBaiduToken key id: %s, error: %v", key.Id, err)
 	}
 
 	return getBaiduTokenRes.AccessToken
@@ -0,0 +1,126 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/internal/model"
+	mcommon "github.com/iimeta/fastapi/internal/model/common"
+	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/logger"
+	"github.com/iimeta/fastapi/utility/redis"
+	"time"
+)
+
+type budgetTarget struct {
+	name    string
+	field   string
+	budgets []mcommon.Budget
+}
+
+// 获取周期标识和计数器过期时间, 按进程时区(Asia/Shanghai)的自然日、自然周和自然月重置
+func budgetPeriod(period string) (string, time.Duration) {
+
+	now := gtime.Now()
+
+	switch period {
+	case consts.BUDGET_PERIOD_WEEK:
+		year, week := now.ISOWeek()
+		return fmt.Sprintf("week:%dW%02d", year, week), 8 * 24 * time.Hour
+	case consts.BUDGET_PERIOD_MONTH:
+		return "month:" + now.Format("Ym"), 32 * 24 * time.Hour
+	}
+
+	return "day:" + now.Format("Ymd"), 2 * 24 * time.Hour
+}
+
+func budgetTargets(user *model.User, app *model.App, key *model.Key) []budgetTarget {
+
+	targets := make([]budgetTarget, 0)
+
+	if user != nil && len(user.Budgets) > 0 {
+		targets = append(targets, budgetTarget{"user", consts.USER_USAGE_FIELD, user.Budgets})
+	}
+
+	if app != nil && len(app.Budgets) > 0 {
+		targets = append(targets, budgetTarget{"app", fmt.Sprintf(consts.APP_USAGE_FIELD, app.AppId), app.Budgets})
+	}
+
+	if key != nil && len(key.Budgets) > 0 {
+		targets = append(targets, budgetTarget{"key", fmt.Sprintf(consts.KEY_USAGE_FIELD, key.AppId, key.KeyHash), key.Budgets})
+	}
+
+	return targets
+}
+
+// 核验用户、应用和密钥的周期预算, 达到硬限制拒绝请求, 达到软限制告警
+func CheckBudgets(ctx context.Context, user *model.User, app *model.App, key *model.Key) error {
+
+	for _, target := range budgetTargets(user, app, key) {
+		for _, budget := range target.budgets {
+
+			if budget.Limit <= 0 && budget.SoftLimit <= 0 {
+				continue
+			}
+
+			period, _ := budgetPeriod(budget.Period)
+
+			// Redis不可用时跳过检查, 不拒绝请求
+			usage, err := redis.HGetInt(ctx, fmt.Sprintf(consts.API_PERIOD_USAGE_KEY, user.UserId, period), target.field)
+			if err != nil {
+				logger.Warningf(ctx, "CheckBudgets %s period: %s, skipped, error: %v", target.name, period, err)
+				service.Health().RecordFailOpen("budget")
+				continue
+			}
+
+			if budget.Limit > 0 && usage >= budget.Limit {
+				err = errors.ERR_BUDGET_EXCEEDED
+				logger.Errorf(ctx, "CheckBudgets %s period: %s, usage: %d, limit: %d, error: %v", target.name, period, usage, budget.Limit, err)
+				return err
+			}
+
+			if budget.SoftLimit > 0 && usage >= budget.SoftLimit {
+
+				logger.Infof(ctx, "CheckBudgets %s period: %s, usage: %d, soft limit: %d, exceeded soft limit", target.name, period, usage, budget.SoftLimit)
+
+				if r := g.RequestFromCtx(ctx); r != nil {
+					r.Response.Header().Add("X-Budget-Warning", fmt.Sprintf("%s %s usage %d exceeded soft limit %d", target.name, period, usage, budget.SoftLimit))
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// 记录周期预算用量
+func RecordBudgetUsage(ctx context.Context, totalTokens int) {
+
+	userId := service.Session().GetUserId(ctx)
+
+	for _, target := range budgetTargets(service.Session().GetUser(ctx), service.Session().GetApp(ctx), service.Session().GetKey(ctx)) {
+
+		periods := make(map[string]time.Duration)
+		for _, budget := range target.budgets {
+			period, ttl := budgetPeriod(budget.Period)
+			periods[period] = ttl
+		}
+
+		for period, ttl := range periods {
+
+			usageKey := fmt.Sprintf(consts.API_PERIOD_USAGE_KEY, userId, period)
+
+			if _, err := redis.HIncrBy(ctx, usageKey, target.field, int64(totalTokens)); err != nil {
+				logger.Error(ctx, err)
+				continue
+			}
+
+			if _, err := redis.Expire(ctx, usageKey, int64(ttl.Seconds())); err != nil {
+				logger.Error(ctx, err)
+			}
+		}
+	}
+}
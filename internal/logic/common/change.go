@@ -0,0 +1,73 @@
+package common
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/model"
+	"github.com/iimeta/fastapi/internal/model/entity"
+	"github.com/iimeta/fastapi/utility/crypto"
+	"github.com/iimeta/fastapi/utility/logger"
+	"github.com/iimeta/fastapi/utility/redis"
+)
+
+// 原子地递增版本号并写入变更流
+const publishChangeScript = `
+local version = redis.call('INCR', KEYS[2])
+redis.call('XADD', KEYS[1], 'MAXLEN', '~', ARGV[1], '*', 'version', version, 'channel', ARGV[2], 'payload', ARGV[3])
+return version
+`
+
+// 发布变更通知, 写入变更流后再发布到频道, 兼容只订阅频道的实例
+func PublishChange(ctx context.Context, channel string, message model.PubMessage) error {
+
+	maxLen := config.Cfg.Core.ChangeStream.MaxLen
+	if maxLen <= 0 {
+		maxLen = 100000
+	}
+
+	message.OldData = redactChange(message.OldData)
+	message.NewData = redactChange(message.NewData)
+
+	payload, err := gjson.Marshal(message)
+	if err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
+	reply, err := redis.Eval(ctx, publishChangeScript, []string{consts.CHANGE_STREAM_KEY, consts.CHANGE_VERSION_KEY}, maxLen, channel, payload)
+	if err != nil {
+		logger.Errorf(ctx, "PublishChange channel: %s, error: %v", channel, err)
+		return err
+	}
+
+	message.Version = gconv.Int64(reply)
+
+	if _, err = redis.Publish(ctx, channel, message); err != nil {
+		logger.Errorf(ctx, "PublishChange channel: %s, version: %d, error: %v", channel, message.Version, err)
+		return err
+	}
+
+	return nil
+}
+
+// 脱敏变更数据中的密钥, 变更流会持久化在Redis中, 应用密钥保留哈希, 模型密钥由订阅方按ID重新读取
+func redactChange(data any) any {
+
+	key, ok := data.(*entity.Key)
+	if !ok || key == nil || key.Key == "" || crypto.IsKeyPrefix(key.Key) {
+		return data
+	}
+
+	redacted := *key
+
+	if redacted.Type == 1 && redacted.KeyHash == "" {
+		redacted.KeyHash = crypto.HashKey(key.Key)
+	}
+
+	redacted.Key = crypto.KeyPrefix(key.Key)
+
+	return &redacted
+}
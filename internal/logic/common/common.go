@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/os/gctx"
-	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/text/gregex"
 	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/gogf/gf/v2/util/gconv"
@@ -15,6 +14,7 @@ import (
 	"github.com/iimeta/fastapi/internal/errors"
 	"github.com/iimeta/fastapi/internal/model"
 	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/graceful"
 	"github.com/iimeta/fastapi/utility/logger"
 	"net"
 	"strings"
@@ -65,7 +65,7 @@ func (s *sCommon) RecordError(ctx context.Context, model *model.Model, key *mode
 		service.Session().RecordErrorKey(ctx, key.Id)
 	}
 
-	if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+	if err := graceful.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
 		if model.IsEnableModelAgent {
 			service.ModelAgent().RecordErrorModelAgentKey(ctx, modelAgent, key)
 			service.ModelAgent().RecordErrorModelAgent(ctx, model, modelAgent)
@@ -83,7 +83,8 @@ func IsAborted(err error) bool {
 		gstr.Contains(err.Error(), "aborted")
 }
 
-func IsNeedRetry(err error) (isRetry bool, isDisabled bool) {
+// 是否需要重试, 先按重试策略的状态码和错误码判断, 再按错误信息匹配
+func IsNeedRetry(ctx context.Context, mak *MAK, err error) (isRetry bool, isDisabled bool) {
 
 	if IsAborted(err) {
 		return false, false
@@ -96,6 +97,10 @@ func IsNeedRetry(err error) (isRetry bool, isDisabled bool) {
 		}
 	}
 
+	if isRetry, ok := IsRetryStatus(ctx, mak, err); ok {
+		return isRetry, false
+	}
+
 	// 不重试错误
 	for _, notRetryError := range config.Cfg.Error.NotRetry {
 		if gstr.Contains(err.Error(), notRetryError) {
@@ -0,0 +1,116 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/internal/model"
+	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/logger"
+	"github.com/iimeta/fastapi/utility/redis"
+	"time"
+)
+
+// 获取终端用户预算, 优先级: 应用 > 默认
+func GetEndUserBudget(appId int) config.EndUserBudget {
+
+	if budget, ok := config.Cfg.EndUser.Apps[appId]; ok {
+		return budget
+	}
+
+	return config.Cfg.EndUser.Default
+}
+
+// 核验终端用户预算
+func CheckEndUserBudget(ctx context.Context) error {
+
+	endUser := service.Session().GetEndUser(ctx)
+	if endUser == "" {
+		return nil
+	}
+
+	appId := service.Session().GetAppId(ctx)
+	budget := GetEndUserBudget(appId)
+
+	for _, window := range []struct {
+		period string
+		budget int
+	}{
+		{gtime.Now().Format("Ymd"), budget.Daily},
+		{gtime.Now().Format("Ym"), budget.Monthly},
+	} {
+
+		if window.budget <= 0 {
+			continue
+		}
+
+		// Redis不可用时跳过检查, 不拒绝请求
+		usage, err := redis.HGetInt(ctx, fmt.Sprintf(consts.API_END_USER_USAGE_KEY, appId, window.period), endUser)
+		if err != nil {
+			logger.Warningf(ctx, "CheckEndUserBudget appId: %d, endUser: %s, period: %s, skipped, error: %v", appId, endUser, window.period, err)
+			service.Health().RecordFailOpen("end_user_budget")
+			continue
+		}
+
+		if usage >= window.budget {
+			err = errors.ERR_END_USER_BUDGET_EXCEEDED
+			logger.Errorf(ctx, "CheckEndUserBudget appId: %d, endUser: %s, period: %s, usage: %d, budget: %d, error: %v", appId, endUser, window.period, usage, window.budget, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 记录终端用户用量, 每日和每月用量记录在应用下, 到期后自动删除
+func RecordEndUserUsage(ctx context.Context, appId int, endUser string, totalTokens int) {
+
+	for _, window := range []struct {
+		period string
+		ttl    time.Duration
+	}{
+		{gtime.Now().Format("Ymd"), 2 * 24 * time.Hour},
+		{gtime.Now().Format("Ym"), 32 * 24 * time.Hour},
+	} {
+
+		key := fmt.Sprintf(consts.API_END_USER_USAGE_KEY, appId, window.period)
+
+		if _, err := redis.HIncrBy(ctx, key, endUser, int64(totalTokens)); err != nil {
+			logger.Error(ctx, err)
+			continue
+		}
+
+		if _, err := redis.Expire(ctx, key, int64(window.ttl.Seconds())); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
+}
+
+// 获取终端用户用量
+func GetEndUserUsage(ctx context.Context, appId int, endUser string) (*model.EndUserUsage, error) {
+
+	budget := GetEndUserBudget(appId)
+
+	usage := &model.EndUserUsage{
+		EndUser:       endUser,
+		DailyBudget:   budget.Daily,
+		MonthlyBudget: budget.Monthly,
+	}
+
+	var err error
+
+	if usage.DailyUsage, err = redis.HGetInt(ctx, fmt.Sprintf(consts.API_END_USER_USAGE_KEY, appId, gtime.Now().Format("Ymd")), endUser); err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	if usage.MonthlyUsage, err = redis.HGetInt(ctx, fmt.Sprintf(consts.API_END_USER_USAGE_KEY, appId, gtime.Now().Format("Ym")), endUser); err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	return usage, nil
+}
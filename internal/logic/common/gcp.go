@@ -8,7 +8,6 @@ import (
 	"github.com/gogf/gf/v2/encoding/gjson"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/os/gctx"
-	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/iimeta/fastapi/internal/config"
@@ -17,6 +16,7 @@ import (
 	"github.com/iimeta/fastapi/internal/service"
 	"github.com/iimeta/fastapi/utility/cache"
 	"github.com/iimeta/fastapi/utility/crypto"
+	"github.com/iimeta/fastapi/utility/graceful"
 	"github.com/iimeta/fastapi/utility/logger"
 	"github.com/iimeta/fastapi/utility/redis"
 	"github.com/iimeta/fastapi/utility/util"
@@ -33,18 +33,18 @@ func getGcpToken(ctx context.Context, key *model.Key, proxyURL string) string {
 		logger.Debugf(ctx, "getGcpToken time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	if gcpTokenCacheValue := gcpCache.GetVal(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, key.Key)); gcpTokenCacheValue != nil {
+	if gcpTokenCacheValue := gcpCache.GetVal(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(key.Key))); gcpTokenCacheValue != nil {
 		return gcpTokenCacheValue.(string)
 	}
 
-	reply, err := redis.GetStr(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, key.Key))
+	reply, err := redis.GetStr(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(key.Key)))
 	if err == nil && reply != "" {
 
-		if expiresIn, err := redis.TTL(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, key.Key)); err != nil {
-			logger.Errorf(ctx, "getGcpToken key: %s, error: %v", key.Key, err)
+		if expiresIn, err := redis.TTL(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(key.Key))); err != nil {
+			logger.Errorf(ctx, "getGcpToken key id: %s, error: %v", key.Id, err)
 		} else {
-			if err = gcpCache.Set(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, key.Key), reply, time.Second*time.Duration(expiresIn-60)); err != nil {
-				logger.Errorf(ctx, "getGcpToken key: %s, error: %v", key.Key, err)
+			if err = gcpCache.Set(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(key.Key)), reply, time.Second*time.Duration(expiresIn-60)); err != nil {
+				logger.Errorf(ctx, "getGcpToken key id: %s, error: %v", key.Id, err)
 			}
 		}
 
@@ -62,13 +62,13 @@ func getGcpToken(ctx context.Context, key *model.Key, proxyURL string) string {
 
 	getGcpTokenRes := new(model.GetGcpTokenRes)
 	if err = util.HttpPost(ctx, config.Cfg.Gcp.GetTokenUrl, nil, data, &getGcpTokenRes, proxyURL); err != nil {
-		logger.Errorf(ctx, "getGcpToken key: %s, error: %v", key.Key, err)
+		logger.Errorf(ctx, "getGcpToken key id: %s, error: %v", key.Id, err)
 		return ""
 	}
 
 	if getGcpTokenRes.Error != "" {
-		logger.Errorf(ctx, "getGcpToken key: %s, getGcpTokenRes.Error: %s", key.Key, getGcpTokenRes.Error)
-		if err = grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+		logger.Errorf(ctx, "getGcpToken key id: %s, getGcpTokenRes.Error: %s", key.Id, getGcpTokenRes.Error)
+		if err = graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
 			service.Key().DisabledModelKey(ctx, key, getGcpTokenRes.Error)
 		}); err != nil {
 			logger.Error(ctx, err)
@@ -76,12 +76,12 @@ func getGcpToken(ctx context.Context, key *model.Key, proxyURL string) string {
 		return ""
 	}
 
-	if err = gcpCache.Set(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, key.Key), getGcpTokenRes.AccessToken, time.Second*time.Duration(getGcpTokenRes.ExpiresIn-60)); err != nil {
-		logger.Errorf(ctx, "getGcpToken key: %s, error: %v", key.Key, err)
+	if err = gcpCache.Set(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(key.Key)), getGcpTokenRes.AccessToken, time.Second*time.Duration(getGcpTokenRes.ExpiresIn-60)); err != nil {
+		logger.Errorf(ctx, "getGcpToken key id: %s, error: %v", key.Id, err)
 	}
 
-	if err = redis.SetEX(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, key.Key), getGcpTokenRes.AccessToken, getGcpTokenRes.ExpiresIn-60); err != nil {
-		logger.Errorf(ctx, "getGcpToken key: %s, error: %v", key.Key, err)
+	if err = redis.SetEX(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(key.Key)), getGcpTokenRes.AccessToken, getGcpTokenRes.ExpiresIn-60); err != nil {
+		logger.Errorf(ctx, "getGcpToken key id: %s, error: %v", key.Id, err)
 	}
 
 	return getGcpTokenRes.AccessToken
@@ -101,7 +101,7 @@ type ApplicationDefaultCredentials struct {
 	UniverseDomain          string `json:"universe_domain"`
 }
 
-func getGcpTokenNew(ctx context.Context, key *model.Key, proxyURL string) (string, string, error) {
+func getGcpTokenNew(ctx context.Context, key *model.Key, secret, proxyURL string) (string, string, error) {
 
 	now := gtime.TimestampMilli()
 	defer func() {
@@ -109,32 +109,32 @@ func getGcpTokenNew(ctx context.Context, key *model.Key, proxyURL string) (strin
 	}()
 
 	adc := &ApplicationDefaultCredentials{}
-	if err := gjson.Unmarshal([]byte(key.Key), adc); err != nil {
-		logger.Errorf(ctx, "getGcpTokenNew gjson.Unmarshal key: %s, error: %v", key.Key, err)
+	if err := gjson.Unmarshal([]byte(secret), adc); err != nil {
+		logger.Errorf(ctx, "getGcpTokenNew gjson.Unmarshal key id: %s, error: %v", key.Id, err)
 		return "", "", err
 	}
 
-	if gcpTokenCacheValue := gcpCache.GetVal(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(key.Key))); gcpTokenCacheValue != nil {
+	if gcpTokenCacheValue := gcpCache.GetVal(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(secret))); gcpTokenCacheValue != nil {
 		return adc.ProjectId, gcpTokenCacheValue.(string), nil
 	}
 
-	reply, err := redis.GetStr(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(key.Key)))
+	reply, err := redis.GetStr(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(secret)))
 	if err == nil && reply != "" {
 
-		if expiresIn, err := redis.TTL(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(key.Key))); err != nil {
-			logger.Errorf(ctx, "getGcpTokenNew key: %s, error: %v", key.Key, err)
+		if expiresIn, err := redis.TTL(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(secret))); err != nil {
+			logger.Errorf(ctx, "getGcpTokenNew key id: %s, error: %v", key.Id, err)
 		} else {
-			if err = gcpCache.Set(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(key.Key)), reply, time.Second*time.Duration(expiresIn-60)); err != nil {
-				logger.Errorf(ctx, "getGcpTokenNew key: %s, error: %v", key.Key, err)
+			if err = gcpCache.Set(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(secret)), reply, time.Second*time.Duration(expiresIn-60)); err != nil {
+				logger.Errorf(ctx, "getGcpTokenNew key id: %s, error: %v", key.Id, err)
 			}
 		}
 
 		return adc.ProjectId, reply, nil
 	}
 
-	client, err := credentials.NewIamCredentialsClient(ctx, option.WithCredentialsJSON([]byte(key.Key)))
+	client, err := credentials.NewIamCredentialsClient(ctx, option.WithCredentialsJSON([]byte(secret)))
 	if err != nil {
-		logger.Errorf(ctx, "getGcpTokenNew NewIamCredentialsClient key: %s, error: %v", key.Key, err)
+		logger.Errorf(ctx, "getGcpTokenNew NewIamCredentialsClient key id: %s, error: %v", key.Id, err)
 		return "", "", err
 	}
 
@@ -151,10 +151,10 @@ func getGcpTokenNew(ctx context.Context, key *model.Key, proxyURL string) (strin
 
 	response, err := client.GenerateAccessToken(ctx, request)
 	if err != nil {
-		logger.Errorf(ctx, "getGcpTokenNew GenerateAccessToken key: %s, error: %v", key.Key, err)
+		logger.Errorf(ctx, "getGcpTokenNew GenerateAccessToken key id: %s, error: %v", key.Id, err)
 		for _, autoDisabledError := range config.Cfg.Error.AutoDisabled {
 			if gstr.Contains(err.Error(), autoDisabledError) {
-				if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+				if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
 					service.Key().DisabledModelKey(ctx, key, err.Error())
 				}); err != nil {
 					logger.Error(ctx, err)
@@ -165,12 +165,12 @@ func getGcpTokenNew(ctx context.Context, key *model.Key, proxyURL string) (strin
 		return "", "", err
 	}
 
-	if err = gcpCache.Set(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(key.Key)), response.AccessToken, time.Minute*50); err != nil {
-		logger.Errorf(ctx, "getGcpTokenNew key: %s, error: %v", key.Key, err)
+	if err = gcpCache.Set(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(secret)), response.AccessToken, time.Minute*50); err != nil {
+		logger.Errorf(ctx, "getGcpTokenNew key id: %s, error: %v", key.Id, err)
 	}
 
-	if err = redis.SetEX(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(key.Key)), response.AccessToken, 60*50); err != nil {
-		logger.Errorf(ctx, "getGcpTokenNew key: %s, error: %v", key.Key, err)
+	if err = redis.SetEX(ctx, fmt.Sprintf(consts.GCP_TOKEN_KEY, crypto.SM3(secret)), response.AccessToken, 60*50); err != nil {
+		logger.Errorf(ctx, "getGcpTokenNew key id: %s, error: %v", key.Id, err)
 	}
 
 	return adc.ProjectId, response.AccessToken, nil
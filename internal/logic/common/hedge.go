@@ -0,0 +1,50 @@
+package common
+
+import (
+	"context"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/utility/logger"
+	"time"
+)
+
+// 获取模型的对冲请求首字节超时阈值, 0表示不对冲
+func GetHedgeThreshold(model string) time.Duration {
+
+	if threshold, ok := config.Cfg.Hedge.Thresholds[model]; ok && threshold > 0 {
+		return time.Duration(threshold) * time.Millisecond
+	}
+
+	return 0
+}
+
+// 获取对冲请求的MAK, 重新选择与当前不同的密钥或模型代理
+func (mak *MAK) HedgeMAK(ctx context.Context) (*MAK, error) {
+
+	total := mak.KeyTotal
+	if mak.RealModel.IsEnableModelAgent {
+		total = mak.AgentTotal * mak.KeyTotal
+	}
+
+	for i := 0; i < total; i++ {
+
+		hedge := &MAK{
+			Model:              mak.Model,
+			Messages:           mak.Messages,
+			ReqModel:           mak.ReqModel,
+			FallbackModelAgent: mak.FallbackModelAgent,
+			FallbackModel:      mak.FallbackModel,
+		}
+
+		if err := hedge.InitMAK(ctx); err != nil {
+			logger.Error(ctx, err)
+			return nil, err
+		}
+
+		if hedge.Key.Id != mak.Key.Id {
+			return hedge, nil
+		}
+	}
+
+	return nil, errors.ERR_NO_AVAILABLE_KEY
+}
@@ -4,13 +4,13 @@ import (
 	"context"
 	"fmt"
 	"github.com/gogf/gf/v2/os/gctx"
-	"github.com/gogf/gf/v2/os/grpool"
 	sdkm "github.com/iimeta/fastapi-sdk/model"
 	"github.com/iimeta/fastapi/internal/config"
 	"github.com/iimeta/fastapi/internal/consts"
 	"github.com/iimeta/fastapi/internal/errors"
 	"github.com/iimeta/fastapi/internal/model"
 	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/graceful"
 	"github.com/iimeta/fastapi/utility/logger"
 )
 
@@ -155,10 +155,10 @@ func (mak *MAK) InitMAK(ctx context.Context, retry ...int) (err error) {
 		// 记录错误次数和禁用
 		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
 
-		isRetry, isDisabled := IsNeedRetry(err)
+		isRetry, isDisabled := IsNeedRetry(ctx, mak, err)
 
 		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
 				if mak.RealModel.IsEnableModelAgent {
 					service.ModelAgent().DisabledModelAgentKey(ctx, mak.Key, err.Error())
 				} else {
@@ -184,9 +184,16 @@ func (mak *MAK) InitMAK(ctx context.Context, retry ...int) (err error) {
 
 func getRealKey(ctx context.Context, mak *MAK) error {
 
+	// 解密上游密钥
+	secret, err := DecryptKey(mak.Key.Key)
+	if err != nil {
+		logger.Errorf(ctx, "getRealKey key id: %s, DecryptKey error: %v", mak.Key.Id, err)
+		return err
+	}
+
 	if GetCorpCode(ctx, mak.RealModel.Corp) == consts.CORP_GCP_CLAUDE {
 
-		projectId, key, err := getGcpTokenNew(ctx, mak.Key, config.Cfg.Http.ProxyUrl)
+		projectId, key, err := getGcpTokenNew(ctx, mak.Key, secret, config.Cfg.Http.ProxyUrl)
 		if err != nil {
 			logger.Error(ctx, err)
 			return err
@@ -196,9 +203,9 @@ func getRealKey(ctx context.Context, mak *MAK) error {
 		mak.Path = fmt.Sprintf(mak.Path, projectId, mak.RealModel.Model)
 
 	} else if GetCorpCode(ctx, mak.RealModel.Corp) == consts.CORP_BAIDU {
-		mak.RealKey = getBaiduToken(ctx, mak.Key.Key, mak.BaseUrl, config.Cfg.Http.ProxyUrl)
+		mak.RealKey = getBaiduToken(ctx, mak.Key, secret, mak.BaseUrl, config.Cfg.Http.ProxyUrl)
 	} else {
-		mak.RealKey = mak.Key.Key
+		mak.RealKey = secret
 	}
 
 	return nil
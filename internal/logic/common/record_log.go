@@ -0,0 +1,31 @@
+package common
+
+import (
+	"context"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/service"
+	"slices"
+)
+
+// 是否记录日志内容, 应用配置了日志策略时使用应用的配置, 保留天数为-1的内容不记录
+func IsRecordLog(ctx context.Context, field string) bool {
+
+	recordLogs := config.Cfg.RecordLogs
+	if app := service.Session().GetApp(ctx); app != nil && app.LogPolicy != nil {
+		recordLogs = app.LogPolicy.RecordLogs
+	}
+
+	return slices.Contains(recordLogs, field) && GetLogRetention(ctx, field) != -1
+}
+
+// 日志内容保留天数, 0为永久保留, -1为不记录
+func GetLogRetention(ctx context.Context, field string) int {
+
+	if app := service.Session().GetApp(ctx); app != nil && app.LogPolicy != nil {
+		if days, ok := app.LogPolicy.Retention[field]; ok {
+			return days
+		}
+	}
+
+	return config.Cfg.Retention.Fields[field]
+}
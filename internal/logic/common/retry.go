@@ -0,0 +1,178 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/text/gregex"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/gogf/gf/v2/util/grand"
+	"github.com/iimeta/fastapi-sdk/sdkerr"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/errors"
+	mcommon "github.com/iimeta/fastapi/internal/model/common"
+	"github.com/iimeta/fastapi/utility/logger"
+	"math"
+	"slices"
+	"time"
+)
+
+// 获取重试策略, 优先级: 模型 > 公司 > 默认
+func GetRetryRule(ctx context.Context, mak *MAK) config.RetryRule {
+
+	if mak.RealModel != nil {
+		if rule, ok := config.Cfg.RetryPolicy.Models[mak.RealModel.Model]; ok {
+			return rule
+		}
+	}
+
+	if rule, ok := config.Cfg.RetryPolicy.Corps[GetCorpCode(ctx, mak.Corp)]; ok {
+		return rule
+	}
+
+	return config.Cfg.RetryPolicy.Default
+}
+
+// 获取上游错误的HTTP状态码和错误码
+func GetErrorStatusCode(err error) (int, string) {
+
+	apiError := &sdkerr.ApiError{}
+	if errors.As(err, &apiError) {
+		return apiError.HttpStatusCode, gconv.String(apiError.Code)
+	}
+
+	if match, _ := gregex.MatchString(`status code: (\d+)`, err.Error()); len(match) > 1 {
+		return gconv.Int(match[1]), ""
+	}
+
+	return 0, ""
+}
+
+// 获取上游要求的重试等待时间, SDK未透传响应头, 从错误信息中解析
+func GetRetryAfter(err error) time.Duration {
+
+	match, _ := gregex.MatchString(`(?i)(?:retry[- ]after|try again in|retry in)\D{0,3}(\d+(?:\.\d+)?)\s*(ms|milliseconds?|s|secs?|seconds?|m|mins?|minutes?)?`, err.Error())
+	if len(match) < 2 {
+		return 0
+	}
+
+	value := gconv.Float64(match[1])
+
+	switch match[2] {
+	case "ms", "millisecond", "milliseconds":
+		return time.Duration(value * float64(time.Millisecond))
+	case "m", "min", "mins", "minute", "minutes":
+		return time.Duration(value * float64(time.Minute))
+	}
+
+	return time.Duration(value * float64(time.Second))
+}
+
+// 按重试策略的状态码和错误码判断是否重试, 可重试的状态码优先, 无法判断时ok为false
+func IsRetryStatus(ctx context.Context, mak *MAK, err error) (isRetry bool, ok bool) {
+
+	rule := GetRetryRule(ctx, mak)
+
+	status, code := GetErrorStatusCode(err)
+
+	if status != 0 {
+
+		if len(rule.RetryStatus) > 0 {
+			if slices.Contains(rule.RetryStatus, status) {
+				return true, true
+			}
+		} else if status == 429 || status >= 500 {
+			return true, true
+		}
+
+		if slices.Contains(rule.NotRetryStatus, status) {
+			return false, true
+		}
+	}
+
+	if code != "" && slices.Contains(rule.NotRetryCodes, code) {
+		return false, true
+	}
+
+	return false, false
+}
+
+// 重试退避, 按重试策略分类错误并等待退避时间, 决策过程记录到retryInfo, 返回是否继续重试
+func RetryBackoff(ctx context.Context, mak *MAK, err error, retryInfo *mcommon.Retry) bool {
+
+	rule := GetRetryRule(ctx, mak)
+
+	retryInfo.Status, retryInfo.Code = GetErrorStatusCode(err)
+
+	if isRetry, ok := IsRetryStatus(ctx, mak, err); ok && !isRetry {
+		retryInfo.Decision = fmt.Sprintf("status=%d code=%s not retry", retryInfo.Status, retryInfo.Code)
+		logger.Infof(ctx, "RetryBackoff decision: %s", retryInfo.Decision)
+		return false
+	}
+
+	var (
+		delay      time.Duration
+		retryAfter = GetRetryAfter(err)
+	)
+
+	// 指数退避
+	if rule.BaseDelay > 0 {
+
+		multiplier := rule.Multiplier
+		if multiplier <= 1 {
+			multiplier = 2
+		}
+
+		delay = time.Duration(float64(rule.BaseDelay)*math.Pow(multiplier, float64(retryInfo.RetryCount))) * time.Millisecond
+
+		if rule.MaxDelay > 0 && delay > time.Duration(rule.MaxDelay)*time.Millisecond {
+			delay = time.Duration(rule.MaxDelay) * time.Millisecond
+		}
+
+		// 抖动, 在[delay*(1-jitter), delay]之间随机
+		if rule.Jitter > 0 && rule.Jitter <= 1 && delay > 0 {
+			delay -= time.Duration(float64(delay) * rule.Jitter * float64(grand.Intn(1000)) / 1000)
+		}
+	}
+
+	retryInfo.Decision = fmt.Sprintf("status=%d code=%s backoff=%dms", retryInfo.Status, retryInfo.Code, delay.Milliseconds())
+
+	if retryAfter > delay {
+		delay = retryAfter
+		retryInfo.Decision += fmt.Sprintf(" retry_after=%dms", retryAfter.Milliseconds())
+	}
+
+	// 总重试时间不能超过客户端截止时间
+	deadline, ok := ctx.Deadline()
+	if r := g.RequestFromCtx(ctx); r != nil && rule.MaxElapsed > 0 {
+		if maxDeadline := time.UnixMilli(r.EnterTime.TimestampMilli()).Add(time.Duration(rule.MaxElapsed) * time.Millisecond); !ok || maxDeadline.Before(deadline) {
+			deadline, ok = maxDeadline, true
+		}
+	}
+
+	if ok && time.Now().Add(delay).After(deadline) {
+		retryInfo.Decision += " exceeded deadline, not retry"
+		logger.Infof(ctx, "RetryBackoff decision: %s", retryInfo.Decision)
+		return false
+	}
+
+	retryInfo.Delay = delay.Milliseconds()
+	retryInfo.Decision += fmt.Sprintf(" wait=%dms", retryInfo.Delay)
+
+	logger.Infof(ctx, "RetryBackoff decision: %s", retryInfo.Decision)
+
+	if delay > 0 {
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			retryInfo.Decision += " canceled"
+			return false
+		}
+	}
+
+	return true
+}
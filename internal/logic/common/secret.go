@@ -0,0 +1,164 @@
+package common
+
+import (
+	"encoding/base64"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/gogf/gf/v2/text/gstr"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/internal/model"
+	"github.com/iimeta/fastapi/utility/crypto"
+	"time"
+)
+
+// 获取当前主密钥, 未配置时返回nil
+func GetMasterKey() ([]byte, error) {
+
+	masterKey := config.Cfg.Secret.MasterKey
+	if config.Cfg.Secret.MasterKeyFile != "" {
+		masterKey = gfile.GetContentsWithCache(config.Cfg.Secret.MasterKeyFile, time.Minute)
+	}
+
+	return decodeMasterKey(masterKey)
+}
+
+// 获取旧主密钥列表
+func GetOldMasterKeys() ([][]byte, error) {
+
+	masterKeys := make([][]byte, 0)
+
+	for _, oldMasterKey := range config.Cfg.Secret.OldMasterKeys {
+
+		masterKey, err := decodeMasterKey(oldMasterKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if masterKey != nil {
+			masterKeys = append(masterKeys, masterKey)
+		}
+	}
+
+	return masterKeys, nil
+}
+
+func decodeMasterKey(masterKey string) ([]byte, error) {
+
+	if masterKey = gstr.Trim(masterKey); masterKey == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) != 32 {
+		return nil, errors.New("master key must be 32 bytes")
+	}
+
+	return key, nil
+}
+
+// 根据密文的主密钥ID查找主密钥
+func findMasterKey(data string) ([]byte, error) {
+
+	keyId := crypto.EnvelopeKeyId(data)
+
+	masterKey, err := GetMasterKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if masterKey != nil && crypto.MasterKeyId(masterKey) == keyId {
+		return masterKey, nil
+	}
+
+	oldMasterKeys, err := GetOldMasterKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, oldMasterKey := range oldMasterKeys {
+		if crypto.MasterKeyId(oldMasterKey) == keyId {
+			return oldMasterKey, nil
+		}
+	}
+
+	return nil, errors.Newf("master key %s not found", keyId)
+}
+
+// 解密上游密钥, 未加密的明文密钥原样返回
+func DecryptKey(key string) (string, error) {
+
+	if !crypto.IsEnvelope(key) {
+		return key, nil
+	}
+
+	masterKey, err := findMasterKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return crypto.EnvelopeDecrypt(masterKey, key)
+}
+
+// 使用当前主密钥加密上游密钥, 旧主密钥加密的密钥会重新加密数据密钥, 未配置主密钥时原样返回
+func EncryptKey(key string) (string, error) {
+
+	masterKey, err := GetMasterKey()
+	if err != nil || masterKey == nil {
+		return key, err
+	}
+
+	if !crypto.IsEnvelope(key) {
+		return crypto.EnvelopeEncrypt(masterKey, key)
+	}
+
+	if crypto.EnvelopeKeyId(key) == crypto.MasterKeyId(masterKey) {
+		return key, nil
+	}
+
+	oldMasterKey, err := findMasterKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return crypto.EnvelopeRewrap(oldMasterKey, masterKey, key)
+}
+
+// 写入Redis前加密上游密钥, 返回加密后的副本
+func SealKeys(keys []*model.Key) ([]*model.Key, error) {
+
+	sealedKeys := make([]*model.Key, 0, len(keys))
+
+	for _, key := range keys {
+
+		if crypto.IsEnvelope(key.Key) {
+			sealedKeys = append(sealedKeys, key)
+			continue
+		}
+
+		sealed, err := EncryptKey(key.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		sealedKey := *key
+		sealedKey.Key = sealed
+		sealedKeys = append(sealedKeys, &sealedKey)
+	}
+
+	return sealedKeys, nil
+}
+
+// 日志中引用上游密钥, 格式: 密钥ID:****末4位
+func KeyRef(key *model.Key) string {
+
+	secret, err := DecryptKey(key.Key)
+	if err != nil {
+		return key.Id
+	}
+
+	return key.Id + ":" + crypto.MaskKey(secret)
+}
@@ -1,50 +1,54 @@
 package common
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"github.com/gogf/gf/v2/encoding/gjson"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/util/gconv"
 	sdkm "github.com/iimeta/fastapi-sdk/model"
-	"github.com/iimeta/fastapi-sdk/tiktoken"
 	"github.com/iimeta/fastapi/internal/consts"
 	"github.com/iimeta/fastapi/internal/model"
 	mcommon "github.com/iimeta/fastapi/internal/model/common"
 	"github.com/iimeta/fastapi/utility/logger"
+	"github.com/iimeta/fastapi/utility/tokenizer"
+	"github.com/iimeta/fastapi/utility/util"
+	"math"
 )
 
-func GetPromptTokens(ctx context.Context, model string, messages []sdkm.ChatCompletionMessage) int {
+// 获取模型的分词器
+func GetTokenizer(model *model.Model) tokenizer.Tokenizer {
+	return tokenizer.Resolve(model.Tokenizer, model.Model)
+}
+
+func GetPromptTokens(ctx context.Context, model *model.Model, messages []sdkm.ChatCompletionMessage) int {
 
 	promptTime := gtime.TimestampMilli()
 
-	promptTokens, err := tiktoken.NumTokensFromMessages(model, messages)
+	promptTokens, err := GetTokenizer(model).NumTokensFromMessages(messages)
 	if err != nil {
-		logger.Errorf(ctx, "GetPromptTokens NumTokensFromMessages model: %s, messages: %s, error: %v", model, gjson.MustEncodeString(messages), err)
-		if promptTokens, err = tiktoken.NumTokensFromMessages(consts.DEFAULT_MODEL, messages); err != nil {
-			logger.Errorf(ctx, "GetPromptTokens NumTokensFromMessages model: %s, messages: %s, error: %v", consts.DEFAULT_MODEL, gjson.MustEncodeString(messages), err)
-		}
+		logger.Errorf(ctx, "GetPromptTokens NumTokensFromMessages model: %s, messages: %s, error: %v", model.Model, gjson.MustEncodeString(messages), err)
 	}
-	logger.Debugf(ctx, "GetPromptTokens NumTokensFromMessages model: %s, len(messages): %d, promptTokens: %d, time: %d", model, len(gjson.MustEncodeString(messages)), promptTokens, gtime.TimestampMilli()-promptTime)
+	logger.Debugf(ctx, "GetPromptTokens NumTokensFromMessages model: %s, len(messages): %d, promptTokens: %d, time: %d", model.Model, len(gjson.MustEncodeString(messages)), promptTokens, gtime.TimestampMilli()-promptTime)
 
 	return promptTokens
 }
 
-func GetCompletionTokens(ctx context.Context, model, completion string) int {
+func GetCompletionTokens(ctx context.Context, model *model.Model, completion string) int {
 
 	completionTime := gtime.TimestampMilli()
-	completionTokens, err := tiktoken.NumTokensFromString(model, completion)
+	completionTokens, err := GetTokenizer(model).NumTokensFromString(completion)
 	if err != nil {
-		logger.Errorf(ctx, "GetCompletionTokens NumTokensFromString model: %s, completion: %s, error: %v", model, completion, err)
-		if completionTokens, err = tiktoken.NumTokensFromString(consts.DEFAULT_MODEL, completion); err != nil {
-			logger.Errorf(ctx, "GetCompletionTokens NumTokensFromString model: %s, completion: %s, error: %v", consts.DEFAULT_MODEL, completion, err)
-		}
+		logger.Errorf(ctx, "GetCompletionTokens NumTokensFromString model: %s, completion: %s, error: %v", model.Model, completion, err)
 	}
-	logger.Debugf(ctx, "GetCompletionTokens NumTokensFromString model: %s, len(completion): %d, completionTokens: %d, time: %d", model, len(completion), completionTokens, gtime.TimestampMilli()-completionTime)
+	logger.Debugf(ctx, "GetCompletionTokens NumTokensFromString model: %s, len(completion): %d, completionTokens: %d, time: %d", model.Model, len(completion), completionTokens, gtime.TimestampMilli()-completionTime)
 
 	return completionTokens
 }
 
-func GetMultimodalTokens(ctx context.Context, model string, multiContent []interface{}, reqModel *model.Model) (textTokens, imageTokens int) {
+// 获取多模态tokens, 无法获取图像宽高时按固定额度计算, 固定额度不参与倍率计算
+func GetMultimodalTokens(ctx context.Context, reqModel *model.Model, multiContent []interface{}) (textTokens, imageTokens, imageFixedQuota int) {
 
 	for _, value := range multiContent {
 
@@ -52,7 +56,15 @@ func GetMultimodalTokens(ctx context.Context, model string, multiContent []inter
 
 			if imageUrl, ok := content["image_url"].(map[string]interface{}); ok {
 
-				detail := imageUrl["detail"]
+				detail := gconv.String(imageUrl["detail"])
+
+				width, height, err := util.GetImageSize(ctx, gconv.String(imageUrl["url"]))
+				if err == nil && width > 0 && height > 0 {
+					imageTokens += GetImageTokens(reqModel, width, height, detail)
+					continue
+				}
+
+				logger.Errorf(ctx, "GetMultimodalTokens GetImageSize model: %s, error: %v, use fixed quota", reqModel.Model, err)
 
 				var imageQuota mcommon.ImageQuota
 				for _, quota := range reqModel.MultimodalQuota.ImageQuotas {
@@ -67,27 +79,42 @@ func GetMultimodalTokens(ctx context.Context, model string, multiContent []inter
 					}
 				}
 
-				imageTokens += imageQuota.FixedQuota
+				imageFixedQuota += imageQuota.FixedQuota
 			}
 
 		} else {
 			contentTime := gtime.TimestampMilli()
-			tokens, err := tiktoken.NumTokensFromString(model, gconv.String(content))
+			tokens, err := GetTokenizer(reqModel).NumTokensFromString(gconv.String(content))
 			if err != nil {
-				logger.Errorf(ctx, "GetMultimodalQuota NumTokensFromString model: %s, content: %s, error: %v", model, gconv.String(content), err)
-				if tokens, err = tiktoken.NumTokensFromString(consts.DEFAULT_MODEL, gconv.String(content)); err != nil {
-					logger.Errorf(ctx, "GetMultimodalQuota NumTokensFromString model: %s, content: %s, error: %v", consts.DEFAULT_MODEL, gconv.String(content), err)
-				}
+				logger.Errorf(ctx, "GetMultimodalQuota NumTokensFromString model: %s, content: %s, error: %v", reqModel.Model, gconv.String(content), err)
 			}
 			textTokens += tokens
-			logger.Debugf(ctx, "GetMultimodalQuota NumTokensFromString model: %s, len(content): %d, tokens: %d, time: %d", model, len(gconv.String(content)), tokens, gtime.TimestampMilli()-contentTime)
+			logger.Debugf(ctx, "GetMultimodalQuota NumTokensFromString model: %s, len(content): %d, tokens: %d, time: %d", reqModel.Model, len(gconv.String(content)), tokens, gtime.TimestampMilli()-contentTime)
 		}
 	}
 
-	return textTokens, imageTokens
+	return textTokens, imageTokens, imageFixedQuota
+}
+
+// 预读最后一条消息中的URL图像宽高, 计费时无需等待读取
+func PrefetchImageSizes(ctx context.Context, messages []sdkm.ChatCompletionMessage) {
+
+	if len(messages) == 0 {
+		return
+	}
+
+	if multiContent, ok := messages[len(messages)-1].Content.([]interface{}); ok {
+		for _, value := range multiContent {
+			if content, ok := value.(map[string]interface{}); ok && content["type"] == "image_url" {
+				if imageUrl, ok := content["image_url"].(map[string]interface{}); ok {
+					util.PrefetchImageSize(ctx, gconv.String(imageUrl["url"]))
+				}
+			}
+		}
+	}
 }
 
-func GetMultimodalAudioTokens(ctx context.Context, model string, messages []sdkm.ChatCompletionMessage, reqModel *model.Model) (textTokens, audioTokens int) {
+func GetMultimodalAudioTokens(ctx context.Context, reqModel *model.Model, messages []sdkm.ChatCompletionMessage) (textTokens, audioTokens int) {
 
 	var text string
 
@@ -97,6 +124,8 @@ func GetMultimodalAudioTokens(ctx context.Context, model string, messages []sdkm
 				if content, ok := value.(map[string]interface{}); ok {
 					if content["type"] == "text" {
 						text += gconv.String(content["text"])
+					} else if content["type"] == "input_audio" {
+						audioTokens += getInputAudioTokens(ctx, content["input_audio"])
 					}
 				}
 			}
@@ -107,17 +136,76 @@ func GetMultimodalAudioTokens(ctx context.Context, model string, messages []sdkm
 
 	contentTime := gtime.TimestampMilli()
 
-	tokens, err := tiktoken.NumTokensFromString(model, text)
+	tokens, err := GetTokenizer(reqModel).NumTokensFromString(text)
 	if err != nil {
-		logger.Errorf(ctx, "GetMultimodalAudioTokens NumTokensFromString model: %s, content: %s, error: %v", model, text, err)
-		if tokens, err = tiktoken.NumTokensFromString(consts.DEFAULT_MODEL, text); err != nil {
-			logger.Errorf(ctx, "GetMultimodalAudioTokens NumTokensFromString model: %s, content: %s, error: %v", consts.DEFAULT_MODEL, text, err)
-		}
+		logger.Errorf(ctx, "GetMultimodalAudioTokens NumTokensFromString model: %s, content: %s, error: %v", reqModel.Model, text, err)
 	}
 
 	textTokens += tokens
 
-	logger.Debugf(ctx, "GetMultimodalAudioTokens NumTokensFromString model: %s, len(content): %d, tokens: %d, time: %d", model, len(text), tokens, gtime.TimestampMilli()-contentTime)
+	logger.Debugf(ctx, "GetMultimodalAudioTokens NumTokensFromString model: %s, len(content): %d, tokens: %d, audioTokens: %d, time: %d", reqModel.Model, len(text), tokens, audioTokens, gtime.TimestampMilli()-contentTime)
+
+	return textTokens, audioTokens
+}
+
+// 按音频时长计算输入音频tokens, 无法解析时长时按默认tokens计算
+func getInputAudioTokens(ctx context.Context, inputAudio any) int {
+
+	audio, ok := inputAudio.(map[string]interface{})
+	if !ok {
+		return consts.DEFAULT_AUDIO_TOKENS
+	}
+
+	data, err := base64.StdEncoding.DecodeString(gconv.String(audio["data"]))
+	if err != nil {
+		logger.Errorf(ctx, "getInputAudioTokens DecodeString error: %v", err)
+		return consts.DEFAULT_AUDIO_TOKENS
+	}
+
+	duration, err := util.GetAudioDurationFromReader(bytes.NewReader(data), gconv.String(audio["format"]))
+	if err != nil || duration <= 0 {
+		logger.Errorf(ctx, "getInputAudioTokens GetAudioDurationFromReader format: %s, duration: %d, error: %v", audio["format"], duration, err)
+		return consts.DEFAULT_AUDIO_TOKENS
+	}
+
+	return int(math.Ceil(duration.Seconds() * consts.AUDIO_TOKENS_PER_SECOND))
+}
+
+// 按图像宽高计算图像tokens, 不同模型系列的计算方式不同
+func GetImageTokens(reqModel *model.Model, width, height int, detail string) int {
+
+	switch tokenizer.Family(reqModel.Tokenizer, reqModel.Model) {
+	case "claude":
+		// 长边超过1568时等比缩放, tokens = 宽 * 高 / 750
+		if scale := 1568 / float64(max(width, height)); scale < 1 {
+			width, height = int(float64(width)*scale), int(float64(height)*scale)
+		}
+		return int(math.Ceil(float64(width*height) / 750))
+
+	case "gemini":
+		// 宽高都不超过384时按258计算, 否则按768x768切片, 每片258
+		if width <= 384 && height <= 384 {
+			return 258
+		}
+		return int(math.Ceil(float64(width)/768)*math.Ceil(float64(height)/768)) * 258
+
+	case "qwen":
+		// 按28x28像素计算1个token, 最少4个, 最多1280个
+		return min(max(int(math.Ceil(float64(width)/28)*math.Ceil(float64(height)/28)), 4), 1280) + 2
+	}
+
+	// OpenAI: low固定85; 其它先缩放到2048x2048内, 再将短边缩放到768, 按512x512切片, 每片170, 另加85
+	if detail == "low" {
+		return 85
+	}
+
+	if scale := 2048 / float64(max(width, height)); scale < 1 {
+		width, height = int(float64(width)*scale), int(float64(height)*scale)
+	}
+
+	if scale := 768 / float64(min(width, height)); scale < 1 {
+		width, height = int(float64(width)*scale), int(float64(height)*scale)
+	}
 
-	return textTokens, 288
+	return int(math.Ceil(float64(width)/512)*math.Ceil(float64(height)/512))*170 + 85
 }
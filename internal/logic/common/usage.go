@@ -12,7 +12,7 @@ import (
 )
 
 // 记录使用额度
-func (s *sCommon) RecordUsage(ctx context.Context, totalTokens int, key string) error {
+func (s *sCommon) RecordUsage(ctx context.Context, totalTokens int, keyId string) error {
 
 	now := gtime.TimestampMilli()
 	defer func() {
@@ -27,40 +27,45 @@ func (s *sCommon) RecordUsage(ctx context.Context, totalTokens int, key string)
 	appId := service.Session().GetAppId(ctx)
 	appKey := service.Session().GetSecretKey(ctx)
 
-	logger.Infof(ctx, "sCommon RecordUsage userId: %d, appId: %d, appKey: %s, spendQuota: %d, key: %s", userId, appId, appKey, totalTokens, key)
+	logger.Infof(ctx, "sCommon RecordUsage userId: %d, appId: %d, appKey: %s, endUser: %s, spendQuota: %d, keyId: %s", userId, appId, appKey, service.Session().GetEndUser(ctx), totalTokens, keyId)
 
 	usageKey := s.GetUserUsageKey(ctx)
 
-	currentQuota, err := redisSpendQuota(ctx, usageKey, consts.USER_QUOTA_FIELD, totalTokens)
-	if err != nil {
-		logger.Error(ctx, err)
-		panic(err)
-	}
+	currentQuota := redisSpendQuota(ctx, usageKey, consts.USER_QUOTA_FIELD, totalTokens, func() int {
+		return service.User().GetCacheUserQuota(ctx, userId)
+	})
 
-	if err = mongoSpendQuota(ctx, func() error {
+	if err := mongoSpendQuota(ctx, func() error {
 		return service.User().SpendQuota(ctx, userId, totalTokens, currentQuota)
 	}); err != nil {
 		logger.Error(ctx, err)
 		panic(err)
 	}
 
+	// 额度余量告警
+	if user := service.Session().GetUser(ctx); user != nil {
+		service.Alert().QuotaAlert(ctx, "user", userId, 0, user.Quota+user.UsedQuota, currentQuota+totalTokens, currentQuota)
+	}
+
 	if service.Session().GetAppIsLimitQuota(ctx) {
 
-		currentQuota, err = redisSpendQuota(ctx, usageKey, s.GetAppTotalTokensField(ctx), totalTokens)
-		if err != nil {
-			logger.Error(ctx, err)
-			panic(err)
-		}
+		currentQuota = redisSpendQuota(ctx, usageKey, s.GetAppTotalTokensField(ctx), totalTokens, func() int {
+			return service.App().GetCacheAppQuota(ctx, appId)
+		})
 
-		if err = mongoSpendQuota(ctx, func() error {
+		if err := mongoSpendQuota(ctx, func() error {
 			return service.App().SpendQuota(ctx, appId, totalTokens, currentQuota)
 		}); err != nil {
 			logger.Error(ctx, err)
 			panic(err)
 		}
 
+		if app := service.Session().GetApp(ctx); app != nil {
+			service.Alert().QuotaAlert(ctx, "app", userId, appId, app.Quota+app.UsedQuota, currentQuota+totalTokens, currentQuota)
+		}
+
 	} else {
-		if err = mongoUsedQuota(ctx, func() error {
+		if err := mongoUsedQuota(ctx, func() error {
 			return service.App().UsedQuota(ctx, appId, totalTokens)
 		}); err != nil {
 			logger.Error(ctx, err)
@@ -70,13 +75,11 @@ func (s *sCommon) RecordUsage(ctx context.Context, totalTokens int, key string)
 
 	if service.Session().GetKeyIsLimitQuota(ctx) {
 
-		currentQuota, err = redisSpendQuota(ctx, usageKey, s.GetKeyTotalTokensField(ctx), totalTokens)
-		if err != nil {
-			logger.Error(ctx, err)
-			panic(err)
-		}
+		currentQuota = redisSpendQuota(ctx, usageKey, s.GetKeyTotalTokensField(ctx), totalTokens, func() int {
+			return service.App().GetCacheAppKeyQuota(ctx, appKey)
+		})
 
-		if err = mongoSpendQuota(ctx, func() error {
+		if err := mongoSpendQuota(ctx, func() error {
 			return service.App().AppKeySpendQuota(ctx, appKey, totalTokens, currentQuota)
 		}); err != nil {
 			logger.Error(ctx, err)
@@ -84,7 +87,7 @@ func (s *sCommon) RecordUsage(ctx context.Context, totalTokens int, key string)
 		}
 
 	} else {
-		if err = mongoUsedQuota(ctx, func() error {
+		if err := mongoUsedQuota(ctx, func() error {
 			return service.App().AppKeyUsedQuota(ctx, appKey, totalTokens)
 		}); err != nil {
 			logger.Error(ctx, err)
@@ -92,36 +95,41 @@ func (s *sCommon) RecordUsage(ctx context.Context, totalTokens int, key string)
 		}
 	}
 
-	if err = mongoUsedQuota(ctx, func() error {
-		return service.Key().UsedQuota(ctx, key, totalTokens)
+	if err := mongoUsedQuota(ctx, func() error {
+		return service.Key().UsedQuota(ctx, keyId, totalTokens)
 	}); err != nil {
 		logger.Error(ctx, err)
 		panic(err)
 	}
 
-	return nil
-}
-
-func redisSpendQuota(ctx context.Context, usageKey, field string, totalTokens int, retry ...int) (int, error) {
+	// 周期预算用量
+	RecordBudgetUsage(ctx, totalTokens)
 
-	currentQuota, err := redis.HIncrBy(ctx, usageKey, field, int64(-totalTokens))
-	if err != nil {
-		logger.Errorf(ctx, "redisSpendQuota usageKey: %s, field: %s, totalTokens: %d, error: %v", usageKey, field, totalTokens, err)
-
-		if len(retry) == 10 {
-			return -1, err
+	// 派生令牌花费
+	if claims := service.Session().GetToken(ctx); claims != nil {
+		if err := service.Token().RecordSpend(ctx, claims, totalTokens); err != nil {
+			logger.Error(ctx, err)
 		}
+	}
 
-		retry = append(retry, 1)
+	// 终端用户用量
+	if endUser := service.Session().GetEndUser(ctx); endUser != "" {
+		RecordEndUserUsage(ctx, appId, endUser, totalTokens)
+	}
 
-		time.Sleep(time.Duration(len(retry)*5) * time.Second)
+	return nil
+}
 
-		logger.Errorf(ctx, "redisSpendQuota usageKey: %s, field: %s, totalTokens: %d, retry: %d", usageKey, field, totalTokens, len(retry))
+// Redis不可用时降级为本地额度扣减, 重连后由预热按数据库额度重写用量
+func redisSpendQuota(ctx context.Context, usageKey, field string, totalTokens int, localQuota func() int) int {
 
-		return redisSpendQuota(ctx, usageKey, field, totalTokens, retry...)
+	currentQuota, err := redis.HIncrBy(ctx, usageKey, field, int64(-totalTokens))
+	if err != nil {
+		logger.Errorf(ctx, "redisSpendQuota usageKey: %s, field: %s, totalTokens: %d, degraded, error: %v", usageKey, field, totalTokens, err)
+		return localQuota() - totalTokens
 	}
 
-	return int(currentQuota), nil
+	return int(currentQuota)
 }
 
 func mongoSpendQuota(ctx context.Context, f func() error, retry ...int) error {
@@ -166,6 +174,30 @@ func mongoUsedQuota(ctx context.Context, f func() error, retry ...int) error {
 	return nil
 }
 
+// 加载用量中的剩余额度, 已有用量时以Redis中的为准, 没有时写入数据库中的剩余额度, Redis不可用时返回数据库中的剩余额度
+func LoadUsageQuota(ctx context.Context, userId int, field string, quota int) int {
+
+	usageKey := fmt.Sprintf(consts.API_USAGE_KEY, userId)
+
+	ok, err := redis.HSetNX(ctx, usageKey, field, quota)
+	if err != nil {
+		logger.Errorf(ctx, "LoadUsageQuota usageKey: %s, field: %s, error: %v", usageKey, field, err)
+		return quota
+	}
+
+	if ok {
+		return quota
+	}
+
+	currentQuota, err := redis.HGetInt(ctx, usageKey, field)
+	if err != nil {
+		logger.Errorf(ctx, "LoadUsageQuota usageKey: %s, field: %s, error: %v", usageKey, field, err)
+		return quota
+	}
+
+	return currentQuota
+}
+
 func (s *sCommon) GetUserTotalTokens(ctx context.Context) (int, error) {
 	return redis.HGetInt(ctx, s.GetUserUsageKey(ctx), consts.USER_QUOTA_FIELD)
 }
@@ -101,7 +101,6 @@ func (s *sCorp) GetCorpAndSaveCache(ctx context.Context, id string) (*model.Corp
 	if corp != nil {
 		if err = s.SaveCache(ctx, corp); err != nil {
 			logger.Error(ctx, err)
-			return nil, err
 		}
 	}
 
@@ -209,7 +208,7 @@ func (s *sCorp) GetCacheList(ctx context.Context, ids ...string) ([]*model.Corp,
 		result := new(model.Corp)
 		if err = gjson.Unmarshal([]byte(str), &result); err != nil {
 			logger.Error(ctx, err)
-			return nil, err
+			continue
 		}
 
 		if s.corpCache.ContainsKey(ctx, result.Id) {
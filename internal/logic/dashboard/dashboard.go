@@ -3,7 +3,8 @@ package dashboard
 import (
 	"context"
 	"github.com/gogf/gf/v2/os/gtime"
-	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/internal/logic/common"
 	"github.com/iimeta/fastapi/internal/model"
 	"github.com/iimeta/fastapi/internal/service"
 	"github.com/iimeta/fastapi/utility/logger"
@@ -66,9 +67,9 @@ func (s *sDashboard) Subscription(ctx context.Context) (*model.DashboardSubscrip
 	return &model.DashboardSubscriptionRes{
 		Object:             "billing_subscription",
 		HasPaymentMethod:   true,
-		SoftLimitUSD:       round(float64(quota)/consts.QUOTA_USD_UNIT, 4),
-		HardLimitUSD:       round(float64(quota)/consts.QUOTA_USD_UNIT, 4),
-		SystemHardLimitUSD: round(float64(quota)/consts.QUOTA_USD_UNIT, 4),
+		SoftLimitUSD:       round(float64(quota)/service.Billing().QuotaUnit(), 4),
+		HardLimitUSD:       round(float64(quota)/service.Billing().QuotaUnit(), 4),
+		SystemHardLimitUSD: round(float64(quota)/service.Billing().QuotaUnit(), 4),
 		AccessUntil:        0,
 	}, nil
 }
@@ -118,7 +119,42 @@ func (s *sDashboard) Usage(ctx context.Context) (*model.DashboardUsageRes, error
 
 	return &model.DashboardUsageRes{
 		Object:     "list",
-		TotalUsage: round(float64(usedQuota)/consts.QUOTA_USD_UNIT, 4),
+		TotalUsage: round(float64(usedQuota)/service.Billing().QuotaUnit(), 4),
+	}, nil
+}
+
+// EndUserUsage
+func (s *sDashboard) EndUserUsage(ctx context.Context, endUser string) (*model.DashboardEndUserUsageRes, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sDashboard EndUserUsage time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	// 派生令牌只能查询自身的终端用户
+	if claims := service.Session().GetToken(ctx); claims != nil && claims.EndUser != "" {
+		endUser = claims.EndUser
+	}
+
+	if endUser == "" {
+		endUser = service.Session().GetEndUser(ctx)
+	}
+
+	if endUser == "" {
+		err := errors.ERR_INVALID_PARAMETER
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	usage, err := common.GetEndUserUsage(ctx, service.Session().GetAppId(ctx), endUser)
+	if err != nil {
+		logger.Errorf(ctx, "sDashboard EndUserUsage GetEndUserUsage error: %v", err)
+		return nil, err
+	}
+
+	return &model.DashboardEndUserUsageRes{
+		Object:       "end_user_usage",
+		EndUserUsage: usage,
 	}, nil
 }
 
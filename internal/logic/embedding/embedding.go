@@ -4,24 +4,20 @@ import (
 	"context"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/os/gctx"
-	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/util/gconv"
 	"github.com/iimeta/fastapi-sdk"
 	sdkm "github.com/iimeta/fastapi-sdk/model"
-	"github.com/iimeta/fastapi/internal/config"
-	"github.com/iimeta/fastapi/internal/dao"
+	"github.com/iimeta/fastapi/internal/consts"
 	"github.com/iimeta/fastapi/internal/errors"
 	"github.com/iimeta/fastapi/internal/logic/common"
 	"github.com/iimeta/fastapi/internal/model"
 	mcommon "github.com/iimeta/fastapi/internal/model/common"
 	"github.com/iimeta/fastapi/internal/model/do"
 	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/graceful"
 	"github.com/iimeta/fastapi/utility/logger"
 	"github.com/iimeta/fastapi/utility/util"
-	"math"
-	"slices"
-	"time"
 )
 
 type sEmbedding struct{}
@@ -59,26 +55,35 @@ func (s *sEmbedding) Embeddings(ctx context.Context, params sdkm.EmbeddingReques
 		internalTime := gtime.TimestampMilli() - enterTime - response.TotalTime
 
 		if mak.ReqModel != nil && response.Usage != nil {
-			if mak.ReqModel.TextQuota.BillingMethod == 1 {
-				totalTokens = int(math.Ceil(float64(response.Usage.PromptTokens)*mak.ReqModel.TextQuota.PromptRatio + float64(response.Usage.CompletionTokens)*mak.ReqModel.TextQuota.CompletionRatio))
-			} else {
-				totalTokens = mak.ReqModel.TextQuota.FixedQuota
-			}
+			totalTokens = service.Billing().TextQuota(mak.ReqModel, response.Usage)
 		}
 
 		if retryInfo == nil && (err == nil || common.IsAborted(err)) && mak.ReqModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-				if err := service.Common().RecordUsage(ctx, totalTokens, mak.Key.Key); err != nil {
+
+			bill := service.Billing().Bill(ctx, &model.BillingReq{
+				Model:      mak.ReqModel,
+				Action:     consts.BILLING_ACTION_EMBEDDING,
+				Usage:      response.Usage,
+				RatioQuota: totalTokens,
+			})
+			totalTokens = bill.Quota
+
+			if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+				if err := service.Common().RecordUsage(ctx, totalTokens, mak.Key.Id); err != nil {
 					logger.Error(ctx, err)
 					panic(err)
 				}
+
+				if err := service.Billing().Ledger(ctx, bill, mak.Key.Id); err != nil {
+					logger.Error(ctx, err)
+				}
 			}); err != nil {
 				logger.Error(ctx, err)
 			}
 		}
 
 		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
 
 				mak.RealModel.ModelAgent = mak.ModelAgent
 
@@ -125,10 +130,10 @@ func (s *sEmbedding) Embeddings(ctx context.Context, params sdkm.EmbeddingReques
 		// 记录错误次数和禁用
 		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+		isRetry, isDisabled := common.IsNeedRetry(ctx, mak, err)
 
 		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
 				if mak.RealModel.IsEnableModelAgent {
 					service.ModelAgent().DisabledModelAgentKey(ctx, mak.Key, err.Error())
 				} else {
@@ -177,6 +182,12 @@ func (s *sEmbedding) Embeddings(ctx context.Context, params sdkm.EmbeddingReques
 				ErrMsg:     err.Error(),
 			}
 
+			// 重试退避
+			if !common.RetryBackoff(ctx, mak, err, retryInfo) {
+				retryInfo.IsRetry = false
+				return response, err
+			}
+
 			return s.Embeddings(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
 		}
 
@@ -187,7 +198,7 @@ func (s *sEmbedding) Embeddings(ctx context.Context, params sdkm.EmbeddingReques
 }
 
 // 保存日志
-func (s *sEmbedding) SaveLog(ctx context.Context, reqModel, realModel *model.Model, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, key *model.Key, completionsReq *sdkm.EmbeddingRequest, completionsRes *model.CompletionsRes, retryInfo *mcommon.Retry, retry ...int) {
+func (s *sEmbedding) SaveLog(ctx context.Context, reqModel, realModel *model.Model, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, key *model.Key, completionsReq *sdkm.EmbeddingRequest, completionsRes *model.CompletionsRes, retryInfo *mcommon.Retry) {
 
 	now := gtime.TimestampMilli()
 	defer func() {
@@ -203,6 +214,7 @@ func (s *sEmbedding) SaveLog(ctx context.Context, reqModel, realModel *model.Mod
 		TraceId:      gctx.CtxId(ctx),
 		UserId:       service.Session().GetUserId(ctx),
 		AppId:        service.Session().GetAppId(ctx),
+		EndUser:      service.Session().GetEndUser(ctx),
 		ConnTime:     completionsRes.ConnTime,
 		Duration:     completionsRes.Duration,
 		TotalTime:    completionsRes.TotalTime,
@@ -216,11 +228,11 @@ func (s *sEmbedding) SaveLog(ctx context.Context, reqModel, realModel *model.Mod
 		Host:         g.RequestFromCtx(ctx).GetHost(),
 	}
 
-	if slices.Contains(config.Cfg.RecordLogs, "prompt") {
+	if common.IsRecordLog(ctx, "prompt") {
 		chat.Prompt = gconv.String(completionsReq.Input)
 	}
 
-	if slices.Contains(config.Cfg.RecordLogs, "completion") {
+	if common.IsRecordLog(ctx, "completion") {
 		chat.Completion = completionsRes.Completion
 	}
 
@@ -281,7 +293,7 @@ func (s *sEmbedding) SaveLog(ctx context.Context, reqModel, realModel *model.Mod
 	}
 
 	if key != nil {
-		chat.Key = key.Key
+		chat.Key = common.KeyRef(key)
 	}
 
 	if completionsRes.Error != nil {
@@ -300,6 +312,10 @@ func (s *sEmbedding) SaveLog(ctx context.Context, reqModel, realModel *model.Mod
 			IsRetry:    retryInfo.IsRetry,
 			RetryCount: retryInfo.RetryCount,
 			ErrMsg:     retryInfo.ErrMsg,
+			Status:     retryInfo.Status,
+			Code:       retryInfo.Code,
+			Delay:      retryInfo.Delay,
+			Decision:   retryInfo.Decision,
 		}
 
 		if chat.IsRetry {
@@ -308,19 +324,7 @@ func (s *sEmbedding) SaveLog(ctx context.Context, reqModel, realModel *model.Mod
 		}
 	}
 
-	if _, err := dao.Chat.Insert(ctx, chat); err != nil {
+	if err := service.LogWriter().Write(ctx, chat); err != nil {
 		logger.Error(ctx, err)
-
-		if len(retry) == 10 {
-			panic(err)
-		}
-
-		retry = append(retry, 1)
-
-		time.Sleep(time.Duration(len(retry)*5) * time.Second)
-
-		logger.Errorf(ctx, "sEmbedding SaveLog retry: %d", len(retry))
-
-		s.SaveLog(ctx, reqModel, realModel, fallbackModelAgent, fallbackModel, key, completionsReq, completionsRes, retryInfo, retry...)
 	}
 }
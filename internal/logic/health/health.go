@@ -0,0 +1,321 @@
+package health
+
+import (
+	"cmp"
+	"context"
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/os/grpool"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/model"
+	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/db"
+	"github.com/iimeta/fastapi/utility/graceful"
+	"github.com/iimeta/fastapi/utility/logger"
+	"github.com/iimeta/fastapi/utility/redis"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type sHealth struct {
+	startTime  int64
+	subscribed atomic.Bool
+	models     atomic.Pointer[model.HealthCheck] // 模型检查结果
+	modelsAt   atomic.Int64                      // 模型检查时间
+	modelsMu   sync.Mutex
+	failOpen   sync.Map     // [检查项]*atomic.Int64, Redis异常时跳过的次数
+	failOpenAt atomic.Int64 // 最近一次跳过检查的时间
+}
+
+func init() {
+	service.RegisterHealth(New())
+}
+
+func New() service.IHealth {
+	return &sHealth{
+		startTime: gtime.Timestamp(),
+	}
+}
+
+// 存活检查, 只检查进程自身, 依赖异常时不重启实例
+func (s *sHealth) Liveness(ctx context.Context) *model.Health {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sHealth Liveness time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	return s.result(map[string]*model.HealthCheck{})
+}
+
+// 就绪检查, 依赖异常或正在关闭时不接收流量
+func (s *sHealth) Readiness(ctx context.Context) *model.Health {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sHealth Readiness time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	return s.result(map[string]*model.HealthCheck{
+		"shutdown":  s.checkShutdown(),
+		"redis":     s.checkPing(ctx, redis.Ping, consts.HEALTH_STATUS_WARN),
+		"mongodb":   s.checkPing(ctx, db.Ping, consts.HEALTH_STATUS_FAIL),
+		"subscribe": s.checkSubscribe(),
+		"pool":      s.checkPool(),
+		"models":    s.checkModels(ctx),
+		"fail_open": s.checkFailOpen(),
+	})
+}
+
+// 设置变更流消费的连接状态
+func (s *sHealth) SetSubscribed(subscribed bool) {
+	s.subscribed.Store(subscribed)
+}
+
+// 记录Redis异常时跳过的限额检查
+func (s *sHealth) RecordFailOpen(name string) {
+
+	count, _ := s.failOpen.LoadOrStore(name, new(atomic.Int64))
+	count.(*atomic.Int64).Add(1)
+
+	s.failOpenAt.Store(gtime.TimestampMilli())
+}
+
+// 汇总检查结果, 任一检查项异常时为异常
+func (s *sHealth) result(checks map[string]*model.HealthCheck) *model.Health {
+
+	health := &model.Health{
+		Status:    consts.HEALTH_STATUS_OK,
+		Uptime:    gtime.Timestamp() - s.startTime,
+		Timestamp: gtime.TimestampMilli(),
+		Checks:    checks,
+	}
+
+	for _, check := range checks {
+		if check.Status == consts.HEALTH_STATUS_FAIL {
+			health.Status = consts.HEALTH_STATUS_FAIL
+			break
+		}
+		if check.Status == consts.HEALTH_STATUS_WARN {
+			health.Status = consts.HEALTH_STATUS_WARN
+		}
+	}
+
+	return health
+}
+
+func (s *sHealth) checkShutdown() *model.HealthCheck {
+
+	check := &model.HealthCheck{
+		Status: consts.HEALTH_STATUS_OK,
+	}
+
+	if graceful.IsShuttingDown() {
+		check.Status = consts.HEALTH_STATUS_FAIL
+		check.Error = "server is shutting down"
+	}
+
+	return check
+}
+
+// 检查失败时为指定状态, Redis异常时本地缓存仍可服务, 只告警
+func (s *sHealth) checkPing(ctx context.Context, ping func(ctx context.Context) error, failStatus string) *model.HealthCheck {
+
+	timeout := config.Cfg.Health.Timeout
+	if timeout <= 0 {
+		timeout = 3
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout*time.Second)
+	defer cancel()
+
+	now := gtime.TimestampMilli()
+
+	check := &model.HealthCheck{
+		Status: consts.HEALTH_STATUS_OK,
+	}
+
+	if err := ping(ctx); err != nil {
+		logger.Error(ctx, err)
+		check.Status = failStatus
+		check.Error = err.Error()
+	}
+
+	check.Latency = gtime.TimestampMilli() - now
+
+	return check
+}
+
+// 变更流消费断开是由Redis异常导致的, 同Redis检查只告警, 本地缓存仍可服务
+func (s *sHealth) checkSubscribe() *model.HealthCheck {
+
+	check := &model.HealthCheck{
+		Status: consts.HEALTH_STATUS_OK,
+	}
+
+	if !s.subscribed.Load() {
+		check.Status = consts.HEALTH_STATUS_WARN
+		check.Error = "change stream consumer is disconnected"
+	}
+
+	return check
+}
+
+// 最近1分钟内有跳过的限额检查时告警, 详情为启动以来各检查项跳过的次数
+func (s *sHealth) checkFailOpen() *model.HealthCheck {
+
+	detail := make(map[string]int64)
+	s.failOpen.Range(func(key, value any) bool {
+		detail[key.(string)] = value.(*atomic.Int64).Load()
+		return true
+	})
+
+	check := &model.HealthCheck{
+		Status: consts.HEALTH_STATUS_OK,
+		Detail: detail,
+	}
+
+	if gtime.TimestampMilli()-s.failOpenAt.Load() < time.Minute.Milliseconds() {
+		check.Status = consts.HEALTH_STATUS_WARN
+		check.Error = "limit checks are skipped while redis is unavailable"
+	}
+
+	return check
+}
+
+// 协程池运行中的任务数超过上限时为异常
+func (s *sHealth) checkPool() *model.HealthCheck {
+
+	check := &model.HealthCheck{
+		Status: consts.HEALTH_STATUS_OK,
+		Detail: map[string]int64{
+			"size":     int64(grpool.Size()),
+			"jobs":     int64(grpool.Jobs()),
+			"tasks":    graceful.Tasks(),
+			"sessions": graceful.Sessions(),
+		},
+	}
+
+	if config.Cfg.Health.MaxPoolSize > 0 && grpool.Size()+grpool.Jobs() > config.Cfg.Health.MaxPoolSize {
+		check.Status = consts.HEALTH_STATUS_FAIL
+		check.Error = "goroutine pool is saturated"
+	}
+
+	return check
+}
+
+// 没有可用密钥的模型, 只告警不影响就绪, 检查结果按间隔缓存
+func (s *sHealth) checkModels(ctx context.Context) *model.HealthCheck {
+
+	interval := cmp.Or(config.Cfg.Health.ModelsInterval, 30) * time.Second
+
+	if check := s.models.Load(); check != nil && time.Since(time.UnixMilli(s.modelsAt.Load())) < interval {
+		return check
+	}
+
+	// 并发探测只检查一次, 其它请求使用上次的结果
+	if !s.modelsMu.TryLock() {
+		if check := s.models.Load(); check != nil {
+			return check
+		}
+		s.modelsMu.Lock()
+	}
+	defer s.modelsMu.Unlock()
+
+	if check := s.models.Load(); check != nil && time.Since(time.UnixMilli(s.modelsAt.Load())) < interval {
+		return check
+	}
+
+	check := s.loadModels(ctx)
+
+	s.models.Store(check)
+	s.modelsAt.Store(gtime.TimestampMilli())
+
+	return check
+}
+
+// 检查模型可用密钥
+func (s *sHealth) loadModels(ctx context.Context) *model.HealthCheck {
+
+	check := &model.HealthCheck{
+		Status: consts.HEALTH_STATUS_OK,
+	}
+
+	reply, err := redis.HVals(ctx, consts.API_MODELS_KEY)
+	if err != nil {
+		logger.Error(ctx, err)
+		check.Status = consts.HEALTH_STATUS_WARN
+		check.Error = err.Error()
+		return check
+	}
+
+	total := 0
+	noKeyModels := make([]string, 0)
+
+	for _, str := range reply.Strings() {
+
+		m := new(model.Model)
+		if err = gjson.Unmarshal([]byte(str), m); err != nil {
+			logger.Error(ctx, err)
+			continue
+		}
+
+		// 转发的模型使用目标模型的密钥
+		if m.Status != 1 || m.IsEnableForward {
+			continue
+		}
+
+		total++
+
+		if s.availableKeys(ctx, m) == 0 {
+			noKeyModels = append(noKeyModels, m.Model)
+		}
+	}
+
+	if len(noKeyModels) > 0 {
+		check.Status = consts.HEALTH_STATUS_WARN
+	}
+
+	check.Detail = map[string]interface{}{
+		"total":         total,
+		"no_key_models": noKeyModels,
+	}
+
+	return check
+}
+
+// 可用密钥数
+func (s *sHealth) availableKeys(ctx context.Context, m *model.Model) int {
+
+	keys := make([]*model.Key, 0)
+
+	if m.IsEnableModelAgent {
+
+		modelAgents, err := service.ModelAgent().GetCacheList(ctx, m.ModelAgents...)
+		if err != nil {
+			return 0
+		}
+
+		for _, modelAgent := range modelAgents {
+			if modelAgent.Status == 1 {
+				if agentKeys, err := service.ModelAgent().GetCacheModelAgentKeys(ctx, modelAgent.Id); err == nil {
+					keys = append(keys, agentKeys...)
+				}
+			}
+		}
+
+	} else if modelKeys, err := service.Key().GetCacheModelKeys(ctx, m.Id); err == nil {
+		keys = modelKeys
+	}
+
+	count := 0
+	for _, key := range keys {
+		if key.Status == 1 {
+			count++
+		}
+	}
+
+	return count
+}
@@ -5,21 +5,20 @@ import (
 	"fmt"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/os/gctx"
-	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/iimeta/fastapi-sdk"
 	sdkm "github.com/iimeta/fastapi-sdk/model"
-	"github.com/iimeta/fastapi/internal/dao"
+	"github.com/iimeta/fastapi/internal/consts"
 	"github.com/iimeta/fastapi/internal/errors"
 	"github.com/iimeta/fastapi/internal/logic/common"
 	"github.com/iimeta/fastapi/internal/model"
 	mcommon "github.com/iimeta/fastapi/internal/model/common"
 	"github.com/iimeta/fastapi/internal/model/do"
 	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/graceful"
 	"github.com/iimeta/fastapi/utility/logger"
 	"github.com/iimeta/fastapi/utility/util"
-	"time"
 )
 
 type sImage struct{}
@@ -56,22 +55,37 @@ func (s *sImage) Generations(ctx context.Context, params sdkm.ImageRequest, fall
 		enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
 		internalTime := gtime.TimestampMilli() - enterTime - response.TotalTime
 		usage := &sdkm.Usage{
-			TotalTokens: imageQuota.FixedQuota * len(response.Data),
+			TotalTokens: service.Billing().ImageQuota(mak.RealModel, params.Size, len(response.Data)),
 		}
 
 		if retryInfo == nil && (err == nil || common.IsAborted(err)) && mak.ReqModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-				if err := service.Common().RecordUsage(ctx, usage.TotalTokens, mak.Key.Key); err != nil {
+
+			bill := service.Billing().Bill(ctx, &model.BillingReq{
+				Model:        mak.ReqModel,
+				Action:       consts.BILLING_ACTION_IMAGE,
+				ImageSize:    params.Size,
+				ImageQuality: params.Quality,
+				ImageCount:   len(response.Data),
+				RatioQuota:   usage.TotalTokens,
+			})
+			usage.TotalTokens = bill.Quota
+
+			if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+				if err := service.Common().RecordUsage(ctx, usage.TotalTokens, mak.Key.Id); err != nil {
 					logger.Error(ctx, err)
 					panic(err)
 				}
+
+				if err := service.Billing().Ledger(ctx, bill, mak.Key.Id); err != nil {
+					logger.Error(ctx, err)
+				}
 			}); err != nil {
 				logger.Error(ctx, err)
 			}
 		}
 
 		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
 
 				mak.RealModel.ModelAgent = mak.ModelAgent
 
@@ -122,10 +136,10 @@ func (s *sImage) Generations(ctx context.Context, params sdkm.ImageRequest, fall
 		// 记录错误次数和禁用
 		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+		isRetry, isDisabled := common.IsNeedRetry(ctx, mak, err)
 
 		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
 				if mak.RealModel.IsEnableModelAgent {
 					service.ModelAgent().DisabledModelAgentKey(ctx, mak.Key, err.Error())
 				} else {
@@ -174,6 +188,12 @@ func (s *sImage) Generations(ctx context.Context, params sdkm.ImageRequest, fall
 				ErrMsg:     err.Error(),
 			}
 
+			// 重试退避
+			if !common.RetryBackoff(ctx, mak, err, retryInfo) {
+				retryInfo.IsRetry = false
+				return response, err
+			}
+
 			return s.Generations(g.RequestFromCtx(ctx).GetCtx(), params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
 		}
 
@@ -184,7 +204,7 @@ func (s *sImage) Generations(ctx context.Context, params sdkm.ImageRequest, fall
 }
 
 // 保存日志
-func (s *sImage) SaveLog(ctx context.Context, reqModel, realModel *model.Model, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, key *model.Key, imageReq *sdkm.ImageRequest, imageRes *model.ImageRes, retryInfo *mcommon.Retry, retry ...int) {
+func (s *sImage) SaveLog(ctx context.Context, reqModel, realModel *model.Model, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, key *model.Key, imageReq *sdkm.ImageRequest, imageRes *model.ImageRes, retryInfo *mcommon.Retry) {
 
 	now := gtime.TimestampMilli()
 	defer func() {
@@ -200,7 +220,7 @@ func (s *sImage) SaveLog(ctx context.Context, reqModel, realModel *model.Model,
 		TraceId:        gctx.CtxId(ctx),
 		UserId:         service.Session().GetUserId(ctx),
 		AppId:          service.Session().GetAppId(ctx),
-		Prompt:         imageReq.Prompt,
+		EndUser:        service.Session().GetEndUser(ctx),
 		Size:           imageReq.Size,
 		N:              imageReq.N,
 		Quality:        imageReq.Quality,
@@ -217,12 +237,22 @@ func (s *sImage) SaveLog(ctx context.Context, reqModel, realModel *model.Model,
 		Host:           g.RequestFromCtx(ctx).GetHost(),
 	}
 
+	if common.IsRecordLog(ctx, "prompt") {
+		image.Prompt = imageReq.Prompt
+	}
+
 	for _, data := range imageRes.Data {
-		image.ImageData = append(image.ImageData, mcommon.ImageData{
+
+		imageData := mcommon.ImageData{
 			URL: data.URL,
 			//B64JSON:       data.B64JSON, // 太大了, 不存
-			RevisedPrompt: data.RevisedPrompt,
-		})
+		}
+
+		if common.IsRecordLog(ctx, "prompt") {
+			imageData.RevisedPrompt = data.RevisedPrompt
+		}
+
+		image.ImageData = append(image.ImageData, imageData)
 	}
 
 	if reqModel != nil {
@@ -279,7 +309,7 @@ func (s *sImage) SaveLog(ctx context.Context, reqModel, realModel *model.Model,
 	}
 
 	if key != nil {
-		image.Key = key.Key
+		image.Key = common.KeyRef(key)
 	}
 
 	if imageRes.Error != nil {
@@ -298,6 +328,10 @@ func (s *sImage) SaveLog(ctx context.Context, reqModel, realModel *model.Model,
 			IsRetry:    retryInfo.IsRetry,
 			RetryCount: retryInfo.RetryCount,
 			ErrMsg:     retryInfo.ErrMsg,
+			Status:     retryInfo.Status,
+			Code:       retryInfo.Code,
+			Delay:      retryInfo.Delay,
+			Decision:   retryInfo.Decision,
 		}
 
 		if image.IsRetry {
@@ -306,19 +340,7 @@ func (s *sImage) SaveLog(ctx context.Context, reqModel, realModel *model.Model,
 		}
 	}
 
-	if _, err := dao.Image.Insert(ctx, image); err != nil {
+	if err := service.LogWriter().Write(ctx, image); err != nil {
 		logger.Error(ctx, err)
-
-		if len(retry) == 10 {
-			panic(err)
-		}
-
-		retry = append(retry, 1)
-
-		time.Sleep(time.Duration(len(retry)*5) * time.Second)
-
-		logger.Errorf(ctx, "sImage SaveLog retry: %d", len(retry))
-
-		s.SaveLog(ctx, reqModel, realModel, fallbackModelAgent, fallbackModel, key, imageReq, imageRes, retryInfo, retry...)
 	}
 }
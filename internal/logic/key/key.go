@@ -11,20 +11,24 @@ import (
 	"github.com/iimeta/fastapi/internal/consts"
 	"github.com/iimeta/fastapi/internal/dao"
 	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/internal/logic/common"
 	"github.com/iimeta/fastapi/internal/model"
 	"github.com/iimeta/fastapi/internal/model/entity"
 	"github.com/iimeta/fastapi/internal/service"
 	"github.com/iimeta/fastapi/utility/cache"
+	"github.com/iimeta/fastapi/utility/crypto"
 	"github.com/iimeta/fastapi/utility/lb"
 	"github.com/iimeta/fastapi/utility/logger"
 	"github.com/iimeta/fastapi/utility/redis"
 	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/sync/singleflight"
 	"slices"
 )
 
 type sKey struct {
 	modelKeysCache           *cache.Cache // [模型ID][]密钥列表
 	modelKeysRoundRobinCache *cache.Cache // [模型ID]密钥下标索引
+	group                    singleflight.Group
 }
 
 func init() {
@@ -38,15 +42,15 @@ func New() service.IKey {
 	}
 }
 
-// 根据secretKey获取密钥信息
-func (s *sKey) GetKey(ctx context.Context, secretKey string) (*model.Key, error) {
+// 根据密钥哈希获取密钥信息
+func (s *sKey) GetKey(ctx context.Context, keyHash string) (*model.Key, error) {
 
 	now := gtime.TimestampMilli()
 	defer func() {
 		logger.Debugf(ctx, "sKey GetKey time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	key, err := dao.Key.FindOne(ctx, bson.M{"key": secretKey, "status": 1})
+	key, err := dao.Key.FindOne(ctx, bson.M{"key_hash": keyHash, "status": 1})
 	if err != nil {
 		logger.Error(ctx, err)
 		return nil, err
@@ -58,6 +62,7 @@ func (s *sKey) GetKey(ctx context.Context, secretKey string) (*model.Key, error)
 		AppId:               key.AppId,
 		Corp:                key.Corp,
 		Key:                 key.Key,
+		KeyHash:             key.KeyHash,
 		Type:                key.Type,
 		Weight:              key.Weight,
 		Models:              key.Models,
@@ -67,6 +72,7 @@ func (s *sKey) GetKey(ctx context.Context, secretKey string) (*model.Key, error)
 		UsedQuota:           key.UsedQuota,
 		QuotaExpiresRule:    key.QuotaExpiresRule,
 		QuotaExpiresAt:      key.QuotaExpiresAt,
+		Budgets:             key.Budgets,
 		QuotaExpiresMinutes: key.QuotaExpiresMinutes,
 		IpWhitelist:         key.IpWhitelist,
 		IpBlacklist:         key.IpBlacklist,
@@ -96,6 +102,7 @@ func (s *sKey) GetModelKeys(ctx context.Context, id string) ([]*model.Key, error
 			AppId:               result.AppId,
 			Corp:                result.Corp,
 			Key:                 result.Key,
+			KeyHash:             result.KeyHash,
 			Type:                result.Type,
 			Weight:              result.Weight,
 			Models:              result.Models,
@@ -105,6 +112,7 @@ func (s *sKey) GetModelKeys(ctx context.Context, id string) ([]*model.Key, error
 			UsedQuota:           result.UsedQuota,
 			QuotaExpiresRule:    result.QuotaExpiresRule,
 			QuotaExpiresAt:      result.QuotaExpiresAt,
+			Budgets:             result.Budgets,
 			QuotaExpiresMinutes: result.QuotaExpiresMinutes,
 			IpWhitelist:         result.IpWhitelist,
 			IpBlacklist:         result.IpBlacklist,
@@ -141,6 +149,7 @@ func (s *sKey) List(ctx context.Context, typ int) ([]*model.Key, error) {
 			AppId:               result.AppId,
 			Corp:                result.Corp,
 			Key:                 result.Key,
+			KeyHash:             result.KeyHash,
 			Type:                result.Type,
 			Weight:              result.Weight,
 			Models:              result.Models,
@@ -150,6 +159,7 @@ func (s *sKey) List(ctx context.Context, typ int) ([]*model.Key, error) {
 			UsedQuota:           result.UsedQuota,
 			QuotaExpiresRule:    result.QuotaExpiresRule,
 			QuotaExpiresAt:      result.QuotaExpiresAt,
+			Budgets:             result.Budgets,
 			QuotaExpiresMinutes: result.QuotaExpiresMinutes,
 			IpWhitelist:         result.IpWhitelist,
 			IpBlacklist:         result.IpBlacklist,
@@ -160,6 +170,48 @@ func (s *sKey) List(ctx context.Context, typ int) ([]*model.Key, error) {
 	return items, nil
 }
 
+// 旧版明文应用密钥哈希存储, 用于未执行迁移的密钥首次使用时补充哈希
+func (s *sKey) HashAppKey(ctx context.Context, secretKey string) error {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sKey HashAppKey time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	key, err := dao.Key.FindOne(ctx, bson.M{"type": 1, "key": secretKey, "key_hash": bson.M{"$exists": false}})
+	if err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
+	if err = dao.Key.UpdateById(ctx, key.Id, bson.M{
+		"$set": bson.M{"key": crypto.KeyPrefix(key.Key), "key_hash": crypto.HashKey(key.Key)},
+		"$inc": bson.M{"version": 1},
+	}); err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
+	logger.Infof(ctx, "sKey HashAppKey key: %s", key.Id)
+
+	newData, err := dao.Key.FindById(ctx, key.Id)
+	if err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
+	// 通知其他实例刷新缓存中的明文密钥
+	if err = common.PublishChange(ctx, consts.CHANGE_CHANNEL_APP_KEY, model.PubMessage{
+		Action:  consts.ACTION_UPDATE,
+		OldData: key,
+		NewData: newData,
+	}); err != nil {
+		logger.Error(ctx, err)
+	}
+
+	return nil
+}
+
 // 挑选模型密钥
 func (s *sKey) PickModelKey(ctx context.Context, m *model.Model) (int, *model.Key, error) {
 
@@ -181,13 +233,7 @@ func (s *sKey) PickModelKey(ctx context.Context, m *model.Model) (int, *model.Ke
 	if len(modelKeys) == 0 {
 
 		if modelKeys, err = s.GetCacheModelKeys(ctx, m.Id); err != nil {
-
-			if modelKeys, err = s.GetModelKeys(ctx, m.Id); err != nil {
-				logger.Error(ctx, err)
-				return 0, nil, err
-			}
-
-			if err = s.SaveCacheModelKeys(ctx, m.Id, modelKeys); err != nil {
+			if modelKeys, err = s.loadModelKeys(ctx, m.Id); err != nil {
 				logger.Error(ctx, err)
 				return 0, nil, err
 			}
@@ -295,7 +341,7 @@ func (s *sKey) RecordErrorModelKey(ctx context.Context, m *model.Model, key *mod
 		logger.Debugf(ctx, "sKey RecordErrorModelKey time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	reply, err := redis.HIncrBy(ctx, fmt.Sprintf(consts.ERROR_MODEL_KEY, m.Model), key.Key, 1)
+	reply, err := redis.HIncrBy(ctx, fmt.Sprintf(consts.ERROR_MODEL_KEY, m.Model), key.Id, 1)
 	if err != nil {
 		logger.Error(ctx, err)
 	}
@@ -323,6 +369,7 @@ func (s *sKey) DisabledModelKey(ctx context.Context, key *model.Key, disabledRea
 		AppId:               key.AppId,
 		Corp:                key.Corp,
 		Key:                 key.Key,
+		KeyHash:             key.KeyHash,
 		Type:                key.Type,
 		Weight:              key.Weight,
 		Models:              key.Models,
@@ -332,6 +379,7 @@ func (s *sKey) DisabledModelKey(ctx context.Context, key *model.Key, disabledRea
 		UsedQuota:           key.UsedQuota,
 		QuotaExpiresRule:    key.QuotaExpiresRule,
 		QuotaExpiresAt:      key.QuotaExpiresAt,
+		Budgets:             key.Budgets,
 		QuotaExpiresMinutes: key.QuotaExpiresMinutes,
 		IpWhitelist:         key.IpWhitelist,
 		IpBlacklist:         key.IpBlacklist,
@@ -347,6 +395,8 @@ func (s *sKey) DisabledModelKey(ctx context.Context, key *model.Key, disabledRea
 	}); err != nil {
 		logger.Error(ctx, err)
 	}
+
+	service.Alert().KeyDisabledAlert(ctx, "model_key", key, disabledReason)
 }
 
 // 保存模型密钥列表到缓存
@@ -357,19 +407,26 @@ func (s *sKey) SaveCacheModelKeys(ctx context.Context, id string, keys []*model.
 		logger.Debugf(ctx, "sKey SaveCacheModelKeys time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	// 写入Redis前加密上游密钥
+	sealedKeys, err := common.SealKeys(keys)
+	if err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
 	fields := g.Map{}
-	for _, key := range keys {
+	for _, key := range sealedKeys {
 		fields[key.Id] = key
 	}
 
 	if len(fields) > 0 {
 
-		if _, err := redis.HSet(ctx, fmt.Sprintf(consts.API_MODEL_KEYS_KEY, id), fields); err != nil {
+		if err := s.modelKeysCache.Set(ctx, id, keys, 0); err != nil {
 			logger.Error(ctx, err)
 			return err
 		}
 
-		if err := s.modelKeysCache.Set(ctx, id, keys, 0); err != nil {
+		if _, err := redis.HSet(ctx, fmt.Sprintf(consts.API_MODEL_KEYS_KEY, id), fields); err != nil {
 			logger.Error(ctx, err)
 			return err
 		}
@@ -378,6 +435,32 @@ func (s *sKey) SaveCacheModelKeys(ctx context.Context, id string, keys []*model.
 	return nil
 }
 
+// 从数据库加载模型密钥列表并保存到缓存, 同一模型并发请求只加载一次, Redis不可用时只保存到本地缓存
+func (s *sKey) loadModelKeys(ctx context.Context, id string) ([]*model.Key, error) {
+
+	// 共享的加载不受首个请求取消的影响
+	ctx = context.WithoutCancel(ctx)
+
+	value, err, _ := s.group.Do(id, func() (interface{}, error) {
+
+		modelKeys, err := s.GetModelKeys(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = s.SaveCacheModelKeys(ctx, id, modelKeys); err != nil {
+			logger.Error(ctx, err)
+		}
+
+		return modelKeys, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value.([]*model.Key), nil
+}
+
 // 获取缓存中的模型密钥列表
 func (s *sKey) GetCacheModelKeys(ctx context.Context, id string) ([]*model.Key, error) {
 
@@ -410,7 +493,7 @@ func (s *sKey) GetCacheModelKeys(ctx context.Context, id string) ([]*model.Key,
 		result := new(model.Key)
 		if err = gjson.Unmarshal([]byte(str), &result); err != nil {
 			logger.Error(ctx, err)
-			return nil, err
+			continue
 		}
 
 		if result.Status == 1 {
@@ -448,6 +531,7 @@ func (s *sKey) CreateCacheModelKey(ctx context.Context, key *entity.Key) {
 		AppId:               key.AppId,
 		Corp:                key.Corp,
 		Key:                 key.Key,
+		KeyHash:             key.KeyHash,
 		Type:                key.Type,
 		Weight:              key.Weight,
 		Models:              key.Models,
@@ -457,6 +541,7 @@ func (s *sKey) CreateCacheModelKey(ctx context.Context, key *entity.Key) {
 		UsedQuota:           key.UsedQuota,
 		QuotaExpiresRule:    key.QuotaExpiresRule,
 		QuotaExpiresAt:      key.QuotaExpiresAt,
+		Budgets:             key.Budgets,
 		QuotaExpiresMinutes: key.QuotaExpiresMinutes,
 		IpWhitelist:         key.IpWhitelist,
 		IpBlacklist:         key.IpBlacklist,
@@ -500,6 +585,7 @@ func (s *sKey) UpdateCacheModelKey(ctx context.Context, oldData *entity.Key, new
 		UsedQuota:           newData.UsedQuota,
 		QuotaExpiresRule:    newData.QuotaExpiresRule,
 		QuotaExpiresAt:      newData.QuotaExpiresAt,
+		Budgets:             newData.Budgets,
 		QuotaExpiresMinutes: newData.QuotaExpiresMinutes,
 		IpWhitelist:         newData.IpWhitelist,
 		IpBlacklist:         newData.IpBlacklist,
@@ -637,14 +723,14 @@ func (s *sKey) RemoveCacheModelKey(ctx context.Context, key *entity.Key) {
 }
 
 // 密钥已用额度
-func (s *sKey) UsedQuota(ctx context.Context, key string, quota int) error {
+func (s *sKey) UsedQuota(ctx context.Context, id string, quota int) error {
 
 	now := gtime.TimestampMilli()
 	defer func() {
 		logger.Debugf(ctx, "sKey UsedQuota time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	if err := dao.Key.UpdateOne(ctx, bson.M{"key": key}, bson.M{
+	if err := dao.Key.UpdateById(ctx, id, bson.M{
 		"$inc": bson.M{
 			"used_quota": quota,
 		},
@@ -671,11 +757,20 @@ func (s *sKey) Subscribe(ctx context.Context, msg string) error {
 	}
 	logger.Infof(ctx, "sKey Subscribe: %s", gjson.MustEncodeString(message))
 
-	var key *entity.Key
+	var (
+		key *entity.Key
+		err error
+	)
+
 	switch message.Action {
 	case consts.ACTION_CREATE:
 
-		if err := gjson.Unmarshal(gjson.MustEncode(message.NewData), &key); err != nil {
+		if err = gjson.Unmarshal(gjson.MustEncode(message.NewData), &key); err != nil {
+			logger.Error(ctx, err)
+			return err
+		}
+
+		if key, err = s.reloadRedactedKey(ctx, key); err != nil {
 			logger.Error(ctx, err)
 			return err
 		}
@@ -690,12 +785,17 @@ func (s *sKey) Subscribe(ctx context.Context, msg string) error {
 	case consts.ACTION_UPDATE, consts.ACTION_MODELS:
 
 		var oldData *entity.Key
-		if err := gjson.Unmarshal(gjson.MustEncode(message.OldData), &oldData); err != nil {
+		if err = gjson.Unmarshal(gjson.MustEncode(message.OldData), &oldData); err != nil {
+			logger.Error(ctx, err)
+			return err
+		}
+
+		if err = gjson.Unmarshal(gjson.MustEncode(message.NewData), &key); err != nil {
 			logger.Error(ctx, err)
 			return err
 		}
 
-		if err := gjson.Unmarshal(gjson.MustEncode(message.NewData), &key); err != nil {
+		if key, err = s.reloadRedactedKey(ctx, key); err != nil {
 			logger.Error(ctx, err)
 			return err
 		}
@@ -717,7 +817,12 @@ func (s *sKey) Subscribe(ctx context.Context, msg string) error {
 
 	case consts.ACTION_STATUS:
 
-		if err := gjson.Unmarshal(gjson.MustEncode(message.NewData), &key); err != nil {
+		if err = gjson.Unmarshal(gjson.MustEncode(message.NewData), &key); err != nil {
+			logger.Error(ctx, err)
+			return err
+		}
+
+		if key, err = s.reloadRedactedKey(ctx, key); err != nil {
 			logger.Error(ctx, err)
 			return err
 		}
@@ -735,13 +840,13 @@ func (s *sKey) Subscribe(ctx context.Context, msg string) error {
 
 	case consts.ACTION_DELETE:
 
-		if err := gjson.Unmarshal(gjson.MustEncode(message.OldData), &key); err != nil {
+		if err = gjson.Unmarshal(gjson.MustEncode(message.OldData), &key); err != nil {
 			logger.Error(ctx, err)
 			return err
 		}
 
 		if key.Type == 1 {
-			service.App().RemoveCacheAppKey(ctx, key.Key)
+			service.App().RemoveCacheAppKey(ctx, cmp.Or(key.KeyHash, crypto.HashKey(key.Key)))
 		} else {
 			if key.IsAgentsOnly {
 				service.ModelAgent().RemoveCacheModelAgentKey(ctx, key)
@@ -754,3 +859,13 @@ func (s *sKey) Subscribe(ctx context.Context, msg string) error {
 
 	return nil
 }
+
+// 变更通知中的模型密钥已脱敏, 按ID重新读取
+func (s *sKey) reloadRedactedKey(ctx context.Context, key *entity.Key) (*entity.Key, error) {
+
+	if key == nil || key.Type == 1 || !crypto.IsKeyPrefix(key.Key) {
+		return key, nil
+	}
+
+	return dao.Key.FindById(ctx, key.Id)
+}
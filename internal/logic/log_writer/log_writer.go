@@ -0,0 +1,488 @@
+package log_writer
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/gogf/gf/v2/os/grpool"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/dao"
+	"github.com/iimeta/fastapi/internal/model"
+	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type sLogWriter struct {
+	queue          chan *entry
+	flush          chan chan struct{}
+	closed         chan struct{}
+	done           chan struct{}
+	batchSize      int
+	flushInterval  time.Duration
+	replayInterval time.Duration
+	sinks          []*logSink
+	spillMutex     sync.Mutex
+	startOnce      sync.Once
+	closeOnce      sync.Once
+	enqueued       atomic.Int64
+	written        atomic.Int64
+	rejected       atomic.Int64
+	spilled        atomic.Int64
+	replayed       atomic.Int64
+	failed         atomic.Int64
+	legacyWarned   map[string]bool // 已告警删除历史日志的集合, 只在清理任务中使用
+}
+
+type entry struct {
+	collection string
+	document   bson.M
+}
+
+func init() {
+	service.RegisterLogWriter(New())
+}
+
+func New() service.ILogWriter {
+
+	queueSize := config.Cfg.LogWriter.QueueSize
+	if queueSize <= 0 {
+		queueSize = 10000
+	}
+
+	batchSize := config.Cfg.LogWriter.BatchSize
+	if batchSize <= 0 {
+		batchSize = 200
+	}
+
+	flushInterval := config.Cfg.LogWriter.FlushInterval
+	if flushInterval <= 0 {
+		flushInterval = 1
+	}
+
+	replayInterval := config.Cfg.LogWriter.ReplayInterval
+	if replayInterval <= 0 {
+		replayInterval = 30
+	}
+
+	spillFile := config.Cfg.LogWriter.SpillFile
+	if spillFile == "" {
+		spillFile = "./resource/log/spill.log"
+	}
+
+	sinks, err := newSinks(spillFile)
+	if err != nil {
+		panic(err)
+	}
+
+	return &sLogWriter{
+		queue:          make(chan *entry, queueSize),
+		legacyWarned:   make(map[string]bool),
+		flush:          make(chan chan struct{}),
+		closed:         make(chan struct{}),
+		done:           make(chan struct{}),
+		batchSize:      batchSize,
+		flushInterval:  flushInterval * time.Second,
+		replayInterval: replayInterval * time.Second,
+		sinks:          sinks,
+	}
+}
+
+// 启动写入, 回放和清理任务
+func (s *sLogWriter) Start(ctx context.Context) {
+	s.startOnce.Do(func() {
+
+		if err := grpool.AddWithRecover(gctx.NeverDone(ctx), s.run, nil); err != nil {
+			logger.Error(ctx, err)
+		}
+
+		if err := grpool.AddWithRecover(gctx.NeverDone(ctx), s.replayLoop, nil); err != nil {
+			logger.Error(ctx, err)
+		}
+
+		if s.isMongoDB() {
+			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), s.pruneLoop, nil); err != nil {
+				logger.Error(ctx, err)
+			}
+		}
+	})
+}
+
+// 写入日志, 入队后异步批量写入, 队列已满时写入溢出文件
+func (s *sLogWriter) Write(ctx context.Context, document interface{}) error {
+
+	// 在请求上下文中生成主键和创建人
+	collection, value, err := dao.NewDocument(ctx, document)
+	if err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
+	s.retention(ctx, collection, value)
+
+	select {
+	case <-s.closed:
+		s.insert(ctx, collection, []bson.M{value})
+		return nil
+	default:
+	}
+
+	select {
+	case s.queue <- &entry{collection: collection, document: value}:
+		s.enqueued.Add(1)
+		return nil
+	default:
+		s.rejected.Add(1)
+		logger.Errorf(ctx, "sLogWriter Write queue is full, collection: %s, spill to file", collection)
+		for _, sink := range s.sinks {
+			if sink.accept(collection) {
+				// 单个输出溢出失败时继续写入其他输出的溢出文件
+				if err = s.spill(ctx, sink, collection, []bson.M{value}); err != nil {
+					s.failed.Add(1)
+					logger.Errorf(ctx, "sLogWriter Write spill sink: %s, collection: %s, error: %v", sink.name, collection, err)
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// 立即写入队列中的日志
+func (s *sLogWriter) Flush(ctx context.Context) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sLogWriter Flush time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	done := make(chan struct{})
+
+	select {
+	case s.flush <- done:
+		<-done
+	case <-s.done:
+	}
+}
+
+// 停止写入任务, 写入队列中剩余的日志
+func (s *sLogWriter) Close(ctx context.Context) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Infof(ctx, "sLogWriter Close time: %d, stats: %s", gtime.TimestampMilli()-now, gjson.MustEncodeString(s.Stats()))
+	}()
+
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+
+	// 未启动时直接写入
+	started := true
+	s.startOnce.Do(func() {
+		started = false
+	})
+
+	if started {
+		<-s.done
+	}
+
+	batch := make(map[string][]bson.M)
+	s.drain(batch)
+	for collection, documents := range batch {
+		s.insert(ctx, collection, documents)
+	}
+
+	for _, sink := range s.sinks {
+		if err := sink.Close(ctx); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
+}
+
+// 写入统计
+func (s *sLogWriter) Stats() *model.LogWriterStats {
+
+	stats := &model.LogWriterStats{
+		Enqueued: s.enqueued.Load(),
+		Written:  s.written.Load(),
+		Rejected: s.rejected.Load(),
+		Spilled:  s.spilled.Load(),
+		Replayed: s.replayed.Load(),
+		Failed:   s.failed.Load(),
+		QueueLen: len(s.queue),
+		QueueCap: cap(s.queue),
+	}
+
+	for _, sink := range s.sinks {
+		if gfile.Exists(sink.spillFile) {
+			stats.SpillSize += gfile.Size(sink.spillFile)
+		}
+	}
+
+	return stats
+}
+
+// 写入任务, 按批量大小或刷新间隔批量写入
+func (s *sLogWriter) run(ctx context.Context) {
+
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make(map[string][]bson.M)
+	size := 0
+
+	write := func() {
+		for collection, documents := range batch {
+			s.insert(ctx, collection, documents)
+		}
+		clear(batch)
+		size = 0
+	}
+
+	for {
+		select {
+		case e := <-s.queue:
+			batch[e.collection] = append(batch[e.collection], e.document)
+			if size++; size >= s.batchSize {
+				write()
+			}
+		case <-ticker.C:
+			write()
+		case done := <-s.flush:
+			s.drain(batch)
+			write()
+			close(done)
+		case <-s.closed:
+			s.drain(batch)
+			write()
+			return
+		}
+	}
+}
+
+// 取出队列中的全部日志
+func (s *sLogWriter) drain(batch map[string][]bson.M) {
+	for {
+		select {
+		case e := <-s.queue:
+			batch[e.collection] = append(batch[e.collection], e.document)
+		default:
+			return
+		}
+	}
+}
+
+// 批量写入各日志输出, 失败时写入该输出的溢出文件
+func (s *sLogWriter) insert(ctx context.Context, collection string, documents []bson.M) {
+
+	if len(documents) == 0 {
+		return
+	}
+
+	for _, sink := range s.sinks {
+
+		if !sink.accept(collection) {
+			continue
+		}
+
+		if err := sink.Write(ctx, collection, documents); err != nil {
+			s.failed.Add(int64(len(documents)))
+			logger.Errorf(ctx, "sLogWriter insert sink: %s, collection: %s, size: %d, error: %v", sink.name, collection, len(documents), err)
+			if err = s.spill(ctx, sink, collection, documents); err != nil {
+				logger.Error(ctx, err)
+			}
+			continue
+		}
+
+		s.written.Add(int64(len(documents)))
+	}
+}
+
+// 写入溢出文件, 每行格式: 集合名称\tBASE64(BSON)
+func (s *sLogWriter) spill(ctx context.Context, sink *logSink, collection string, documents []bson.M) error {
+
+	s.spillMutex.Lock()
+	defer s.spillMutex.Unlock()
+
+	if err := gfile.Mkdir(gfile.Dir(sink.spillFile)); err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
+	file, err := os.OpenFile(sink.spillFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
+	defer func() {
+		if err := file.Close(); err != nil {
+			logger.Error(ctx, err)
+		}
+	}()
+
+	writer := bufio.NewWriter(file)
+
+	for _, document := range documents {
+
+		bytes, err := bson.Marshal(document)
+		if err != nil {
+			logger.Error(ctx, err)
+			continue
+		}
+
+		if _, err = writer.WriteString(collection + "\t" + base64.StdEncoding.EncodeToString(bytes) + "\n"); err != nil {
+			logger.Error(ctx, err)
+			return err
+		}
+
+		s.spilled.Add(1)
+	}
+
+	return writer.Flush()
+}
+
+// 回放任务, 定时回放溢出文件
+func (s *sLogWriter) replayLoop(ctx context.Context) {
+
+	ticker := time.NewTicker(s.replayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+
+			for _, sink := range s.sinks {
+				s.replay(ctx, sink)
+			}
+
+			if stats := s.Stats(); stats.QueueLen > stats.QueueCap/2 || stats.SpillSize > 0 {
+				logger.Infof(ctx, "sLogWriter stats: %s", gjson.MustEncodeString(stats))
+			}
+
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// 回放溢出文件, 日志输出仍不可用时剩余日志重新写入溢出文件
+func (s *sLogWriter) replay(ctx context.Context, sink *logSink) {
+
+	replayFile := sink.spillFile + ".replay"
+
+	// 上次回放中断时先回放遗留的文件
+	if !gfile.Exists(replayFile) {
+
+		s.spillMutex.Lock()
+
+		if !gfile.Exists(sink.spillFile) {
+			s.spillMutex.Unlock()
+			return
+		}
+
+		err := gfile.Rename(sink.spillFile, replayFile)
+
+		s.spillMutex.Unlock()
+
+		if err != nil {
+			logger.Error(ctx, err)
+			return
+		}
+	}
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Infof(ctx, "sLogWriter replay sink: %s, time: %d", sink.name, gtime.TimestampMilli()-now)
+	}()
+
+	file, err := os.Open(replayFile)
+	if err != nil {
+		logger.Error(ctx, err)
+		return
+	}
+
+	available := true
+	batch := make(map[string][]bson.M)
+
+	write := func(collection string) {
+
+		documents := batch[collection]
+		delete(batch, collection)
+
+		if available {
+
+			err := sink.Write(ctx, collection, documents)
+			if err == nil {
+				s.replayed.Add(int64(len(documents)))
+				return
+			}
+
+			available = false
+			logger.Errorf(ctx, "sLogWriter replay sink: %s, collection: %s, size: %d, error: %v", sink.name, collection, len(documents), err)
+		}
+
+		if err := s.spill(ctx, sink, collection, documents); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+
+	for scanner.Scan() {
+
+		fields := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(fields) != 2 {
+			logger.Errorf(ctx, "sLogWriter replay invalid line: %s", scanner.Text())
+			continue
+		}
+
+		collection, data := fields[0], fields[1]
+
+		bytes, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			logger.Error(ctx, err)
+			continue
+		}
+
+		document := bson.M{}
+		if err = bson.Unmarshal(bytes, &document); err != nil {
+			logger.Error(ctx, err)
+			continue
+		}
+
+		if batch[collection] = append(batch[collection], document); len(batch[collection]) >= s.batchSize {
+			write(collection)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		logger.Error(ctx, err)
+	}
+
+	for collection := range batch {
+		write(collection)
+	}
+
+	if err = file.Close(); err != nil {
+		logger.Error(ctx, err)
+	}
+
+	if err = scanner.Err(); err == nil {
+		if err = gfile.Remove(replayFile); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
+}
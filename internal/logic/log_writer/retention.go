@@ -0,0 +1,212 @@
+package log_writer
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/dao"
+	"github.com/iimeta/fastapi/internal/logic/common"
+	"github.com/iimeta/fastapi/utility/db"
+	"github.com/iimeta/fastapi/utility/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"slices"
+	"time"
+)
+
+// 调用日志集合
+var logCollections = []string{"chat", "image", "audio", "midjourney"}
+
+// 日志内容对应的字段
+var retentionFields = map[string][]string{
+	"prompt":     {"prompt", "prompt_en", "input"},
+	"completion": {"completion", "text"},
+	"messages":   {"messages"},
+}
+
+const dayMilli = int64(24 * time.Hour / time.Millisecond)
+
+// 设置过期时间, expires_at到期后由TTL索引删除日志, retention记录各内容的过期时间, 到期后由清理任务清空内容
+func (s *sLogWriter) retention(ctx context.Context, collection string, document bson.M) {
+
+	if !slices.Contains(logCollections, collection) {
+		return
+	}
+
+	createdAt := gconv.Int64(document["created_at"])
+
+	if days := config.Cfg.Retention.Collections[collection]; days > 0 {
+		document["expires_at"] = time.UnixMilli(createdAt).AddDate(0, 0, days)
+	}
+
+	// 没有过期内容时也写入, 用于区分未设置过期时间的历史日志
+	retention := bson.M{}
+	for field, keys := range retentionFields {
+
+		days := common.GetLogRetention(ctx, field)
+		if days <= 0 {
+			continue
+		}
+
+		for _, key := range keys {
+			if _, ok := document[key]; ok {
+				retention[field] = createdAt + int64(days)*dayMilli
+				break
+			}
+		}
+	}
+
+	document["retention"] = retention
+}
+
+// 清理任务, 定时清空过期内容和删除历史日志
+func (s *sLogWriter) pruneLoop(ctx context.Context) {
+
+	interval := config.Cfg.Retention.PruneInterval
+	if interval <= 0 {
+		interval = 3600
+	}
+
+	ticker := time.NewTicker(interval * time.Second)
+	defer ticker.Stop()
+
+	indexed := false
+
+	for {
+
+		if !indexed {
+			indexed = s.createIndexes(ctx)
+		}
+
+		s.prune(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// 创建TTL索引和内容过期时间索引, 返回是否全部创建成功
+func (s *sLogWriter) createIndexes(ctx context.Context) bool {
+
+	models := []mongo.IndexModel{{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}}
+
+	for field := range retentionFields {
+		models = append(models, mongo.IndexModel{
+			Keys:    bson.D{{Key: "retention." + field, Value: 1}},
+			Options: options.Index().SetSparse(true),
+		})
+	}
+
+	for _, collection := range logCollections {
+
+		m := &db.MongoDB{
+			Database:   db.DefaultDatabase,
+			Collection: collection,
+		}
+
+		if _, err := m.CreateIndexes(ctx, models); err != nil {
+			logger.Errorf(ctx, "sLogWriter createIndexes collection: %s, error: %v", collection, err)
+			return false
+		}
+	}
+
+	return true
+}
+
+// 清空过期内容, 未设置过期时间的历史日志按创建时间和全局配置清理
+func (s *sLogWriter) prune(ctx context.Context) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sLogWriter prune time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	for _, collection := range logCollections {
+
+		if days := config.Cfg.Retention.Collections[collection]; days > 0 {
+			s.pruneLegacy(ctx, collection, now-int64(days)*dayMilli)
+		}
+
+		for field, keys := range retentionFields {
+
+			unset := bson.M{}
+			exists := bson.A{}
+			for _, key := range keys {
+				unset[key] = ""
+				exists = append(exists, bson.M{key: bson.M{"$exists": true}})
+			}
+
+			expired := bson.M{"retention." + field: ""}
+			for key := range unset {
+				expired[key] = ""
+			}
+
+			filter := bson.M{"retention." + field: bson.M{"$lte": now}}
+
+			if err := dao.UpdateMany(ctx, db.DefaultDatabase, collection, filter, bson.M{"$unset": expired}); err != nil {
+				logger.Errorf(ctx, "sLogWriter prune collection: %s, field: %s, error: %v", collection, field, err)
+			}
+
+			if days := config.Cfg.Retention.Fields[field]; days > 0 {
+
+				filter = bson.M{
+					"retention":  bson.M{"$exists": false},
+					"created_at": bson.M{"$lt": now - int64(days)*dayMilli},
+					"$or":        exists,
+				}
+
+				if err := dao.UpdateMany(ctx, db.DefaultDatabase, collection, filter, bson.M{"$unset": unset}); err != nil {
+					logger.Errorf(ctx, "sLogWriter prune collection: %s, field: %s, error: %v", collection, field, err)
+				}
+			}
+		}
+	}
+}
+
+// 删除未设置过期时间且创建时间早于before的历史日志, 首次删除前告警, 便于确认保留天数配置
+func (s *sLogWriter) pruneLegacy(ctx context.Context, collection string, before int64) {
+
+	filter := bson.M{
+		"expires_at": bson.M{"$exists": false},
+		"created_at": bson.M{"$lt": before},
+	}
+
+	if !s.legacyWarned[collection] {
+
+		count, err := dao.CountDocuments(ctx, db.DefaultDatabase, collection, filter)
+		if err != nil {
+			logger.Errorf(ctx, "sLogWriter pruneLegacy collection: %s, error: %v", collection, err)
+			return
+		}
+
+		if count > 0 {
+			logger.Warningf(ctx, "sLogWriter pruneLegacy collection: %s, deleting %d legacy logs without expires_at created before %s", collection, count, gtime.NewFromTimeStamp(before).String())
+		}
+
+		s.legacyWarned[collection] = true
+	}
+
+	if deleted, err := dao.DeleteMany(ctx, db.DefaultDatabase, collection, filter); err != nil {
+		logger.Errorf(ctx, "sLogWriter prune collection: %s, error: %v", collection, err)
+	} else if deleted > 0 {
+		logger.Infof(ctx, "sLogWriter prune collection: %s, deleted: %d", collection, deleted)
+	}
+}
+
+// 是否写入MongoDB
+func (s *sLogWriter) isMongoDB() bool {
+	for _, sink := range s.sinks {
+		if _, ok := sink.sink.(*mongoDBSink); ok {
+			return true
+		}
+	}
+	return false
+}
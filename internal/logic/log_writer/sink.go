@@ -0,0 +1,95 @@
+package log_writer
+
+import (
+	"context"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"slices"
+	"strings"
+)
+
+// 日志输出
+type sink interface {
+	// 批量写入日志, collection为日志集合名称, 如: chat, image, audio, midjourney
+	Write(ctx context.Context, collection string, documents []bson.M) error
+	// 关闭并释放资源
+	Close(ctx context.Context) error
+}
+
+// 带配置的日志输出
+type logSink struct {
+	sink
+	name        string
+	collections []string
+	spillFile   string
+}
+
+// 是否输出该集合的日志
+func (l *logSink) accept(collection string) bool {
+	return len(l.collections) == 0 || slices.Contains(l.collections, collection)
+}
+
+// 根据配置创建日志输出, 未配置时只写入MongoDB
+func newSinks(spillFile string) ([]*logSink, error) {
+
+	sinkConfigs := config.Cfg.LogWriter.Sinks
+	if len(sinkConfigs) == 0 {
+		sinkConfigs = []config.LogSink{{Type: consts.LOG_SINK_MONGODB}}
+	}
+
+	sinks := make([]*logSink, 0, len(sinkConfigs))
+	names := make(map[string]bool)
+
+	for _, sinkConfig := range sinkConfigs {
+
+		var (
+			s   sink
+			err error
+		)
+
+		switch sinkConfig.Type {
+		case consts.LOG_SINK_MONGODB:
+			s = newMongoDBSink()
+		case consts.LOG_SINK_FILE:
+			s, err = newFileSink(sinkConfig)
+		case consts.LOG_SINK_KAFKA:
+			s, err = newKafkaSink(sinkConfig)
+		case consts.LOG_SINK_CLICKHOUSE:
+			s, err = newClickHouseSink(sinkConfig)
+		default:
+			err = errors.Newf("log sink type %s is not supported", sinkConfig.Type)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		name := sinkConfig.Name
+		if name == "" {
+			name = sinkConfig.Type
+		}
+
+		if names[name] {
+			return nil, errors.Newf("log sink name %s is duplicated", name)
+		}
+		names[name] = true
+
+		l := &logSink{
+			sink:        s,
+			name:        name,
+			collections: sinkConfig.Collections,
+			spillFile:   spillFile,
+		}
+
+		// MongoDB沿用原溢出文件, 其它输出使用各自的溢出文件
+		if sinkConfig.Type != consts.LOG_SINK_MONGODB {
+			l.spillFile = strings.TrimSuffix(spillFile, ".log") + "." + name + ".log"
+		}
+
+		sinks = append(sinks, l)
+	}
+
+	return sinks, nil
+}
@@ -0,0 +1,96 @@
+package log_writer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ClickHouse日志输出, 通过HTTP接口以JSONEachRow格式写入, 表中不存在的字段会被忽略
+type clickHouseSink struct {
+	url      string
+	database string
+	table    string
+	headers  map[string]string
+}
+
+func newClickHouseSink(sinkConfig config.LogSink) (*clickHouseSink, error) {
+
+	if sinkConfig.Url == "" {
+		return nil, errors.New("clickhouse log sink url is not configured")
+	}
+
+	s := &clickHouseSink{
+		url:      strings.TrimSuffix(sinkConfig.Url, "/"),
+		database: sinkConfig.Database,
+		table:    sinkConfig.Table,
+		headers:  make(map[string]string),
+	}
+
+	if s.database == "" {
+		s.database = "default"
+	}
+
+	if s.table == "" {
+		s.table = "{collection}"
+	}
+
+	for key, value := range sinkConfig.Headers {
+		s.headers[key] = value
+	}
+
+	if sinkConfig.Username != "" {
+		s.headers["X-ClickHouse-User"] = sinkConfig.Username
+		s.headers["X-ClickHouse-Key"] = sinkConfig.Password
+	}
+
+	return s, nil
+}
+
+// 批量写入, 表名中的{collection}替换为集合名称
+func (s *clickHouseSink) Write(ctx context.Context, collection string, documents []bson.M) error {
+
+	builder := strings.Builder{}
+	for _, document := range documents {
+
+		bytes, err := json.Marshal(document)
+		if err != nil {
+			return err
+		}
+
+		builder.Write(bytes)
+		builder.WriteByte('\n')
+	}
+
+	query := fmt.Sprintf("INSERT INTO `%s`.`%s` FORMAT JSONEachRow", s.database, strings.ReplaceAll(s.table, "{collection}", collection))
+
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("input_format_skip_unknown_fields", "1")
+
+	response, err := g.Client().Timeout(config.Cfg.Http.Timeout*time.Second).SetHeaderMap(s.headers).Post(ctx, s.url+"/?"+params.Encode(), builder.String())
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = response.Close()
+	}()
+
+	if response.StatusCode != 200 {
+		return errors.Newf("clickhouse insert status code: %d, response: %s", response.StatusCode, response.ReadAllString())
+	}
+
+	return nil
+}
+
+func (s *clickHouseSink) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,123 @@
+package log_writer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/internal/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// JSONL文件日志输出, 每个集合一个文件, 超过大小后滚动
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	mutex      sync.Mutex
+}
+
+func newFileSink(sinkConfig config.LogSink) (*fileSink, error) {
+
+	s := &fileSink{
+		path:       sinkConfig.Path,
+		maxSize:    sinkConfig.MaxSize * 1024 * 1024,
+		maxBackups: sinkConfig.MaxBackups,
+	}
+
+	if s.path == "" {
+		s.path = "./resource/log/sink"
+	}
+
+	if s.maxSize <= 0 {
+		s.maxSize = 100 * 1024 * 1024
+	}
+
+	if err := gfile.Mkdir(s.path); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// 批量写入, 每行一条JSON格式的日志
+func (s *fileSink) Write(ctx context.Context, collection string, documents []bson.M) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	builder := strings.Builder{}
+	for _, document := range documents {
+
+		bytes, err := json.Marshal(document)
+		if err != nil {
+			return err
+		}
+
+		builder.Write(bytes)
+		builder.WriteByte('\n')
+	}
+
+	fileName := filepath.Join(s.path, collection+".jsonl")
+
+	if err := s.rotate(collection, fileName, int64(builder.Len())); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.WriteString(builder.String()); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func (s *fileSink) Close(ctx context.Context) error {
+	return nil
+}
+
+// 写入后超过大小时滚动, 滚动文件名: 集合名称-时间.jsonl, 超过保留数量时删除最旧的文件
+func (s *fileSink) rotate(collection, fileName string, size int64) error {
+
+	if !gfile.Exists(fileName) || gfile.Size(fileName)+size <= s.maxSize {
+		return nil
+	}
+
+	if err := gfile.Rename(fileName, filepath.Join(s.path, fmt.Sprintf("%s-%s.jsonl", collection, gtime.Now().Format("YmdHisu")))); err != nil {
+		return err
+	}
+
+	if s.maxBackups <= 0 {
+		return nil
+	}
+
+	backups, err := filepath.Glob(filepath.Join(s.path, collection+"-*.jsonl"))
+	if err != nil {
+		return err
+	}
+
+	// 文件名包含时间, 按名称排序即按时间排序
+	slices.Sort(backups)
+
+	for len(backups) > s.maxBackups {
+
+		if err = gfile.Remove(backups[0]); err != nil {
+			return err
+		}
+
+		backups = backups[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package log_writer
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/utility/kafka"
+	"go.mongodb.org/mongo-driver/bson"
+	"strings"
+	"time"
+)
+
+// Kafka日志输出, 兼容Kafka协议的消息队列均可使用, 如: Redpanda
+type kafkaSink struct {
+	topic    string
+	producer *kafka.Producer
+}
+
+func newKafkaSink(sinkConfig config.LogSink) (*kafkaSink, error) {
+
+	if len(sinkConfig.Brokers) == 0 {
+		return nil, errors.New("kafka log sink brokers is not configured")
+	}
+
+	topic := sinkConfig.Topic
+	if topic == "" {
+		topic = "fastapi_{collection}"
+	}
+
+	timeout := config.Cfg.Http.Timeout * time.Second
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+
+	return &kafkaSink{
+		topic:    topic,
+		producer: kafka.NewProducer(sinkConfig.Brokers, "fastapi", timeout),
+	}, nil
+}
+
+// 批量写入, 主题中的{collection}替换为集合名称, 消息键为日志ID
+func (s *kafkaSink) Write(ctx context.Context, collection string, documents []bson.M) error {
+
+	messages := make([]kafka.Message, 0, len(documents))
+	for _, document := range documents {
+
+		value, err := json.Marshal(document)
+		if err != nil {
+			return err
+		}
+
+		messages = append(messages, kafka.Message{
+			Key:   []byte(gconv.String(document["_id"])),
+			Value: value,
+		})
+	}
+
+	return s.producer.Produce(ctx, strings.ReplaceAll(s.topic, "{collection}", collection), messages)
+}
+
+func (s *kafkaSink) Close(ctx context.Context) error {
+	return s.producer.Close()
+}
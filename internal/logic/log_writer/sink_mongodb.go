@@ -0,0 +1,47 @@
+package log_writer
+
+import (
+	"context"
+	"github.com/iimeta/fastapi/internal/dao"
+	"github.com/iimeta/fastapi/utility/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MongoDB日志输出
+type mongoDBSink struct{}
+
+func newMongoDBSink() *mongoDBSink {
+	return &mongoDBSink{}
+}
+
+// 批量写入, 主键重复说明回放前已写入过, 逐条写入跳过重复的日志
+func (s *mongoDBSink) Write(ctx context.Context, collection string, documents []bson.M) error {
+
+	values := make([]interface{}, 0, len(documents))
+	for _, document := range documents {
+		values = append(values, document)
+	}
+
+	_, err := dao.InsertDocuments(ctx, db.DefaultDatabase, collection, values)
+	if err == nil || !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	m := &db.MongoDB{
+		Database:   db.DefaultDatabase,
+		Collection: collection,
+	}
+
+	for _, value := range values {
+		if _, err = m.InsertOne(ctx, value); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *mongoDBSink) Close(ctx context.Context) error {
+	return nil
+}
@@ -5,22 +5,28 @@
 package logic
 
 import (
+	_ "github.com/iimeta/fastapi/internal/logic/admin"
+	_ "github.com/iimeta/fastapi/internal/logic/alert"
 	_ "github.com/iimeta/fastapi/internal/logic/app"
 	_ "github.com/iimeta/fastapi/internal/logic/audio"
 	_ "github.com/iimeta/fastapi/internal/logic/auth"
+	_ "github.com/iimeta/fastapi/internal/logic/billing"
 	_ "github.com/iimeta/fastapi/internal/logic/chat"
 	_ "github.com/iimeta/fastapi/internal/logic/common"
 	_ "github.com/iimeta/fastapi/internal/logic/corp"
 	_ "github.com/iimeta/fastapi/internal/logic/dashboard"
 	_ "github.com/iimeta/fastapi/internal/logic/embedding"
 	_ "github.com/iimeta/fastapi/internal/logic/file"
+	_ "github.com/iimeta/fastapi/internal/logic/health"
 	_ "github.com/iimeta/fastapi/internal/logic/image"
 	_ "github.com/iimeta/fastapi/internal/logic/key"
+	_ "github.com/iimeta/fastapi/internal/logic/log_writer"
 	_ "github.com/iimeta/fastapi/internal/logic/midjourney"
 	_ "github.com/iimeta/fastapi/internal/logic/model"
 	_ "github.com/iimeta/fastapi/internal/logic/model_agent"
 	_ "github.com/iimeta/fastapi/internal/logic/moderation"
 	_ "github.com/iimeta/fastapi/internal/logic/realtime"
 	_ "github.com/iimeta/fastapi/internal/logic/session"
+	_ "github.com/iimeta/fastapi/internal/logic/token"
 	_ "github.com/iimeta/fastapi/internal/logic/user"
 )
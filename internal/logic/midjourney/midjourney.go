@@ -7,23 +7,22 @@ import (
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/net/ghttp"
 	"github.com/gogf/gf/v2/os/gctx"
-	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/iimeta/fastapi-sdk"
 	sdkm "github.com/iimeta/fastapi-sdk/model"
 	"github.com/iimeta/fastapi/internal/config"
-	"github.com/iimeta/fastapi/internal/dao"
+	"github.com/iimeta/fastapi/internal/consts"
 	"github.com/iimeta/fastapi/internal/errors"
 	"github.com/iimeta/fastapi/internal/logic/common"
 	"github.com/iimeta/fastapi/internal/model"
 	mcommon "github.com/iimeta/fastapi/internal/model/common"
 	"github.com/iimeta/fastapi/internal/model/do"
 	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/graceful"
 	"github.com/iimeta/fastapi/utility/logger"
 	"github.com/iimeta/fastapi/utility/util"
 	"net/http"
-	"time"
 )
 
 type sMidjourney struct{}
@@ -75,18 +74,31 @@ func (s *sMidjourney) Submit(ctx context.Context, request *ghttp.Request, fallba
 		}
 
 		if retryInfo == nil && (err == nil || common.IsAborted(err)) && mak.ReqModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-				if err := service.Common().RecordUsage(ctx, usage.TotalTokens, mak.Key.Key); err != nil {
+
+			bill := service.Billing().Bill(ctx, &model.BillingReq{
+				Model:            mak.ReqModel,
+				Action:           consts.BILLING_ACTION_MIDJOURNEY,
+				MidjourneyAction: midjourneyQuota.Action,
+				RatioQuota:       usage.TotalTokens,
+			})
+			usage.TotalTokens = bill.Quota
+
+			if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+				if err := service.Common().RecordUsage(ctx, usage.TotalTokens, mak.Key.Id); err != nil {
 					logger.Error(ctx, err)
 					panic(err)
 				}
+
+				if err := service.Billing().Ledger(ctx, bill, mak.Key.Id); err != nil {
+					logger.Error(ctx, err)
+				}
 			}); err != nil {
 				logger.Error(ctx, err)
 			}
 		}
 
 		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
 
 				mak.RealModel.ModelAgent = mak.ModelAgent
 
@@ -132,10 +144,10 @@ func (s *sMidjourney) Submit(ctx context.Context, request *ghttp.Request, fallba
 		// 记录错误次数和禁用
 		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+		isRetry, isDisabled := common.IsNeedRetry(ctx, mak, err)
 
 		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
 				if mak.RealModel.IsEnableModelAgent {
 					service.ModelAgent().DisabledModelAgentKey(ctx, mak.Key, err.Error())
 				} else {
@@ -184,6 +196,12 @@ func (s *sMidjourney) Submit(ctx context.Context, request *ghttp.Request, fallba
 				ErrMsg:     err.Error(),
 			}
 
+			// 重试退避
+			if !common.RetryBackoff(ctx, mak, err, retryInfo) {
+				retryInfo.IsRetry = false
+				return response, err
+			}
+
 			return s.Submit(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel, append(retry, 1)...)
 		}
 
@@ -239,18 +257,31 @@ func (s *sMidjourney) Task(ctx context.Context, request *ghttp.Request, fallback
 		}
 
 		if retryInfo == nil && (err == nil || common.IsAborted(err)) && mak.ReqModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-				if err := service.Common().RecordUsage(ctx, usage.TotalTokens, mak.Key.Key); err != nil {
+
+			bill := service.Billing().Bill(ctx, &model.BillingReq{
+				Model:            mak.ReqModel,
+				Action:           consts.BILLING_ACTION_MIDJOURNEY,
+				MidjourneyAction: midjourneyQuota.Action,
+				RatioQuota:       usage.TotalTokens,
+			})
+			usage.TotalTokens = bill.Quota
+
+			if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+				if err := service.Common().RecordUsage(ctx, usage.TotalTokens, mak.Key.Id); err != nil {
 					logger.Error(ctx, err)
 					panic(err)
 				}
+
+				if err := service.Billing().Ledger(ctx, bill, mak.Key.Id); err != nil {
+					logger.Error(ctx, err)
+				}
 			}); err != nil {
 				logger.Error(ctx, err)
 			}
 		}
 
 		if mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
 
 				mak.RealModel.ModelAgent = mak.ModelAgent
 
@@ -295,10 +326,10 @@ func (s *sMidjourney) Task(ctx context.Context, request *ghttp.Request, fallback
 		// 记录错误次数和禁用
 		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+		isRetry, isDisabled := common.IsNeedRetry(ctx, mak, err)
 
 		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
 				if mak.RealModel.IsEnableModelAgent {
 					service.ModelAgent().DisabledModelAgentKey(ctx, mak.Key, err.Error())
 				} else {
@@ -347,6 +378,12 @@ func (s *sMidjourney) Task(ctx context.Context, request *ghttp.Request, fallback
 				ErrMsg:     err.Error(),
 			}
 
+			// 重试退避
+			if !common.RetryBackoff(ctx, mak, err, retryInfo) {
+				retryInfo.IsRetry = false
+				return response, err
+			}
+
 			return s.Task(g.RequestFromCtx(ctx).GetCtx(), request, fallbackModelAgent, fallbackModel, append(retry, 1)...)
 		}
 
@@ -377,7 +414,7 @@ func (s *sMidjourney) Task(ctx context.Context, request *ghttp.Request, fallback
 }
 
 // 保存日志
-func (s *sMidjourney) SaveLog(ctx context.Context, reqModel, realModel *model.Model, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, key *model.Key, response model.MidjourneyResponse, retryInfo *mcommon.Retry, retry ...int) {
+func (s *sMidjourney) SaveLog(ctx context.Context, reqModel, realModel *model.Model, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, key *model.Key, response model.MidjourneyResponse, retryInfo *mcommon.Retry) {
 
 	now := gtime.TimestampMilli()
 	defer func() {
@@ -393,11 +430,10 @@ func (s *sMidjourney) SaveLog(ctx context.Context, reqModel, realModel *model.Mo
 		TraceId:      gctx.CtxId(ctx),
 		UserId:       service.Session().GetUserId(ctx),
 		AppId:        service.Session().GetAppId(ctx),
+		EndUser:      service.Session().GetEndUser(ctx),
 		ReqUrl:       response.ReqUrl,
 		TaskId:       response.TaskId,
 		Action:       response.Action,
-		Prompt:       response.Prompt,
-		PromptEn:     response.PromptEn,
 		ImageUrl:     response.ImageUrl,
 		Progress:     response.Progress,
 		ConnTime:     response.ConnTime,
@@ -413,6 +449,11 @@ func (s *sMidjourney) SaveLog(ctx context.Context, reqModel, realModel *model.Mo
 		Host:         g.RequestFromCtx(ctx).GetHost(),
 	}
 
+	if common.IsRecordLog(ctx, "prompt") {
+		midjourney.Prompt = response.Prompt
+		midjourney.PromptEn = response.PromptEn
+	}
+
 	if reqModel != nil {
 		midjourney.Corp = reqModel.Corp
 		midjourney.ModelId = reqModel.Id
@@ -467,7 +508,7 @@ func (s *sMidjourney) SaveLog(ctx context.Context, reqModel, realModel *model.Mo
 	}
 
 	if key != nil {
-		midjourney.Key = key.Key
+		midjourney.Key = common.KeyRef(key)
 	}
 
 	if response.Response != nil {
@@ -492,6 +533,10 @@ func (s *sMidjourney) SaveLog(ctx context.Context, reqModel, realModel *model.Mo
 			IsRetry:    retryInfo.IsRetry,
 			RetryCount: retryInfo.RetryCount,
 			ErrMsg:     retryInfo.ErrMsg,
+			Status:     retryInfo.Status,
+			Code:       retryInfo.Code,
+			Delay:      retryInfo.Delay,
+			Decision:   retryInfo.Decision,
 		}
 
 		if midjourney.IsRetry {
@@ -500,19 +545,7 @@ func (s *sMidjourney) SaveLog(ctx context.Context, reqModel, realModel *model.Mo
 		}
 	}
 
-	if _, err := dao.Midjourney.Insert(ctx, midjourney); err != nil {
+	if err := service.LogWriter().Write(ctx, midjourney); err != nil {
 		logger.Error(ctx, err)
-
-		if len(retry) == 10 {
-			panic(err)
-		}
-
-		retry = append(retry, 1)
-
-		time.Sleep(time.Duration(len(retry)*5) * time.Second)
-
-		logger.Errorf(ctx, "sMidjourney SaveLog retry: %d", len(retry))
-
-		s.SaveLog(ctx, reqModel, realModel, fallbackModelAgent, fallbackModel, key, response, retryInfo, retry...)
 	}
 }
@@ -69,6 +69,8 @@ func (s *sModel) GetModel(ctx context.Context, m string) (*model.Model, error) {
 		RealtimeQuota:        result.RealtimeQuota,
 		MultimodalAudioQuota: result.MultimodalAudioQuota,
 		MidjourneyQuotas:     result.MidjourneyQuotas,
+		Pricing:              result.Pricing,
+		Tokenizer:            result.Tokenizer,
 		DataFormat:           result.DataFormat,
 		IsPublic:             result.IsPublic,
 		IsEnableModelAgent:   result.IsEnableModelAgent,
@@ -114,6 +116,8 @@ func (s *sModel) GetModelById(ctx context.Context, id string) (*model.Model, err
 		RealtimeQuota:        result.RealtimeQuota,
 		MultimodalAudioQuota: result.MultimodalAudioQuota,
 		MidjourneyQuotas:     result.MidjourneyQuotas,
+		Pricing:              result.Pricing,
+		Tokenizer:            result.Tokenizer,
 		DataFormat:           result.DataFormat,
 		IsPublic:             result.IsPublic,
 		IsEnableModelAgent:   result.IsEnableModelAgent,
@@ -136,6 +140,13 @@ func (s *sModel) GetModelBySecretKey(ctx context.Context, m, secretKey string) (
 		logger.Debugf(ctx, "sModel GetModelBySecretKey time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	// 派生令牌限制的模型
+	if claims := service.Session().GetToken(ctx); claims != nil && len(claims.Models) > 0 && !slices.Contains(claims.Models, m) {
+		err := errors.ERR_MODEL_NOT_FOUND
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
 	user, err := service.User().GetCacheUser(ctx, service.Session().GetUserId(ctx))
 	if err != nil {
 		logger.Error(ctx, err)
@@ -525,6 +536,8 @@ func (s *sModel) List(ctx context.Context, ids []string) ([]*model.Model, error)
 			RealtimeQuota:        result.RealtimeQuota,
 			MultimodalAudioQuota: result.MultimodalAudioQuota,
 			MidjourneyQuotas:     result.MidjourneyQuotas,
+			Pricing:              result.Pricing,
+			Tokenizer:            result.Tokenizer,
 			DataFormat:           result.DataFormat,
 			IsPublic:             result.IsPublic,
 			IsEnableModelAgent:   result.IsEnableModelAgent,
@@ -578,6 +591,8 @@ func (s *sModel) ListAll(ctx context.Context) ([]*model.Model, error) {
 			RealtimeQuota:        result.RealtimeQuota,
 			MultimodalAudioQuota: result.MultimodalAudioQuota,
 			MidjourneyQuotas:     result.MidjourneyQuotas,
+			Pricing:              result.Pricing,
+			Tokenizer:            result.Tokenizer,
 			DataFormat:           result.DataFormat,
 			IsPublic:             result.IsPublic,
 			IsEnableModelAgent:   result.IsEnableModelAgent,
@@ -613,7 +628,6 @@ func (s *sModel) GetModelAndSaveCache(ctx context.Context, id string) (*model.Mo
 	if model != nil {
 		if err = s.SaveCache(ctx, model); err != nil {
 			logger.Error(ctx, err)
-			return nil, err
 		}
 	}
 
@@ -637,7 +651,6 @@ func (s *sModel) GetModelListAndSaveCacheList(ctx context.Context, ids []string)
 	if len(models) > 0 {
 		if err = s.SaveCacheList(ctx, models); err != nil {
 			logger.Error(ctx, err)
-			return nil, err
 		}
 	}
 
@@ -724,7 +737,7 @@ func (s *sModel) GetCacheList(ctx context.Context, ids ...string) ([]*model.Mode
 		result := new(model.Model)
 		if err = gjson.Unmarshal([]byte(str), &result); err != nil {
 			logger.Error(ctx, err)
-			return nil, err
+			continue
 		}
 
 		if s.modelCache.ContainsKey(ctx, result.Id) {
@@ -791,6 +804,8 @@ func (s *sModel) UpdateCacheModel(ctx context.Context, oldData *entity.Model, ne
 		RealtimeQuota:        newData.RealtimeQuota,
 		MultimodalAudioQuota: newData.MultimodalAudioQuota,
 		MidjourneyQuotas:     newData.MidjourneyQuotas,
+		Pricing:              newData.Pricing,
+		Tokenizer:            newData.Tokenizer,
 		DataFormat:           newData.DataFormat,
 		IsPublic:             newData.IsPublic,
 		IsEnableModelAgent:   newData.IsEnableModelAgent,
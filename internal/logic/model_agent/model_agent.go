@@ -11,6 +11,7 @@ import (
 	"github.com/iimeta/fastapi/internal/consts"
 	"github.com/iimeta/fastapi/internal/dao"
 	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/internal/logic/common"
 	"github.com/iimeta/fastapi/internal/model"
 	"github.com/iimeta/fastapi/internal/model/entity"
 	"github.com/iimeta/fastapi/internal/service"
@@ -19,6 +20,7 @@ import (
 	"github.com/iimeta/fastapi/utility/logger"
 	"github.com/iimeta/fastapi/utility/redis"
 	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/sync/singleflight"
 	"slices"
 )
 
@@ -28,6 +30,7 @@ type sModelAgent struct {
 	modelAgentsRoundRobinCache    *cache.Cache // [模型ID]模型代理下标索引
 	modelAgentKeysCache           *cache.Cache // [模型代理ID][]模型代理密钥列表
 	modelAgentKeysRoundRobinCache *cache.Cache // [模型代理ID]模型代理密钥下标索引
+	group                         singleflight.Group
 }
 
 func init() {
@@ -198,6 +201,7 @@ func (s *sModelAgent) GetModelAgentKeys(ctx context.Context, id string) ([]*mode
 			AppId:          result.AppId,
 			Corp:           result.Corp,
 			Key:            result.Key,
+			KeyHash:        result.KeyHash,
 			Type:           result.Type,
 			Weight:         result.Weight,
 			Models:         result.Models,
@@ -206,6 +210,7 @@ func (s *sModelAgent) GetModelAgentKeys(ctx context.Context, id string) ([]*mode
 			Quota:          result.Quota,
 			UsedQuota:      result.UsedQuota,
 			QuotaExpiresAt: result.QuotaExpiresAt,
+			Budgets:        result.Budgets,
 			IpWhitelist:    result.IpWhitelist,
 			IpBlacklist:    result.IpBlacklist,
 			Status:         result.Status,
@@ -245,7 +250,6 @@ func (s *sModelAgent) PickModelAgent(ctx context.Context, m *model.Model) (int,
 
 			if err = s.SaveCacheList(ctx, modelAgents); err != nil {
 				logger.Error(ctx, err)
-				return 0, nil, err
 			}
 		}
 
@@ -408,7 +412,7 @@ func (s *sModelAgent) PickModelAgentKey(ctx context.Context, modelAgent *model.M
 	if len(keys) == 0 {
 
 		if keys, err = s.GetCacheModelAgentKeys(ctx, modelAgent.Id); err != nil {
-			if keys, err = s.GetModelAgentKeys(ctx, modelAgent.Id); err != nil {
+			if keys, err = s.loadModelAgentKeys(ctx, modelAgent.Id); err != nil {
 				logger.Error(ctx, err)
 				return 0, nil, err
 			}
@@ -417,11 +421,6 @@ func (s *sModelAgent) PickModelAgentKey(ctx context.Context, modelAgent *model.M
 		if len(keys) == 0 {
 			return 0, nil, errors.ERR_NO_AVAILABLE_MODEL_AGENT_KEY
 		}
-
-		if err = s.SaveCacheModelAgentKeys(ctx, modelAgent.Id, keys); err != nil {
-			logger.Error(ctx, err)
-			return 0, nil, err
-		}
 	}
 
 	keyList := make([]*model.Key, 0)
@@ -517,7 +516,7 @@ func (s *sModelAgent) RecordErrorModelAgentKey(ctx context.Context, modelAgent *
 		logger.Debugf(ctx, "sModelAgent RecordErrorModelAgentKey time: %d", gtime.TimestampMilli()-now)
 	}()
 
-	reply, err := redis.HIncrBy(ctx, fmt.Sprintf(consts.ERROR_MODEL_AGENT_KEY, modelAgent.Id), key.Key, 1)
+	reply, err := redis.HIncrBy(ctx, fmt.Sprintf(consts.ERROR_MODEL_AGENT_KEY, modelAgent.Id), key.Id, 1)
 	if err != nil {
 		logger.Error(ctx, err)
 	}
@@ -545,6 +544,7 @@ func (s *sModelAgent) DisabledModelAgentKey(ctx context.Context, key *model.Key,
 		AppId:              key.AppId,
 		Corp:               key.Corp,
 		Key:                key.Key,
+		KeyHash:            key.KeyHash,
 		Type:               key.Type,
 		Weight:             key.Weight,
 		Models:             key.Models,
@@ -553,6 +553,7 @@ func (s *sModelAgent) DisabledModelAgentKey(ctx context.Context, key *model.Key,
 		Quota:              key.Quota,
 		UsedQuota:          key.UsedQuota,
 		QuotaExpiresAt:     key.QuotaExpiresAt,
+		Budgets:            key.Budgets,
 		IpWhitelist:        key.IpWhitelist,
 		IpBlacklist:        key.IpBlacklist,
 		Status:             2,
@@ -567,6 +568,8 @@ func (s *sModelAgent) DisabledModelAgentKey(ctx context.Context, key *model.Key,
 	}); err != nil {
 		logger.Error(ctx, err)
 	}
+
+	service.Alert().KeyDisabledAlert(ctx, "model_agent_key", key, disabledReason)
 }
 
 // 保存模型代理列表到缓存
@@ -638,7 +641,7 @@ func (s *sModelAgent) GetCacheList(ctx context.Context, ids ...string) ([]*model
 		result := new(model.ModelAgent)
 		if err = gjson.Unmarshal([]byte(str), &result); err != nil {
 			logger.Error(ctx, err)
-			return nil, err
+			continue
 		}
 
 		if s.modelAgentCache.ContainsKey(ctx, result.Id) {
@@ -826,19 +829,26 @@ func (s *sModelAgent) SaveCacheModelAgentKeys(ctx context.Context, id string, ke
 		logger.Debugf(ctx, "sModelAgent SaveCacheModelAgentKeys time: %d", gtime.TimestampMilli()-now)
 	}()
 
+	// 写入Redis前加密上游密钥
+	sealedKeys, err := common.SealKeys(keys)
+	if err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
 	fields := g.Map{}
-	for _, key := range keys {
+	for _, key := range sealedKeys {
 		fields[key.Id] = key
 	}
 
 	if len(fields) > 0 {
 
-		if _, err := redis.HSet(ctx, fmt.Sprintf(consts.API_MODEL_AGENT_KEYS_KEY, id), fields); err != nil {
+		if err := s.modelAgentKeysCache.Set(ctx, id, keys, 0); err != nil {
 			logger.Error(ctx, err)
 			return err
 		}
 
-		if err := s.modelAgentKeysCache.Set(ctx, id, keys, 0); err != nil {
+		if _, err := redis.HSet(ctx, fmt.Sprintf(consts.API_MODEL_AGENT_KEYS_KEY, id), fields); err != nil {
 			logger.Error(ctx, err)
 			return err
 		}
@@ -847,6 +857,32 @@ func (s *sModelAgent) SaveCacheModelAgentKeys(ctx context.Context, id string, ke
 	return nil
 }
 
+// 从数据库加载模型代理密钥列表并保存到缓存, 同一模型代理并发请求只加载一次, Redis不可用时只保存到本地缓存
+func (s *sModelAgent) loadModelAgentKeys(ctx context.Context, id string) ([]*model.Key, error) {
+
+	// 共享的加载不受首个请求取消的影响
+	ctx = context.WithoutCancel(ctx)
+
+	value, err, _ := s.group.Do(id, func() (interface{}, error) {
+
+		keys, err := s.GetModelAgentKeys(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = s.SaveCacheModelAgentKeys(ctx, id, keys); err != nil {
+			logger.Error(ctx, err)
+		}
+
+		return keys, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value.([]*model.Key), nil
+}
+
 // 获取缓存中的模型代理密钥列表
 func (s *sModelAgent) GetCacheModelAgentKeys(ctx context.Context, id string) ([]*model.Key, error) {
 
@@ -879,7 +915,7 @@ func (s *sModelAgent) GetCacheModelAgentKeys(ctx context.Context, id string) ([]
 		result := new(model.Key)
 		if err = gjson.Unmarshal([]byte(str), &result); err != nil {
 			logger.Error(ctx, err)
-			return nil, err
+			continue
 		}
 
 		if result.Status == 1 {
@@ -917,6 +953,7 @@ func (s *sModelAgent) CreateCacheModelAgentKey(ctx context.Context, key *entity.
 		AppId:          key.AppId,
 		Corp:           key.Corp,
 		Key:            key.Key,
+		KeyHash:        key.KeyHash,
 		Type:           key.Type,
 		Weight:         key.Weight,
 		Models:         key.Models,
@@ -925,6 +962,7 @@ func (s *sModelAgent) CreateCacheModelAgentKey(ctx context.Context, key *entity.
 		Quota:          key.Quota,
 		UsedQuota:      key.UsedQuota,
 		QuotaExpiresAt: key.QuotaExpiresAt,
+		Budgets:        key.Budgets,
 		IpWhitelist:    key.IpWhitelist,
 		IpBlacklist:    key.IpBlacklist,
 		Status:         key.Status,
@@ -966,6 +1004,7 @@ func (s *sModelAgent) UpdateCacheModelAgentKey(ctx context.Context, oldData *ent
 		Quota:              newData.Quota,
 		UsedQuota:          newData.UsedQuota,
 		QuotaExpiresAt:     newData.QuotaExpiresAt,
+		Budgets:            newData.Budgets,
 		IpWhitelist:        newData.IpWhitelist,
 		IpBlacklist:        newData.IpBlacklist,
 		Status:             newData.Status,
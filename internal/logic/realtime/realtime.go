@@ -6,27 +6,25 @@ import (
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/net/ghttp"
 	"github.com/gogf/gf/v2/os/gctx"
-	"github.com/gogf/gf/v2/os/grpool"
 	"github.com/gogf/gf/v2/os/gtime"
 	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/gogf/gf/v2/util/gconv"
 	"github.com/gorilla/websocket"
 	sdk "github.com/iimeta/fastapi-sdk"
 	sdkm "github.com/iimeta/fastapi-sdk/model"
-	"github.com/iimeta/fastapi/internal/config"
-	"github.com/iimeta/fastapi/internal/dao"
+	"github.com/iimeta/fastapi/internal/consts"
 	"github.com/iimeta/fastapi/internal/errors"
 	"github.com/iimeta/fastapi/internal/logic/common"
 	"github.com/iimeta/fastapi/internal/model"
 	mcommon "github.com/iimeta/fastapi/internal/model/common"
 	"github.com/iimeta/fastapi/internal/model/do"
 	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/graceful"
 	"github.com/iimeta/fastapi/utility/logger"
 	"github.com/iimeta/fastapi/utility/util"
 	"io"
 	"math"
 	"net/http"
-	"slices"
 	"time"
 )
 
@@ -80,6 +78,9 @@ func (s *sRealtime) Realtime(ctx context.Context, r *ghttp.Request, params model
 		return err
 	}
 
+	// 关闭服务时等待会话结束
+	defer graceful.Track()()
+
 	var (
 		mak = &common.MAK{
 			Model:              params.Model,
@@ -99,7 +100,7 @@ func (s *sRealtime) Realtime(ctx context.Context, r *ghttp.Request, params model
 		internalTime := gtime.TimestampMilli() - enterTime - totalTime
 
 		if err != nil && mak.ReqModel != nil && mak.RealModel != nil {
-			if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
 
 				mak.RealModel.ModelAgent = mak.ModelAgent
 
@@ -139,10 +140,10 @@ func (s *sRealtime) Realtime(ctx context.Context, r *ghttp.Request, params model
 		// 记录错误次数和禁用
 		service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
 
-		isRetry, isDisabled := common.IsNeedRetry(err)
+		isRetry, isDisabled := common.IsNeedRetry(ctx, mak, err)
 
 		if isDisabled {
-			if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+			if err := graceful.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
 				if mak.RealModel.IsEnableModelAgent {
 					service.ModelAgent().DisabledModelAgentKey(ctx, mak.Key, err.Error())
 				} else {
@@ -191,13 +192,19 @@ func (s *sRealtime) Realtime(ctx context.Context, r *ghttp.Request, params model
 				ErrMsg:     err.Error(),
 			}
 
+			// 重试退避
+			if !common.RetryBackoff(ctx, mak, err, retryInfo) {
+				retryInfo.IsRetry = false
+				return err
+			}
+
 			return s.Realtime(g.RequestFromCtx(ctx).GetCtx(), r, params, fallbackModelAgent, fallbackModel, append(retry, 1)...)
 		}
 
 		return err
 	}
 
-	if err := grpool.AddWithRecover(ctx, func(ctx context.Context) {
+	if err := graceful.AddWithRecover(ctx, func(ctx context.Context) {
 
 		defer close(response)
 
@@ -228,7 +235,7 @@ func (s *sRealtime) Realtime(ctx context.Context, r *ghttp.Request, params model
 				// 记录错误次数和禁用
 				service.Common().RecordError(ctx, mak.RealModel, mak.Key, mak.ModelAgent)
 
-				if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+				if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
 
 					mak.RealModel.ModelAgent = mak.ModelAgent
 					enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
@@ -326,16 +333,28 @@ func (s *sRealtime) Realtime(ctx context.Context, r *ghttp.Request, params model
 					totalTokens = int(math.Ceil(float64(usage.PromptTokens)*mak.ReqModel.RealtimeQuota.AudioQuota.PromptRatio)) + int(math.Ceil(float64(usage.CompletionTokens)*mak.ReqModel.RealtimeQuota.AudioQuota.CompletionRatio))
 				}
 
-				if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
-					if err := service.Common().RecordUsage(ctx, totalTokens, mak.Key.Key); err != nil {
+				bill := service.Billing().Bill(ctx, &model.BillingReq{
+					Model:      mak.ReqModel,
+					Action:     consts.BILLING_ACTION_REALTIME,
+					Usage:      usage,
+					RatioQuota: totalTokens,
+				})
+				totalTokens = bill.Quota
+
+				if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+					if err := service.Common().RecordUsage(ctx, totalTokens, mak.Key.Id); err != nil {
 						logger.Error(ctx, err)
 						panic(err)
 					}
+
+					if err := service.Billing().Ledger(ctx, bill, mak.Key.Id); err != nil {
+						logger.Error(ctx, err)
+					}
 				}); err != nil {
 					logger.Error(ctx, err)
 				}
 
-				if err := grpool.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
+				if err := graceful.Add(gctx.NeverDone(ctx), func(ctx context.Context) {
 
 					mak.RealModel.ModelAgent = mak.ModelAgent
 					enterTime := g.RequestFromCtx(ctx).EnterTime.TimestampMilli()
@@ -421,7 +440,7 @@ func (s *sRealtime) Realtime(ctx context.Context, r *ghttp.Request, params model
 }
 
 // 保存日志
-func (s *sRealtime) SaveLog(ctx context.Context, reqModel, realModel *model.Model, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, key *model.Key, completionsReq *sdkm.ChatCompletionRequest, completionsRes *model.CompletionsRes, retryInfo *mcommon.Retry, isSmartMatch bool, retry ...int) {
+func (s *sRealtime) SaveLog(ctx context.Context, reqModel, realModel *model.Model, fallbackModelAgent *model.ModelAgent, fallbackModel *model.Model, key *model.Key, completionsReq *sdkm.ChatCompletionRequest, completionsRes *model.CompletionsRes, retryInfo *mcommon.Retry, isSmartMatch bool) {
 
 	now := gtime.TimestampMilli()
 	defer func() {
@@ -437,6 +456,7 @@ func (s *sRealtime) SaveLog(ctx context.Context, reqModel, realModel *model.Mode
 		TraceId:      gctx.CtxId(ctx),
 		UserId:       service.Session().GetUserId(ctx),
 		AppId:        service.Session().GetAppId(ctx),
+		EndUser:      service.Session().GetEndUser(ctx),
 		IsSmartMatch: isSmartMatch,
 		Stream:       completionsReq.Stream,
 		ConnTime:     completionsRes.ConnTime,
@@ -452,11 +472,11 @@ func (s *sRealtime) SaveLog(ctx context.Context, reqModel, realModel *model.Mode
 		Host:         g.RequestFromCtx(ctx).GetHost(),
 	}
 
-	if len(completionsReq.Messages) > 0 && slices.Contains(config.Cfg.RecordLogs, "prompt") {
+	if len(completionsReq.Messages) > 0 && common.IsRecordLog(ctx, "prompt") {
 		chat.Prompt = gconv.String(completionsReq.Messages[len(completionsReq.Messages)-1].Content)
 	}
 
-	if slices.Contains(config.Cfg.RecordLogs, "completion") {
+	if common.IsRecordLog(ctx, "completion") {
 		chat.Completion = completionsRes.Completion
 	}
 
@@ -524,7 +544,7 @@ func (s *sRealtime) SaveLog(ctx context.Context, reqModel, realModel *model.Mode
 	}
 
 	if key != nil {
-		chat.Key = key.Key
+		chat.Key = common.KeyRef(key)
 	}
 
 	if completionsRes.Error != nil {
@@ -536,7 +556,7 @@ func (s *sRealtime) SaveLog(ctx context.Context, reqModel, realModel *model.Mode
 		}
 	}
 
-	if slices.Contains(config.Cfg.RecordLogs, "messages") {
+	if common.IsRecordLog(ctx, "messages") {
 		for _, message := range completionsReq.Messages {
 			chat.Messages = append(chat.Messages, mcommon.Message{
 				Role:    message.Role,
@@ -552,6 +572,10 @@ func (s *sRealtime) SaveLog(ctx context.Context, reqModel, realModel *model.Mode
 			IsRetry:    retryInfo.IsRetry,
 			RetryCount: retryInfo.RetryCount,
 			ErrMsg:     retryInfo.ErrMsg,
+			Status:     retryInfo.Status,
+			Code:       retryInfo.Code,
+			Delay:      retryInfo.Delay,
+			Decision:   retryInfo.Decision,
 		}
 
 		if chat.IsRetry {
@@ -560,19 +584,7 @@ func (s *sRealtime) SaveLog(ctx context.Context, reqModel, realModel *model.Mode
 		}
 	}
 
-	if _, err := dao.Chat.Insert(ctx, chat); err != nil {
+	if err := service.LogWriter().Write(ctx, chat); err != nil {
 		logger.Error(ctx, err)
-
-		if len(retry) == 10 {
-			panic(err)
-		}
-
-		retry = append(retry, 1)
-
-		time.Sleep(time.Duration(len(retry)*5) * time.Second)
-
-		logger.Errorf(ctx, "sRealtime SaveLog retry: %d", len(retry))
-
-		s.SaveLog(ctx, reqModel, realModel, fallbackModelAgent, fallbackModel, key, completionsReq, completionsRes, retryInfo, isSmartMatch, retry...)
 	}
 }
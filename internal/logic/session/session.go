@@ -7,6 +7,7 @@ import (
 	"github.com/iimeta/fastapi/internal/errors"
 	"github.com/iimeta/fastapi/internal/model"
 	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/crypto"
 	"github.com/iimeta/fastapi/utility/logger"
 )
 
@@ -36,12 +37,55 @@ func (s *sSession) Save(ctx context.Context, secretKey string) error {
 	if r := g.RequestFromCtx(ctx); r != nil {
 		r.SetCtxVar(consts.USER_ID_KEY, userId)
 		r.SetCtxVar(consts.APP_ID_KEY, appId)
-		r.SetCtxVar(consts.SECRET_KEY, secretKey)
+		r.SetCtxVar(consts.SECRET_KEY, crypto.HashKey(secretKey))
 	}
 
 	return nil
 }
 
+// 保存派生令牌会话, 使用父密钥的用户、应用和密钥哈希
+func (s *sSession) SaveToken(ctx context.Context, claims *model.TokenClaims) {
+	if r := g.RequestFromCtx(ctx); r != nil {
+		r.SetCtxVar(consts.USER_ID_KEY, claims.UserId)
+		r.SetCtxVar(consts.APP_ID_KEY, claims.AppId)
+		r.SetCtxVar(consts.SECRET_KEY, claims.KeyHash)
+		r.SetCtxVar(consts.SESSION_TOKEN, claims)
+	}
+}
+
+// 获取会话中的派生令牌声明
+func (s *sSession) GetToken(ctx context.Context) *model.TokenClaims {
+
+	claims := ctx.Value(consts.SESSION_TOKEN)
+	if claims == nil {
+		return nil
+	}
+
+	return claims.(*model.TokenClaims)
+}
+
+// 保存终端用户
+func (s *sSession) SaveEndUser(ctx context.Context, endUser string) {
+	if r := g.RequestFromCtx(ctx); r != nil {
+		r.SetCtxVar(consts.END_USER_KEY, endUser)
+	}
+}
+
+// 获取终端用户, 派生令牌中指定的终端用户优先
+func (s *sSession) GetEndUser(ctx context.Context) string {
+
+	if claims := s.GetToken(ctx); claims != nil && claims.EndUser != "" {
+		return claims.EndUser
+	}
+
+	endUser := ctx.Value(consts.END_USER_KEY)
+	if endUser == nil {
+		return ""
+	}
+
+	return endUser.(string)
+}
+
 // 保存应用和密钥是否限制额度
 func (s *sSession) SaveIsLimitQuota(ctx context.Context, app, key bool) {
 	if r := g.RequestFromCtx(ctx); r != nil {
@@ -74,7 +118,7 @@ func (s *sSession) GetAppId(ctx context.Context) int {
 	return appId.(int)
 }
 
-// 获取密钥
+// 获取密钥哈希
 func (s *sSession) GetSecretKey(ctx context.Context) string {
 
 	secretKey := ctx.Value(consts.SECRET_KEY)
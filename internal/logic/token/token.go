@@ -0,0 +1,176 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/os/gtime"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/internal/model"
+	"github.com/iimeta/fastapi/internal/service"
+	"github.com/iimeta/fastapi/utility/crypto"
+	"github.com/iimeta/fastapi/utility/logger"
+	"github.com/iimeta/fastapi/utility/redis"
+	"github.com/iimeta/fastapi/utility/util"
+	"time"
+)
+
+type sToken struct{}
+
+func init() {
+	service.RegisterToken(New())
+}
+
+func New() service.IToken {
+	return &sToken{}
+}
+
+// 签发派生令牌
+func (s *sToken) Create(ctx context.Context, params model.TokenCreateReq) (*model.TokenCreateRes, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sToken Create time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	if config.Cfg.Token.Secret == "" {
+		err := errors.ERR_FORBIDDEN
+		logger.Error(ctx, "sToken Create token secret is not configured")
+		return nil, err
+	}
+
+	// 派生令牌不能再签发令牌
+	if service.Session().GetToken(ctx) != nil {
+		err := errors.ERR_FORBIDDEN
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	if params.MaxSpend < 0 || params.ExpiresIn < 0 {
+		err := errors.ERR_INVALID_PARAMETER
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	// 令牌限制的模型必须在父密钥的权限内
+	for _, m := range params.Models {
+		if _, err := service.Model().GetModelBySecretKey(ctx, m, service.Session().GetSecretKey(ctx)); err != nil {
+			logger.Error(ctx, err)
+			return nil, err
+		}
+	}
+
+	maxTtl := config.Cfg.Token.MaxTtl
+	if maxTtl <= 0 {
+		maxTtl = 3600
+	}
+
+	expiresIn := params.ExpiresIn
+	if expiresIn == 0 || expiresIn > maxTtl {
+		expiresIn = maxTtl
+	}
+
+	claims := &model.TokenClaims{
+		Id:        util.GenerateId(),
+		KeyHash:   service.Session().GetSecretKey(ctx),
+		UserId:    service.Session().GetUserId(ctx),
+		AppId:     service.Session().GetAppId(ctx),
+		Models:    params.Models,
+		MaxSpend:  params.MaxSpend,
+		EndUser:   params.EndUser,
+		IssuedAt:  gtime.Timestamp(),
+		ExpiresAt: gtime.Timestamp() + expiresIn,
+	}
+
+	token, err := crypto.JwtSign([]byte(config.Cfg.Token.Secret), claims)
+	if err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
+	logger.Infof(ctx, "sToken Create id: %s, models: %v, maxSpend: %d, endUser: %s, expiresAt: %d", claims.Id, claims.Models, claims.MaxSpend, claims.EndUser, claims.ExpiresAt)
+
+	return &model.TokenCreateRes{
+		Token:     token,
+		ExpiresAt: claims.ExpiresAt,
+	}, nil
+}
+
+// 解析并校验派生令牌
+func (s *sToken) Parse(ctx context.Context, token string) (*model.TokenClaims, error) {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sToken Parse time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	if config.Cfg.Token.Secret == "" {
+		return nil, errors.ERR_INVALID_TOKEN
+	}
+
+	claims := new(model.TokenClaims)
+	if err := crypto.JwtParse([]byte(config.Cfg.Token.Secret), token, claims); err != nil {
+		logger.Error(ctx, err)
+		return nil, errors.ERR_INVALID_TOKEN
+	}
+
+	if claims.ExpiresAt < gtime.Timestamp() || claims.UserId == 0 || claims.AppId == 0 || claims.KeyHash == "" {
+		err := errors.ERR_INVALID_TOKEN
+		logger.Errorf(ctx, "sToken Parse id: %s, expiresAt: %d, error: %v", claims.Id, claims.ExpiresAt, err)
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// 核验派生令牌的限制条件
+func (s *sToken) Verify(ctx context.Context, claims *model.TokenClaims) error {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sToken Verify time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	if claims.MaxSpend > 0 {
+
+		// Redis不可用时跳过检查, 不拒绝请求
+		spend, err := redis.HGetInt(ctx, fmt.Sprintf(consts.API_TOKEN_USAGE_KEY, claims.Id), consts.TOKEN_SPEND_FIELD)
+		if err != nil {
+			logger.Warningf(ctx, "sToken Verify id: %s, skipped, error: %v", claims.Id, err)
+			service.Health().RecordFailOpen("token_spend")
+			return nil
+		}
+
+		if spend >= claims.MaxSpend {
+			err = errors.ERR_INSUFFICIENT_QUOTA
+			logger.Errorf(ctx, "sToken Verify id: %s, spend: %d, maxSpend: %d, error: %v", claims.Id, spend, claims.MaxSpend, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 记录派生令牌花费
+func (s *sToken) RecordSpend(ctx context.Context, claims *model.TokenClaims, spend int) error {
+
+	now := gtime.TimestampMilli()
+	defer func() {
+		logger.Debugf(ctx, "sToken RecordSpend time: %d", gtime.TimestampMilli()-now)
+	}()
+
+	key := fmt.Sprintf(consts.API_TOKEN_USAGE_KEY, claims.Id)
+
+	if _, err := redis.HIncrBy(ctx, key, consts.TOKEN_SPEND_FIELD, int64(spend)); err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
+	if _, err := redis.ExpireAt(ctx, key, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/iimeta/fastapi/internal/consts"
 	"github.com/iimeta/fastapi/internal/dao"
 	"github.com/iimeta/fastapi/internal/errors"
+	"github.com/iimeta/fastapi/internal/logic/common"
 	"github.com/iimeta/fastapi/internal/model"
 	"github.com/iimeta/fastapi/internal/model/entity"
 	"github.com/iimeta/fastapi/internal/service"
@@ -15,11 +16,13 @@ import (
 	"github.com/iimeta/fastapi/utility/logger"
 	"github.com/iimeta/fastapi/utility/redis"
 	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/sync/singleflight"
 )
 
 type sUser struct {
 	userCache      *cache.Cache // [userId]User
 	userQuotaCache *cache.Cache // [userId]Quota
+	group          singleflight.Group
 }
 
 func init() {
@@ -57,6 +60,7 @@ func (s *sUser) GetUser(ctx context.Context, userId int) (*model.User, error) {
 		Quota:          user.Quota,
 		UsedQuota:      user.UsedQuota,
 		QuotaExpiresAt: user.QuotaExpiresAt,
+		Budgets:        user.Budgets,
 		Models:         user.Models,
 		Status:         user.Status,
 	}, nil
@@ -90,6 +94,7 @@ func (s *sUser) List(ctx context.Context) ([]*model.User, error) {
 			Quota:          result.Quota,
 			UsedQuota:      result.UsedQuota,
 			QuotaExpiresAt: result.QuotaExpiresAt,
+			Budgets:        result.Budgets,
 			Models:         result.Models,
 			Status:         result.Status,
 		})
@@ -135,11 +140,7 @@ func (s *sUser) SaveCacheUser(ctx context.Context, user *model.User) error {
 		return errors.New("user is nil")
 	}
 
-	if _, err := redis.Set(ctx, fmt.Sprintf(consts.API_USER_KEY, user.UserId), user); err != nil {
-		logger.Error(ctx, err)
-		return err
-	}
-
+	// 先写本地缓存, Redis不可用时本实例仍可使用
 	service.Session().SaveUser(ctx, user)
 
 	if err := s.userCache.Set(ctx, user.UserId, user, 0); err != nil {
@@ -152,6 +153,11 @@ func (s *sUser) SaveCacheUser(ctx context.Context, user *model.User) error {
 		return err
 	}
 
+	if _, err := redis.Set(ctx, fmt.Sprintf(consts.API_USER_KEY, user.UserId), user); err != nil {
+		logger.Error(ctx, err)
+		return err
+	}
+
 	return nil
 }
 
@@ -173,29 +179,52 @@ func (s *sUser) GetCacheUser(ctx context.Context, userId int) (*model.User, erro
 		return user, nil
 	}
 
-	reply, err := redis.Get(ctx, fmt.Sprintf(consts.API_USER_KEY, userId))
+	// 本地缓存未命中时按需加载, 同一用户并发请求只加载一次, 不受首个请求取消的影响
+	value, err, _ := s.group.Do(fmt.Sprint(userId), func() (interface{}, error) {
+		return s.loadUser(context.WithoutCancel(ctx), userId)
+	})
 	if err != nil {
-		logger.Error(ctx, err)
 		return nil, err
 	}
 
-	if reply == nil || reply.IsNil() {
-		return nil, errors.New("user is nil")
-	}
+	user := value.(*model.User)
+	service.Session().SaveUser(ctx, user)
+
+	return user, nil
+}
+
+// 加载用户信息, 优先从Redis获取, Redis不可用或不存在时从数据库获取
+func (s *sUser) loadUser(ctx context.Context, userId int) (*model.User, error) {
 
 	user := new(model.User)
-	if err = reply.Struct(&user); err != nil {
+
+	reply, err := redis.Get(ctx, fmt.Sprintf(consts.API_USER_KEY, userId))
+	if err != nil {
 		logger.Error(ctx, err)
-		return nil, err
 	}
 
-	service.Session().SaveUser(ctx, user)
+	if err != nil || reply == nil || reply.IsNil() || reply.Struct(&user) != nil {
+
+		if user, err = s.GetUser(ctx, userId); err != nil {
+			logger.Error(ctx, err)
+			return nil, err
+		}
+
+		if _, err = redis.Set(ctx, fmt.Sprintf(consts.API_USER_KEY, userId), user); err != nil {
+			logger.Error(ctx, err)
+		}
+	}
 
 	if err = s.userCache.Set(ctx, user.UserId, user, 0); err != nil {
 		logger.Error(ctx, err)
 		return nil, err
 	}
 
+	if err = s.userQuotaCache.Set(ctx, user.UserId, common.LoadUsageQuota(ctx, user.UserId, consts.USER_QUOTA_FIELD, user.Quota), 0); err != nil {
+		logger.Error(ctx, err)
+		return nil, err
+	}
+
 	return user, nil
 }
 
@@ -217,6 +246,7 @@ func (s *sUser) UpdateCacheUser(ctx context.Context, user *entity.User) {
 		Quota:          user.Quota,
 		UsedQuota:      user.UsedQuota,
 		QuotaExpiresAt: user.QuotaExpiresAt,
+		Budgets:        user.Budgets,
 		Models:         user.Models,
 		Status:         user.Status,
 	}); err != nil {
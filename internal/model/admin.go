@@ -0,0 +1,14 @@
+package model
+
+type AdminListReq struct {
+	Page     int64                  `json:"page"`      // 当前页
+	PageSize int64                  `json:"page_size"` // 每页条数
+	Filter   map[string]interface{} `json:"filter"`    // 过滤条件, 字段名同数据库
+}
+
+type AdminListRes struct {
+	Items    interface{} `json:"items"`     // 数据列表
+	Page     int64       `json:"page"`      // 当前页
+	PageSize int64       `json:"page_size"` // 每页条数
+	Total    int64       `json:"total"`     // 总条数
+}
@@ -0,0 +1,16 @@
+package model
+
+// 告警事件
+type AlertEvent struct {
+	Id         string `json:"id"`                    // 事件ID
+	Event      string `json:"event"`                 // 事件类型[quota.threshold:额度余量低于阈值, key.disabled:密钥被自动禁用]
+	UserId     int    `json:"user_id,omitempty"`     // 用户ID
+	AppId      int    `json:"app_id,omitempty"`      // 应用ID
+	Target     string `json:"target"`                // 告警对象[user:用户, app:应用, model_key:模型密钥, model_agent_key:模型代理密钥]
+	Threshold  int    `json:"threshold,omitempty"`   // 阈值百分比
+	Quota      int    `json:"quota,omitempty"`       // 剩余额度
+	TotalQuota int    `json:"total_quota,omitempty"` // 总额度
+	KeyId      string `json:"key_id,omitempty"`      // 密钥ID
+	Reason     string `json:"reason,omitempty"`      // 禁用原因
+	CreatedAt  int64  `json:"created_at"`            // 事件时间
+}
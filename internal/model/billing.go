@@ -0,0 +1,30 @@
+package model
+
+import sdkm "github.com/iimeta/fastapi-sdk/model"
+
+// 计费请求参数
+type BillingReq struct {
+	Model            *Model      // 模型
+	Action           string      // 计费项[chat, image, audio, embedding, moderation, realtime, midjourney]
+	Usage            *sdkm.Usage // 用量, 不为空时从中获取tokens
+	PromptTokens     int         // 提示tokens
+	CompletionTokens int         // 补全tokens
+	CachedTokens     int         // 缓存提示tokens
+	ReasoningTokens  int         // 推理tokens, 包含在补全tokens中
+	ImageSize        string      // 图像尺寸
+	ImageQuality     string      // 图像质量
+	ImageCount       int         // 图像数量
+	AudioMinutes     float64     // 音频时长, 单位: 分钟
+	MidjourneyAction string      // Midjourney动作
+	RatioQuota       int         // 按倍率计算的额度, 模型未配置价格时使用
+}
+
+// 计费结果
+type Bill struct {
+	*BillingReq
+	BillingMethod string  // 计费方式[price:按价格, ratio:按倍率]
+	Currency      string  // 币种
+	Amount        float64 // 费用
+	ExchangeRate  float64 // 汇率, 1单位币种兑换的美元
+	Quota         int     // 额度
+}
@@ -16,6 +16,8 @@ type CompletionsRes struct {
 	Type         string     `json:"type"`
 	Completion   string     `json:"completion"`
 	Usage        sdkm.Usage `json:"usage"`
+	RepairCount  int        `json:"repair_count"`
+	RepairFail   bool       `json:"repair_fail"`
 	Error        error      `json:"err"`
 	ConnTime     int64      `json:"-"`
 	Duration     int64      `json:"-"`
@@ -23,3 +25,26 @@ type CompletionsRes struct {
 	InternalTime int64      `json:"-"`
 	EnterTime    int64      `json:"-"`
 }
+
+type ChatFanoutReq struct {
+	sdkm.ChatCompletionRequest
+	Models     []string `json:"models"`      // 并发请求的模型
+	JudgeModel string   `json:"judge_model"` // 评判模型, 为空时不评判
+}
+
+type ChatFanoutRes struct {
+	Id      string              `json:"id"`
+	Object  string              `json:"object"`
+	Created int64               `json:"created"`
+	Results []*ChatFanoutResult `json:"results"`
+	Winner  string              `json:"winner,omitempty"` // 评判选出的最佳模型
+	Judge   *ChatFanoutResult   `json:"judge,omitempty"`
+}
+
+type ChatFanoutResult struct {
+	Model    string                       `json:"model"`
+	Response *sdkm.ChatCompletionResponse `json:"response,omitempty"`
+	Usage    *sdkm.Usage                  `json:"usage,omitempty"`
+	Latency  int64                        `json:"latency"` // 耗时(毫秒)
+	Error    string                       `json:"error,omitempty"`
+}
@@ -11,6 +11,8 @@ type TextQuota struct {
 	BillingMethod   int     `bson:"billing_method,omitempty"   json:"billing_method,omitempty"`         // 计费方式[1:倍率, 2:固定额度]
 	PromptRatio     float64 `bson:"prompt_ratio,omitempty"     json:"prompt_ratio,omitempty"     d:"1"` // 提示倍率(提问倍率)
 	CompletionRatio float64 `bson:"completion_ratio,omitempty" json:"completion_ratio,omitempty" d:"1"` // 补全倍率(回答倍率)
+	CachedRatio     float64 `bson:"cached_ratio,omitempty"     json:"cached_ratio,omitempty"`           // 缓存命中倍率, 为0时按提示倍率计算
+	ReasoningRatio  float64 `bson:"reasoning_ratio,omitempty"  json:"reasoning_ratio,omitempty"`        // 推理倍率, 为0时按补全倍率计算
 	FixedQuota      int     `bson:"fixed_quota,omitempty"      json:"fixed_quota,omitempty"`            // 固定额度
 }
 
@@ -53,6 +55,30 @@ type MidjourneyQuota struct {
 	FixedQuota int    `bson:"fixed_quota,omitempty" json:"fixed_quota,omitempty"` // 固定额度
 }
 
+type Pricing struct {
+	Currency         string            `bson:"currency,omitempty"           json:"currency,omitempty"`           // 币种, 默认USD
+	InputPrice       float64           `bson:"input_price,omitempty"        json:"input_price,omitempty"`        // 输入价格, 每百万tokens
+	OutputPrice      float64           `bson:"output_price,omitempty"       json:"output_price,omitempty"`       // 输出价格, 每百万tokens
+	CachedInputPrice float64           `bson:"cached_input_price,omitempty" json:"cached_input_price,omitempty"` // 缓存输入价格, 每百万tokens, 为0时按输入价格计算
+	ReasoningPrice   float64           `bson:"reasoning_price,omitempty"    json:"reasoning_price,omitempty"`    // 推理输出价格, 每百万tokens, 为0时按输出价格计算
+	ImagePrices      []ImagePrice      `bson:"image_prices,omitempty"       json:"image_prices,omitempty"`       // 图像价格
+	AudioMinutePrice float64           `bson:"audio_minute_price,omitempty" json:"audio_minute_price,omitempty"` // 音频价格, 每分钟
+	MidjourneyPrices []MidjourneyPrice `bson:"midjourney_prices,omitempty"  json:"midjourney_prices,omitempty"`  // Midjourney价格
+}
+
+type ImagePrice struct {
+	Width     int     `bson:"width,omitempty"      json:"width,omitempty"`      // 宽度
+	Height    int     `bson:"height,omitempty"     json:"height,omitempty"`     // 高度
+	Quality   string  `bson:"quality,omitempty"    json:"quality,omitempty"`    // 图像质量[standard, hd]
+	Price     float64 `bson:"price,omitempty"      json:"price,omitempty"`      // 价格, 每张
+	IsDefault bool    `bson:"is_default,omitempty" json:"is_default,omitempty"` // 是否默认选项
+}
+
+type MidjourneyPrice struct {
+	Action string  `bson:"action,omitempty" json:"action,omitempty"` // 动作[IMAGINE, UPSCALE, VARIATION, ZOOM, PAN, DESCRIBE, BLEND, SHORTEN, SWAP_FACE]
+	Price  float64 `bson:"price,omitempty"  json:"price,omitempty"`  // 价格, 每次
+}
+
 type ForwardConfig struct {
 	ForwardRule   int      `bson:"forward_rule,omitempty"   json:"forward_rule,omitempty"`   // 转发规则[1:全部转发, 2:按关键字, 3:内容长度]
 	MatchRule     []int    `bson:"match_rule,omitempty"     json:"match_rule,omitempty"`     // 转发规则为2时的匹配规则[1:智能匹配, 2:正则匹配]
@@ -79,6 +105,10 @@ type Retry struct {
 	IsRetry    bool   `bson:"is_retry,omitempty"    json:"is_retry,omitempty"`    // 是否重试
 	RetryCount int    `bson:"retry_count,omitempty" json:"retry_count,omitempty"` // 重试次数
 	ErrMsg     string `bson:"err_msg,omitempty"     json:"err_msg,omitempty"`     // 错误信息
+	Status     int    `bson:"status,omitempty"      json:"status,omitempty"`      // 上游HTTP状态码
+	Code       string `bson:"code,omitempty"        json:"code,omitempty"`        // 上游错误码
+	Delay      int64  `bson:"delay,omitempty"       json:"delay,omitempty"`       // 退避等待时间(毫秒)
+	Decision   string `bson:"decision,omitempty"    json:"decision,omitempty"`    // 重试决策过程
 }
 
 type ImageData struct {
@@ -86,3 +116,14 @@ type ImageData struct {
 	B64JSON       string `bson:"b64_json,omitempty"`
 	RevisedPrompt string `bson:"revised_prompt,omitempty"`
 }
+
+type Budget struct {
+	Period    string `bson:"period,omitempty"     json:"period,omitempty"`     // 周期[day:每日, week:每周, month:每月]
+	Limit     int    `bson:"limit,omitempty"      json:"limit,omitempty"`      // 硬限制, 周期内用量达到后拒绝请求
+	SoftLimit int    `bson:"soft_limit,omitempty" json:"soft_limit,omitempty"` // 软限制, 周期内用量达到后告警
+}
+
+type LogPolicy struct {
+	RecordLogs []string       `bson:"record_logs,omitempty" json:"record_logs,omitempty"` // 调用日志记录内容, 为空时不记录任何内容
+	Retention  map[string]int `bson:"retention,omitempty"   json:"retention,omitempty"`   // 日志内容保留天数, 覆盖全局配置
+}
@@ -18,6 +18,21 @@ type DashboardUsageRes struct {
 	TotalUsage float64 `json:"total_usage"`
 }
 
+// EndUserUsage接口响应参数
+type DashboardEndUserUsageRes struct {
+	Object string `json:"object"`
+	*EndUserUsage
+}
+
+// 终端用户用量
+type EndUserUsage struct {
+	EndUser       string `json:"end_user"`
+	DailyUsage    int    `json:"daily_usage"`
+	DailyBudget   int    `json:"daily_budget"`
+	MonthlyUsage  int    `json:"monthly_usage"`
+	MonthlyBudget int    `json:"monthly_budget"`
+}
+
 // Models接口响应参数
 type DashboardModelsRes struct {
 	Object string                `json:"object"`
@@ -64,5 +79,6 @@ type FastAPI struct {
 	RealtimeQuota        common.RealtimeQuota        `json:"realtime_quota,omitempty"`         // 多模态实时额度
 	MultimodalAudioQuota common.MultimodalAudioQuota `json:"multimodal_audio_quota,omitempty"` // 多模态语音额度
 	MidjourneyQuotas     []common.MidjourneyQuota    `json:"midjourney_quotas,omitempty"`      // Midjourney额度
+	Pricing              *common.Pricing             `json:"pricing,omitempty"`                // 价格
 	Remark               string                      `json:"remark,omitempty"`                 // 备注
 }
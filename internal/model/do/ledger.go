@@ -0,0 +1,36 @@
+package do
+
+import "github.com/gogf/gf/v2/util/gmeta"
+
+const (
+	LEDGER_COLLECTION = "ledger"
+)
+
+type Ledger struct {
+	gmeta.Meta       `collection:"ledger" bson:"-"`
+	TraceId          string  `bson:"trace_id,omitempty"`          // 日志ID
+	UserId           int     `bson:"user_id,omitempty"`           // 用户ID
+	AppId            int     `bson:"app_id,omitempty"`            // 应用ID
+	AppKey           string  `bson:"app_key,omitempty"`           // 应用密钥哈希
+	EndUser          string  `bson:"end_user,omitempty"`          // 终端用户
+	Model            string  `bson:"model,omitempty"`             // 模型
+	ModelId          string  `bson:"model_id,omitempty"`          // 模型ID
+	Key              string  `bson:"key,omitempty"`               // 模型密钥ID
+	Action           string  `bson:"action,omitempty"`            // 计费项
+	PromptTokens     int     `bson:"prompt_tokens,omitempty"`     // 提示tokens
+	CompletionTokens int     `bson:"completion_tokens,omitempty"` // 补全tokens
+	CachedTokens     int     `bson:"cached_tokens,omitempty"`     // 缓存提示tokens
+	ReasoningTokens  int     `bson:"reasoning_tokens,omitempty"`  // 推理tokens
+	ImageSize        string  `bson:"image_size,omitempty"`        // 图像尺寸
+	ImageQuality     string  `bson:"image_quality,omitempty"`     // 图像质量
+	ImageCount       int     `bson:"image_count,omitempty"`       // 图像数量
+	AudioMinutes     float64 `bson:"audio_minutes,omitempty"`     // 音频时长, 单位: 分钟
+	MidjourneyAction string  `bson:"midjourney_action,omitempty"` // Midjourney动作
+	BillingMethod    string  `bson:"billing_method,omitempty"`    // 计费方式[price:按价格, ratio:按倍率]
+	Currency         string  `bson:"currency,omitempty"`          // 币种
+	Amount           float64 `bson:"amount,omitempty"`            // 费用
+	ExchangeRate     float64 `bson:"exchange_rate,omitempty"`     // 汇率, 1单位币种兑换的美元
+	Quota            int     `bson:"quota,omitempty"`             // 额度
+	Creator          string  `bson:"creator,omitempty"`           // 创建人
+	CreatedAt        int64   `bson:"created_at,omitempty"`        // 创建时间
+}
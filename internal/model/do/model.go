@@ -26,6 +26,8 @@ type Model struct {
 	RealtimeQuota        common.RealtimeQuota        `bson:"realtime_quota,omitempty"`          // 多模态实时额度
 	MultimodalAudioQuota common.MultimodalAudioQuota `bson:"multimodal_audio_quota,omitempty"`  // 多模态语音额度
 	MidjourneyQuotas     []common.MidjourneyQuota    `bson:"midjourney_quotas,omitempty"`       // Midjourney额度
+	Pricing              *common.Pricing             `bson:"pricing,omitempty"`                 // 价格
+	Tokenizer            string                      `bson:"tokenizer,omitempty"`               // 分词器
 	DataFormat           int                         `bson:"data_format,omitempty"`             // 数据格式[1:统一格式, 2:官方格式]
 	IsPublic             bool                        `bson:"is_public,omitempty"`               // 是否公开
 	IsEnableModelAgent   bool                        `bson:"is_enable_model_agent,omitempty"`   // 是否启用模型代理
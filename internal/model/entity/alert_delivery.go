@@ -0,0 +1,19 @@
+package entity
+
+type AlertDelivery struct {
+	Id         string `bson:"_id,omitempty"`         // ID
+	EventId    string `bson:"event_id,omitempty"`    // 事件ID
+	Event      string `bson:"event,omitempty"`       // 事件类型
+	Url        string `bson:"url,omitempty"`         // 推送地址
+	Payload    string `bson:"payload,omitempty"`     // 推送内容
+	Attempts   int    `bson:"attempts,omitempty"`    // 推送次数
+	StatusCode int    `bson:"status_code,omitempty"` // 响应状态码
+	Response   string `bson:"response,omitempty"`    // 响应内容
+	ErrMsg     string `bson:"err_msg,omitempty"`     // 错误信息
+	TotalTime  int64  `bson:"total_time,omitempty"`  // 总时间
+	Status     int    `bson:"status,omitempty"`      // 状态[1:成功, -1:失败]
+	Creator    string `bson:"creator,omitempty"`     // 创建人
+	Updater    string `bson:"updater,omitempty"`     // 更新人
+	CreatedAt  int64  `bson:"created_at,omitempty"`  // 创建时间
+	UpdatedAt  int64  `bson:"updated_at,omitempty"`  // 更新时间
+}
@@ -1,21 +1,26 @@
 package entity
 
+import "github.com/iimeta/fastapi/internal/model/common"
+
 type App struct {
-	Id             string   `bson:"_id,omitempty"`              // ID
-	AppId          int      `bson:"app_id,omitempty"`           // 应用ID
-	Name           string   `bson:"name,omitempty"`             // 应用名称
-	Models         []string `bson:"models,omitempty"`           // 模型权限
-	IsLimitQuota   bool     `bson:"is_limit_quota,omitempty"`   // 是否限制额度
-	Quota          int      `bson:"quota,omitempty"`            // 剩余额度
-	UsedQuota      int      `bson:"used_quota,omitempty"`       // 已用额度
-	QuotaExpiresAt int64    `bson:"quota_expires_at,omitempty"` // 额度过期时间
-	IpWhitelist    []string `bson:"ip_whitelist,omitempty"`     // IP白名单
-	IpBlacklist    []string `bson:"ip_blacklist,omitempty"`     // IP黑名单
-	Remark         string   `bson:"remark,omitempty"`           // 备注
-	Status         int      `bson:"status,omitempty"`           // 状态[1:正常, 2:禁用, -1:删除]
-	UserId         int      `bson:"user_id,omitempty"`          // 用户ID
-	Creator        string   `bson:"creator,omitempty"`          // 创建人
-	Updater        string   `bson:"updater,omitempty"`          // 更新人
-	CreatedAt      int64    `bson:"created_at,omitempty"`       // 创建时间
-	UpdatedAt      int64    `bson:"updated_at,omitempty"`       // 更新时间
+	Id             string            `bson:"_id,omitempty"`              // ID
+	AppId          int               `bson:"app_id,omitempty"`           // 应用ID
+	Name           string            `bson:"name,omitempty"`             // 应用名称
+	Models         []string          `bson:"models,omitempty"`           // 模型权限
+	IsLimitQuota   bool              `bson:"is_limit_quota,omitempty"`   // 是否限制额度
+	Quota          int               `bson:"quota,omitempty"`            // 剩余额度
+	UsedQuota      int               `bson:"used_quota,omitempty"`       // 已用额度
+	QuotaExpiresAt int64             `bson:"quota_expires_at,omitempty"` // 额度过期时间
+	Budgets        []common.Budget   `bson:"budgets,omitempty"`          // 周期预算
+	IpWhitelist    []string          `bson:"ip_whitelist,omitempty"`     // IP白名单
+	IpBlacklist    []string          `bson:"ip_blacklist,omitempty"`     // IP黑名单
+	LogPolicy      *common.LogPolicy `bson:"log_policy,omitempty"`       // 日志策略, 为空时使用全局配置
+	Remark         string            `bson:"remark,omitempty"`           // 备注
+	Status         int               `bson:"status,omitempty"`           // 状态[1:正常, 2:禁用, -1:删除]
+	UserId         int               `bson:"user_id,omitempty"`          // 用户ID
+	Creator        string            `bson:"creator,omitempty"`          // 创建人
+	Updater        string            `bson:"updater,omitempty"`          // 更新人
+	CreatedAt      int64             `bson:"created_at,omitempty"`       // 创建时间
+	UpdatedAt      int64             `bson:"updated_at,omitempty"`       // 更新时间
+	Version        int64             `bson:"version,omitempty"`          // 版本号, 配置变更时递增
 }
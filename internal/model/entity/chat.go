@@ -7,6 +7,7 @@ type Chat struct {
 	TraceId              string                      `bson:"trace_id,omitempty"`                // 日志ID
 	UserId               int                         `bson:"user_id,omitempty"`                 // 用户ID
 	AppId                int                         `bson:"app_id,omitempty"`                  // 应用ID
+	EndUser              string                      `bson:"end_user,omitempty"`                // 终端用户
 	Corp                 string                      `bson:"corp,omitempty"`                    // 公司
 	ModelId              string                      `bson:"model_id,omitempty"`                // 模型ID
 	Name                 string                      `bson:"name,omitempty"`                    // 模型名称
@@ -37,6 +38,8 @@ type Chat struct {
 	PromptTokens         int                         `bson:"prompt_tokens,omitempty"`           // 提示令牌数(提问令牌数)
 	CompletionTokens     int                         `bson:"completion_tokens,omitempty"`       // 补全令牌数(回答令牌数)
 	TotalTokens          int                         `bson:"total_tokens,omitempty"`            // 总令牌数
+	CachedTokens         int                         `bson:"cached_tokens,omitempty"`           // 缓存命中令牌数
+	ReasoningTokens      int                         `bson:"reasoning_tokens,omitempty"`        // 推理令牌数
 	ConnTime             int64                       `bson:"conn_time,omitempty"`               // 连接时间
 	Duration             int64                       `bson:"duration,omitempty"`                // 持续时间
 	TotalTime            int64                       `bson:"total_time,omitempty"`              // 总时间
@@ -49,6 +52,8 @@ type Chat struct {
 	ErrMsg               string                      `bson:"err_msg,omitempty"`                 // 错误信息
 	IsRetry              bool                        `bson:"is_retry,omitempty"`                // 是否重试
 	Retry                *common.Retry               `bson:"retry,omitempty"`                   // 重试
+	RepairCount          int                         `bson:"repair_count,omitempty"`            // 结构化输出修复次数
+	RepairFail           bool                        `bson:"repair_fail,omitempty"`             // 结构化输出修复后仍校验不通过
 	Status               int                         `bson:"status,omitempty"`                  // 状态[1:成功, -1:失败, 2:中止, 3:重试]
 	Host                 string                      `bson:"host,omitempty"`                    // Host
 	Creator              string                      `bson:"creator,omitempty"`                 // 创建人
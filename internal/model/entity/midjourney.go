@@ -9,6 +9,7 @@ type Midjourney struct {
 	TraceId              string                   `bson:"trace_id,omitempty"`                // 日志ID
 	UserId               int                      `bson:"user_id,omitempty"`                 // 用户ID
 	AppId                int                      `bson:"app_id,omitempty"`                  // 应用ID
+	EndUser              string                   `bson:"end_user,omitempty"`                // 终端用户
 	Corp                 string                   `bson:"corp,omitempty"`                    // 公司
 	ModelId              string                   `bson:"model_id,omitempty"`                // 模型ID
 	Name                 string                   `bson:"name,omitempty"`                    // 模型名称
@@ -16,4 +16,5 @@ type ModelAgent struct {
 	Updater            string `bson:"updater,omitempty"`              // 更新人
 	CreatedAt          int64  `bson:"created_at,omitempty"`           // 创建时间
 	UpdatedAt          int64  `bson:"updated_at,omitempty"`           // 更新时间
+	Version            int64  `bson:"version,omitempty"`              // 版本号, 配置变更时递增
 }
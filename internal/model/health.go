@@ -0,0 +1,17 @@
+package model
+
+// 健康检查结果
+type Health struct {
+	Status    string                  `json:"status"`    // 状态[ok:正常, warn:警告, fail:异常]
+	Uptime    int64                   `json:"uptime"`    // 运行时间, 单位秒
+	Timestamp int64                   `json:"timestamp"` // 检查时间
+	Checks    map[string]*HealthCheck `json:"checks"`    // 检查项
+}
+
+// 健康检查项
+type HealthCheck struct {
+	Status  string      `json:"status"`            // 状态[ok:正常, warn:警告, fail:异常]
+	Latency int64       `json:"latency,omitempty"` // 耗时, 单位毫秒
+	Error   string      `json:"error,omitempty"`   // 错误信息
+	Detail  interface{} `json:"detail,omitempty"`  // 详情
+}
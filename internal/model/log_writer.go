@@ -0,0 +1,14 @@
+package model
+
+// 日志写入统计
+type LogWriterStats struct {
+	Enqueued  int64 `json:"enqueued"`   // 入队数
+	Written   int64 `json:"written"`    // 写入数
+	Rejected  int64 `json:"rejected"`   // 队列已满被拒绝数
+	Spilled   int64 `json:"spilled"`    // 写入溢出文件数
+	Replayed  int64 `json:"replayed"`   // 溢出文件回放数
+	Failed    int64 `json:"failed"`     // 写入失败数
+	QueueLen  int   `json:"queue_len"`  // 队列长度
+	QueueCap  int   `json:"queue_cap"`  // 队列容量
+	SpillSize int64 `json:"spill_size"` // 溢出文件大小
+}
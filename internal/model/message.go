@@ -4,6 +4,7 @@ type PubMessage struct {
 	Action  string `json:"action,omitempty"`   // 消息动作
 	OldData any    `json:"old_data,omitempty"` // 旧数据
 	NewData any    `json:"new_data,omitempty"` // 新数据
+	Version int64  `json:"version,omitempty"`  // 变更版本号, 写入变更流的消息才有
 }
 
 type SubMessage struct {
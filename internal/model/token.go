@@ -0,0 +1,28 @@
+package model
+
+// 派生令牌声明
+type TokenClaims struct {
+	Id        string   `json:"jti"`                 // 令牌ID
+	KeyHash   string   `json:"key"`                 // 父密钥哈希
+	UserId    int      `json:"uid"`                 // 用户ID
+	AppId     int      `json:"aid"`                 // 应用ID
+	Models    []string `json:"models,omitempty"`    // 模型
+	MaxSpend  int      `json:"max_spend,omitempty"` // 最大花费额度
+	EndUser   string   `json:"end_user,omitempty"`  // 终端用户
+	IssuedAt  int64    `json:"iat"`                 // 签发时间
+	ExpiresAt int64    `json:"exp"`                 // 过期时间
+}
+
+// 签发令牌接口请求参数
+type TokenCreateReq struct {
+	Models    []string `json:"models"`     // 模型
+	MaxSpend  int      `json:"max_spend"`  // 最大花费额度
+	ExpiresIn int64    `json:"expires_in"` // 有效期, 单位: 秒
+	EndUser   string   `json:"end_user"`   // 终端用户
+}
+
+// 签发令牌接口响应参数
+type TokenCreateRes struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"`
+}
@@ -40,6 +40,8 @@ type (
 		GetCacheAppKey(ctx context.Context, secretKey string) (*model.Key, error)
 		// 更新缓存中的应用密钥信息
 		UpdateCacheAppKey(ctx context.Context, key *entity.Key)
+		// 旧版明文应用密钥首次使用时补充哈希, 返回是否存在该密钥
+		HashLegacyAppKey(ctx context.Context, secretKey string) bool
 		// 移除缓存中的应用密钥信息
 		RemoveCacheAppKey(ctx context.Context, secretKey string)
 		// 应用密钥花费额度
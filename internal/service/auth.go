@@ -13,7 +13,7 @@ type (
 	IAuth interface {
 		// 身份核验
 		Authenticator(ctx context.Context, secretKey string) error
-		// 核验密钥
+		// 核验密钥, secretKey为密钥哈希
 		VerifySecretKey(ctx context.Context, secretKey string) error
 	}
 )
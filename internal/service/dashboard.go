@@ -17,6 +17,8 @@ type (
 		Subscription(ctx context.Context) (*model.DashboardSubscriptionRes, error)
 		// Usage
 		Usage(ctx context.Context) (*model.DashboardUsageRes, error)
+		// EndUserUsage
+		EndUserUsage(ctx context.Context, endUser string) (*model.DashboardEndUserUsageRes, error)
 	}
 )
 
@@ -14,12 +14,14 @@ import (
 
 type (
 	IKey interface {
-		// 根据secretKey获取密钥信息
-		GetKey(ctx context.Context, secretKey string) (*model.Key, error)
+		// 根据密钥哈希获取密钥信息
+		GetKey(ctx context.Context, keyHash string) (*model.Key, error)
 		// 根据模型ID获取密钥列表
 		GetModelKeys(ctx context.Context, id string) ([]*model.Key, error)
 		// 密钥列表
 		List(ctx context.Context, typ int) ([]*model.Key, error)
+		// 旧版明文应用密钥哈希存储, 用于未执行迁移的密钥首次使用时补充哈希
+		HashAppKey(ctx context.Context, secretKey string) error
 		// 挑选模型密钥
 		PickModelKey(ctx context.Context, m *model.Model) (int, *model.Key, error)
 		// 移除模型密钥
@@ -39,7 +41,7 @@ type (
 		// 移除缓存中的模型密钥
 		RemoveCacheModelKey(ctx context.Context, key *entity.Key)
 		// 密钥已用额度
-		UsedQuota(ctx context.Context, key string, quota int) error
+		UsedQuota(ctx context.Context, id string, quota int) error
 		// 变更订阅
 		Subscribe(ctx context.Context, msg string) error
 	}
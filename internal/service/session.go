@@ -15,13 +15,21 @@ type (
 	ISession interface {
 		// 保存会话
 		Save(ctx context.Context, secretKey string) error
+		// 保存派生令牌会话, 使用父密钥的用户、应用和密钥哈希
+		SaveToken(ctx context.Context, claims *model.TokenClaims)
+		// 获取会话中的派生令牌声明
+		GetToken(ctx context.Context) *model.TokenClaims
+		// 保存终端用户
+		SaveEndUser(ctx context.Context, endUser string)
+		// 获取终端用户, 派生令牌中指定的终端用户优先
+		GetEndUser(ctx context.Context) string
 		// 保存应用和密钥是否限制额度
 		SaveIsLimitQuota(ctx context.Context, app bool, key bool)
 		// 获取用户ID
 		GetUserId(ctx context.Context) int
 		// 获取应用ID
 		GetAppId(ctx context.Context) int
-		// 获取密钥
+		// 获取密钥哈希
 		GetSecretKey(ctx context.Context) string
 		// 获取应用是否限制额度
 		GetAppIsLimitQuota(ctx context.Context) bool
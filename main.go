@@ -19,5 +19,9 @@ func main() {
 		panic(err)
 	}
 
+	if err := cmd.Main.AddCommand(&cmd.Rekey, &cmd.Export, &cmd.Apply, &cmd.HashKeys); err != nil {
+		panic(err)
+	}
+
 	cmd.Main.Run(gctx.GetInitCtx())
 }
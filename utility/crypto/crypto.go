@@ -3,6 +3,7 @@ package crypto
 import (
 	"encoding/hex"
 	"github.com/tjfoc/gmsm/sm3"
+	"strings"
 )
 
 func SM3(data string) string {
@@ -18,3 +19,33 @@ func EncryptPassword(data string) string {
 func VerifyPassword(cipherPwd, plainPwd string) bool {
 	return cipherPwd == SM3(plainPwd)
 }
+
+// 客户端密钥哈希, 存储和缓存中只保留哈希值
+func HashKey(key string) string {
+	return SM3(key)
+}
+
+// 客户端密钥展示前缀
+func KeyPrefix(key string) string {
+
+	if len(key) <= 14 {
+		return MaskKey(key)
+	}
+
+	return key[:10] + "****" + key[len(key)-4:]
+}
+
+// 是否为展示前缀或掩码, 明文密钥中不包含掩码字符
+func IsKeyPrefix(key string) bool {
+	return strings.Contains(key, "****")
+}
+
+// 掩码密钥, 只保留末4位
+func MaskKey(key string) string {
+
+	if len(key) <= 8 {
+		return "****"
+	}
+
+	return "****" + key[len(key)-4:]
+}
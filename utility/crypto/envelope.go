@@ -0,0 +1,162 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// 信封加密密文前缀, 格式: enc:v1:<主密钥ID>:<加密后的数据密钥>:<加密后的数据>
+const ENVELOPE_PREFIX = "enc:v1:"
+
+// 是否为信封加密密文
+func IsEnvelope(data string) bool {
+	return strings.HasPrefix(data, ENVELOPE_PREFIX)
+}
+
+// 主密钥ID, 用于识别密文由哪个主密钥加密
+func MasterKeyId(masterKey []byte) string {
+	sum := sha256.Sum256(masterKey)
+	return hex.EncodeToString(sum[:4])
+}
+
+// 获取密文的主密钥ID
+func EnvelopeKeyId(data string) string {
+
+	parts := strings.Split(strings.TrimPrefix(data, ENVELOPE_PREFIX), ":")
+	if len(parts) != 3 {
+		return ""
+	}
+
+	return parts[0]
+}
+
+// 信封加密, 每条数据生成独立的数据密钥, 数据密钥由主密钥加密
+func EnvelopeEncrypt(masterKey []byte, plaintext string) (string, error) {
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := aesGcmSeal(masterKey, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := aesGcmSeal(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+
+	return ENVELOPE_PREFIX + MasterKeyId(masterKey) + ":" + base64.RawStdEncoding.EncodeToString(wrappedKey) + ":" + base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// 信封解密
+func EnvelopeDecrypt(masterKey []byte, data string) (string, error) {
+
+	dataKey, ciphertext, err := unwrap(masterKey, data)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := aesGcmOpen(dataKey, ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// 使用新主密钥重新加密数据密钥, 数据本身不变
+func EnvelopeRewrap(oldMasterKey, newMasterKey []byte, data string) (string, error) {
+
+	dataKey, ciphertext, err := unwrap(oldMasterKey, data)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := aesGcmSeal(newMasterKey, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	return ENVELOPE_PREFIX + MasterKeyId(newMasterKey) + ":" + base64.RawStdEncoding.EncodeToString(wrappedKey) + ":" + base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+func unwrap(masterKey []byte, data string) ([]byte, []byte, error) {
+
+	if !IsEnvelope(data) {
+		return nil, nil, errors.New("invalid envelope data")
+	}
+
+	parts := strings.Split(strings.TrimPrefix(data, ENVELOPE_PREFIX), ":")
+	if len(parts) != 3 {
+		return nil, nil, errors.New("invalid envelope data")
+	}
+
+	if parts[0] != MasterKeyId(masterKey) {
+		return nil, nil, errors.New("envelope master key mismatch")
+	}
+
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dataKey, err := aesGcmOpen(masterKey, wrappedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dataKey, ciphertext, nil
+}
+
+func aesGcmSeal(key, plaintext []byte) ([]byte, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func aesGcmOpen(key, ciphertext []byte) ([]byte, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("invalid ciphertext")
+	}
+
+	return gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+}
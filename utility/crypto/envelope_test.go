@@ -0,0 +1,159 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestEnvelopeEncryptDecrypt(t *testing.T) {
+
+	masterKey := bytes.Repeat([]byte{1}, 32)
+
+	tests := []struct {
+		name      string
+		plaintext string
+	}{
+		{"空字符串", ""},
+		{"密钥", "sk-abcdefghijklmnopqrstuvwxyz"},
+		{"多行密钥", "sk-1\nsk-2\nsk-3"},
+		{"中文", "密钥"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			data, err := EnvelopeEncrypt(masterKey, test.plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !IsEnvelope(data) {
+				t.Fatalf("data: %s, want prefix: %s", data, ENVELOPE_PREFIX)
+			}
+
+			if keyId := EnvelopeKeyId(data); keyId != MasterKeyId(masterKey) {
+				t.Fatalf("key id: %s, want: %s", keyId, MasterKeyId(masterKey))
+			}
+
+			if test.plaintext != "" && strings.Contains(data, test.plaintext) {
+				t.Fatalf("data: %s, contains plaintext", data)
+			}
+
+			plaintext, err := EnvelopeDecrypt(masterKey, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if plaintext != test.plaintext {
+				t.Fatalf("plaintext: %q, want: %q", plaintext, test.plaintext)
+			}
+		})
+	}
+
+	// 每次加密使用独立的数据密钥和随机数
+	first, _ := EnvelopeEncrypt(masterKey, "sk-test")
+	second, _ := EnvelopeEncrypt(masterKey, "sk-test")
+	if first == second {
+		t.Fatalf("data: %s, want different ciphertext", first)
+	}
+}
+
+func TestEnvelopeDecryptError(t *testing.T) {
+
+	masterKey := bytes.Repeat([]byte{1}, 32)
+	otherKey := bytes.Repeat([]byte{2}, 32)
+
+	data, err := EnvelopeEncrypt(masterKey, "sk-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(data, ENVELOPE_PREFIX), ":")
+
+	// 篡改数据密文的最后一个字节
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext[len(ciphertext)-1] ^= 1
+	tampered := ENVELOPE_PREFIX + parts[0] + ":" + parts[1] + ":" + base64.RawStdEncoding.EncodeToString(ciphertext)
+
+	tests := []struct {
+		name      string
+		masterKey []byte
+		data      string
+	}{
+		{"明文", masterKey, "sk-test"},
+		{"字段缺失", masterKey, ENVELOPE_PREFIX + parts[0] + ":" + parts[1]},
+		{"主密钥不匹配", otherKey, data},
+		{"主密钥ID正确但密钥错误", otherKey, ENVELOPE_PREFIX + MasterKeyId(otherKey) + ":" + parts[1] + ":" + parts[2]},
+		{"非法base64", masterKey, ENVELOPE_PREFIX + parts[0] + ":!!!:" + parts[2]},
+		{"密文被篡改", masterKey, tampered},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if plaintext, err := EnvelopeDecrypt(test.masterKey, test.data); err == nil {
+				t.Fatalf("plaintext: %q, want error", plaintext)
+			}
+		})
+	}
+}
+
+func TestEnvelopeRewrap(t *testing.T) {
+
+	oldMasterKey := bytes.Repeat([]byte{1}, 32)
+	newMasterKey := bytes.Repeat([]byte{2}, 32)
+
+	data, err := EnvelopeEncrypt(oldMasterKey, "sk-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rewrapped, err := EnvelopeRewrap(oldMasterKey, newMasterKey, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keyId := EnvelopeKeyId(rewrapped); keyId != MasterKeyId(newMasterKey) {
+		t.Fatalf("key id: %s, want: %s", keyId, MasterKeyId(newMasterKey))
+	}
+
+	// 只重新加密数据密钥, 数据密文不变
+	if dataPart, rewrappedPart := data[strings.LastIndex(data, ":"):], rewrapped[strings.LastIndex(rewrapped, ":"):]; dataPart != rewrappedPart {
+		t.Fatalf("ciphertext: %s, want: %s", rewrappedPart, dataPart)
+	}
+
+	if plaintext, err := EnvelopeDecrypt(newMasterKey, rewrapped); err != nil || plaintext != "sk-test" {
+		t.Fatalf("plaintext: %q, error: %v, want: sk-test", plaintext, err)
+	}
+
+	if _, err = EnvelopeDecrypt(oldMasterKey, rewrapped); err == nil {
+		t.Fatal("decrypt with old master key, want error")
+	}
+
+	if _, err = EnvelopeRewrap(newMasterKey, oldMasterKey, data); err == nil {
+		t.Fatal("rewrap with wrong master key, want error")
+	}
+}
+
+func TestEnvelopeKeyId(t *testing.T) {
+
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"enc:v1:0a1b2c3d:key:data", "0a1b2c3d"},
+		{"enc:v1:0a1b2c3d:key", ""},
+		{"sk-test", ""},
+	}
+
+	for _, test := range tests {
+		if keyId := EnvelopeKeyId(test.data); keyId != test.want {
+			t.Fatalf("data: %s, key id: %s, want: %s", test.data, keyId, test.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+const jwtHeader = `{"alg":"HS256","typ":"JWT"}`
+
+// 是否为JWT格式
+func IsJwt(token string) bool {
+	return strings.HasPrefix(token, "eyJ") && strings.Count(token, ".") == 2
+}
+
+// 使用HS256签发JWT
+func JwtSign(secret []byte, claims any) (string, error) {
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	data := base64.RawURLEncoding.EncodeToString([]byte(jwtHeader)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	return data + "." + jwtSignature(secret, data), nil
+}
+
+// 校验HS256签名并解析JWT, 过期时间等声明由调用方校验
+func JwtParse(secret []byte, token string, claims any) error {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("invalid jwt")
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return err
+	}
+
+	alg := struct {
+		Alg string `json:"alg"`
+	}{}
+
+	if err = json.Unmarshal(header, &alg); err != nil {
+		return err
+	}
+
+	if alg.Alg != "HS256" {
+		return errors.New("unsupported jwt alg")
+	}
+
+	if !hmac.Equal([]byte(parts[2]), []byte(jwtSignature(secret, parts[0]+"."+parts[1]))) {
+		return errors.New("invalid jwt signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(payload, claims)
+}
+
+func jwtSignature(secret []byte, data string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
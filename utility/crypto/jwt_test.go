@@ -0,0 +1,97 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+type testClaims struct {
+	Sub string `json:"sub"`
+	Exp int64  `json:"exp"`
+}
+
+func TestJwtSignParse(t *testing.T) {
+
+	secret := []byte("secret")
+
+	token, err := JwtSign(secret, testClaims{Sub: "app:1", Exp: 1700000000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsJwt(token) {
+		t.Fatalf("token: %s, want jwt", token)
+	}
+
+	claims := new(testClaims)
+	if err = JwtParse(secret, token, claims); err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Sub != "app:1" || claims.Exp != 1700000000 {
+		t.Fatalf("claims: %+v, want: {Sub:app:1 Exp:1700000000}", *claims)
+	}
+}
+
+func TestJwtParseError(t *testing.T) {
+
+	secret := []byte("secret")
+
+	token, err := JwtSign(secret, testClaims{Sub: "app:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	// 使用原签名替换载荷, 不重新签名
+	forged := parts[0] + "." + encode(`{"sub":"app:2"}`) + "." + parts[2]
+
+	// 声明算法为none, 去掉签名
+	none := encode(`{"alg":"none","typ":"JWT"}`) + "." + parts[1] + "."
+
+	tests := []struct {
+		name   string
+		secret []byte
+		token  string
+	}{
+		{"格式错误", secret, "eyJhbGciOiJIUzI1NiJ9.payload"},
+		{"密钥错误", []byte("other"), token},
+		{"载荷被篡改", secret, forged},
+		{"不支持的算法", secret, none},
+		{"头部非法base64", secret, "!!!." + parts[1] + "." + parts[2]},
+		{"签名为空", secret, parts[0] + "." + parts[1] + "."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := new(testClaims)
+			if err := JwtParse(test.secret, test.token, claims); err == nil {
+				t.Fatalf("claims: %+v, want error", *claims)
+			}
+		})
+	}
+}
+
+func TestIsJwt(t *testing.T) {
+
+	tests := []struct {
+		token string
+		want  bool
+	}{
+		{"eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxIn0.sig", true},
+		{"sk-abcdefghijklmnopqrstuvwxyz", false},
+		{"eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxIn0", false},
+		{"abc.def.ghi", false},
+	}
+
+	for _, test := range tests {
+		if isJwt := IsJwt(test.token); isJwt != test.want {
+			t.Fatalf("token: %s, is jwt: %t, want: %t", test.token, isJwt, test.want)
+		}
+	}
+}
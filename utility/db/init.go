@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"github.com/gogf/gf/v2/os/gctx"
 	"github.com/iimeta/fastapi/internal/config"
@@ -42,3 +43,7 @@ func init() {
 
 	DefaultDatabase = database.String()
 }
+
+func Ping(ctx context.Context) error {
+	return client.Ping(ctx, readpref.Primary())
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -169,6 +170,10 @@ func (m *MongoDB) EstimatedDocumentCount(ctx context.Context) (int64, error) {
 	return client.Database(m.Database).Collection(m.Collection).EstimatedDocumentCount(ctx)
 }
 
+func (m *MongoDB) CreateIndexes(ctx context.Context, models []mongo.IndexModel) ([]string, error) {
+	return client.Database(m.Database).Collection(m.Collection).Indexes().CreateMany(ctx, models)
+}
+
 func (m *MongoDB) Aggregate(ctx context.Context, result interface{}, opts ...*options.AggregateOptions) error {
 
 	cursor, err := client.Database(m.Database).Collection(m.Collection).Aggregate(ctx, m.Pipeline, opts...)
@@ -0,0 +1,109 @@
+package graceful
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/os/grpool"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	taskCount    atomic.Int64
+	sessionCount atomic.Int64
+	shuttingDown atomic.Bool
+)
+
+// 添加后台任务, 用法同grpool.Add, 关闭时等待任务完成, 如: 记录用量, 保存日志
+func Add(ctx context.Context, f grpool.Func) error {
+	return AddWithRecover(ctx, f, nil)
+}
+
+// 添加后台任务, 用法同grpool.AddWithRecover, 关闭时等待任务完成
+func AddWithRecover(ctx context.Context, userFunc grpool.Func, recoverFunc grpool.RecoverFunc) error {
+
+	taskCount.Add(1)
+
+	done := func() {
+		taskCount.Add(-1)
+	}
+
+	job := func(ctx context.Context) {
+		defer done()
+		userFunc(ctx)
+	}
+
+	var err error
+	if recoverFunc == nil {
+		err = grpool.Add(ctx, job)
+	} else {
+		err = grpool.AddWithRecover(ctx, job, recoverFunc)
+	}
+
+	if err != nil {
+		done()
+	}
+
+	return err
+}
+
+// 跟踪长连接会话, 如: 实时语音, 返回会话结束时调用的函数
+func Track() func() {
+
+	sessionCount.Add(1)
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			sessionCount.Add(-1)
+		})
+	}
+}
+
+// 标记正在关闭
+func SetShuttingDown() {
+	shuttingDown.Store(true)
+}
+
+// 是否正在关闭
+func IsShuttingDown() bool {
+	return shuttingDown.Load()
+}
+
+// 进行中的后台任务数
+func Tasks() int64 {
+	return taskCount.Load()
+}
+
+// 进行中的会话数
+func Sessions() int64 {
+	return sessionCount.Load()
+}
+
+// 等待后台任务完成, 超时返回false
+func WaitTasks(timeout time.Duration) bool {
+	return wait(&taskCount, timeout)
+}
+
+// 等待会话结束, 超时返回false
+func WaitSessions(timeout time.Duration) bool {
+	return wait(&sessionCount, timeout)
+}
+
+// 轮询等待计数归零, 关闭期间仍可能有新任务加入, 不使用WaitGroup
+func wait(count *atomic.Int64, timeout time.Duration) bool {
+
+	deadline := time.Now().Add(timeout)
+
+	for count.Load() > 0 {
+
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return true
+}
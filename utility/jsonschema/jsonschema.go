@@ -0,0 +1,258 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gogf/gf/v2/text/gstr"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+)
+
+// 校验JSON是否符合JSON Schema, 支持常用关键字
+func Validate(data []byte, schema map[string]interface{}) error {
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	validator := &schemaValidator{root: schema}
+
+	err := validator.validate(value, schema, "$", 0, nil)
+	if validator.err != nil {
+		return validator.err
+	}
+
+	return err
+}
+
+const (
+	schemaMaxDepth = 64     // 最大嵌套深度
+	schemaMaxSteps = 100000 // 最大校验次数, 避免anyOf/oneOf组合引用导致指数级校验
+)
+
+type schemaValidator struct {
+	root  map[string]interface{}
+	steps int
+	err   error // 超出限制的错误, 不会被anyOf/oneOf忽略
+}
+
+// refs为当前路径上已解析的$ref, 值未向下一层时再次解析相同的$ref即为循环引用
+func (s *schemaValidator) validate(value interface{}, schema map[string]interface{}, path string, depth int, refs []string) error {
+
+	if s.err != nil {
+		return s.err
+	}
+
+	if s.steps++; s.steps > schemaMaxSteps || depth > schemaMaxDepth {
+		s.err = fmt.Errorf("%s: schema is too complex", path)
+		return s.err
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+
+		if slices.Contains(refs, ref) {
+			s.err = fmt.Errorf("%s: circular $ref %s", path, ref)
+			return s.err
+		}
+
+		if !gstr.HasPrefix(ref, "#/") {
+			return nil
+		}
+
+		var node interface{} = s.root
+		for _, key := range gstr.Split(gstr.TrimLeftStr(ref, "#/"), "/") {
+			if m, ok := node.(map[string]interface{}); ok {
+				node = m[key]
+			} else {
+				return fmt.Errorf("%s: unresolved $ref %s", path, ref)
+			}
+		}
+
+		refSchema, ok := node.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: unresolved $ref %s", path, ref)
+		}
+
+		return s.validate(value, refSchema, path, depth+1, append(refs[:len(refs):len(refs)], ref))
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+
+		valueType := jsonType(value)
+		if !slices.Contains(types, valueType) && !(valueType == "integer" && slices.Contains(types, "number")) {
+			return fmt.Errorf("%s: expected type %s, got %s", path, gstr.Join(types, "|"), valueType)
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		if !slices.ContainsFunc(enum, func(v interface{}) bool { return reflect.DeepEqual(v, value) }) {
+			return fmt.Errorf("%s: value is not one of the allowed values %v", path, enum)
+		}
+	}
+
+	if constValue, ok := schema["const"]; ok && !reflect.DeepEqual(constValue, value) {
+		return fmt.Errorf("%s: value must be %v", path, constValue)
+	}
+
+	for _, sub := range schemaList(schema["allOf"]) {
+		if err := s.validate(value, sub, path, depth+1, refs); err != nil {
+			return err
+		}
+	}
+
+	if anyOf := schemaList(schema["anyOf"]); len(anyOf) > 0 {
+		if !slices.ContainsFunc(anyOf, func(sub map[string]interface{}) bool { return s.validate(value, sub, path, depth+1, refs) == nil }) {
+			return fmt.Errorf("%s: value does not match any of the allowed schemas", path)
+		}
+	}
+
+	if oneOf := schemaList(schema["oneOf"]); len(oneOf) > 0 {
+
+		matched := 0
+		for _, sub := range oneOf {
+			if s.validate(value, sub, path, depth+1, refs) == nil {
+				matched++
+			}
+		}
+
+		if matched != 1 {
+			return fmt.Errorf("%s: value must match exactly one schema, matched %d", path, matched)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+
+		properties, _ := schema["properties"].(map[string]interface{})
+
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := v[fmt.Sprint(name)]; !ok {
+					return fmt.Errorf("%s: missing required property %q", path, name)
+				}
+			}
+		}
+
+		for name, field := range v {
+
+			if property, ok := properties[name].(map[string]interface{}); ok {
+				if err := s.validate(field, property, path+"."+name, depth+1, nil); err != nil {
+					return err
+				}
+				continue
+			}
+
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					return fmt.Errorf("%s: additional property %q is not allowed", path, name)
+				}
+			case map[string]interface{}:
+				if err := s.validate(field, additional, path+"."+name, depth+1, nil); err != nil {
+					return err
+				}
+			}
+		}
+
+	case []interface{}:
+
+		if min, ok := schema["minItems"].(float64); ok && float64(len(v)) < min {
+			return fmt.Errorf("%s: expected at least %v items, got %d", path, min, len(v))
+		}
+
+		if max, ok := schema["maxItems"].(float64); ok && float64(len(v)) > max {
+			return fmt.Errorf("%s: expected at most %v items, got %d", path, max, len(v))
+		}
+
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				if err := s.validate(item, items, fmt.Sprintf("%s[%d]", path, i), depth+1, nil); err != nil {
+					return err
+				}
+			}
+		}
+
+	case string:
+
+		length := float64(len([]rune(v)))
+
+		if min, ok := schema["minLength"].(float64); ok && length < min {
+			return fmt.Errorf("%s: expected length >= %v, got %v", path, min, length)
+		}
+
+		if max, ok := schema["maxLength"].(float64); ok && length > max {
+			return fmt.Errorf("%s: expected length <= %v, got %v", path, max, length)
+		}
+
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				return fmt.Errorf("%s: value does not match pattern %s", path, pattern)
+			}
+		}
+
+	case float64:
+
+		if min, ok := schema["minimum"].(float64); ok && v < min {
+			return fmt.Errorf("%s: expected value >= %v, got %v", path, min, v)
+		}
+
+		if max, ok := schema["maximum"].(float64); ok && v > max {
+			return fmt.Errorf("%s: expected value <= %v, got %v", path, max, v)
+		}
+	}
+
+	return nil
+}
+
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+func schemaTypes(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		types := make([]string, 0)
+		for _, t := range v {
+			types = append(types, fmt.Sprint(t))
+		}
+		return types
+	}
+	return nil
+}
+
+func schemaList(value interface{}) []map[string]interface{} {
+
+	list := make([]map[string]interface{}, 0)
+
+	if values, ok := value.([]interface{}); ok {
+		for _, v := range values {
+			if m, ok := v.(map[string]interface{}); ok {
+				list = append(list, m)
+			}
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,90 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// 解析测试用的JSON Schema
+func parseSchema(t *testing.T, schema string) map[string]interface{} {
+
+	t.Helper()
+
+	value := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(schema), &value); err != nil {
+		t.Fatal(err)
+	}
+
+	return value
+}
+
+func TestValidate(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		schema string
+		data   string
+		err    string // 为空时校验通过, 否则错误信息需包含该内容
+	}{
+		{"非法JSON", `{"type":"object"}`, `{`, "invalid JSON"},
+		{"类型匹配", `{"type":"string"}`, `"a"`, ""},
+		{"类型不匹配", `{"type":"string"}`, `1`, "$: expected type string, got integer"},
+		{"整数属于数字", `{"type":"number"}`, `1`, ""},
+		{"小数不属于整数", `{"type":"integer"}`, `1.5`, "expected type integer, got number"},
+		{"多个类型", `{"type":["string","null"]}`, `null`, ""},
+		{"枚举", `{"enum":["a","b"]}`, `"c"`, "not one of the allowed values"},
+		{"常量", `{"const":1}`, `1`, ""},
+		{"必填属性", `{"type":"object","required":["name"]}`, `{}`, `missing required property "name"`},
+		{"属性类型", `{"type":"object","properties":{"age":{"type":"integer"}}}`, `{"age":"1"}`, "$.age: expected type integer"},
+		{"禁止额外属性", `{"type":"object","properties":{"a":{}},"additionalProperties":false}`, `{"a":1,"b":2}`, `additional property "b" is not allowed`},
+		{"额外属性的Schema", `{"type":"object","additionalProperties":{"type":"string"}}`, `{"a":1}`, "$.a: expected type string"},
+		{"数组元素", `{"type":"array","items":{"type":"integer"}}`, `[1,"2"]`, "$[1]: expected type integer"},
+		{"最少元素", `{"type":"array","minItems":2}`, `[1]`, "expected at least 2 items"},
+		{"最多元素", `{"type":"array","maxItems":1}`, `[1,2]`, "expected at most 1 items"},
+		{"字符串长度按字符计算", `{"type":"string","maxLength":2}`, `"中文"`, ""},
+		{"最短长度", `{"type":"string","minLength":3}`, `"ab"`, "expected length >= 3"},
+		{"正则", `{"type":"string","pattern":"^[a-z]+$"}`, `"abc1"`, "does not match pattern"},
+		{"最小值", `{"type":"number","minimum":1}`, `0`, "expected value >= 1"},
+		{"最大值", `{"type":"number","maximum":1}`, `2`, "expected value <= 1"},
+		{"allOf", `{"allOf":[{"type":"integer"},{"minimum":2}]}`, `1`, "expected value >= 2"},
+		{"anyOf匹配", `{"anyOf":[{"type":"string"},{"type":"integer"}]}`, `1`, ""},
+		{"anyOf不匹配", `{"anyOf":[{"type":"string"},{"type":"integer"}]}`, `true`, "does not match any of the allowed schemas"},
+		{"oneOf匹配多个", `{"oneOf":[{"type":"number"},{"type":"integer"}]}`, `1`, "matched 2"},
+		{"引用", `{"$defs":{"name":{"type":"string"}},"properties":{"name":{"$ref":"#/$defs/name"}}}`, `{"name":1}`, "$.name: expected type string"},
+		{"引用不存在", `{"properties":{"name":{"$ref":"#/$defs/name"}}}`, `{"name":1}`, "unresolved $ref"},
+		{"递归引用", `{"$defs":{"node":{"type":"object","properties":{"next":{"$ref":"#/$defs/node"}}}},"$ref":"#/$defs/node"}`, `{"next":{"next":{}}}`, ""},
+		{"循环引用", `{"$defs":{"a":{"$ref":"#/$defs/b"},"b":{"$ref":"#/$defs/a"}},"$ref":"#/$defs/a"}`, `1`, "circular $ref"},
+		// anyOf中的循环引用不会被当作未匹配而忽略
+		{"anyOf中的循环引用", `{"$defs":{"a":{"$ref":"#/$defs/a"}},"anyOf":[{"$ref":"#/$defs/a"},{"type":"integer"}]}`, `1`, "circular $ref"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			err := Validate([]byte(test.data), parseSchema(t, test.schema))
+
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("error: %v, want: nil", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("error: %v, want: %s", err, test.err)
+			}
+		})
+	}
+}
+
+func TestValidateTooComplex(t *testing.T) {
+
+	// 嵌套超过最大深度
+	data := strings.Repeat("[", schemaMaxDepth+2) + strings.Repeat("]", schemaMaxDepth+2)
+	schema := parseSchema(t, `{"$defs":{"list":{"type":"array","items":{"$ref":"#/$defs/list"}}},"$ref":"#/$defs/list"}`)
+
+	if err := Validate([]byte(data), schema); err == nil || !strings.Contains(err.Error(), "schema is too complex") {
+		t.Fatalf("error: %v, want: schema is too complex", err)
+	}
+}
@@ -0,0 +1,276 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 基于Kafka协议的精简生产者, 只支持明文连接, 不压缩, 批次轮询写入分区
+type Producer struct {
+	brokers       []string
+	clientId      string
+	timeout       time.Duration
+	acks          int16
+	mutex         sync.Mutex
+	conns         map[string]net.Conn
+	partitions    map[string][]partition
+	correlationId int32
+	counter       int
+}
+
+type partition struct {
+	index  int32
+	leader string
+}
+
+func NewProducer(brokers []string, clientId string, timeout time.Duration) *Producer {
+	return &Producer{
+		brokers:    brokers,
+		clientId:   clientId,
+		timeout:    timeout,
+		acks:       1,
+		conns:      make(map[string]net.Conn),
+		partitions: make(map[string][]partition),
+	}
+}
+
+// 写入消息, 失败时刷新元数据后重试一次
+func (p *Producer) Produce(ctx context.Context, topic string, messages []Message) error {
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	err := p.produce(ctx, topic, messages)
+	if err == nil {
+		return nil
+	}
+
+	var kafkaErr Error
+	if errors.As(err, &kafkaErr) && !kafkaErr.Retriable() {
+		return err
+	}
+
+	delete(p.partitions, topic)
+
+	return p.produce(ctx, topic, messages)
+}
+
+// 关闭全部连接
+func (p *Producer) Close() error {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var err error
+	for addr, conn := range p.conns {
+		err = errors.Join(err, conn.Close())
+		delete(p.conns, addr)
+	}
+
+	return err
+}
+
+func (p *Producer) produce(ctx context.Context, topic string, messages []Message) error {
+
+	partitions, err := p.metadata(ctx, topic)
+	if err != nil {
+		return err
+	}
+
+	pt := partitions[p.counter%len(partitions)]
+	p.counter++
+
+	request := &encoder{}
+	request.nullString() // transactional_id
+	request.int16(p.acks)
+	request.int32(int32(p.timeout / time.Millisecond))
+	request.int32(1) // topic_data
+	request.string(topic)
+	request.int32(1) // partition_data
+	request.int32(pt.index)
+	request.bytes(encodeRecordBatch(messages, time.Now().UnixMilli()))
+
+	response, err := p.roundTrip(ctx, pt.leader, apiKeyProduce, produceVersion, request.buf)
+	if err != nil {
+		return err
+	}
+
+	d := &decoder{buf: response}
+	for i := d.int32(); i > 0 && d.err == nil; i-- {
+		d.string() // name
+		for j := d.int32(); j > 0 && d.err == nil; j-- {
+			d.int32() // index
+			if code := d.int16(); code != 0 {
+				return Error(code)
+			}
+			d.int64() // base_offset
+			d.int64() // log_append_time_ms
+		}
+	}
+
+	return d.err
+}
+
+// 获取主题的分区和首领, 优先使用缓存
+func (p *Producer) metadata(ctx context.Context, topic string) ([]partition, error) {
+
+	if partitions := p.partitions[topic]; len(partitions) > 0 {
+		return partitions, nil
+	}
+
+	request := &encoder{}
+	request.int32(1)
+	request.string(topic)
+
+	var lastErr error
+	for _, broker := range p.brokers {
+
+		response, err := p.roundTrip(ctx, broker, apiKeyMetadata, metadataVersion, request.buf)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		d := &decoder{buf: response}
+
+		brokers := make(map[int32]string)
+		for i := d.int32(); i > 0 && d.err == nil; i-- {
+			nodeId := d.int32()
+			host := d.string()
+			port := d.int32()
+			d.string() // rack
+			brokers[nodeId] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+		}
+
+		d.int32() // controller_id
+
+		partitions := make([]partition, 0)
+		for i := d.int32(); i > 0 && d.err == nil; i-- {
+
+			topicErr := d.int16()
+			name := d.string()
+			d.int8() // is_internal
+
+			for j := d.int32(); j > 0 && d.err == nil; j-- {
+
+				d.int16() // error_code
+				index := d.int32()
+				leader := d.int32()
+
+				for k := d.int32(); k > 0 && d.err == nil; k-- {
+					d.int32() // replica_nodes
+				}
+
+				for k := d.int32(); k > 0 && d.err == nil; k-- {
+					d.int32() // isr_nodes
+				}
+
+				if addr, ok := brokers[leader]; ok && name == topic {
+					partitions = append(partitions, partition{index: index, leader: addr})
+				}
+			}
+
+			if name == topic && topicErr != 0 {
+				lastErr = Error(topicErr)
+			}
+		}
+
+		if d.err != nil {
+			lastErr = d.err
+			continue
+		}
+
+		if len(partitions) == 0 {
+			if lastErr == nil {
+				lastErr = fmt.Errorf("kafka: topic %s has no available partition", topic)
+			}
+			continue
+		}
+
+		p.partitions[topic] = partitions
+
+		return partitions, nil
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("kafka: no broker configured")
+	}
+
+	return nil, lastErr
+}
+
+// 发送请求并读取响应, 出错时关闭连接
+func (p *Producer) roundTrip(ctx context.Context, addr string, apiKey, apiVersion int16, body []byte) (response []byte, err error) {
+
+	conn, ok := p.conns[addr]
+	if !ok {
+
+		dialer := &net.Dialer{Timeout: p.timeout}
+		if conn, err = dialer.DialContext(ctx, "tcp", addr); err != nil {
+			return nil, err
+		}
+
+		p.conns[addr] = conn
+	}
+
+	defer func() {
+		if err != nil {
+			_ = conn.Close()
+			delete(p.conns, addr)
+		}
+	}()
+
+	deadline := time.Now().Add(p.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+
+	if err = conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	p.correlationId++
+
+	header := &encoder{}
+	header.int16(apiKey)
+	header.int16(apiVersion)
+	header.int32(p.correlationId)
+	header.string(p.clientId)
+
+	request := &encoder{}
+	request.int32(int32(len(header.buf) + len(body)))
+	request.buf = append(request.buf, header.buf...)
+	request.buf = append(request.buf, body...)
+
+	if _, err = conn.Write(request.buf); err != nil {
+		return nil, err
+	}
+
+	size := make([]byte, 4)
+	if _, err = io.ReadFull(conn, size); err != nil {
+		return nil, err
+	}
+
+	response = make([]byte, binary.BigEndian.Uint32(size))
+	if _, err = io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+
+	d := &decoder{buf: response}
+	if correlationId := d.int32(); d.err != nil || correlationId != p.correlationId {
+		return nil, errors.New("kafka: correlation id mismatch")
+	}
+
+	return d.buf, nil
+}
@@ -0,0 +1,312 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 模拟Kafka服务端, 只实现Metadata v1和Produce v3
+type broker struct {
+	t        *testing.T
+	listener net.Listener
+	host     string
+	port     int32
+	mutex    sync.Mutex
+	metadata int              // Metadata请求次数
+	batches  [][]byte         // 写入的消息批次
+	codes    []int16          // 按顺序返回的Produce错误码, 用完后返回0
+	topics   map[string]int32 // [主题]分区数
+}
+
+func newBroker(t *testing.T) *broker {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	b := &broker{
+		t:        t,
+		listener: listener,
+		host:     host,
+		port:     int32(portNum),
+		topics:   map[string]int32{"logs": 2},
+	}
+
+	go b.serve()
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	return b
+}
+
+func (b *broker) addr() string {
+	return b.listener.Addr().String()
+}
+
+// Metadata请求次数和写入的消息批次
+func (b *broker) stats() (int, [][]byte) {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.metadata, b.batches
+}
+
+func (b *broker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *broker) handle(conn net.Conn) {
+
+	defer conn.Close()
+
+	for {
+
+		size := make([]byte, 4)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return
+		}
+
+		request := make([]byte, binary.BigEndian.Uint32(size))
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+
+		d := &decoder{buf: request}
+		apiKey := d.int16()
+		apiVersion := d.int16()
+		correlationId := d.int32()
+		d.string() // client_id
+
+		response := &encoder{}
+		response.int32(correlationId)
+
+		switch {
+		case apiKey == apiKeyMetadata && apiVersion == metadataVersion:
+			b.handleMetadata(d, response)
+		case apiKey == apiKeyProduce && apiVersion == produceVersion:
+			b.handleProduce(d, response)
+		default:
+			b.t.Errorf("unexpected api key: %d, version: %d", apiKey, apiVersion)
+			return
+		}
+
+		if d.err != nil {
+			b.t.Errorf("malformed request: %v", d.err)
+			return
+		}
+
+		frame := &encoder{}
+		frame.bytes(response.buf)
+
+		if _, err := conn.Write(frame.buf); err != nil {
+			return
+		}
+	}
+}
+
+func (b *broker) handleMetadata(d *decoder, response *encoder) {
+
+	topics := make([]string, 0)
+	for i := d.int32(); i > 0 && d.err == nil; i-- {
+		topics = append(topics, d.string())
+	}
+
+	b.mutex.Lock()
+	b.metadata++
+	b.mutex.Unlock()
+
+	response.int32(1) // brokers
+	response.int32(1) // node_id
+	response.string(b.host)
+	response.int32(b.port)
+	response.nullString() // rack
+
+	response.int32(1) // controller_id
+
+	response.int32(int32(len(topics)))
+	for _, topic := range topics {
+
+		partitions, ok := b.topics[topic]
+
+		if !ok {
+			response.int16(3) // UNKNOWN_TOPIC_OR_PARTITION
+		} else {
+			response.int16(0)
+		}
+
+		response.string(topic)
+		response.int8(0) // is_internal
+
+		response.int32(partitions)
+		for index := int32(0); index < partitions; index++ {
+			response.int16(0) // error_code
+			response.int32(index)
+			response.int32(1) // leader
+			response.int32(1) // replica_nodes
+			response.int32(1)
+			response.int32(1) // isr_nodes
+			response.int32(1)
+		}
+	}
+}
+
+func (b *broker) handleProduce(d *decoder, response *encoder) {
+
+	d.string() // transactional_id
+	d.int16()  // acks
+	d.int32()  // timeout
+
+	if topics := d.int32(); topics != 1 {
+		b.t.Errorf("produce topics: %d, want: 1", topics)
+	}
+
+	topic := d.string()
+
+	if partitions := d.int32(); partitions != 1 {
+		b.t.Errorf("produce partitions: %d, want: 1", partitions)
+	}
+
+	index := d.int32()
+	batch := d.read(int(d.int32()))
+
+	b.mutex.Lock()
+
+	code := int16(0)
+	if len(b.codes) > 0 {
+		code, b.codes = b.codes[0], b.codes[1:]
+	}
+
+	if code == 0 {
+		b.batches = append(b.batches, append([]byte(nil), batch...))
+	}
+
+	b.mutex.Unlock()
+
+	response.int32(1)
+	response.string(topic)
+	response.int32(1)
+	response.int32(index)
+	response.int16(code)
+	response.int64(0)  // base_offset
+	response.int64(-1) // log_append_time_ms
+	response.int32(0)  // throttle_time_ms
+}
+
+func TestProducerProduce(t *testing.T) {
+
+	b := newBroker(t)
+
+	producer := NewProducer([]string{b.addr()}, "fastapi", time.Second)
+	defer producer.Close()
+
+	messages := []Message{{Key: []byte("1"), Value: []byte(`{"id":1}`)}, {Value: []byte(`{"id":2}`)}}
+
+	for i := 0; i < 3; i++ {
+		if err := producer.Produce(context.Background(), "logs", messages); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	metadata, batches := b.stats()
+
+	if metadata != 1 {
+		t.Fatalf("metadata requests: %d, want: 1", metadata)
+	}
+
+	if len(batches) != 3 {
+		t.Fatalf("batches: %d, want: 3", len(batches))
+	}
+
+	decoded := decodeRecordBatch(t, batches[0])
+	if len(decoded) != 2 || string(decoded[0].Value) != `{"id":1}` || string(decoded[1].Value) != `{"id":2}` {
+		t.Fatalf("records: %+v", decoded)
+	}
+
+	// 批次轮询写入分区
+	partitions := producer.partitions["logs"]
+	if len(partitions) != 2 || partitions[0].index != 0 || partitions[1].index != 1 || partitions[0].leader != b.addr() {
+		t.Fatalf("partitions: %+v", partitions)
+	}
+}
+
+func TestProducerRetriableError(t *testing.T) {
+
+	b := newBroker(t)
+	b.codes = []int16{6} // NOT_LEADER_OR_FOLLOWER
+
+	producer := NewProducer([]string{b.addr()}, "fastapi", time.Second)
+	defer producer.Close()
+
+	if err := producer.Produce(context.Background(), "logs", []Message{{Value: []byte("v")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 刷新元数据后重试
+	if metadata, batches := b.stats(); metadata != 2 || len(batches) != 1 {
+		t.Fatalf("metadata requests: %d, batches: %d", metadata, len(batches))
+	}
+}
+
+func TestProducerNonRetriableError(t *testing.T) {
+
+	b := newBroker(t)
+	b.codes = []int16{10} // MESSAGE_TOO_LARGE
+
+	producer := NewProducer([]string{b.addr()}, "fastapi", time.Second)
+	defer producer.Close()
+
+	err := producer.Produce(context.Background(), "logs", []Message{{Value: []byte("v")}})
+
+	var kafkaErr Error
+	if !errors.As(err, &kafkaErr) || kafkaErr != 10 {
+		t.Fatalf("error: %v, want: kafka error 10", err)
+	}
+
+	if metadata, batches := b.stats(); metadata != 1 || len(batches) != 0 {
+		t.Fatalf("metadata requests: %d, batches: %d", metadata, len(batches))
+	}
+}
+
+func TestProducerUnknownTopic(t *testing.T) {
+
+	b := newBroker(t)
+
+	producer := NewProducer([]string{b.addr()}, "fastapi", time.Second)
+	defer producer.Close()
+
+	err := producer.Produce(context.Background(), "unknown", []Message{{Value: []byte("v")}})
+
+	var kafkaErr Error
+	if !errors.As(err, &kafkaErr) || kafkaErr != 3 {
+		t.Fatalf("error: %v, want: kafka error 3", err)
+	}
+}
+
+func TestProducerNoBroker(t *testing.T) {
+
+	producer := NewProducer(nil, "fastapi", time.Second)
+
+	if err := producer.Produce(context.Background(), "logs", []Message{{Value: []byte("v")}}); err == nil {
+		t.Fatal("expected error without broker")
+	}
+}
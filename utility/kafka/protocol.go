@@ -0,0 +1,192 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	apiKeyProduce  = 0
+	apiKeyMetadata = 3
+
+	produceVersion  = 3
+	metadataVersion = 1
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// 请求编码
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) int8(v int8) {
+	e.buf = append(e.buf, byte(v))
+}
+
+func (e *encoder) int16(v int16) {
+	e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v))
+}
+
+func (e *encoder) int32(v int32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v))
+}
+
+func (e *encoder) int64(v int64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v))
+}
+
+func (e *encoder) string(v string) {
+	e.int16(int16(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) nullString() {
+	e.int16(-1)
+}
+
+func (e *encoder) bytes(v []byte) {
+	e.int32(int32(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *encoder) varBytes(v []byte) {
+	if v == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// 响应解码, 出错后的读取均返回零值, 由调用方检查err
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) read(n int) []byte {
+
+	if d.err != nil {
+		return nil
+	}
+
+	if n < 0 || len(d.buf) < n {
+		d.err = errors.New("kafka: malformed response")
+		return nil
+	}
+
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.read(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.read(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.read(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.read(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) string() string {
+
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+
+	return string(d.read(int(n)))
+}
+
+// 消息
+type Message struct {
+	Key   []byte
+	Value []byte
+}
+
+// 编码消息批次, RecordBatch v2, 不压缩
+func encodeRecordBatch(messages []Message, timestamp int64) []byte {
+
+	records := &encoder{}
+	for i, message := range messages {
+
+		record := &encoder{}
+		record.int8(0)                 // attributes
+		record.varint(0)               // timestampDelta
+		record.varint(int64(i))        // offsetDelta
+		record.varBytes(message.Key)   // key
+		record.varBytes(message.Value) // value
+		record.varint(0)               // headers
+
+		records.varint(int64(len(record.buf)))
+		records.buf = append(records.buf, record.buf...)
+	}
+
+	// CRC覆盖attributes到结尾的全部内容
+	body := &encoder{}
+	body.int16(0)                        // attributes
+	body.int32(int32(len(messages) - 1)) // lastOffsetDelta
+	body.int64(timestamp)                // firstTimestamp
+	body.int64(timestamp)                // maxTimestamp
+	body.int64(-1)                       // producerId
+	body.int16(-1)                       // producerEpoch
+	body.int32(-1)                       // baseSequence
+	body.int32(int32(len(messages)))     // records count
+	body.buf = append(body.buf, records.buf...)
+
+	batch := &encoder{}
+	batch.int64(0)                                // baseOffset
+	batch.int32(int32(4 + 1 + 4 + len(body.buf))) // batchLength
+	batch.int32(-1)                               // partitionLeaderEpoch
+	batch.int8(2)                                 // magic
+	batch.buf = binary.BigEndian.AppendUint32(batch.buf, crc32.Checksum(body.buf, crc32c))
+	batch.buf = append(batch.buf, body.buf...)
+
+	return batch.buf
+}
+
+// 服务端错误码
+type Error int16
+
+func (e Error) Error() string {
+	return fmt.Sprintf("kafka: server error code %d", int16(e))
+}
+
+// 是否需要刷新元数据后重试, 如: 分区首领变更
+func (e Error) Retriable() bool {
+	switch e {
+	case 3, 5, 6, 7, 9, 19, 20:
+		// UNKNOWN_TOPIC_OR_PARTITION, LEADER_NOT_AVAILABLE, NOT_LEADER_OR_FOLLOWER, REQUEST_TIMED_OUT,
+		// REPLICA_NOT_AVAILABLE, NOT_ENOUGH_REPLICAS, NOT_ENOUGH_REPLICAS_AFTER_APPEND
+		return true
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+// 解析消息批次, 返回批次内的消息
+func decodeRecordBatch(t *testing.T, batch []byte) []Message {
+
+	t.Helper()
+
+	d := &decoder{buf: batch}
+
+	if baseOffset := d.int64(); baseOffset != 0 {
+		t.Fatalf("baseOffset: %d, want: 0", baseOffset)
+	}
+
+	if batchLength := d.int32(); int(batchLength) != len(batch)-12 {
+		t.Fatalf("batchLength: %d, want: %d", batchLength, len(batch)-12)
+	}
+
+	d.int32() // partitionLeaderEpoch
+
+	if magic := d.int8(); magic != 2 {
+		t.Fatalf("magic: %d, want: 2", magic)
+	}
+
+	crc := uint32(d.int32())
+	if sum := crc32.Checksum(d.buf, crc32.MakeTable(crc32.Castagnoli)); crc != sum {
+		t.Fatalf("crc: %x, want: %x", crc, sum)
+	}
+
+	d.int16() // attributes
+	lastOffsetDelta := d.int32()
+	d.int64() // firstTimestamp
+	d.int64() // maxTimestamp
+	d.int64() // producerId
+	d.int16() // producerEpoch
+	d.int32() // baseSequence
+	count := d.int32()
+
+	if d.err != nil {
+		t.Fatal(d.err)
+	}
+
+	if int(lastOffsetDelta) != int(count)-1 {
+		t.Fatalf("lastOffsetDelta: %d, records: %d", lastOffsetDelta, count)
+	}
+
+	varint := func() int64 {
+		v, n := binary.Varint(d.buf)
+		if n <= 0 {
+			t.Fatal("malformed varint")
+		}
+		d.buf = d.buf[n:]
+		return v
+	}
+
+	varBytes := func() []byte {
+		if n := varint(); n >= 0 {
+			return d.read(int(n))
+		}
+		return nil
+	}
+
+	messages := make([]Message, 0, count)
+	for i := 0; i < int(count); i++ {
+
+		length := varint()
+		rest := len(d.buf)
+
+		d.int8() // attributes
+		varint() // timestampDelta
+
+		if offsetDelta := varint(); offsetDelta != int64(i) {
+			t.Fatalf("offsetDelta: %d, want: %d", offsetDelta, i)
+		}
+
+		message := Message{Key: varBytes(), Value: varBytes()}
+
+		if headers := varint(); headers != 0 {
+			t.Fatalf("headers: %d, want: 0", headers)
+		}
+
+		if d.err != nil {
+			t.Fatal(d.err)
+		}
+
+		if consumed := rest - len(d.buf); int64(consumed) != length {
+			t.Fatalf("record length: %d, consumed: %d", length, consumed)
+		}
+
+		messages = append(messages, message)
+	}
+
+	if len(d.buf) != 0 {
+		t.Fatalf("unexpected trailing bytes: %d", len(d.buf))
+	}
+
+	return messages
+}
+
+func TestEncodeRecordBatch(t *testing.T) {
+
+	messages := []Message{
+		{Key: []byte("key"), Value: []byte(`{"id":1}`)},
+		{Value: []byte(`{"id":2}`)},
+		{Key: []byte{}, Value: bytes.Repeat([]byte("x"), 300)},
+	}
+
+	decoded := decodeRecordBatch(t, encodeRecordBatch(messages, 1700000000000))
+
+	if len(decoded) != len(messages) {
+		t.Fatalf("records: %d, want: %d", len(decoded), len(messages))
+	}
+
+	for i, message := range messages {
+
+		if (message.Key == nil) != (decoded[i].Key == nil) || !bytes.Equal(message.Key, decoded[i].Key) {
+			t.Fatalf("record %d key: %q, want: %q", i, decoded[i].Key, message.Key)
+		}
+
+		if !bytes.Equal(message.Value, decoded[i].Value) {
+			t.Fatalf("record %d value: %q, want: %q", i, decoded[i].Value, message.Value)
+		}
+	}
+}
+
+func TestRecordBatchTimestamp(t *testing.T) {
+
+	batch := encodeRecordBatch([]Message{{Value: []byte("v")}}, 1700000000000)
+
+	// baseOffset(8) + batchLength(4) + partitionLeaderEpoch(4) + magic(1) + crc(4) + attributes(2) + lastOffsetDelta(4)
+	firstTimestamp := int64(binary.BigEndian.Uint64(batch[27:35]))
+	maxTimestamp := int64(binary.BigEndian.Uint64(batch[35:43]))
+
+	if firstTimestamp != 1700000000000 || maxTimestamp != 1700000000000 {
+		t.Fatalf("firstTimestamp: %d, maxTimestamp: %d", firstTimestamp, maxTimestamp)
+	}
+}
+
+// CRC-32C标准测试向量
+func TestCrc32c(t *testing.T) {
+	if sum := crc32.Checksum([]byte("123456789"), crc32c); sum != 0xe3069283 {
+		t.Fatalf("crc32c: %x, want: e3069283", sum)
+	}
+}
+
+func TestRecordBatchCrcDetectsCorruption(t *testing.T) {
+
+	batch := encodeRecordBatch([]Message{{Key: []byte("k"), Value: []byte("v")}}, 1)
+
+	crc := binary.BigEndian.Uint32(batch[17:21])
+
+	batch[len(batch)-2] ^= 0xff
+
+	if crc32.Checksum(batch[21:], crc32c) == crc {
+		t.Fatal("crc does not cover records")
+	}
+}
+
+func TestDecoderMalformed(t *testing.T) {
+
+	d := &decoder{buf: []byte{0, 5, 'a', 'b'}}
+
+	if s := d.string(); s != "" || d.err == nil {
+		t.Fatalf("string: %q, err: %v", s, d.err)
+	}
+
+	// 出错后的读取均返回零值
+	if v := d.int32(); v != 0 {
+		t.Fatalf("int32 after error: %d", v)
+	}
+}
+
+func TestDecoderNullString(t *testing.T) {
+
+	e := &encoder{}
+	e.nullString()
+	e.string("topic")
+
+	d := &decoder{buf: e.buf}
+
+	if s := d.string(); s != "" {
+		t.Fatalf("null string: %q", s)
+	}
+
+	if s := d.string(); s != "topic" || d.err != nil {
+		t.Fatalf("string: %q, err: %v", s, d.err)
+	}
+}
+
+func TestErrorRetriable(t *testing.T) {
+
+	for code, retriable := range map[int16]bool{3: true, 6: true, 7: true, 10: false, 87: false} {
+		if got := Error(code).Retriable(); got != retriable {
+			t.Fatalf("code %d retriable: %t, want: %t", code, got, retriable)
+		}
+	}
+
+	var err error = Error(6)
+
+	var kafkaErr Error
+	if !errors.As(err, &kafkaErr) || kafkaErr != 6 {
+		t.Fatalf("errors.As: %v", err)
+	}
+}
@@ -8,25 +8,33 @@ import (
 )
 
 func Debug(ctx context.Context, v ...interface{}) {
-	_ = grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) { g.Log().Debug(ctx, v...) }, nil)
+	_ = grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) { g.Log().Debug(ctx, redactValues(v)...) }, nil)
 }
 
 func Info(ctx context.Context, v ...interface{}) {
-	g.Log().Info(ctx, v...)
+	g.Log().Info(ctx, redactValues(v)...)
+}
+
+func Warning(ctx context.Context, v ...interface{}) {
+	g.Log().Warning(ctx, redactValues(v)...)
 }
 
 func Error(ctx context.Context, v ...interface{}) {
-	g.Log().Error(ctx, v...)
+	g.Log().Error(ctx, redactValues(v)...)
 }
 
 func Debugf(ctx context.Context, format string, v ...interface{}) {
-	_ = grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) { g.Log().Debugf(ctx, format, v...) }, nil)
+	_ = grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) { g.Log().Debug(ctx, redactf(format, v...)) }, nil)
 }
 
 func Infof(ctx context.Context, format string, v ...interface{}) {
-	g.Log().Infof(ctx, format, v...)
+	g.Log().Info(ctx, redactf(format, v...))
+}
+
+func Warningf(ctx context.Context, format string, v ...interface{}) {
+	g.Log().Warning(ctx, redactf(format, v...))
 }
 
 func Errorf(ctx context.Context, format string, v ...interface{}) {
-	g.Log().Errorf(ctx, format, v...)
+	g.Log().Error(ctx, redactf(format, v...))
 }
@@ -0,0 +1,62 @@
+package logger
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// 密钥格式, 日志输出前脱敏
+var redactRules = []struct {
+	regexp  *regexp.Regexp
+	replace func(s string) string
+}{
+	// 私钥, 如GCP服务账号JSON中的private_key
+	{regexp.MustCompile(`(?s)-----BEGIN[A-Z ]*PRIVATE KEY-----.*?-----END[A-Z ]*PRIVATE KEY-----`), func(s string) string { return "[PRIVATE KEY]" }},
+	{regexp.MustCompile(`("private_key"\s*:\s*")[^"]*"`), func(s string) string { return `"private_key":"****"` }},
+	// 信封加密密文
+	{regexp.MustCompile(`enc:v1:[0-9a-f]+:[A-Za-z0-9+/=:]+`), func(s string) string { return "enc:v1:****" }},
+	// Bearer令牌
+	{regexp.MustCompile(`(?i)bearer\s+[^\s"',\]]+`), func(s string) string { return s[:7] + mask(s[7:]) }},
+	// sk-开头的密钥, 包括本系统和OpenAI等
+	{regexp.MustCompile(`sk-[A-Za-z0-9_\-]{12,}`), mask},
+	// 派生令牌
+	{regexp.MustCompile(`eyJ[A-Za-z0-9_\-]+\.eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+`), mask},
+	// Google API Key
+	{regexp.MustCompile(`AIza[0-9A-Za-z_\-]{35}`), mask},
+}
+
+// 脱敏, 保留前6位和末4位
+func mask(s string) string {
+
+	if len(s) <= 12 {
+		return "****"
+	}
+
+	return s[:6] + "****" + s[len(s)-4:]
+}
+
+// 日志脱敏
+func Redact(s string) string {
+
+	for _, rule := range redactRules {
+		s = rule.regexp.ReplaceAllStringFunc(s, rule.replace)
+	}
+
+	return s
+}
+
+func redactf(format string, v ...interface{}) string {
+	return Redact(fmt.Sprintf(format, v...))
+}
+
+// 只替换包含密钥的值, 其它值保持原样以便保留错误堆栈等信息
+func redactValues(v []interface{}) []interface{} {
+
+	for i, value := range v {
+		if s := fmt.Sprint(value); Redact(s) != s {
+			v[i] = Redact(s)
+		}
+	}
+
+	return v
+}
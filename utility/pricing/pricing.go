@@ -0,0 +1,185 @@
+package pricing
+
+import (
+	"github.com/gogf/gf/v2/text/gstr"
+	"github.com/gogf/gf/v2/util/gconv"
+	sdkm "github.com/iimeta/fastapi-sdk/model"
+	"github.com/iimeta/fastapi/internal/model"
+	mcommon "github.com/iimeta/fastapi/internal/model/common"
+	"math"
+)
+
+// 按tokens价格计算费用, 缓存命中的提示tokens按缓存输入价格计算, 推理tokens按推理输出价格计算, 未配置价格时返回false
+func TokenAmount(pricing *mcommon.Pricing, params *model.BillingReq) (float64, bool) {
+
+	if pricing.InputPrice == 0 && pricing.OutputPrice == 0 {
+		return 0, false
+	}
+
+	cachedTokens := min(params.CachedTokens, params.PromptTokens)
+	cachedInputPrice := pricing.CachedInputPrice
+	if cachedInputPrice == 0 {
+		cachedInputPrice = pricing.InputPrice
+	}
+
+	reasoningTokens := min(params.ReasoningTokens, params.CompletionTokens)
+	reasoningPrice := pricing.ReasoningPrice
+	if reasoningPrice == 0 {
+		reasoningPrice = pricing.OutputPrice
+	}
+
+	return (float64(params.PromptTokens-cachedTokens)*pricing.InputPrice +
+		float64(cachedTokens)*cachedInputPrice +
+		float64(params.CompletionTokens-reasoningTokens)*pricing.OutputPrice +
+		float64(reasoningTokens)*reasoningPrice) / 1_000_000, true
+}
+
+// 按图像价格计算费用, 未匹配到价格时返回false
+func ImageAmount(pricing *mcommon.Pricing, params *model.BillingReq) (float64, bool) {
+
+	price, ok := ImagePrice(pricing, params.ImageSize, params.ImageQuality)
+	if !ok {
+		return 0, false
+	}
+
+	return price.Price * float64(params.ImageCount), true
+}
+
+// 按音频每分钟价格计算费用, 未配置价格时返回false
+func AudioAmount(pricing *mcommon.Pricing, params *model.BillingReq) (float64, bool) {
+
+	if pricing.AudioMinutePrice == 0 {
+		return 0, false
+	}
+
+	return params.AudioMinutes * pricing.AudioMinutePrice, true
+}
+
+// 按Midjourney动作价格计算费用, 未匹配到价格时返回false
+func MidjourneyAmount(pricing *mcommon.Pricing, params *model.BillingReq) (float64, bool) {
+
+	for _, price := range pricing.MidjourneyPrices {
+		if price.Action == params.MidjourneyAction {
+			return price.Price, true
+		}
+	}
+
+	return 0, false
+}
+
+// 按文本倍率计算额度, 缓存命中的提示tokens按缓存命中倍率计算, 推理tokens按推理倍率计算
+func TextQuota(textQuota mcommon.TextQuota, usage *sdkm.Usage) int {
+
+	promptTokens := float64(usage.PromptTokens) * textQuota.PromptRatio
+	completionTokens := float64(usage.CompletionTokens) * textQuota.CompletionRatio
+
+	if textQuota.CachedRatio > 0 && usage.PromptTokensDetails != nil && usage.PromptTokensDetails.CachedTokens > 0 {
+		cachedTokens := min(usage.PromptTokensDetails.CachedTokens, usage.PromptTokens)
+		promptTokens = float64(usage.PromptTokens-cachedTokens)*textQuota.PromptRatio + float64(cachedTokens)*textQuota.CachedRatio
+	}
+
+	if textQuota.ReasoningRatio > 0 && usage.CompletionTokensDetails != nil && usage.CompletionTokensDetails.ReasoningTokens > 0 {
+		reasoningTokens := min(usage.CompletionTokensDetails.ReasoningTokens, usage.CompletionTokens)
+		completionTokens = float64(usage.CompletionTokens-reasoningTokens)*textQuota.CompletionRatio + float64(reasoningTokens)*textQuota.ReasoningRatio
+	}
+
+	return int(math.Ceil(promptTokens + completionTokens))
+}
+
+// 按多模态倍率计算估算用量的额度, 图像固定额度单独累加
+func MultimodalQuota(textQuota mcommon.TextQuota, textTokens, imageTokens, imageQuota, completionTokens int) int {
+	return int(math.Ceil(float64(textTokens+imageTokens)*textQuota.PromptRatio)) + imageQuota + int(math.Ceil(float64(completionTokens)*textQuota.CompletionRatio))
+}
+
+// 按多模态语音倍率计算额度, 有明细时文本和音频分别计算, 否则全部按音频计算
+func MultimodalAudioQuota(quota mcommon.MultimodalAudioQuota, usage *sdkm.Usage) int {
+
+	var textTokens, audioTokens int
+
+	if usage.PromptTokensDetails != nil {
+		textTokens = int(math.Ceil(float64(usage.PromptTokensDetails.TextTokens) * quota.TextQuota.PromptRatio))
+		audioTokens = int(math.Ceil(float64(usage.PromptTokensDetails.AudioTokens) * quota.AudioQuota.PromptRatio))
+	} else {
+		audioTokens = int(math.Ceil(float64(usage.PromptTokens) * quota.AudioQuota.PromptRatio))
+	}
+
+	if usage.CompletionTokensDetails != nil {
+		textTokens += int(math.Ceil(float64(usage.CompletionTokensDetails.TextTokens) * quota.TextQuota.CompletionRatio))
+		audioTokens += int(math.Ceil(float64(usage.CompletionTokensDetails.AudioTokens) * quota.AudioQuota.CompletionRatio))
+	} else {
+		audioTokens += int(math.Ceil(float64(usage.CompletionTokens) * quota.AudioQuota.CompletionRatio))
+	}
+
+	return textTokens + audioTokens
+}
+
+// 获取图像价格, 优先匹配尺寸和质量, 其次匹配尺寸, 都未匹配时使用默认选项
+func ImagePrice(pricing *mcommon.Pricing, size, quality string) (mcommon.ImagePrice, bool) {
+
+	var (
+		width, height = ParseImageSize(size)
+		sizePrice     *mcommon.ImagePrice
+		defaultPrice  *mcommon.ImagePrice
+	)
+
+	for i, price := range pricing.ImagePrices {
+
+		if price.Width == width && price.Height == height {
+
+			if price.Quality == quality {
+				return price, true
+			}
+
+			if price.Quality == "" && sizePrice == nil {
+				sizePrice = &pricing.ImagePrices[i]
+			}
+		}
+
+		if price.IsDefault && defaultPrice == nil {
+			defaultPrice = &pricing.ImagePrices[i]
+		}
+	}
+
+	if sizePrice != nil {
+		return *sizePrice, true
+	}
+
+	if defaultPrice != nil {
+		return *defaultPrice, true
+	}
+
+	return mcommon.ImagePrice{}, false
+}
+
+// 解析图像尺寸, 支持1024x1024, 1024×1024, 1024*1024, 1024:1024等格式
+func ParseImageSize(size string) (width, height int) {
+
+	if size == "" {
+		return
+	}
+
+	widthHeight := gstr.Split(size, `×`)
+
+	if len(widthHeight) != 2 {
+		widthHeight = gstr.Split(size, `x`)
+	}
+
+	if len(widthHeight) != 2 {
+		widthHeight = gstr.Split(size, `X`)
+	}
+
+	if len(widthHeight) != 2 {
+		widthHeight = gstr.Split(size, `*`)
+	}
+
+	if len(widthHeight) != 2 {
+		widthHeight = gstr.Split(size, `:`)
+	}
+
+	if len(widthHeight) == 2 {
+		width = gconv.Int(widthHeight[0])
+		height = gconv.Int(widthHeight[1])
+	}
+
+	return
+}
@@ -0,0 +1,187 @@
+package pricing
+
+import (
+	sdkm "github.com/iimeta/fastapi-sdk/model"
+	"github.com/iimeta/fastapi/internal/model"
+	mcommon "github.com/iimeta/fastapi/internal/model/common"
+	"math"
+	"testing"
+)
+
+func TestTokenAmount(t *testing.T) {
+
+	pricing := &mcommon.Pricing{
+		InputPrice:       2,
+		OutputPrice:      8,
+		CachedInputPrice: 0.5,
+		ReasoningPrice:   16,
+	}
+
+	tests := []struct {
+		name    string
+		pricing *mcommon.Pricing
+		params  *model.BillingReq
+		want    float64
+		ok      bool
+	}{
+		{"未配置价格", &mcommon.Pricing{}, &model.BillingReq{PromptTokens: 1000}, 0, false},
+		{"输入输出", pricing, &model.BillingReq{PromptTokens: 1_000_000, CompletionTokens: 500_000}, 6, true},
+		{"缓存命中", pricing, &model.BillingReq{PromptTokens: 1_000_000, CachedTokens: 400_000}, 1.4, true},
+		{"缓存超过提示tokens", pricing, &model.BillingReq{PromptTokens: 1_000_000, CachedTokens: 2_000_000}, 0.5, true},
+		{"推理tokens", pricing, &model.BillingReq{CompletionTokens: 1_000_000, ReasoningTokens: 250_000}, 10, true},
+		{"推理超过补全tokens", pricing, &model.BillingReq{CompletionTokens: 1_000_000, ReasoningTokens: 2_000_000}, 16, true},
+		// 未配置缓存和推理价格时按输入和输出价格计算
+		{"价格回退", &mcommon.Pricing{InputPrice: 2, OutputPrice: 8}, &model.BillingReq{PromptTokens: 1_000_000, CachedTokens: 500_000, CompletionTokens: 1_000_000, ReasoningTokens: 500_000}, 10, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if amount, ok := TokenAmount(test.pricing, test.params); ok != test.ok || math.Abs(amount-test.want) > 1e-9 {
+				t.Fatalf("amount: %v, %v, want: %v, %v", amount, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+func TestImageAmount(t *testing.T) {
+
+	pricing := &mcommon.Pricing{
+		ImagePrices: []mcommon.ImagePrice{
+			{Width: 1024, Height: 1024, Price: 0.04},
+			{Width: 1024, Height: 1024, Quality: "hd", Price: 0.08},
+			{Width: 1792, Height: 1024, Price: 0.08, IsDefault: true},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		params *model.BillingReq
+		want   float64
+		ok     bool
+	}{
+		{"匹配尺寸和质量", &model.BillingReq{ImageSize: "1024x1024", ImageQuality: "hd", ImageCount: 2}, 0.16, true},
+		{"匹配尺寸", &model.BillingReq{ImageSize: "1024×1024", ImageQuality: "standard", ImageCount: 1}, 0.04, true},
+		{"默认选项", &model.BillingReq{ImageSize: "512x512", ImageCount: 3}, 0.24, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if amount, ok := ImageAmount(pricing, test.params); ok != test.ok || math.Abs(amount-test.want) > 1e-9 {
+				t.Fatalf("amount: %v, %v, want: %v, %v", amount, ok, test.want, test.ok)
+			}
+		})
+	}
+
+	if amount, ok := ImageAmount(&mcommon.Pricing{}, &model.BillingReq{ImageSize: "1024x1024", ImageCount: 1}); ok {
+		t.Fatalf("amount: %v, %v, want: 0, false", amount, ok)
+	}
+}
+
+func TestAudioAndMidjourneyAmount(t *testing.T) {
+
+	pricing := &mcommon.Pricing{
+		AudioMinutePrice: 0.006,
+		MidjourneyPrices: []mcommon.MidjourneyPrice{{Action: "IMAGINE", Price: 0.1}},
+	}
+
+	if amount, ok := AudioAmount(pricing, &model.BillingReq{AudioMinutes: 2.5}); !ok || math.Abs(amount-0.015) > 1e-9 {
+		t.Fatalf("audio amount: %v, %v, want: 0.015, true", amount, ok)
+	}
+
+	if amount, ok := AudioAmount(&mcommon.Pricing{}, &model.BillingReq{AudioMinutes: 2.5}); ok {
+		t.Fatalf("audio amount: %v, %v, want: 0, false", amount, ok)
+	}
+
+	if amount, ok := MidjourneyAmount(pricing, &model.BillingReq{MidjourneyAction: "IMAGINE"}); !ok || amount != 0.1 {
+		t.Fatalf("midjourney amount: %v, %v, want: 0.1, true", amount, ok)
+	}
+
+	if amount, ok := MidjourneyAmount(pricing, &model.BillingReq{MidjourneyAction: "UPSCALE"}); ok {
+		t.Fatalf("midjourney amount: %v, %v, want: 0, false", amount, ok)
+	}
+}
+
+func TestTextQuota(t *testing.T) {
+
+	textQuota := mcommon.TextQuota{
+		PromptRatio:     1,
+		CompletionRatio: 4,
+		CachedRatio:     0.25,
+		ReasoningRatio:  8,
+	}
+
+	tests := []struct {
+		name      string
+		textQuota mcommon.TextQuota
+		usage     *sdkm.Usage
+		want      int
+	}{
+		{"提示和补全", textQuota, &sdkm.Usage{PromptTokens: 100, CompletionTokens: 10}, 140},
+		{"缓存命中", textQuota, &sdkm.Usage{PromptTokens: 100, PromptTokensDetails: &sdkm.PromptTokensDetails{CachedTokens: 40}}, 70},
+		{"推理tokens", textQuota, &sdkm.Usage{CompletionTokens: 10, CompletionTokensDetails: &sdkm.CompletionTokensDetails{ReasoningTokens: 5}}, 60},
+		// 未配置缓存和推理倍率时按提示和补全倍率计算
+		{"倍率回退", mcommon.TextQuota{PromptRatio: 1, CompletionRatio: 4}, &sdkm.Usage{
+			PromptTokens: 100, PromptTokensDetails: &sdkm.PromptTokensDetails{CachedTokens: 40},
+			CompletionTokens: 10, CompletionTokensDetails: &sdkm.CompletionTokensDetails{ReasoningTokens: 5},
+		}, 140},
+		{"向上取整", mcommon.TextQuota{PromptRatio: 0.3, CompletionRatio: 0.3}, &sdkm.Usage{PromptTokens: 1, CompletionTokens: 1}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if quota := TextQuota(test.textQuota, test.usage); quota != test.want {
+				t.Fatalf("quota: %d, want: %d", quota, test.want)
+			}
+		})
+	}
+}
+
+func TestMultimodalAudioQuota(t *testing.T) {
+
+	quota := mcommon.MultimodalAudioQuota{
+		TextQuota:  mcommon.TextQuota{PromptRatio: 1, CompletionRatio: 2},
+		AudioQuota: mcommon.AudioQuota{PromptRatio: 10, CompletionRatio: 20},
+	}
+
+	tests := []struct {
+		name  string
+		usage *sdkm.Usage
+		want  int
+	}{
+		{"无明细按音频计算", &sdkm.Usage{PromptTokens: 10, CompletionTokens: 5}, 200},
+		{"文本和音频分别计算", &sdkm.Usage{
+			PromptTokens: 10, PromptTokensDetails: &sdkm.PromptTokensDetails{TextTokens: 6, AudioTokens: 4},
+			CompletionTokens: 5, CompletionTokensDetails: &sdkm.CompletionTokensDetails{TextTokens: 3, AudioTokens: 2},
+		}, 92},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := MultimodalAudioQuota(quota, test.usage); got != test.want {
+				t.Fatalf("quota: %d, want: %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseImageSize(t *testing.T) {
+
+	tests := []struct {
+		size          string
+		width, height int
+	}{
+		{"", 0, 0},
+		{"1024x1024", 1024, 1024},
+		{"1792X1024", 1792, 1024},
+		{"1024×1792", 1024, 1792},
+		{"512*512", 512, 512},
+		{"16:9", 16, 9},
+		{"1024", 0, 0},
+	}
+
+	for _, test := range tests {
+		if width, height := ParseImageSize(test.size); width != test.width || height != test.height {
+			t.Fatalf("size: %s, width: %d, height: %d, want: %d, %d", test.size, width, height, test.width, test.height)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	_ "github.com/gogf/gf/contrib/nosql/redis/v2"
 	"github.com/gogf/gf/v2/container/gvar"
@@ -12,6 +13,7 @@ import (
 	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/iimeta/fastapi/utility/logger"
 	"github.com/redis/go-redis/v9"
+	"strings"
 	"time"
 )
 
@@ -162,6 +164,14 @@ func HSetStrAny(ctx context.Context, key string, field string, value interface{}
 	return HSet(ctx, key, g.MapStrAny{field: value})
 }
 
+func HSetNX(ctx context.Context, key, field string, value interface{}) (bool, error) {
+	reply, err := master.HSetNX(ctx, key, field, value)
+	if err != nil {
+		return false, err
+	}
+	return reply == 1, nil
+}
+
 func HGetStr(ctx context.Context, key, field string) (string, error) {
 	reply, err := HGet(ctx, key, field)
 	if err != nil {
@@ -194,6 +204,20 @@ func SetNX(ctx context.Context, key string, value interface{}) (bool, error) {
 	return master.SetNX(ctx, key, value)
 }
 
+// 键不存在时设置值和过期时间, 原子操作, 返回是否设置成功
+func SetNXEX(ctx context.Context, key string, value interface{}, ttlInSeconds int64) (bool, error) {
+
+	reply, err := master.Set(ctx, key, value, gredis.SetOption{
+		TTLOption: gredis.TTLOption{EX: &ttlInSeconds},
+		NX:        true,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return !reply.IsNil(), nil
+}
+
 func Expire(ctx context.Context, key string, seconds int64, option ...gredis.ExpireOption) (int64, error) {
 	return master.Expire(ctx, key, seconds, option...)
 }
@@ -248,3 +272,67 @@ func LRange(ctx context.Context, key string, start, stop int64) (gvar.Vars, erro
 func TTL(ctx context.Context, key string) (int64, error) {
 	return slave.TTL(ctx, key)
 }
+
+func Ping(ctx context.Context) error {
+	return Client.Ping(ctx).Err()
+}
+
+func Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return UniversalClient.Eval(ctx, script, keys, args...).Result()
+}
+
+// 创建消费组, 流不存在时创建, 消费组已存在时返回false
+func XGroupCreate(ctx context.Context, stream, group, start string) (bool, error) {
+	if err := UniversalClient.XGroupCreateMkStream(ctx, stream, group, start).Err(); err != nil {
+		if strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func XGroupSetID(ctx context.Context, stream, group, start string) error {
+	return UniversalClient.XGroupSetID(ctx, stream, group, start).Err()
+}
+
+func XGroupDestroy(ctx context.Context, stream, group string) (int64, error) {
+	return UniversalClient.XGroupDestroy(ctx, stream, group).Result()
+}
+
+// 按消费组读取消息, 阻塞超时没有消息时返回空
+func XReadGroup(ctx context.Context, stream, group, consumer, id string, count int64, block time.Duration) ([]redis.XMessage, error) {
+
+	streams, err := UniversalClient.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, id},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	messages := make([]redis.XMessage, 0)
+	for _, s := range streams {
+		messages = append(messages, s.Messages...)
+	}
+
+	return messages, nil
+}
+
+func XAck(ctx context.Context, stream, group string, ids ...string) (int64, error) {
+	return UniversalClient.XAck(ctx, stream, group, ids...).Result()
+}
+
+func XInfoGroups(ctx context.Context, stream string) ([]redis.XInfoGroup, error) {
+	return UniversalClient.XInfoGroups(ctx, stream).Result()
+}
+
+func XInfoConsumers(ctx context.Context, stream, group string) ([]redis.XInfoConsumer, error) {
+	return UniversalClient.XInfoConsumers(ctx, stream, group).Result()
+}
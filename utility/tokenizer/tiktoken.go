@@ -0,0 +1,36 @@
+package tokenizer
+
+import (
+	sdkm "github.com/iimeta/fastapi-sdk/model"
+	"github.com/iimeta/fastapi-sdk/tiktoken"
+	"github.com/iimeta/fastapi/internal/consts"
+)
+
+// tiktoken分词器, 用于OpenAI系列模型
+type Tiktoken struct {
+	model string
+}
+
+func NewTiktoken(model string) *Tiktoken {
+	return &Tiktoken{model: model}
+}
+
+func (t *Tiktoken) NumTokensFromString(text string) (int, error) {
+
+	tokens, err := tiktoken.NumTokensFromString(t.model, text)
+	if err != nil && t.model != consts.DEFAULT_MODEL {
+		return tiktoken.NumTokensFromString(consts.DEFAULT_MODEL, text)
+	}
+
+	return tokens, err
+}
+
+func (t *Tiktoken) NumTokensFromMessages(messages []sdkm.ChatCompletionMessage) (int, error) {
+
+	tokens, err := tiktoken.NumTokensFromMessages(t.model, messages)
+	if err != nil && t.model != consts.DEFAULT_MODEL {
+		return tiktoken.NumTokensFromMessages(consts.DEFAULT_MODEL, messages)
+	}
+
+	return tokens, err
+}
@@ -0,0 +1,151 @@
+package tokenizer
+
+import (
+	"github.com/gogf/gf/v2/text/gstr"
+	sdkm "github.com/iimeta/fastapi-sdk/model"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/tiktoken-go"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// 分词器
+type Tokenizer interface {
+	// 计算文本tokens
+	NumTokensFromString(text string) (int, error)
+	// 计算消息tokens
+	NumTokensFromMessages(messages []sdkm.ChatCompletionMessage) (int, error)
+}
+
+// 内置模型系列, key为分词器名称, value为模型名称前缀
+var families = map[string][]string{
+	"qwen":   {"qwen", "qwq"},
+	"glm":    {"glm", "chatglm"},
+	"ernie":  {"ernie"},
+	"claude": {"claude"},
+	"gemini": {"gemini"},
+}
+
+var (
+	mutex      sync.RWMutex
+	tokenizers = make(map[string]Tokenizer)
+)
+
+// 注册分词器, 相同名称会覆盖
+func Register(name string, tokenizer Tokenizer) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	tokenizers[name] = tokenizer
+}
+
+// 根据名称获取分词器, 未注册时按词表目录下的<名称>.tiktoken加载
+func Get(name string) Tokenizer {
+
+	mutex.RLock()
+	tokenizer, ok := tokenizers[name]
+	mutex.RUnlock()
+
+	if ok {
+		return tokenizer
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if tokenizer, ok = tokenizers[name]; !ok {
+		tokenizer = NewVocab(name, filepath.Join(vocabDir(), name+".tiktoken"))
+		tokenizers[name] = tokenizer
+	}
+
+	return tokenizer
+}
+
+// 获取模型的分词器, 优先使用指定的分词器, 其次按模型名称前缀匹配模型系列, 都未匹配时使用tiktoken
+func Resolve(name, model string) Tokenizer {
+
+	if family := Family(name, model); family != "" {
+		return Get(family)
+	}
+
+	if !tiktoken.IsEncodingForModel(model) {
+		model = consts.DEFAULT_MODEL
+	}
+
+	return NewTiktoken(model)
+}
+
+// 获取模型系列, 优先使用指定的分词器名称, 其次按模型名称最长前缀匹配, 前缀长度相同时配置的模型系列优先, 都未匹配时返回空
+func Family(name, model string) string {
+
+	if name != "" {
+		return name
+	}
+
+	model = gstr.ToLower(model)
+
+	var (
+		matched    string
+		matchedLen int
+		configured bool
+	)
+
+	all := Families()
+
+	names := make([]string, 0, len(all))
+	for family := range all {
+		names = append(names, family)
+	}
+
+	// 按名称排序, 保证匹配结果稳定
+	slices.Sort(names)
+
+	for _, family := range names {
+
+		_, isConfigured := config.Cfg.Tokenizer.Families[family]
+
+		for _, prefix := range all[family] {
+
+			prefix = gstr.ToLower(prefix)
+
+			if prefix == "" || !gstr.HasPrefix(model, prefix) {
+				continue
+			}
+
+			if len(prefix) > matchedLen || (len(prefix) == matchedLen && isConfigured && !configured) {
+				matched, matchedLen, configured = family, len(prefix), isConfigured
+			}
+		}
+	}
+
+	return matched
+}
+
+// 模型系列, 配置的模型系列优先于内置模型系列
+func Families() map[string][]string {
+
+	if len(config.Cfg.Tokenizer.Families) == 0 {
+		return families
+	}
+
+	all := make(map[string][]string, len(families)+len(config.Cfg.Tokenizer.Families))
+	for family, prefixes := range families {
+		all[family] = prefixes
+	}
+
+	for family, prefixes := range config.Cfg.Tokenizer.Families {
+		all[family] = prefixes
+	}
+
+	return all
+}
+
+func vocabDir() string {
+
+	if config.Cfg.Tokenizer.VocabDir != "" {
+		return config.Cfg.Tokenizer.VocabDir
+	}
+
+	return "./resource/tokenizer"
+}
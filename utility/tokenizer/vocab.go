@@ -0,0 +1,107 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/gogf/gf/v2/text/gstr"
+	"github.com/gogf/gf/v2/util/gconv"
+	sdkm "github.com/iimeta/fastapi-sdk/model"
+	"github.com/iimeta/fastapi/internal/consts"
+	"github.com/iimeta/fastapi/utility/logger"
+	"github.com/iimeta/tiktoken-go"
+	"sync"
+)
+
+// 分词正则, 与cl100k_base一致, Qwen等模型的词表均使用该规则
+const pattern = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+
+// 词表分词器, 加载tiktoken格式(每行: base64编码的token 排名)的词表文件进行BPE分词, 词表文件不存在或无法加载时使用tiktoken
+type Vocab struct {
+	name     string
+	path     string
+	once     sync.Once
+	tiktoken *tiktoken.Tiktoken
+	fallback Tokenizer
+}
+
+func NewVocab(name, path string) *Vocab {
+	return &Vocab{name: name, path: path, fallback: NewTiktoken(consts.DEFAULT_MODEL)}
+}
+
+func (v *Vocab) NumTokensFromString(text string) (int, error) {
+
+	v.once.Do(v.load)
+
+	if v.tiktoken == nil {
+		return v.fallback.NumTokensFromString(text)
+	}
+
+	return len(v.tiktoken.EncodeOrdinary(text)), nil
+}
+
+func (v *Vocab) NumTokensFromMessages(messages []sdkm.ChatCompletionMessage) (int, error) {
+
+	v.once.Do(v.load)
+
+	if v.tiktoken == nil {
+		return v.fallback.NumTokensFromMessages(messages)
+	}
+
+	numTokens := 3
+
+	for _, message := range messages {
+
+		numTokens += 3
+
+		for _, text := range []string{message.Role, message.Name, gconv.String(message.Content)} {
+
+			tokens, err := v.NumTokensFromString(text)
+			if err != nil {
+				return 0, err
+			}
+
+			numTokens += tokens
+		}
+	}
+
+	return numTokens, nil
+}
+
+func (v *Vocab) load() {
+
+	ctx := gctx.New()
+
+	if !gfile.Exists(v.path) {
+		logger.Infof(ctx, "tokenizer %s vocab file %s not found, use tiktoken", v.name, v.path)
+		return
+	}
+
+	ranks := make(map[string]int)
+
+	for _, line := range gstr.SplitAndTrim(gfile.GetContents(v.path), "\n") {
+
+		parts := gstr.Split(line, " ")
+		if len(parts) != 2 {
+			continue
+		}
+
+		token, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil {
+			logger.Errorf(ctx, "tokenizer %s vocab file %s, line: %s, error: %v", v.name, v.path, line, err)
+			return
+		}
+
+		ranks[string(token)] = gconv.Int(parts[1])
+	}
+
+	bpe, err := tiktoken.NewCoreBPE(ranks, map[string]int{}, pattern)
+	if err != nil {
+		logger.Errorf(ctx, "tokenizer %s vocab file %s, error: %v", v.name, v.path, err)
+		return
+	}
+
+	v.tiktoken = tiktoken.NewTiktoken(bpe, nil, map[string]any{})
+
+	logger.Infof(ctx, "tokenizer %s vocab file %s loaded, size: %d", v.name, v.path, len(ranks))
+}
@@ -5,24 +5,13 @@ import (
 	"github.com/gogf/gf/v2/os/gfile"
 	"github.com/gogf/gf/v2/text/gstr"
 	"github.com/tcolgate/mp3"
+	"io"
 	"os"
 	"time"
 )
 
 func GetAudioDuration(filePath string) (time.Duration, error) {
 
-	switch gstr.ToLower(gfile.Ext(filePath)) {
-	case ".wav":
-		return getWavDuration(filePath)
-	case ".mp3":
-		return getMp3Duration(filePath)
-	}
-
-	return time.Duration(0), nil
-}
-
-func getWavDuration(filePath string) (time.Duration, error) {
-
 	file, err := os.Open(filePath)
 	if err != nil {
 		return 0, err
@@ -32,6 +21,24 @@ func getWavDuration(filePath string) (time.Duration, error) {
 		_ = file.Close()
 	}()
 
+	return GetAudioDurationFromReader(file, gstr.TrimLeftStr(gfile.Ext(filePath), "."))
+}
+
+// 根据音频格式获取音频时长, 支持wav和mp3, 其它格式返回0
+func GetAudioDurationFromReader(reader io.Reader, format string) (time.Duration, error) {
+
+	switch gstr.ToLower(format) {
+	case "wav":
+		return getWavDuration(reader)
+	case "mp3":
+		return getMp3Duration(reader)
+	}
+
+	return time.Duration(0), nil
+}
+
+func getWavDuration(file io.Reader) (time.Duration, error) {
+
 	// 读取 WAV 文件头
 	var riffID [4]byte
 	var fileSize uint32
@@ -66,17 +73,7 @@ func getWavDuration(filePath string) (time.Duration, error) {
 	return duration, nil
 }
 
-func getMp3Duration(filePath string) (time.Duration, error) {
-
-	// 打开 MP3 文件
-	file, err := os.Open(filePath)
-	if err != nil {
-		return 0, err
-	}
-
-	defer func() {
-		_ = file.Close()
-	}()
+func getMp3Duration(file io.Reader) (time.Duration, error) {
 
 	// 创建解码器
 	d := mp3.NewDecoder(file)
@@ -0,0 +1,204 @@
+package util
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/grpool"
+	"github.com/gogf/gf/v2/text/gstr"
+	"github.com/iimeta/fastapi/internal/config"
+	"github.com/iimeta/fastapi/utility/cache"
+	"github.com/iimeta/fastapi/utility/logger"
+	"golang.org/x/sync/singleflight"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// 读取图像头部的最大字节数, 足够解析常见格式的宽高
+const imageHeaderSize = 64 * 1024
+
+var (
+	imageSizeCache = cache.New(1000) // [URL]图像宽高
+	imageSizeGroup singleflight.Group
+)
+
+type imageSize struct {
+	width  int
+	height int
+	err    error // 读取失败的错误, 短时间内直接返回
+}
+
+// 获取图像宽高, 支持base64(data:image/...;base64,...), 开启image_fetch后支持URL, URL只读取图像头部
+func GetImageSize(ctx context.Context, url string) (width, height int, err error) {
+
+	var data []byte
+
+	if gstr.HasPrefix(url, "data:") {
+
+		index := gstr.Pos(url, ",")
+		if index == -1 {
+			return 0, 0, errors.New("invalid image data url")
+		}
+
+		if data, err = base64.StdEncoding.DecodeString(url[index+1:]); err != nil {
+			return 0, 0, err
+		}
+
+		return decodeImageSize(data)
+	}
+
+	if !config.Cfg.ImageFetch.Open {
+		return 0, 0, errors.New("image url fetching is disabled")
+	}
+
+	if value := imageSizeCache.GetVal(ctx, url); value != nil {
+		size := value.(imageSize)
+		return size.width, size.height, size.err
+	}
+
+	// 与预读共享同一次读取
+	value, err, _ := imageSizeGroup.Do(url, func() (interface{}, error) {
+
+		width, height, err := fetchImageSize(gctx.NeverDone(ctx), url)
+		if err != nil {
+			_ = imageSizeCache.Set(ctx, url, imageSize{err: err}, cmp.Or(config.Cfg.ImageFetch.FailTTL, 60)*time.Second)
+			return nil, err
+		}
+
+		size := imageSize{width: width, height: height}
+		_ = imageSizeCache.Set(ctx, url, size, time.Hour)
+
+		return size, nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size := value.(imageSize)
+
+	return size.width, size.height, nil
+}
+
+// 预读URL图像宽高, 在请求上游的同时读取, 计费时直接使用结果
+func PrefetchImageSize(ctx context.Context, url string) {
+
+	if !config.Cfg.ImageFetch.Open || gstr.HasPrefix(url, "data:") || imageSizeCache.ContainsKey(ctx, url) {
+		return
+	}
+
+	if err := grpool.AddWithRecover(gctx.NeverDone(ctx), func(ctx context.Context) {
+		_, _, _ = GetImageSize(ctx, url)
+	}, func(ctx context.Context, exception error) {
+		logger.Error(ctx, exception)
+	}); err != nil {
+		logger.Error(ctx, err)
+	}
+}
+
+// 读取URL图像头部获取宽高
+func fetchImageSize(ctx context.Context, url string) (width, height int, err error) {
+
+	if !gstr.HasPrefix(url, "http://") && !gstr.HasPrefix(url, "https://") {
+		return 0, 0, errors.New("unsupported image url scheme")
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	request.Header.Set("Range", fmt.Sprintf("bytes=0-%d", imageHeaderSize-1))
+
+	response, err := imageClient.Do(request)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, imageHeaderSize))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return decodeImageSize(data)
+}
+
+// 读取图像的客户端, 连接时校验解析后的地址, 重定向和DNS重绑定也无法访问内网
+var imageClient = &http.Client{
+	Timeout: cmp.Or(config.Cfg.ImageFetch.Timeout, 3) * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: cmp.Or(config.Cfg.ImageFetch.Timeout, 3) * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+
+				if config.Cfg.ImageFetch.AllowPrivate {
+					return nil
+				}
+
+				addrPort, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+
+				if addr := addrPort.Addr().Unmap(); !addr.IsGlobalUnicast() || addr.IsPrivate() || addr.IsLoopback() {
+					return fmt.Errorf("image url address %s is not allowed", addr)
+				}
+
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: cmp.Or(config.Cfg.ImageFetch.Timeout, 3) * time.Second,
+		MaxIdleConnsPerHost: 2,
+	},
+}
+
+// 解析图像宽高
+func decodeImageSize(data []byte) (width, height int, err error) {
+
+	if width, height, ok := getWebpSize(data); ok {
+		return width, height, nil
+	}
+
+	imageConfig, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return imageConfig.Width, imageConfig.Height, nil
+}
+
+// 解析WebP宽高, 标准库不支持WebP
+func getWebpSize(data []byte) (width, height int, ok bool) {
+
+	if len(data) < 30 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0, false
+	}
+
+	switch string(data[12:16]) {
+	case "VP8 ":
+		return int(binary.LittleEndian.Uint16(data[26:28]) & 0x3fff), int(binary.LittleEndian.Uint16(data[28:30]) & 0x3fff), true
+	case "VP8L":
+		bits := binary.LittleEndian.Uint32(data[21:25])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, true
+	case "VP8X":
+		return int(uint32(data[24])|uint32(data[25])<<8|uint32(data[26])<<16) + 1, int(uint32(data[27])|uint32(data[28])<<8|uint32(data[29])<<16) + 1, true
+	}
+
+	return 0, 0, false
+}